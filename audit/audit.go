@@ -0,0 +1,303 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// GenesisHash is the previous hash of the first record in a chain.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Record is one points change in the audit chain. Its hash covers every other field, including the hash of the
+// record before it, so changing, removing or reordering any record breaks every link after it.
+type Record struct {
+	Seq         uint64             `json:"seq"`
+	At          time.Time          `json:"at"`
+	Transaction ledger.Transaction `json:"transaction"`
+	PrevHash    string             `json:"prevHash"`
+	Hash        string             `json:"hash"`
+}
+
+// ComputeHash returns the hex SHA-256 of the record's JSON encoding without its hash.
+func (r Record) ComputeHash() (string, error) {
+	r.Hash = ""
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return "", errors.Wrap(err, "ComputeHash")
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends records to an NDJSON file, chaining each to the one before it.
+type Log struct {
+	file     *os.File
+	path     string
+	seq      uint64
+	lastHash string
+	// lastTransactionID is the ID of the newest transaction in the chain.
+	lastTransactionID int64
+	// pending holds the transactions Record could not write yet, in order, and failures counts its failed attempts.
+	pending     []ledger.Transaction
+	failures    int
+	lastError   string
+	lastFailure time.Time
+	clock       clock.Clock
+	lock        sync.Mutex
+}
+
+// Status describes how far a log has got and whether it is failing.
+type Status struct {
+	Seq               uint64
+	LastTransactionID int64
+	Pending           int
+	Failures          int
+	LastError         string
+	LastFailure       time.Time
+}
+
+// OpenLog opens the audit file at path, creating it and its directory if needed, and continues the chain from its last record.
+// It does not verify the chain; that is VerifyChain's job.
+func OpenLog(path string, c clock.Clock) (*Log, error) {
+	l := &Log{path: path, lastHash: GenesisHash, clock: c}
+	if existing, err := os.Open(path); err == nil {
+		err = read(existing, func(record Record, _ int) error {
+			l.seq = record.Seq
+			l.lastHash = record.Hash
+			l.lastTransactionID = record.Transaction.ID
+			return nil
+		})
+		existing.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "OpenLog")
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	l.file = file
+	return l, nil
+}
+
+// Append records a ledger transaction as the next link of the chain.
+func (l *Log) Append(transaction ledger.Transaction) (Record, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.append(transaction)
+}
+
+// Record appends a ledger transaction to the chain unless the chain already holds it or a later one, so a ledger
+// that replays transactions the chain has seen does not record them twice. A transaction that cannot be written is
+// kept and written before the next one, so a failure leaves no gap in the chain; Status reports the failure meanwhile.
+func (l *Log) Record(transaction ledger.Transaction) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if transaction.ID <= l.lastTransactionID {
+		return nil
+	}
+	if len(l.pending) == 0 || l.pending[len(l.pending)-1].ID < transaction.ID {
+		l.pending = append(l.pending, transaction)
+	}
+	for len(l.pending) > 0 {
+		if _, err := l.append(l.pending[0]); err != nil {
+			l.failures++
+			l.lastError = err.Error()
+			l.lastFailure = l.clock.Now().UTC()
+			return errors.Wrapf(err, "Record: %d transactions pending", len(l.pending))
+		}
+		l.pending = l.pending[1:]
+	}
+	l.lastError = ""
+	return nil
+}
+
+// Restart moves the chain's file aside, to its path followed by the time, and starts a new chain in its place. A
+// ledger that starts over from its first transaction, e.g. a replica copying a new leader, needs a new chain, since
+// the old one already holds its transaction IDs. Transactions still pending are dropped with the old chain. It
+// returns where the old chain was moved, or "" when it held no records.
+func (l *Log) Restart() (string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.seq == 0 && len(l.pending) == 0 {
+		return "", nil
+	}
+
+	archived := l.path + "." + l.clock.Now().UTC().Format("20060102T150405.000000000Z")
+	if err := l.file.Close(); err != nil {
+		return "", errors.Wrap(err, "Restart")
+	}
+	if err := os.Rename(l.path, archived); err != nil {
+		return "", errors.Wrap(err, "Restart")
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return "", errors.Wrap(err, "Restart")
+	}
+	l.file = file
+	l.seq = 0
+	l.lastHash = GenesisHash
+	l.lastTransactionID = 0
+	l.pending = nil
+	l.failures = 0
+	l.lastError = ""
+	return archived, nil
+}
+
+// Status reports the head of the chain and the transactions waiting to be written.
+func (l *Log) Status() Status {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return Status{
+		Seq:               l.seq,
+		LastTransactionID: l.lastTransactionID,
+		Pending:           len(l.pending),
+		Failures:          l.failures,
+		LastError:         l.lastError,
+		LastFailure:       l.lastFailure,
+	}
+}
+
+// append writes the next record of the chain. The caller must hold the lock.
+func (l *Log) append(transaction ledger.Transaction) (Record, error) {
+	record := Record{Seq: l.seq + 1, At: l.clock.Now().UTC(), Transaction: transaction, PrevHash: l.lastHash}
+	hash, err := record.ComputeHash()
+	if err != nil {
+		return Record{}, errors.Wrap(err, "Append")
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return Record{}, errors.Wrap(err, "Append")
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return Record{}, errors.Wrap(err, "Append")
+	}
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	l.lastTransactionID = transaction.ID
+	return record, nil
+}
+
+// Close closes the audit file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Break describes the first broken link of a chain.
+type Break struct {
+	Line   int    `json:"line"`
+	Seq    uint64 `json:"seq"`
+	Reason string `json:"reason"`
+}
+
+// Result is the outcome of verifying a chain.
+type Result struct {
+	Records  int    `json:"records"`
+	HeadSeq  uint64 `json:"headSeq"`
+	HeadHash string `json:"headHash"`
+	Broken   *Break `json:"broken,omitempty"`
+}
+
+// VerifyChain reads a chain from r and checks that its records are numbered without gaps, that each links to the
+// hash of the one before it and that each hash matches its contents. It stops at the first broken link. The head
+// is the last record that verified; compare it with a head recorded earlier to detect records cut off the end.
+func VerifyChain(r io.Reader) (Result, error) {
+	result := Result{HeadHash: GenesisHash}
+	err := read(r, func(record Record, line int) error {
+		broken := func(reason string, args ...interface{}) error {
+			result.Broken = &Break{Line: line, Seq: record.Seq, Reason: fmt.Sprintf(reason, args...)}
+			return errStop
+		}
+		if record.Seq != result.HeadSeq+1 {
+			return broken("expected record %d, found %d", result.HeadSeq+1, record.Seq)
+		}
+		if record.PrevHash != result.HeadHash {
+			return broken("previous hash %s does not match record %d's hash %s", record.PrevHash, result.HeadSeq, result.HeadHash)
+		}
+		hash, err := record.ComputeHash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return broken("contents hash to %s, not the recorded %s", hash, record.Hash)
+		}
+		result.Records++
+		result.HeadSeq = record.Seq
+		result.HeadHash = record.Hash
+		return nil
+	})
+	if errors.Is(err, errStop) {
+		return result, nil
+	}
+	if errMalformed, ok := errors.Cause(err).(malformedError); ok {
+		result.Broken = &Break{Line: errMalformed.line, Seq: result.HeadSeq + 1, Reason: errMalformed.Error()}
+		return result, nil
+	}
+	return result, errors.Wrap(err, "VerifyChain")
+}
+
+// errStop ends a read early.
+var errStop = errors.New("stop")
+
+// malformedError is a line that is not a record.
+type malformedError struct {
+	line int
+	err  error
+}
+
+func (e malformedError) Error() string {
+	return "malformed record: " + e.err.Error()
+}
+
+// read decodes NDJSON records from r and calls fn with each and its line number.
+func read(r io.Reader, fn func(record Record, line int) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return malformedError{line: line, err: err}
+		}
+		if err := fn(record, line); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "read")
+}
+
+// ContainsHash reports whether a record with the given hash is in the chain read from r.
+func ContainsHash(r io.Reader, hash string) (bool, error) {
+	found := false
+	err := read(r, func(record Record, _ int) error {
+		if record.Hash == hash {
+			found = true
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return false, errors.Wrap(err, "ContainsHash")
+	}
+	return found, nil
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// writeChain appends n earn transactions to a new audit log and returns its lines.
+func writeChain(t *testing.T, n int) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	l, err := OpenLog(path, clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	for i := 1; i <= n; i++ {
+		transaction := ledger.Transaction{ID: int64(i), Kind: ledger.KindEarn, Postings: ledger.Transfer(ledger.IssuedAccount, ledger.UserAccount("alice"), 10*i)}
+		if _, err := l.Append(transaction); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	l.Close()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(contents)), "\n")
+}
+
+// TestVerifyChain tests that an intact chain verifies and that edits and gaps are reported at the first broken link.
+func TestVerifyChain(t *testing.T) {
+	lines := writeChain(t, 4)
+	tests := []struct {
+		name       string
+		edit       func(lines []string) []string
+		brokenLine int
+	}{
+		{"Intact", func(lines []string) []string { return lines }, 0},
+		{"Modified amount", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"amount":20`, `"amount":200`, 1)
+			return lines
+		}, 2},
+		{"Removed record", func(lines []string) []string { return append(lines[:1:1], lines[2:]...) }, 2},
+		{"Rehashed record", func(lines []string) []string {
+			var record Record
+			_ = json.Unmarshal([]byte(lines[1]), &record)
+			record.Transaction.Memo = "tampered"
+			record.Hash, _ = record.ComputeHash()
+			encoded, _ := json.Marshal(record)
+			lines[1] = string(encoded)
+			return lines
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := tt.edit(append([]string(nil), lines...))
+			result, err := VerifyChain(strings.NewReader(strings.Join(edited, "\n") + "\n"))
+			if err != nil {
+				t.Fatalf("VerifyChain() error = %v", err)
+			}
+			if tt.brokenLine == 0 {
+				if result.Broken != nil || result.Records != 4 {
+					t.Errorf("VerifyChain() = %+v, expected 4 intact records", result)
+				}
+				return
+			}
+			if result.Broken == nil || result.Broken.Line != tt.brokenLine {
+				t.Errorf("VerifyChain() = %+v, expected a break at line %d", result, tt.brokenLine)
+			}
+		})
+	}
+}
+
+// TestOpenLogContinuesChain tests that reopening a log links new records to the last one written.
+func TestOpenLogContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	for i := 0; i < 2; i++ {
+		l, err := OpenLog(path, clock.Real{})
+		if err != nil {
+			t.Fatalf("OpenLog() error = %v", err)
+		}
+		if _, err := l.Append(ledger.Transaction{ID: int64(i + 1), Kind: ledger.KindAdjust}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		l.Close()
+	}
+	contents, _ := os.ReadFile(path)
+	result, err := VerifyChain(bytes.NewReader(contents))
+	if err != nil || result.Broken != nil || result.Records != 2 {
+		t.Errorf("VerifyChain() = %+v, %v; expected 2 intact records", result, err)
+	}
+}
+
+// TestRestartStartsNewChain tests that a restarted log records transaction IDs it recorded before in a new chain, and
+// that the old chain is kept intact beside it.
+func TestRestartStartsNewChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	l, err := OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer l.Close()
+	for id := int64(1); id <= 2; id++ {
+		if err := l.Record(ledger.Transaction{ID: id, Kind: ledger.KindEarn}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	archived, err := l.Restart()
+	if err != nil {
+		t.Fatalf("Restart() error = %v", err)
+	}
+	if err := l.Record(ledger.Transaction{ID: 1, Kind: ledger.KindEarn}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if status := l.Status(); status.Seq != 1 || status.LastTransactionID != 1 {
+		t.Errorf("Status() = %+v, expected transaction 1 recorded again as the first record", status)
+	}
+
+	for file, records := range map[string]int{archived: 2, path: 1} {
+		contents, _ := os.ReadFile(file)
+		result, err := VerifyChain(bytes.NewReader(contents))
+		if err != nil || result.Broken != nil || result.Records != records {
+			t.Errorf("VerifyChain(%s) = %+v, %v; expected %d intact records", file, result, err, records)
+		}
+	}
+}
+
+// TestRecordRetriesFailedWrites tests that Record skips transactions the chain holds and keeps failed ones pending
+// until the next write.
+func TestRecordRetriesFailedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	l, err := OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	for _, id := range []int64{1, 1} {
+		if err := l.Record(ledger.Transaction{ID: id, Kind: ledger.KindAdjust}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	l.file.Close()
+	if err := l.Record(ledger.Transaction{ID: 2, Kind: ledger.KindAdjust}); err == nil {
+		t.Fatal("Record() to a closed file succeeded")
+	}
+	if status := l.Status(); status.Pending != 1 || status.Failures != 1 || status.LastError == "" {
+		t.Errorf("Status() = %+v, expected 1 pending transaction and 1 failure", status)
+	}
+
+	if l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	defer l.Close()
+	if err := l.Record(ledger.Transaction{ID: 3, Kind: ledger.KindAdjust}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if status := l.Status(); status.Pending != 0 || status.LastTransactionID != 3 || status.LastError != "" {
+		t.Errorf("Status() = %+v, expected every transaction written", status)
+	}
+	contents, _ := os.ReadFile(path)
+	result, err := VerifyChain(bytes.NewReader(contents))
+	if err != nil || result.Broken != nil || result.Records != 3 {
+		t.Errorf("VerifyChain() = %+v, %v; expected 3 intact records", result, err)
+	}
+}
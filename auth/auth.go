@@ -0,0 +1,73 @@
+// Package auth issues and verifies the bearer tokens that identify users to the API. A token names a user and when
+// it expires, and is signed with HMAC-SHA256 under a secret shared by every instance, so any instance, or a gateway
+// in front of them, can issue tokens the others accept.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tokenPrefix starts every token and names its format.
+const tokenPrefix = "u1."
+
+var (
+	// ErrInvalidToken is returned for a token that is malformed or not signed with the signer's secret.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for a correctly signed token past its expiry.
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// Signer issues and verifies user tokens.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a Signer for the given secret, which should be at least 32 random bytes.
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns a token identifying the user until expiresAt.
+func (s *Signer) Sign(userID string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return tokenPrefix + payload + "." + s.signature(payload)
+}
+
+// Verify returns the user a token identifies, if it is signed with the signer's secret and has not expired at now.
+func (s *Signer) Verify(token string, now time.Time) (string, error) {
+	payload, found := strings.CutPrefix(token, tokenPrefix)
+	if !found {
+		return "", ErrInvalidToken
+	}
+	cut := strings.LastIndexByte(payload, '.')
+	if cut < 0 || !hmac.Equal([]byte(payload[cut+1:]), []byte(s.signature(payload[:cut]))) {
+		return "", ErrInvalidToken
+	}
+	rawUserID, rawExpiry, found := strings.Cut(payload[:cut], ".")
+	userID, err := base64.RawURLEncoding.DecodeString(rawUserID)
+	if !found || err != nil || len(userID) == 0 {
+		return "", ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(rawExpiry, 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if !now.Before(time.Unix(expiry, 0)) {
+		return "", ErrExpiredToken
+	}
+	return string(userID), nil
+}
+
+// signature returns the URL-safe base64 HMAC of a token payload.
+func (s *Signer) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(tokenPrefix + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
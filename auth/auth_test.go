@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TestSignVerify tests that tokens verify under their own secret until they expire, and are refused when tampered
+// with or signed under another secret.
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	signer := NewSigner("a-secret-of-at-least-thirty-two-bytes")
+	token := signer.Sign("user-42", now.Add(time.Hour))
+
+	if userID, err := signer.Verify(token, now); err != nil || userID != "user-42" {
+		t.Errorf("Verify() = %q, %v, want user-42", userID, err)
+	}
+	if _, err := signer.Verify(token, now.Add(time.Hour)); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify() of an expired token error = %v, want %v", err, ErrExpiredToken)
+	}
+
+	forged := NewSigner("another-secret").Sign("user-42", now.Add(time.Hour))
+	impersonated := strings.Replace(token, token[len(tokenPrefix):strings.IndexByte(token[len(tokenPrefix):], '.')+len(tokenPrefix)], "dXNlci00Mw", 1)
+	for _, invalid := range []string{"", "user-42", forged, impersonated, token + "x", "u1..0.x"} {
+		if _, err := signer.Verify(invalid, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(%q) error = %v, want %v", invalid, err, ErrInvalidToken)
+		}
+	}
+}
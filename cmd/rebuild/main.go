@@ -0,0 +1,77 @@
+// Command rebuild replays a receipt event log into a fresh store and writes the replayed events to a new log. With
+// -rescore, receipts are scored again under the rules configured in the .env file, so a fix to the scoring or
+// projection code can be applied to existing data. It prints a report of the receipts whose points changed.
+//
+//	rebuild -in data/events.ndjson -out data/events.rebuilt.ndjson -rescore
+//
+// Stop the server, rebuild, replace the log with the rebuilt one and start the server again to serve the result.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/handlers"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/config"
+)
+
+func main() {
+	log.SetFlags(0)
+	envFile := flag.String("env", ".env", "file to read the scoring rules from")
+	in := flag.String("in", "", "event log to replay, defaults to $EVENT_LOG_PATH")
+	out := flag.String("out", "", "file to write the rebuilt event log to; it must not exist")
+	rescore := flag.Bool("rescore", false, "score receipts under the current rules instead of keeping their recorded points")
+	flag.Parse()
+
+	if err := godotenv.Load(*envFile); err != nil {
+		log.Fatalf("Error loading %s: %v", *envFile, err)
+	}
+	if *in == "" {
+		*in = os.Getenv("EVENT_LOG_PATH")
+	}
+	if *in == "" || *out == "" {
+		log.Fatalf("usage: rebuild -in events.ndjson -out rebuilt.ndjson [-rescore]")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s already exists", *out)
+	}
+
+	storeOptions, err := config.ScoringOptions()
+	if err != nil {
+		log.Fatalf("Error configuring scoring: %v", err)
+	}
+	rebuilt, err := events.OpenLog(*out, clock.Real{})
+	if err != nil {
+		log.Fatalf("Error creating %s: %v", *out, err)
+	}
+	//Syncing every event would slow the rebuild down for nothing, since the log is synced once it is complete
+	rebuilt.SyncWrites(false)
+	receiptStore := handlers.NewReceiptStore(append(storeOptions, handlers.WithEventLog(rebuilt))...)
+
+	source := func(fn func(event events.Event) error) error {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return events.Read(file, fn)
+	}
+	report, err := receiptStore.Rebuild(source, *rescore)
+	if err != nil {
+		log.Fatalf("Error rebuilding: %v", err)
+	}
+	if err := rebuilt.Close(); err != nil {
+		log.Fatalf("Error writing %s: %v", *out, err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Error printing report: %v", err)
+	}
+}
@@ -1,14 +1,39 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/praveensundaram1/receipt-processor-challenge/audit"
+	"github.com/praveensundaram1/receipt-processor-challenge/dedup"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/expiry"
+	"github.com/praveensundaram1/receipt-processor-challenge/grpcapi"
 	"github.com/praveensundaram1/receipt-processor-challenge/handlers"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/config"
 	"github.com/praveensundaram1/receipt-processor-challenge/internal/routes"
+	"github.com/praveensundaram1/receipt-processor-challenge/ratelimit"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptcsv"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/receipttext"
+	"github.com/praveensundaram1/receipt-processor-challenge/replication"
+	"github.com/praveensundaram1/receipt-processor-challenge/risk"
+	"github.com/praveensundaram1/receipt-processor-challenge/sinks"
+	"github.com/praveensundaram1/receipt-processor-challenge/storage"
+	"github.com/praveensundaram1/receipt-processor-challenge/webhooks"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Load from .env file and set up logging
@@ -27,11 +52,257 @@ func init() {
 func main() {
 
 	Port := os.Getenv("PORT")
-	receiptStore := handlers.NewReceiptStore()
+	expiryPolicy, err := expiry.ParsePolicy(os.Getenv("POINTS_EXPIRY_POLICY"), config.DurationEnv("POINTS_EXPIRY_PERIOD", 365*24*time.Hour))
+	if err != nil {
+		log.Fatalf("Error configuring points expiry: %v", err)
+	}
+	duplicatePolicy, err := dedup.ParsePolicy(os.Getenv("DUPLICATE_POLICY"))
+	if err != nil {
+		log.Fatalf("Error configuring duplicate detection: %v", err)
+	}
+	exactDuplicatePolicy, err := dedup.ParsePolicy(os.Getenv("EXACT_DUPLICATE_POLICY"))
+	if err != nil {
+		log.Fatalf("Error configuring duplicate detection: %v", err)
+	}
+	idStrategy, err := receiptid.ParseStrategy(os.Getenv("RECEIPT_ID_STRATEGY"), clock.Real{})
+	if err != nil {
+		log.Fatalf("Error configuring receipt IDs: %v", err)
+	}
+	duplicateTolerance, err := strconv.ParseInt(os.Getenv("DUPLICATE_TOTAL_TOLERANCE_CENTS"), 10, 64)
+	if err != nil {
+		duplicateTolerance = 0
+	}
+	deprecatedAt, err := config.DateEnv("API_UNVERSIONED_DEPRECATED_AT")
+	if err != nil {
+		log.Fatalf("Error configuring API deprecation: %v", err)
+	}
+	sunsetAt, err := config.DateEnv("API_UNVERSIONED_SUNSET_AT")
+	if err != nil {
+		log.Fatalf("Error configuring API deprecation: %v", err)
+	}
+	storeOptions := []handlers.StoreOption{
+		handlers.WithAPIDeprecation(deprecatedAt, sunsetAt),
+		handlers.WithAdminToken(os.Getenv("ADMIN_TOKEN")),
+		handlers.WithExpiryPolicy(expiryPolicy),
+		handlers.WithDuplicatePolicy(duplicatePolicy, duplicateTolerance),
+		handlers.WithExactDuplicatePolicy(exactDuplicatePolicy),
+		handlers.WithIDStrategy(idStrategy),
+	}
+	scoringOptions, err := config.ScoringOptions()
+	if err != nil {
+		log.Fatalf("Error configuring scoring: %v", err)
+	}
+	storeOptions = append(storeOptions, scoringOptions...)
+	if secret := os.Getenv("USER_TOKEN_SECRET"); secret != "" {
+		storeOptions = append(storeOptions, handlers.WithUserTokens(secret, config.DurationEnv("USER_TOKEN_TTL", 24*time.Hour)))
+	}
+	if os.Getenv("RISK_SCORING_ENABLED") == "true" {
+		storeOptions = append(storeOptions, handlers.WithRiskPipeline(risk.DefaultPipeline(clock.Real{})))
+	}
+	if os.Getenv("RATE_LIMIT_ENABLED") == "true" {
+		var defaultLimit *ratelimit.Limit
+		if raw := os.Getenv("RATE_LIMIT_DEFAULT"); raw != "" {
+			limit, err := ratelimit.ParseLimit(raw)
+			if err != nil {
+				log.Fatalf("Error configuring rate limits: %v", err)
+			}
+			defaultLimit = &limit
+		}
+		routeLimits, err := ratelimit.ParseRouteLimits(os.Getenv("RATE_LIMIT_ROUTES"))
+		if err != nil {
+			log.Fatalf("Error configuring rate limits: %v", err)
+		}
+		apiKeys, err := ratelimit.ParseAPIKeys(os.Getenv("RATE_LIMIT_API_KEYS"))
+		if err != nil {
+			log.Fatalf("Error configuring rate limits: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithAPIKeys(apiKeys))
+		var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+		if raw := os.Getenv("RATE_LIMIT_REDIS_URL"); raw != "" {
+			redisOptions, err := redis.ParseURL(raw)
+			if err != nil {
+				log.Fatalf("Error configuring rate limits: %v", err)
+			}
+			limitStore = ratelimit.NewRedisStore(redis.NewClient(redisOptions), "ratelimit:")
+		}
+		limiter := ratelimit.NewLimiter(limitStore, clock.Real{}, defaultLimit, routeLimits)
+		storeOptions = append(storeOptions, handlers.WithRateLimiter(limiter))
+	}
+	memoryLimits := handlers.MemoryLimits{TTL: config.DurationEnv("STORE_TTL", 0)}
+	if raw := os.Getenv("STORE_MAX_ENTRIES"); raw != "" {
+		if memoryLimits.MaxEntries, err = strconv.Atoi(raw); err != nil {
+			log.Fatalf("Error configuring store limits: %v", err)
+		}
+	}
+	if raw := os.Getenv("STORE_MAX_BYTES"); raw != "" {
+		if memoryLimits.MaxBytes, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			log.Fatalf("Error configuring store limits: %v", err)
+		}
+	}
+	var spillBackend storage.Backend
+	switch mode := os.Getenv("STORE_EVICTION_MODE"); mode {
+	case "", "cache":
+	case "spill":
+		if spillBackend, err = storage.NewFileBackend(os.Getenv("STORE_SPILL_DIR")); err != nil {
+			log.Fatalf("Error configuring store spill directory: %v", err)
+		}
+	default:
+		log.Fatalf("Error configuring store limits: unknown eviction mode %q", mode)
+	}
+	storeOptions = append(storeOptions, handlers.WithMemoryLimits(memoryLimits, spillBackend))
+	replicationLog := replication.NewLog(clock.Real{})
+	if replicationLogPath := os.Getenv("REPLICATION_LOG_PATH"); replicationLogPath != "" && os.Getenv("REPLICATION_ROLE") != "" {
+		if replicationLog, err = replication.OpenLog(replicationLogPath, clock.Real{}); err != nil {
+			log.Fatalf("Error opening replication log: %v", err)
+		}
+	}
+	switch role := os.Getenv("REPLICATION_ROLE"); role {
+	case "":
+	case "leader":
+		storeOptions = append(storeOptions, handlers.WithReplicationLog(replicationLog))
+	case "follower":
+		storeOptions = append(storeOptions, handlers.WithReplicationLog(replicationLog), handlers.WithLeader(os.Getenv("REPLICATION_LEADER_URL"), os.Getenv("REPLICATION_LEADER_TOKEN")))
+	default:
+		log.Fatalf("Error configuring replication: unknown role %q", role)
+	}
+	//A follower copies the leader's state instead of restoring its own event log, and records what it copied there
+	//when it is promoted
+	eventLog := events.NewLog(clock.Real{})
+	if eventLogPath := os.Getenv("EVENT_LOG_PATH"); eventLogPath != "" {
+		if eventLog, err = events.OpenLog(eventLogPath, clock.Real{}); err != nil {
+			log.Fatalf("Error opening event log: %v", err)
+		}
+		eventLog.SyncWrites(os.Getenv("EVENT_LOG_SYNC") != "false")
+	}
+	storeOptions = append(storeOptions, handlers.WithEventLog(eventLog))
+	for _, name := range strings.Split(os.Getenv("EVENT_SINKS"), ",") {
+		var sink sinks.EventSink
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			continue
+		case "file":
+			maxBytes, _ := strconv.ParseInt(os.Getenv("EVENT_SINK_FILE_MAX_BYTES"), 10, 64)
+			sink, err = sinks.NewFileSink(os.Getenv("EVENT_SINK_FILE_DIR"), maxBytes)
+		case "nats":
+			sink, err = sinks.NewNATSSink(os.Getenv("EVENT_SINK_NATS_URL"), os.Getenv("EVENT_SINK_NATS_SUBJECT"))
+		default:
+			log.Fatalf("Error configuring event sinks: unknown sink %q", name)
+		}
+		if err != nil {
+			log.Fatalf("Error configuring event sinks: %v", err)
+		}
+		cursorPath := ""
+		if cursorDir := os.Getenv("EVENT_SINK_CURSOR_DIR"); cursorDir != "" {
+			cursorPath = filepath.Join(cursorDir, name+".cursor")
+		}
+		forwarder, err := sinks.NewForwarder(sink, eventLog, cursorPath, clock.Real{})
+		if err != nil {
+			log.Fatalf("Error configuring event sinks: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithEventSinks(forwarder))
+	}
+	if auditLogPath := os.Getenv("AUDIT_LOG_PATH"); auditLogPath != "" {
+		auditLog, err := audit.OpenLog(auditLogPath, clock.Real{})
+		if err != nil {
+			log.Fatalf("Error opening audit log: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithAuditLog(auditLog))
+	}
+	if os.Getenv("WEBHOOKS_ENABLED") == "true" {
+		webhookStore, err := webhooks.NewFileStore(os.Getenv("WEBHOOK_OUTBOX_DIR"))
+		if err != nil {
+			log.Fatalf("Error opening webhook outbox: %v", err)
+		}
+		backoff := webhooks.DefaultBackoff
+		backoff.Base = config.DurationEnv("WEBHOOK_BACKOFF_BASE", backoff.Base)
+		backoff.Max = config.DurationEnv("WEBHOOK_BACKOFF_MAX", backoff.Max)
+		if raw := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); raw != "" {
+			if backoff.MaxAttempts, err = strconv.Atoi(raw); err != nil {
+				log.Fatalf("Error configuring webhooks: %v", err)
+			}
+		}
+		dispatcher, err := webhooks.NewDispatcher(webhookStore, backoff, clock.Real{})
+		if err != nil {
+			log.Fatalf("Error configuring webhooks: %v", err)
+		}
+		if raw := os.Getenv("WEBHOOK_CONCURRENCY"); raw != "" {
+			concurrency, err := strconv.Atoi(raw)
+			if err != nil {
+				log.Fatalf("Error configuring webhooks: %v", err)
+			}
+			dispatcher.SetConcurrency(concurrency)
+		}
+		//The feed's cursor lives with the outbox, since it records which events are already in it
+		feed, err := handlers.NewWebhookFeed(dispatcher, eventLog, filepath.Join(os.Getenv("WEBHOOK_OUTBOX_DIR"), "events.cursor"), clock.Real{})
+		if err != nil {
+			log.Fatalf("Error configuring webhooks: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithWebhooks(dispatcher, feed))
+	}
+	if raw := os.Getenv("RECEIPT_FEED_BUFFER"); raw != "" {
+		feedBuffer, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("Error configuring the receipt feed: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithFeedBuffer(feedBuffer))
+	}
+	if templatesPath, rawConfidence := os.Getenv("RECEIPT_TEXT_TEMPLATES"), os.Getenv("RECEIPT_TEXT_MIN_CONFIDENCE"); templatesPath != "" || rawConfidence != "" {
+		var templates []receipttext.Template
+		if templatesPath != "" {
+			if templates, err = receipttext.LoadTemplates(templatesPath); err != nil {
+				log.Fatalf("Error configuring the receipt text parser: %v", err)
+			}
+		}
+		minConfidence := 0.6
+		if rawConfidence != "" {
+			if minConfidence, err = strconv.ParseFloat(rawConfidence, 64); err != nil {
+				log.Fatalf("Error configuring the receipt text parser: %v", err)
+			}
+		}
+		storeOptions = append(storeOptions, handlers.WithTextParser(receipttext.NewParser(templates...), minConfidence))
+	}
+	if raw := os.Getenv("CSV_COLUMNS"); raw != "" {
+		csvMapping, err := receiptcsv.ParseMapping(raw)
+		if err != nil {
+			log.Fatalf("Error configuring CSV columns: %v", err)
+		}
+		storeOptions = append(storeOptions, handlers.WithCSVMapping(csvMapping))
+	}
+	receiptStore := handlers.NewReceiptStore(storeOptions...)
+	if err := receiptStore.RestoreEvents(); err != nil {
+		log.Fatalf("Error restoring receipts from the event log: %v", err)
+	}
+	receiptStore.StartReplication(context.Background())
+	receiptStore.StartExpirySweeper(context.Background(), config.DurationEnv("POINTS_EXPIRY_SWEEP_INTERVAL", time.Hour))
+	receiptStore.StartEvictionSweeper(context.Background(), config.DurationEnv("STORE_EVICTION_SWEEP_INTERVAL", time.Minute))
+	receiptStore.StartEventSinks(context.Background())
+	receiptStore.StartWebhookDelivery(context.Background(), config.DurationEnv("WEBHOOK_RETRY_INTERVAL", 5*time.Second))
+	//gRPC calls carry the callers' tokens, so they are only served over TLS
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		certFile, keyFile := os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY")
+		if certFile == "" || keyFile == "" {
+			log.Fatalf("Error configuring gRPC: GRPC_TLS_CERT and GRPC_TLS_KEY are required")
+		}
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Error configuring gRPC: %v", err)
+		}
+		listener, err := net.Listen("tcp", grpcPort)
+		if err != nil {
+			log.Fatalf("Error starting gRPC server: %v", err)
+		}
+		grpcServer := grpcapi.NewServer(receiptStore, append(receiptStore.GRPCServerOptions(), grpc.Creds(creds))...)
+		go func() {
+			fmt.Println("gRPC listening on", "localhost"+grpcPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatalf("Error starting gRPC server: %v", err)
+			}
+		}()
+	}
 	router := routes.NewRouter(receiptStore) // Create a new router, and sets up the routes
 	addr := "localhost" + Port
 	fmt.Println("Listening on", addr)
-	err := http.ListenAndServe(Port, router)
+	err = http.ListenAndServe(Port, router)
 	if err != nil {
 		log.Println("Error starting server:", err)
 		panic(err)
@@ -0,0 +1,122 @@
+// Command snapshot exports and imports the receipt store of a running receipt processor through its admin endpoints.
+//
+//	snapshot export -format archive -out backup.tar.gz
+//	snapshot import -in backup.tar.gz -conflict overwrite -rescore
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	addr := flags.String("addr", "http://localhost:8080", "base URL of the receipt processor")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin token, defaults to $ADMIN_TOKEN")
+
+	switch os.Args[1] {
+	case "export":
+		format := flags.String("format", "ndjson", "snapshot format: ndjson or archive")
+		out := flags.String("out", "-", "file to write the snapshot to, - for stdout")
+		flags.Parse(os.Args[2:])
+		if err := exportSnapshot(*addr, *token, *format, *out); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+	case "import":
+		in := flags.String("in", "-", "snapshot file to import, - for stdin")
+		conflict := flags.String("conflict", "skip", "what to do with receipt IDs that are already stored: skip, overwrite or fail")
+		rescore := flags.Bool("rescore", false, "score receipts under the current rules instead of keeping their points")
+		flags.Parse(os.Args[2:])
+		if err := importSnapshot(*addr, *token, *in, *conflict, *rescore); err != nil {
+			log.Fatalf("import: %v", err)
+		}
+	default:
+		usage()
+	}
+}
+
+// usage prints the commands and exits.
+func usage() {
+	log.Fatalf("usage: snapshot export|import [flags]; run with -h after the command for its flags")
+}
+
+// exportSnapshot downloads a snapshot to a file.
+func exportSnapshot(addr string, token string, format string, out string) error {
+	request, err := http.NewRequest(http.MethodGet, addr+"/admin/snapshot?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return err
+	}
+	response, err := send(request, token)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	writer := io.Writer(os.Stdout)
+	if out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// importSnapshot uploads a snapshot file and prints the import report.
+func importSnapshot(addr string, token string, in string, conflict string, rescore bool) error {
+	reader := io.Reader(os.Stdin)
+	if in != "-" {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	query := url.Values{"conflict": {conflict}, "rescore": {strconv.FormatBool(rescore)}}
+	request, err := http.NewRequest(http.MethodPost, addr+"/admin/snapshot?"+query.Encode(), reader)
+	if err != nil {
+		return err
+	}
+	response, err := send(request, token)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	report, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(report))
+	return nil
+}
+
+// send performs an admin request and turns error statuses into errors.
+func send(request *http.Request, token string) (*http.Response, error) {
+	request.Header.Set("X-Admin-Token", token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", request.Method, request.URL.Path, response.Status)
+	}
+	return response, nil
+}
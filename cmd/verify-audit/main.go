@@ -0,0 +1,61 @@
+// Command verify-audit checks a hash-chained audit log for gaps and modifications and reports the first broken
+// link. It exits with status 1 when the chain is broken.
+//
+//	verify-audit -in data/audit.ndjson -head 3f5a...
+//
+// Pass the head hash reported by an earlier run with -head to also detect records cut off the end of the log.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/audit"
+)
+
+func main() {
+	log.SetFlags(0)
+	in := flag.String("in", os.Getenv("AUDIT_LOG_PATH"), "audit log to verify, defaults to $AUDIT_LOG_PATH")
+	head := flag.String("head", "", "hash of a record the log must still contain, e.g. the head of an earlier run")
+	flag.Parse()
+	if *in == "" {
+		log.Fatalf("usage: verify-audit -in audit.ndjson [-head hash]")
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("Error opening %s: %v", *in, err)
+	}
+	defer file.Close()
+	result, err := audit.VerifyChain(file)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", *in, err)
+	}
+	headFound := *head == "" || *head == audit.GenesisHash
+	if !headFound && result.Broken == nil {
+		if _, err := file.Seek(0, 0); err != nil {
+			log.Fatalf("Error reading %s: %v", *in, err)
+		}
+		headFound, err = audit.ContainsHash(file, *head)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", *in, err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatalf("Error printing result: %v", err)
+	}
+	switch {
+	case result.Broken != nil:
+		log.Printf("audit chain broken at line %d (record %d): %s", result.Broken.Line, result.Broken.Seq, result.Broken.Reason)
+		os.Exit(1)
+	case !headFound:
+		log.Printf("audit chain no longer contains the expected head %s; records were removed from its end", *head)
+		os.Exit(1)
+	}
+	log.Printf("audit chain intact: %d records", result.Records)
+}
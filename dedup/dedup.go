@@ -0,0 +1,160 @@
+package dedup
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Policy decides what happens to a receipt that looks like a near-duplicate of a stored one.
+type Policy string
+
+const (
+	// PolicyReject refuses near-duplicates outright.
+	PolicyReject Policy = "reject"
+	// PolicyReview stores near-duplicates but holds their points until an operator reviews them.
+	PolicyReview Policy = "review"
+	// PolicyAllow stores near-duplicates like any other receipt, e.g. for clients where identical purchases are legitimate.
+	PolicyAllow Policy = "allow"
+)
+
+// ParsePolicy reads a policy from configuration. An empty value defaults to PolicyReject.
+func ParsePolicy(raw string) (Policy, error) {
+	switch Policy(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", PolicyReject:
+		return PolicyReject, nil
+	case PolicyReview:
+		return PolicyReview, nil
+	case PolicyAllow:
+		return PolicyAllow, nil
+	default:
+		return "", errors.Errorf("ParsePolicy: unknown duplicate policy %q", raw)
+	}
+}
+
+// Canonicalize returns the form of a receipt that identifies the purchase: text is trimmed, case-folded and has its
+// inner whitespace collapsed, items are sorted, and every field the server fills in is cleared. Two receipts for
+// the same purchase canonicalize to the same value however their items were ordered or spaced.
+func Canonicalize(receipt models.Receipt) models.Receipt {
+	canonical := models.Receipt{
+		UserID:       strings.TrimSpace(receipt.UserID),
+		Retailer:     normalizeText(receipt.Retailer),
+		PurchaseDate: strings.TrimSpace(receipt.PurchaseDate),
+		PurchaseTime: strings.TrimSpace(receipt.PurchaseTime),
+		Total:        strings.TrimSpace(receipt.Total),
+		Items:        make([]models.Item, 0, len(receipt.Items)),
+	}
+	for _, item := range receipt.Items {
+		canonical.Items = append(canonical.Items, models.Item{
+			ShortDescription: normalizeText(item.ShortDescription),
+			Price:            strings.TrimSpace(item.Price),
+		})
+	}
+	sort.Slice(canonical.Items, func(i, j int) bool {
+		if canonical.Items[i].ShortDescription != canonical.Items[j].ShortDescription {
+			return canonical.Items[i].ShortDescription < canonical.Items[j].ShortDescription
+		}
+		return canonical.Items[i].Price < canonical.Items[j].Price
+	})
+	return canonical
+}
+
+// normalizeText trims, case-folds and collapses inner whitespace.
+func normalizeText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// candidate is a stored receipt that later receipts are compared against.
+type candidate struct {
+	receiptID  string
+	totalCents int64
+}
+
+// Detector finds receipts that are probably the same purchase even though they are not byte-for-byte identical:
+// same user, same retailer and same purchase date and time, with totals no more than a tolerance apart. Receipts of
+// different users are never compared, since two shoppers can make the same purchase at the same till minute.
+type Detector struct {
+	toleranceCents int64
+	// index groups stored receipts by user, retailer and purchase timestamp.
+	index map[string][]candidate
+	lock  sync.RWMutex
+}
+
+// NewDetector returns an empty detector that treats totals up to toleranceCents apart as the same.
+func NewDetector(toleranceCents int64) *Detector {
+	return &Detector{toleranceCents: toleranceCents, index: make(map[string][]candidate)}
+}
+
+// FindSimilar returns the ID of a stored receipt that the given receipt is a near-duplicate of.
+func (d *Detector) FindSimilar(receipt models.Receipt) (string, bool) {
+	key, totalCents, ok := similarityKey(receipt)
+	if !ok {
+		return "", false
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, stored := range d.index[key] {
+		difference := stored.totalCents - totalCents
+		if difference < 0 {
+			difference = -difference
+		}
+		if difference <= d.toleranceCents {
+			return stored.receiptID, true
+		}
+	}
+	return "", false
+}
+
+// Add makes a stored receipt available for comparison. Adding a receipt that is already there replaces it.
+func (d *Detector) Add(receiptID string, receipt models.Receipt) {
+	key, totalCents, ok := similarityKey(receipt)
+	if !ok {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, stored := range d.index[key] {
+		if stored.receiptID == receiptID {
+			d.index[key][i].totalCents = totalCents
+			return
+		}
+	}
+	d.index[key] = append(d.index[key], candidate{receiptID: receiptID, totalCents: totalCents})
+}
+
+// Remove forgets a receipt, e.g. after it was rejected in review.
+func (d *Detector) Remove(receiptID string, receipt models.Receipt) {
+	key, _, ok := similarityKey(receipt)
+	if !ok {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	candidates := d.index[key]
+	for i, stored := range candidates {
+		if stored.receiptID == receiptID {
+			d.index[key] = append(candidates[:i:i], candidates[i+1:]...)
+			break
+		}
+	}
+	if len(d.index[key]) == 0 {
+		delete(d.index, key)
+	}
+}
+
+// similarityKey returns the user, retailer and timestamp bucket of a receipt and its total in cents.
+func similarityKey(receipt models.Receipt) (string, int64, bool) {
+	totalCents, err := strconv.ParseInt(strings.ReplaceAll(strings.TrimSpace(receipt.Total), ".", ""), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	key := strings.TrimSpace(receipt.UserID) + "\x00" + normalizeText(receipt.Retailer) + "\x00" + strings.TrimSpace(receipt.PurchaseDate) + "\x00" + strings.TrimSpace(receipt.PurchaseTime)
+	return key, totalCents, true
+}
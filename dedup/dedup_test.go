@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// sampleReceipt returns a receipt for testing.
+func sampleReceipt() models.Receipt {
+	return models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Doritos", Price: "3.50"},
+		},
+		Total: "5.75",
+	}
+}
+
+// TestCanonicalize tests that cosmetic differences canonicalize to the same receipt.
+func TestCanonicalize(t *testing.T) {
+	original := sampleReceipt()
+	variant := sampleReceipt()
+	variant.Retailer = "  m&m  corner MARKET "
+	variant.Items = []models.Item{
+		{ShortDescription: "doritos", Price: "3.50"},
+		{ShortDescription: "Gatorade ", Price: "2.25"},
+	}
+	variant.Points = 109
+	variant.Tier = "Gold"
+
+	if !reflect.DeepEqual(Canonicalize(original), Canonicalize(variant)) {
+		t.Errorf("Canonicalize() differs:\n%+v\n%+v", Canonicalize(original), Canonicalize(variant))
+	}
+
+	different := sampleReceipt()
+	different.Items[0].Price = "2.26"
+	if reflect.DeepEqual(Canonicalize(original), Canonicalize(different)) {
+		t.Error("Canonicalize() of receipts with different prices should differ")
+	}
+}
+
+// TestDetectorFindSimilar tests near-duplicate detection against the total tolerance, and that a receipt added
+// twice is removed at once.
+func TestDetectorFindSimilar(t *testing.T) {
+	detector := NewDetector(50)
+	detector.Add("stored", sampleReceipt())
+
+	testCases := []struct {
+		name     string
+		modify   func(*models.Receipt)
+		expected bool
+	}{
+		{"Total within tolerance", func(r *models.Receipt) { r.Total = "6.25" }, true},
+		{"Total outside tolerance", func(r *models.Receipt) { r.Total = "6.26" }, false},
+		{"Retailer spelled differently", func(r *models.Receipt) { r.Retailer = "m&m corner market" }, true},
+		{"Different minute", func(r *models.Receipt) { r.PurchaseTime = "14:34" }, false},
+		{"Different retailer", func(r *models.Receipt) { r.Retailer = "Target" }, false},
+		{"Different user", func(r *models.Receipt) { r.UserID = "bob" }, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := sampleReceipt()
+			tc.modify(&receipt)
+			if _, found := detector.FindSimilar(receipt); found != tc.expected {
+				t.Errorf("FindSimilar() got %v, expected %v", found, tc.expected)
+			}
+		})
+	}
+
+	detector.Add("stored", sampleReceipt())
+	detector.Remove("stored", sampleReceipt())
+	if _, found := detector.FindSimilar(sampleReceipt()); found {
+		t.Error("FindSimilar() found a removed receipt, expected adding it twice to keep one entry")
+	}
+}
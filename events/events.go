@@ -0,0 +1,102 @@
+package events
+
+import (
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+// Type names a step in a receipt's lifecycle.
+type Type string
+
+const (
+	// Submitted records a receipt as it was received.
+	Submitted Type = "submitted"
+	// Validated records that a receipt passed validation, or that a review approved it.
+	Validated Type = "validated"
+	// Flagged records why a receipt was flagged and whether its points are held for review.
+	Flagged Type = "flagged"
+	// Scored records the points a receipt earned and how they were derived.
+	Scored Type = "scored"
+	// Adjusted records a change to a scored receipt's points, e.g. after its user's history changed.
+	Adjusted Type = "adjusted"
+	// Refunded records items returned against a receipt and the points clawed back for them.
+	Refunded Type = "refunded"
+	// Voided records a receipt whose points were voided, or that was replaced by an import.
+	Voided Type = "voided"
+	// Posted records a ledger transaction that belongs to no receipt: an operator's adjustment or an expiry. A promoted
+	// follower records every transaction it copied this way.
+	Posted Type = "posted"
+	// Listed records a reward added to the catalog or changed.
+	Listed Type = "listed"
+	// Redeemed records a redemption, or its cancellation, and the ledger transaction it posted.
+	Redeemed Type = "redeemed"
+	// Copied records the state of a receipt copied from a leader, as a promoted follower holds it. Its points are in
+	// the transactions of the follower's Posted events.
+	Copied Type = "copied"
+	// Reset records that the store was emptied, e.g. by a promoted follower before it records the state it copied.
+	Reset Type = "reset"
+)
+
+// Reasons qualify events that happen for more than one reason.
+const (
+	// ReasonReviewApproved marks the Validated event of a receipt approved in review.
+	ReasonReviewApproved = "review-approved"
+	// ReasonReviewRejected marks the Voided event of a receipt rejected in review.
+	ReasonReviewRejected = "review-rejected"
+	// ReasonHistory marks the Adjusted event of a receipt re-scored after an earlier purchase arrived.
+	ReasonHistory = "history"
+	// ReasonImported marks the events of a receipt imported from a snapshot, which keep its recorded points.
+	ReasonImported = "imported"
+	// ReasonReplaced marks the Voided event of a receipt about to be replaced by an imported one.
+	ReasonReplaced = "replaced"
+	// ReasonCancelled marks the Redeemed event of a cancelled redemption.
+	ReasonCancelled = "cancelled"
+)
+
+// Event is one step in a receipt's lifecycle, or a change to balances or the rewards catalog outside any receipt, which
+// has no receipt ID. Only the fields of its type are set.
+type Event struct {
+	Seq       uint64    `json:"seq"`
+	Type      Type      `json:"type"`
+	ReceiptID string    `json:"receiptId"`
+	At        time.Time `json:"at"`
+	Reason    string    `json:"reason,omitempty"`
+
+	// Receipt is the submitted receipt, or the state of a copied one.
+	Receipt *models.Receipt `json:"receipt,omitempty"`
+	// ContentID is the content-addressed ID of a validated receipt.
+	ContentID string `json:"contentId,omitempty"`
+	// Flags, RiskScore and Held describe a flagged receipt.
+	Flags     []string `json:"flags,omitempty"`
+	RiskScore int      `json:"riskScore,omitempty"`
+	Held      bool     `json:"held,omitempty"`
+	// Score is the result of scoring a receipt, or the re-scored history bonuses of an adjusted one.
+	Score *Score `json:"score,omitempty"`
+	// Delta is the change in points of an adjusted or refunded receipt.
+	Delta int `json:"delta,omitempty"`
+	// Reference is the refund ID of a refund, or the receipt that caused an adjustment.
+	Reference string `json:"reference,omitempty"`
+	// Items are the refunded items, or every item refunded from a copied receipt.
+	Items []models.Item `json:"items,omitempty"`
+	// Transaction is the posted ledger transaction. Replaying it posts it again under a new ID.
+	Transaction *ledger.Transaction `json:"transaction,omitempty"`
+	// Catalog is the state of the listed reward, or of the redemption and its reward.
+	Catalog *rewards.Change `json:"catalog,omitempty"`
+}
+
+// Score records how a receipt's points were derived.
+type Score struct {
+	BasePoints     int                `json:"basePoints"`
+	Tier           string             `json:"tier,omitempty"`
+	TierMultiplier int                `json:"tierMultiplier,omitempty"`
+	HistoryBonuses []models.RuleBonus `json:"historyBonuses,omitempty"`
+	Points         int                `json:"points"`
+}
+
+// Projection derives state from events. Applying the same events in the same order always derives the same state.
+type Projection interface {
+	Apply(event Event) error
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// TestLogFileRoundTrip tests that a reopened log file continues its sequence and replays the events it holds.
+func TestLogFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	l, err := OpenLog(path, fakeClock)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	receipt := models.Receipt{UserID: "alice", Retailer: "Target"}
+	if _, err := l.Append(Event{Type: Submitted, ReceiptID: "r1", Receipt: &receipt}, Event{Type: Scored, ReceiptID: "r1", Score: &Score{Points: 28}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	l.Close()
+
+	l, err = OpenLog(path, fakeClock)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer l.Close()
+	appended, err := l.Append(Event{Type: Refunded, ReceiptID: "r1", Delta: -3})
+	if err != nil || appended[0].Seq != 3 {
+		t.Fatalf("Append() = %+v, %v; expected event 3", appended, err)
+	}
+
+	points := NewPoints()
+	if err := Replay(l.Each, points); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if points.Receipts["r1"] != 25 || points.Users["alice"] != 25 {
+		t.Errorf("Points = %v / %v, expected 25 for r1 and alice", points.Receipts, points.Users)
+	}
+
+	for after, expected := range []string{"[1 2 3]", "[2 3]", "[3]", "[]"} {
+		var seqs []uint64
+		if err := l.EachAfter(uint64(after), func(event Event) error {
+			seqs = append(seqs, event.Seq)
+			return nil
+		}); err != nil {
+			t.Fatalf("EachAfter(%d) error = %v", after, err)
+		}
+		if got := fmt.Sprint(seqs); got != expected {
+			t.Errorf("EachAfter(%d) = %s, expected %s", after, got, expected)
+		}
+	}
+}
+
+// TestLogFileSurvivesTornWrites tests that a line cut short by a crash is dropped when the log is opened, and that a
+// log whose failed write cannot be cut off refuses further appends.
+func TestLogFileSurvivesTornWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	l, err := OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	if _, err := l.Append(Event{Type: Refunded, ReceiptID: "r1"}, Event{Type: Refunded, ReceiptID: "r2"}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	l.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	f.WriteString(`{"seq":3,"type":"ref`)
+	f.Close()
+
+	l, err = OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() after a torn write error = %v", err)
+	}
+	if appended, err := l.Append(Event{Type: Refunded, ReceiptID: "r3"}); err != nil || appended[0].Seq != 3 {
+		t.Fatalf("Append() = %+v, %v; expected event 3", appended, err)
+	}
+	var receipts []string
+	if err := l.Each(func(event Event) error {
+		receipts = append(receipts, event.ReceiptID)
+		return nil
+	}); err != nil || fmt.Sprint(receipts) != "[r1 r2 r3]" {
+		t.Errorf("Each() read %v, %v; expected [r1 r2 r3]", receipts, err)
+	}
+
+	l.file.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := l.Append(Event{Type: Refunded, ReceiptID: "r4"}); err == nil {
+			t.Errorf("Append() %d to a closed file succeeded", i+1)
+		}
+	}
+	if l.LastSeq() != 3 || l.err == nil {
+		t.Errorf("LastSeq() = %d and err = %v after failed appends, expected 3 and a refusal", l.LastSeq(), l.err)
+	}
+}
+
+// TestPointsVoided tests that voiding a receipt takes its points back from its user.
+func TestPointsVoided(t *testing.T) {
+	l := NewLog(clock.Real{})
+	receipt := models.Receipt{UserID: "alice"}
+	_, err := l.Append(
+		Event{Type: Submitted, ReceiptID: "r1", Receipt: &receipt},
+		Event{Type: Scored, ReceiptID: "r1", Score: &Score{Points: 40}},
+		Event{Type: Adjusted, ReceiptID: "r1", Delta: 10, Reason: ReasonHistory},
+		Event{Type: Voided, ReceiptID: "r1", Reason: ReasonReviewRejected},
+	)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	points := NewPoints()
+	if err := Replay(l.Each, points); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if points.Receipts["r1"] != 0 || points.Users["alice"] != 0 {
+		t.Errorf("Points = %v / %v, expected nothing left", points.Receipts, points.Users)
+	}
+}
+
+// TestPointsCopied tests that a reset drops the points recorded before it and that a copied receipt holds its points.
+func TestPointsCopied(t *testing.T) {
+	l := NewLog(clock.Real{})
+	submitted := models.Receipt{UserID: "alice"}
+	copied := models.Receipt{UserID: "bob", Points: 25}
+	_, err := l.Append(
+		Event{Type: Submitted, ReceiptID: "r1", Receipt: &submitted},
+		Event{Type: Scored, ReceiptID: "r1", Score: &Score{Points: 40}},
+		Event{Type: Reset},
+		Event{Type: Copied, ReceiptID: "r2", Receipt: &copied},
+	)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	points := NewPoints()
+	if err := Replay(l.Each, points); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(points.Receipts) != 1 || points.Receipts["r2"] != 25 || points.Users["bob"] != 25 || points.Users["alice"] != 0 {
+		t.Errorf("Points = %v / %v, expected only the copied receipt's 25 points", points.Receipts, points.Users)
+	}
+}
@@ -0,0 +1,294 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// Log is an append-only log of events. A log backed by a file appends every event to it as a line of NDJSON and
+// reads it back from the file; an in-memory log keeps its events in memory.
+type Log struct {
+	// events holds the events of an in-memory log.
+	events []Event
+	// file receives the events of a file-backed log, and path is where it lives. offsets holds where each event's
+	// line starts and size where the next one goes, so reads can start at any event.
+	file    *os.File
+	path    string
+	offsets []int64
+	size    int64
+	// sync makes Append wait until the disk holds its events. err is set once a failed write could not be cut off
+	// again, after which the file no longer ends where the log expects and nothing more is appended.
+	sync bool
+	err  error
+	// seq is the sequence number of the newest event.
+	seq   uint64
+	clock clock.Clock
+	lock  sync.RWMutex
+}
+
+// NewLog returns an empty in-memory log that timestamps events with the given clock.
+func NewLog(c clock.Clock) *Log {
+	return &Log{clock: c}
+}
+
+// OpenLog opens the event log file at path, creating it and its directory if needed, and appends new events after the
+// ones it holds. A last line cut short by a crash is dropped. Appends are synced to disk until SyncWrites says
+// otherwise.
+func OpenLog(path string, c clock.Clock) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	if err := dropTornLine(path); err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	l := &Log{path: path, sync: true, clock: c}
+	err := l.readFile(0, func(event Event) error {
+		if event.Seq != l.seq+1 {
+			return errors.Errorf("event %d follows event %d", event.Seq, l.seq)
+		}
+		l.seq = event.Seq
+		return nil
+	})
+	if err == nil {
+		err = l.index()
+	}
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	return l, nil
+}
+
+// SyncWrites sets whether Append waits until the disk holds its events before returning. Without it appends are
+// faster, but the events of the last moments before a power failure can be lost, though never left half written.
+func (l *Log) SyncWrites(sync bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sync = sync
+}
+
+// Append numbers and timestamps events and adds them to the log together, so the events of one change are never
+// interleaved with another's. When the events cannot be written, none of them are added.
+func (l *Log) Append(events ...Event) ([]Event, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.err != nil {
+		return nil, errors.Wrap(l.err, "Append")
+	}
+
+	now := l.clock.Now().UTC()
+	appended := make([]Event, len(events))
+	var lines []byte
+	var offsets []int64
+	for i, event := range events {
+		event.Seq = l.seq + uint64(i) + 1
+		if event.At.IsZero() {
+			event.At = now
+		}
+		appended[i] = event
+		if l.file != nil {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return nil, errors.Wrap(err, "Append")
+			}
+			offsets = append(offsets, l.size+int64(len(lines)))
+			lines = append(append(lines, line...), '\n')
+		}
+	}
+
+	if l.file != nil {
+		if err := l.write(lines); err != nil {
+			return nil, errors.Wrap(err, "Append")
+		}
+		l.offsets = append(l.offsets, offsets...)
+		l.size += int64(len(lines))
+	} else {
+		l.events = append(l.events, appended...)
+	}
+	l.seq += uint64(len(events))
+	return appended, nil
+}
+
+// write adds lines to the end of the log's file. Whatever part of a failed write reached the file is cut off again,
+// so the next write starts on a line of its own. The caller must hold the lock.
+func (l *Log) write(lines []byte) error {
+	_, err := l.file.Write(lines)
+	if err == nil && l.sync {
+		err = l.file.Sync()
+	}
+	if err == nil {
+		return nil
+	}
+	if truncateErr := l.file.Truncate(l.size); truncateErr != nil {
+		l.err = errors.Wrapf(truncateErr, "cutting off a failed write to %s", l.path)
+	}
+	return err
+}
+
+// errEachDone stops reading the log file once Each has seen the last event it covers.
+var errEachDone = errors.New("done")
+
+// Each calls fn with every event in order, stopping at the first error. It covers the events appended before it was
+// called and holds no lock while fn runs, so appends go on meanwhile.
+func (l *Log) Each(fn func(event Event) error) error {
+	return l.EachAfter(0, fn)
+}
+
+// EachAfter calls fn with every event after the given sequence number in order, like Each. A file-backed log starts
+// reading at the first of them rather than at the start of its file.
+func (l *Log) EachAfter(seq uint64, fn func(event Event) error) error {
+	l.lock.RLock()
+	recorded, last, file := l.events, l.seq, l.file
+	var offset int64
+	if file != nil && seq < last {
+		offset = l.offsets[seq]
+	}
+	l.lock.RUnlock()
+
+	if seq >= last {
+		return nil
+	}
+	if file == nil {
+		for _, event := range recorded[seq:last] {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	//Events are written as whole lines before seq counts them, so every line up to the last one is complete
+	err := l.readFile(offset, func(event Event) error {
+		if err := fn(event); err != nil {
+			return err
+		}
+		if event.Seq >= last {
+			return errEachDone
+		}
+		return nil
+	})
+	if err == errEachDone {
+		return nil
+	}
+	return err
+}
+
+// LastSeq returns the sequence number of the newest event, or 0 when the log is empty.
+func (l *Log) LastSeq() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.seq
+}
+
+// Close writes the log's file to disk and closes it.
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return errors.Wrap(err, "Close")
+	}
+	return l.file.Close()
+}
+
+// dropTornLine cuts a last line without a newline off the file at path. Append writes every line with its newline,
+// so only a write interrupted by a crash leaves one. While writes are synced, its events were never acknowledged.
+func dropTornLine(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "dropTornLine")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "dropTornLine")
+	}
+
+	//Search backwards for the end of the last whole line
+	end := info.Size()
+	chunk := make([]byte, 4096)
+	for end > 0 {
+		n := min(int64(len(chunk)), end)
+		if _, err := f.ReadAt(chunk[:n], end-n); err != nil {
+			return errors.Wrap(err, "dropTornLine")
+		}
+		if i := bytes.LastIndexByte(chunk[:n], '\n'); i >= 0 {
+			end += int64(i) + 1 - n
+			break
+		}
+		end -= n
+	}
+	if end == info.Size() {
+		return nil
+	}
+	return errors.Wrap(f.Truncate(end), "dropTornLine")
+}
+
+// readFile calls fn with every event in the log's file from the given offset on.
+func (l *Log) readFile(offset int64, fn func(event Event) error) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return errors.Wrap(err, "readFile")
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "readFile")
+	}
+	return Read(f, fn)
+}
+
+// index records where each event in the log's file starts and where the file ends.
+func (l *Log) index() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return errors.Wrap(err, "index")
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			l.offsets = append(l.offsets, l.size)
+		}
+		l.size += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "index")
+		}
+	}
+}
+
+// Read decodes NDJSON events from r and calls fn with each, stopping at the first error.
+func Read(r io.Reader, fn func(event Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return errors.Wrapf(err, "Read: line %d", line)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "Read")
+}
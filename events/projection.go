@@ -0,0 +1,61 @@
+package events
+
+// Points is a projection of the points each receipt holds and each user has earned from receipts, as recorded in
+// the events. Comparing it before and after a replay shows what the replay changed.
+type Points struct {
+	Receipts map[string]int
+	Users    map[string]int
+	// owners maps receipt IDs to the users that submitted them.
+	owners map[string]string
+}
+
+// NewPoints returns an empty points projection.
+func NewPoints() *Points {
+	return &Points{Receipts: make(map[string]int), Users: make(map[string]int), owners: make(map[string]string)}
+}
+
+// Apply adds the points an event records.
+func (p *Points) Apply(event Event) error {
+	delta := 0
+	switch event.Type {
+	case Submitted:
+		if event.Receipt != nil {
+			p.owners[event.ReceiptID] = event.Receipt.UserID
+		}
+	case Scored:
+		if event.Score != nil {
+			delta = event.Score.Points - p.Receipts[event.ReceiptID]
+		}
+	case Adjusted, Refunded:
+		delta = event.Delta
+	case Voided:
+		delta = -p.Receipts[event.ReceiptID]
+	case Copied:
+		if event.Receipt != nil {
+			p.owners[event.ReceiptID] = event.Receipt.UserID
+			delta = event.Receipt.Points - p.Receipts[event.ReceiptID]
+		}
+	case Reset:
+		*p = *NewPoints()
+	}
+	if delta == 0 {
+		return nil
+	}
+	p.Receipts[event.ReceiptID] += delta
+	if userID := p.owners[event.ReceiptID]; userID != "" {
+		p.Users[userID] += delta
+	}
+	return nil
+}
+
+// Replay applies every event from each to the projections in order.
+func Replay(each func(fn func(event Event) error) error, projections ...Projection) error {
+	return each(func(event Event) error {
+		for _, projection := range projections {
+			if err := projection.Apply(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
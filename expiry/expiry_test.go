@@ -0,0 +1,110 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// post records a transfer for alice at the fake clock's current time.
+func post(t *testing.T, pointsLedger *ledger.Ledger, kind ledger.Kind, from, to string, points int) {
+	t.Helper()
+	if _, err := pointsLedger.Post(ledger.Transaction{Kind: kind, Postings: ledger.Transfer(from, to, points)}); err != nil {
+		t.Fatalf("Post() failed: %v", err)
+	}
+}
+
+// TestFIFOPolicySweep tests that lots expire oldest first and that spending consumes the oldest lots.
+func TestFIFOPolicySweep(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	pointsLedger := ledger.NewWithClock(fakeClock)
+	alice := ledger.UserAccount("alice")
+	sweeper := NewSweeper(pointsLedger, FIFOPolicy{Lifetime: 30 * 24 * time.Hour}, fakeClock)
+	var posted []ledger.Transaction
+	sweeper.OnExpire(func(transaction ledger.Transaction) error {
+		posted = append(posted, transaction)
+		return nil
+	})
+
+	post(t, pointsLedger, ledger.KindEarn, ledger.IssuedAccount, alice, 100)
+	fakeClock.Advance(10 * 24 * time.Hour)
+	post(t, pointsLedger, ledger.KindEarn, ledger.IssuedAccount, alice, 50)
+	post(t, pointsLedger, ledger.KindRedeem, alice, ledger.RedeemedAccount, 60)
+
+	// 40 points remain from the first lot, which expires on day 30.
+	fakeClock.Set(start.Add(25 * 24 * time.Hour))
+	upcoming := Within(FIFOPolicy{Lifetime: 30 * 24 * time.Hour}.Schedule(alice, pointsLedger.AccountTransactions(alice)), fakeClock.Now(), 7*24*time.Hour)
+	if len(upcoming) != 1 || upcoming[0].Points != 40 {
+		t.Fatalf("Within() got %+v, expected a single expiration of 40 points", upcoming)
+	}
+
+	fakeClock.Set(start.Add(31 * 24 * time.Hour))
+	for i, expected := range []int{40, 0} {
+		expired, err := sweeper.Sweep()
+		if err != nil {
+			t.Fatalf("Sweep() failed: %v", err)
+		}
+		if expired != expected {
+			t.Errorf("Sweep() pass %d expired %d points, expected %d", i+1, expired, expected)
+		}
+	}
+	if balance := pointsLedger.Balance(alice); balance != 50 {
+		t.Errorf("Balance() got = %d, expected 50", balance)
+	}
+	if len(posted) != 1 || posted[0].Kind != ledger.KindExpire {
+		t.Errorf("OnExpire() saw %+v, expected a single expiry", posted)
+	}
+
+	fakeClock.Set(start.Add(41 * 24 * time.Hour))
+	if _, err := sweeper.Sweep(); err != nil {
+		t.Fatalf("Sweep() failed: %v", err)
+	}
+	if balance := pointsLedger.Balance(alice); balance != 0 {
+		t.Errorf("Balance() got = %d, expected 0", balance)
+	}
+}
+
+// TestInactivityPolicySweep tests that activity pushes back the expiry of the whole balance.
+func TestInactivityPolicySweep(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	pointsLedger := ledger.NewWithClock(fakeClock)
+	alice := ledger.UserAccount("alice")
+	sweeper := NewSweeper(pointsLedger, InactivityPolicy{Period: 90 * 24 * time.Hour}, fakeClock)
+
+	post(t, pointsLedger, ledger.KindEarn, ledger.IssuedAccount, alice, 100)
+	fakeClock.Advance(60 * 24 * time.Hour)
+	post(t, pointsLedger, ledger.KindEarn, ledger.IssuedAccount, alice, 20)
+
+	fakeClock.Advance(89 * 24 * time.Hour)
+	if expired, _ := sweeper.Sweep(); expired != 0 {
+		t.Errorf("Sweep() before the inactivity period expired %d points, expected 0", expired)
+	}
+
+	fakeClock.Advance(2 * 24 * time.Hour)
+	if expired, _ := sweeper.Sweep(); expired != 120 {
+		t.Errorf("Sweep() after the inactivity period expired %d points, expected 120", expired)
+	}
+	if balance := pointsLedger.Balance(ledger.ExpiredAccount); balance != 120 {
+		t.Errorf("Balance(expired) got = %d, expected 120", balance)
+	}
+}
+
+// TestParsePolicy tests building policies from configuration.
+func TestParsePolicy(t *testing.T) {
+	if policy, err := ParsePolicy("", time.Hour); policy != nil || err != nil {
+		t.Errorf("ParsePolicy(\"\") got %v, %v, expected no policy", policy, err)
+	}
+	if _, err := ParsePolicy("fifo", 0); err == nil {
+		t.Error("ParsePolicy(\"fifo\", 0) expected an error")
+	}
+	if _, err := ParsePolicy("weekly", time.Hour); err == nil {
+		t.Error("ParsePolicy(\"weekly\") expected an error")
+	}
+	if policy, err := ParsePolicy("Inactivity", time.Hour); err != nil || policy != (InactivityPolicy{Period: time.Hour}) {
+		t.Errorf("ParsePolicy(\"Inactivity\") got %v, %v", policy, err)
+	}
+}
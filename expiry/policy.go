@@ -0,0 +1,137 @@
+package expiry
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// Expiration is a quantity of points that will expire at a given time unless they are spent first.
+type Expiration struct {
+	Points    int       `json:"points"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Policy decides when the points currently held by an account expire.
+// Schedule is given the account's full history, oldest first, and returns its expirations soonest first.
+type Policy interface {
+	Schedule(account string, history []ledger.Transaction) []Expiration
+}
+
+// InactivityPolicy expires an account's whole balance once it has seen no activity for Period.
+// Expirations themselves do not count as activity.
+type InactivityPolicy struct {
+	Period time.Duration
+}
+
+// Schedule returns the account's balance expiring Period after its last activity.
+func (p InactivityPolicy) Schedule(account string, history []ledger.Transaction) []Expiration {
+	balance := 0
+	var lastActivity time.Time
+	for _, transaction := range history {
+		amount := transaction.Amount(account)
+		balance += amount
+		if amount != 0 && transaction.Kind != ledger.KindExpire {
+			lastActivity = transaction.CreatedAt
+		}
+	}
+	if balance <= 0 || lastActivity.IsZero() {
+		return nil
+	}
+	return []Expiration{{Points: balance, ExpiresAt: lastActivity.Add(p.Period)}}
+}
+
+// FIFOPolicy treats every credit as a lot that expires Lifetime after it was earned.
+// Debits consume the oldest lots first, so points are always spent before they expire.
+type FIFOPolicy struct {
+	Lifetime time.Duration
+}
+
+// lot is a quantity of points credited to an account at a given time.
+type lot struct {
+	points   int
+	earnedAt time.Time
+}
+
+// Schedule returns the remaining points of each lot and when it expires.
+func (p FIFOPolicy) Schedule(account string, history []ledger.Transaction) []Expiration {
+	var lots []lot
+	// debt tracks debits that exceeded every open lot, e.g. a refund clawback after the points were spent.
+	// It is settled from the next credits before they become lots.
+	debt := 0
+	for _, transaction := range history {
+		amount := transaction.Amount(account)
+		switch {
+		case amount > 0:
+			settled := min(debt, amount)
+			debt -= settled
+			if amount -= settled; amount > 0 {
+				lots = append(lots, lot{points: amount, earnedAt: transaction.CreatedAt})
+			}
+		case amount < 0:
+			owed := -amount
+			for owed > 0 && len(lots) > 0 {
+				consumed := min(owed, lots[0].points)
+				lots[0].points -= consumed
+				owed -= consumed
+				if lots[0].points == 0 {
+					lots = lots[1:]
+				}
+			}
+			debt += owed
+		}
+	}
+
+	expirations := make([]Expiration, 0, len(lots))
+	for _, open := range lots {
+		expirations = append(expirations, Expiration{Points: open.points, ExpiresAt: open.earnedAt.Add(p.Lifetime)})
+	}
+	sort.SliceStable(expirations, func(i, j int) bool { return expirations[i].ExpiresAt.Before(expirations[j].ExpiresAt) })
+	return expirations
+}
+
+// ParsePolicy builds a policy from its configuration name. An empty name or "none" disables expiration.
+func ParsePolicy(name string, period time.Duration) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return nil, nil
+	case "inactivity":
+		if period <= 0 {
+			return nil, errors.New("ParsePolicy: inactivity period must be positive")
+		}
+		return InactivityPolicy{Period: period}, nil
+	case "fifo":
+		if period <= 0 {
+			return nil, errors.New("ParsePolicy: lot lifetime must be positive")
+		}
+		return FIFOPolicy{Lifetime: period}, nil
+	default:
+		return nil, errors.Errorf("ParsePolicy: unknown expiry policy %q", name)
+	}
+}
+
+// Due returns the total points in a schedule that have expired at the given time.
+func Due(schedule []Expiration, now time.Time) int {
+	due := 0
+	for _, expiration := range schedule {
+		if !expiration.ExpiresAt.After(now) {
+			due += expiration.Points
+		}
+	}
+	return due
+}
+
+// Within returns the expirations in a schedule that fall after now but no later than now plus window.
+func Within(schedule []Expiration, now time.Time, window time.Duration) []Expiration {
+	deadline := now.Add(window)
+	var upcoming []Expiration
+	for _, expiration := range schedule {
+		if expiration.ExpiresAt.After(now) && !expiration.ExpiresAt.After(deadline) {
+			upcoming = append(upcoming, expiration)
+		}
+	}
+	return upcoming
+}
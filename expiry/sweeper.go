@@ -0,0 +1,122 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// Sweeper periodically writes expiration entries to the ledger for every user whose points are due to expire.
+type Sweeper struct {
+	ledger *ledger.Ledger
+	policy Policy
+	clock  clock.Clock
+	// paused, when set, makes Run skip its sweeps while it returns true.
+	paused func() bool
+	// onExpire, when set, is told about every expiry posted.
+	onExpire func(transaction ledger.Transaction) error
+	// lock, when set, is held while an account's expiry is posted and onExpire told about it.
+	lock sync.Locker
+}
+
+// NewSweeper returns a sweeper that applies the policy to the ledger, reading the time from the given clock.
+func NewSweeper(pointsLedger *ledger.Ledger, policy Policy, c clock.Clock) *Sweeper {
+	return &Sweeper{ledger: pointsLedger, policy: policy, clock: c}
+}
+
+// PauseWhile makes Run skip its sweeps while paused returns true, e.g. while a replica applies another
+// instance's expiries instead of writing its own.
+func (s *Sweeper) PauseWhile(paused func() bool) {
+	s.paused = paused
+}
+
+// OnExpire calls fn with every expiry transaction after it is posted, e.g. to record it. An error from fn stops the
+// sweep.
+func (s *Sweeper) OnExpire(fn func(transaction ledger.Transaction) error) {
+	s.onExpire = fn
+}
+
+// LockWith makes Sweep hold lock while it posts each expiry and reports it to OnExpire, e.g. so the two happen in
+// order with other changes to the ledger.
+func (s *Sweeper) LockWith(lock sync.Locker) {
+	s.lock = lock
+}
+
+// Sweep makes a single pass over every user account and expires the points that are due.
+// It returns the total number of points expired. Sweeping again at the same time is a no-op.
+func (s *Sweeper) Sweep() (int, error) {
+	now := s.clock.Now()
+	expired := 0
+	for _, account := range s.ledger.Accounts() {
+		if !ledger.IsUserAccount(account) {
+			continue
+		}
+		points, err := s.expire(account, now)
+		expired += points
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// expire posts the expiry of an account's due points, if any, and reports it. It returns the points expired.
+func (s *Sweeper) expire(account string, now time.Time) (int, error) {
+	if s.lock != nil {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+	}
+	transaction, err := s.ledger.PostFor(account, func(history []ledger.Transaction) *ledger.Transaction {
+		due := Due(s.policy.Schedule(account, history), now)
+		if due <= 0 {
+			return nil
+		}
+		return &ledger.Transaction{
+			Kind:      ledger.KindExpire,
+			Memo:      "Points expired",
+			Postings:  ledger.Transfer(account, ledger.ExpiredAccount, due),
+			CreatedAt: now.UTC(),
+		}
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "Sweep: expiring %s", account)
+	}
+	if transaction == nil {
+		return 0, nil
+	}
+	points := transaction.Amount(ledger.ExpiredAccount)
+	if s.onExpire != nil {
+		if err := s.onExpire(*transaction); err != nil {
+			return points, errors.Wrapf(err, "Sweep: recording the expiry of %s", account)
+		}
+	}
+	return points, nil
+}
+
+// Run sweeps every interval until the context is cancelled.
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.paused != nil && s.paused() {
+				continue
+			}
+			expired, err := s.Sweep()
+			if err != nil {
+				log.Printf("expiry sweeper: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("expiry sweeper: expired %d points", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package feed
+
+import (
+	"sync"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Item is a receipt on the feed. IDs increase with every item, so a consumer can resume after the last one it saw.
+type Item struct {
+	ID      uint64
+	Receipt models.ReceiptFeedEvent
+}
+
+// Filter selects the items a subscriber wants. A nil filter selects every item.
+type Filter func(Item) bool
+
+// Hub fans published items out to subscribers and keeps the most recent ones in a ring buffer, so a subscriber
+// that reconnects can catch up on what it missed. Publishing never blocks: a subscriber that falls a full buffer
+// behind is dropped, and is expected to reconnect and resume from the ring.
+type Hub struct {
+	ring []Item
+	// next is the ring index the next item is written to, and size the number of items held.
+	next int
+	size int
+	// evicted is the ID of the newest item pushed out of the ring. Resuming from before it misses items.
+	evicted          uint64
+	subscribers      map[*Subscription]struct{}
+	subscriberBuffer int
+	lock             sync.Mutex
+}
+
+// NewHub returns a hub that keeps the last capacity items and lets each subscriber fall up to subscriberBuffer
+// items behind.
+func NewHub(capacity int, subscriberBuffer int) *Hub {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if subscriberBuffer < 1 {
+		subscriberBuffer = 1
+	}
+	return &Hub{
+		ring:             make([]Item, capacity),
+		subscribers:      make(map[*Subscription]struct{}),
+		subscriberBuffer: subscriberBuffer,
+	}
+}
+
+// Subscription is one consumer of a hub.
+type Subscription struct {
+	// C receives the items published after the subscription was made that pass its filter.
+	C <-chan Item
+	// Backlog holds the buffered items after the resumed ID that pass the filter, oldest first.
+	Backlog []Item
+	// Gap is set when items after the resumed ID have already left the ring, so the backlog misses some.
+	Gap bool
+
+	items   chan Item
+	filter  Filter
+	dropped chan struct{}
+}
+
+// Dropped is closed when the subscription is dropped for falling behind, or cancelled.
+func (s *Subscription) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+// Publish adds an item to the ring and sends it to every subscriber that wants it, without waiting for any of them.
+func (h *Hub) Publish(item Item) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.size == len(h.ring) {
+		h.evicted = h.ring[h.next].ID
+	} else {
+		h.size++
+	}
+	h.ring[h.next] = item
+	h.next = (h.next + 1) % len(h.ring)
+
+	for subscription := range h.subscribers {
+		if subscription.filter != nil && !subscription.filter(item) {
+			continue
+		}
+		select {
+		case subscription.items <- item:
+		default:
+			h.drop(subscription)
+		}
+	}
+}
+
+// Subscribe starts a subscription to the items after the given ID. Pass 0 to only receive new items.
+func (h *Hub) Subscribe(after uint64, filter Filter) *Subscription {
+	items := make(chan Item, h.subscriberBuffer)
+	subscription := &Subscription{C: items, items: items, filter: filter, dropped: make(chan struct{})}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if after > 0 {
+		subscription.Gap = after < h.evicted
+		start := (h.next - h.size + len(h.ring)) % len(h.ring)
+		for i := 0; i < h.size; i++ {
+			item := h.ring[(start+i)%len(h.ring)]
+			if item.ID > after && (filter == nil || filter(item)) {
+				subscription.Backlog = append(subscription.Backlog, item)
+			}
+		}
+	}
+	h.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Unsubscribe ends a subscription. It is safe to call more than once, and after the subscription was dropped.
+func (h *Hub) Unsubscribe(subscription *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.drop(subscription)
+}
+
+// drop removes a subscriber and tells it so. The caller must hold the lock.
+func (h *Hub) drop(subscription *Subscription) {
+	if _, found := h.subscribers[subscription]; !found {
+		return
+	}
+	delete(h.subscribers, subscription)
+	close(subscription.dropped)
+}
+
+// Subscribers returns the number of live subscriptions.
+func (h *Hub) Subscribers() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subscribers)
+}
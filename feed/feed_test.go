@@ -0,0 +1,95 @@
+package feed
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+func item(id uint64, points int) Item {
+	return Item{ID: id, Receipt: models.ReceiptFeedEvent{ID: "r", Points: points}}
+}
+
+func ids(items []Item) []uint64 {
+	var out []uint64
+	for _, i := range items {
+		out = append(out, i.ID)
+	}
+	return out
+}
+
+// TestSubscribeResumesFromRing tests that a subscriber resuming after an ID gets the buffered items after it that
+// pass its filter, and is told when some of them already left the ring.
+func TestSubscribeResumesFromRing(t *testing.T) {
+	hub := NewHub(3, 10)
+	for id := uint64(1); id <= 5; id++ {
+		hub.Publish(item(id, int(id)*10))
+	}
+
+	tests := []struct {
+		name    string
+		after   uint64
+		filter  Filter
+		want    []uint64
+		wantGap bool
+	}{
+		{"New items only", 0, nil, nil, false},
+		{"Within the ring", 3, nil, []uint64{4, 5}, false},
+		{"Oldest in the ring", 2, nil, []uint64{3, 4, 5}, false},
+		{"Before the ring", 1, nil, []uint64{3, 4, 5}, true},
+		{"Filtered", 2, func(i Item) bool { return i.Receipt.Points >= 40 }, []uint64{4, 5}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription := hub.Subscribe(test.after, test.filter)
+			defer hub.Unsubscribe(subscription)
+			if got := ids(subscription.Backlog); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Backlog = %v, want %v", got, test.want)
+			}
+			if subscription.Gap != test.wantGap {
+				t.Errorf("Gap = %v, want %v", subscription.Gap, test.wantGap)
+			}
+		})
+	}
+}
+
+// TestSlowSubscriberIsDropped tests that publishing does not wait for a subscriber that stopped reading, and drops
+// it once its buffer is full, while other subscribers keep receiving.
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	hub := NewHub(100, 2)
+	slow := hub.Subscribe(0, nil)
+	fast := hub.Subscribe(0, nil)
+
+	var received []uint64
+	for id := uint64(1); id <= 10; id++ {
+		hub.Publish(item(id, 0))
+		received = append(received, (<-fast.C).ID)
+	}
+
+	select {
+	case <-slow.Dropped():
+	default:
+		t.Fatalf("slow subscriber was not dropped")
+	}
+	select {
+	case <-fast.Dropped():
+		t.Fatalf("fast subscriber was dropped")
+	default:
+	}
+	if len(received) != 10 || received[9] != 10 {
+		t.Errorf("fast subscriber received %v", received)
+	}
+	if hub.Subscribers() != 1 {
+		t.Errorf("Subscribers() = %d, want 1", hub.Subscribers())
+	}
+
+	// The dropped subscriber resumes from the ring after the last item it read.
+	last := (<-slow.C).ID
+	resumed := hub.Subscribe(last, nil)
+	if len(resumed.Backlog) != int(10-last) {
+		t.Errorf("resumed Backlog = %v, want the items after %d", ids(resumed.Backlog), last)
+	}
+	hub.Unsubscribe(resumed)
+	hub.Unsubscribe(resumed)
+}
@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestReceiptConversion tests that a receipt converts to its message and back unchanged.
+func TestReceiptConversion(t *testing.T) {
+	receipt := models.Receipt{
+		UserID:       "alice",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}, {ShortDescription: "Emils Cheese Pizza", Price: "12.25"}},
+		Total:        "18.74",
+	}
+	if got := receiptFromProto(receiptToProto(receipt)); !reflect.DeepEqual(got, receipt) {
+		t.Errorf("receiptFromProto(receiptToProto()) = %+v, want %+v", got, receipt)
+	}
+
+	stored := receipt
+	stored.Points, stored.Status, stored.Flags = 28, models.ReceiptPendingReview, []string{"risk:velocity"}
+	message := storedReceiptToProto(models.StoredReceipt{ID: "r1", Receipt: stored})
+	if message.GetId() != "r1" || message.GetPoints() != 28 || message.GetStatus() != models.ReceiptPendingReview || !reflect.DeepEqual(message.GetFlags(), stored.Flags) {
+		t.Errorf("storedReceiptToProto() = %v", message)
+	}
+}
+
+// fakeService stores receipts under IDs derived from their retailer and rejects receipts without one.
+type fakeService struct {
+	receipts map[string]models.Receipt
+}
+
+func (s *fakeService) SubmitReceipt(_ context.Context, receipt models.Receipt) (models.ReceiptResponse, error) {
+	if receipt.Retailer == "" {
+		return models.ReceiptResponse{}, status.Errorf(codes.InvalidArgument, "retailer validation failed: 100%% required")
+	}
+	receipt.Points = len(receipt.Retailer)
+	s.receipts["id-"+receipt.Retailer] = receipt
+	return models.ReceiptResponse{Id: "id-" + receipt.Retailer}, nil
+}
+
+func (s *fakeService) ReceiptPoints(ctx context.Context, receiptID string) (models.PointsResponse, error) {
+	stored, err := s.StoredReceipt(ctx, receiptID)
+	return models.PointsResponse{Points: stored.Receipt.Points}, err
+}
+
+func (s *fakeService) StoredReceipt(_ context.Context, receiptID string) (models.StoredReceipt, error) {
+	receipt, found := s.receipts[receiptID]
+	if !found {
+		return models.StoredReceipt{}, status.Errorf(codes.NotFound, "receipt %s not found", receiptID)
+	}
+	return models.StoredReceipt{ID: receiptID, Receipt: receipt}, nil
+}
+
+func (s *fakeService) ListReceipts(context.Context, string, int, string) (models.ReceiptPage, error) {
+	return models.ReceiptPage{}, errors.New("listing is broken")
+}
+
+// newTestClient serves a fake service over an in-memory connection and returns a client of it.
+func newTestClient(t *testing.T) (ReceiptServiceClient, *grpc.ClientConn) {
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(&fakeService{receipts: make(map[string]models.Receipt)})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewReceiptServiceClient(conn), conn
+}
+
+// TestUnaryCalls tests that unary calls return their responses, and their errors with the right status codes.
+func TestUnaryCalls(t *testing.T) {
+	ctx := context.Background()
+	client, conn := newTestClient(t)
+
+	created, err := client.ProcessReceipt(ctx, &Receipt{Retailer: "Target"})
+	if err != nil || created.GetId() != "id-Target" {
+		t.Fatalf("ProcessReceipt() = %v, %v", created, err)
+	}
+	if points, err := client.GetPoints(ctx, &GetPointsRequest{Id: created.GetId()}); err != nil || points.GetPoints() != 6 {
+		t.Errorf("GetPoints() = %v, %v; want 6 points", points, err)
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+		wantMsg  string
+	}{
+		{"Invalid argument", func() error { _, err := client.ProcessReceipt(ctx, &Receipt{}); return err }, codes.InvalidArgument, "retailer validation failed: 100% required"},
+		{"Not found", func() error { _, err := client.GetReceipt(ctx, &GetReceiptRequest{Id: "missing"}); return err }, codes.NotFound, "receipt missing not found"},
+		{"Internal", func() error { _, err := client.ListReceipts(ctx, &ListReceiptsRequest{}); return err }, codes.Internal, "listing is broken"},
+		{"Unimplemented", func() error {
+			return conn.Invoke(ctx, "/receiptprocessor.v1.ReceiptService/Unknown", &GetPointsRequest{}, &GetPointsResponse{})
+		}, codes.Unimplemented, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := status.Convert(test.call())
+			if got.Code() != test.wantCode || (test.wantMsg != "" && got.Message() != test.wantMsg) {
+				t.Errorf("status = %v, want code %v and message %q", got, test.wantCode, test.wantMsg)
+			}
+		})
+	}
+}
+
+// TestBatchScoreStream tests that each receipt sent on the stream is answered in order as it arrives, and that a
+// failing receipt does not end the stream.
+func TestBatchScoreStream(t *testing.T) {
+	client, _ := newTestClient(t)
+	stream, err := client.BatchScore(context.Background())
+	if err != nil {
+		t.Fatalf("BatchScore() error = %v", err)
+	}
+	for i, retailer := range []string{"Target", "", "Walgreens"} {
+		if err := stream.Send(&BatchScoreRequest{RequestId: retailer + "-request", Receipt: &Receipt{Retailer: retailer}}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		//Each answer arrives before the next receipt is sent, so the stream really is bidirectional
+		response, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() %d error = %v", i, err)
+		}
+		if response.GetRequestId() != retailer+"-request" {
+			t.Errorf("response %d request ID = %q", i, response.GetRequestId())
+		}
+		if retailer == "" && codes.Code(response.GetCode()) != codes.InvalidArgument {
+			t.Errorf("response %d = %v, want InvalidArgument", i, response)
+		}
+		if retailer != "" && (response.GetCode() != 0 || response.GetPoints() != int64(len(retailer))) {
+			t.Errorf("response %d = %v, want %d points", i, response, len(retailer))
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv() after CloseSend error = %v, want io.EOF", err)
+	}
+}
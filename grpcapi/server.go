@@ -0,0 +1,158 @@
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative receipts.proto
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReceiptService is what the gRPC server calls into. The receipt store implements it with the same validation,
+// scoring and storage as the REST handlers. Methods fail a call with a code by returning a status error, e.g. from
+// status.Errorf; any other error fails it with Internal.
+type ReceiptService interface {
+	SubmitReceipt(ctx context.Context, receipt models.Receipt) (models.ReceiptResponse, error)
+	ReceiptPoints(ctx context.Context, receiptID string) (models.PointsResponse, error)
+	StoredReceipt(ctx context.Context, receiptID string) (models.StoredReceipt, error)
+	ListReceipts(ctx context.Context, userID string, pageSize int, pageToken string) (models.ReceiptPage, error)
+}
+
+// Server adapts a ReceiptService to the ReceiptServiceServer generated from receipts.proto.
+type Server struct {
+	UnimplementedReceiptServiceServer
+	service ReceiptService
+}
+
+// NewServer returns a gRPC server serving the service with the given options, e.g. credentials and interceptors.
+func NewServer(service ReceiptService, options ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(options...)
+	RegisterReceiptServiceServer(server, &Server{service: service})
+	return server
+}
+
+// ProcessReceipt scores and stores a receipt.
+func (s *Server) ProcessReceipt(ctx context.Context, request *Receipt) (*ProcessReceiptResponse, error) {
+	response, err := s.service.SubmitReceipt(ctx, receiptFromProto(request))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &ProcessReceiptResponse{Id: response.Id, Status: response.Status}, nil
+}
+
+// GetPoints returns a receipt's points.
+func (s *Server) GetPoints(ctx context.Context, request *GetPointsRequest) (*GetPointsResponse, error) {
+	response, err := s.service.ReceiptPoints(ctx, request.GetId())
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &GetPointsResponse{Points: int64(response.Points)}, nil
+}
+
+// GetReceipt returns a stored receipt.
+func (s *Server) GetReceipt(ctx context.Context, request *GetReceiptRequest) (*StoredReceipt, error) {
+	stored, err := s.service.StoredReceipt(ctx, request.GetId())
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return storedReceiptToProto(stored), nil
+}
+
+// ListReceipts returns a page of stored receipts.
+func (s *Server) ListReceipts(ctx context.Context, request *ListReceiptsRequest) (*ListReceiptsResponse, error) {
+	page, err := s.service.ListReceipts(ctx, request.GetUserId(), int(request.GetPageSize()), request.GetPageToken())
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	response := &ListReceiptsResponse{NextPageToken: page.NextPageToken}
+	for _, stored := range page.Receipts {
+		response.Receipts = append(response.Receipts, storedReceiptToProto(stored))
+	}
+	return response, nil
+}
+
+// BatchScore submits each receipt read from the stream and answers it as soon as its outcome is known. A receipt
+// that fails is answered with its status; the stream carries on.
+func (s *Server) BatchScore(stream ReceiptService_BatchScoreServer) error {
+	ctx := stream.Context()
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		outcome := &BatchScoreResponse{RequestId: request.GetRequestId()}
+		response, err := s.service.SubmitReceipt(ctx, receiptFromProto(request.GetReceipt()))
+		if err != nil {
+			failed := statusOf(err)
+			outcome.Code, outcome.Error = int32(status.Code(failed)), status.Convert(failed).Message()
+		} else {
+			outcome.Id, outcome.Status = response.Id, response.Status
+			if points, err := s.service.ReceiptPoints(ctx, response.Id); err == nil {
+				outcome.Points = int64(points.Points)
+			}
+		}
+		if err := stream.Send(outcome); err != nil {
+			return err
+		}
+	}
+}
+
+// statusOf returns a service error as a status error, logging and failing with Internal any error that is not one.
+func statusOf(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	log.Printf("grpcapi: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+// receiptFromProto returns the submitted fields of a receipt message.
+func receiptFromProto(message *Receipt) models.Receipt {
+	receipt := models.Receipt{
+		UserID:       message.GetUserId(),
+		Retailer:     message.GetRetailer(),
+		PurchaseDate: message.GetPurchaseDate(),
+		PurchaseTime: message.GetPurchaseTime(),
+		Items:        []models.Item{},
+		Total:        message.GetTotal(),
+	}
+	for _, item := range message.GetItems() {
+		receipt.Items = append(receipt.Items, models.Item{ShortDescription: item.GetShortDescription(), Price: item.GetPrice()})
+	}
+	return receipt
+}
+
+// receiptToProto returns the submitted fields of a receipt as a message.
+func receiptToProto(receipt models.Receipt) *Receipt {
+	message := &Receipt{
+		UserId:       receipt.UserID,
+		Retailer:     receipt.Retailer,
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+		Total:        receipt.Total,
+	}
+	for _, item := range receipt.Items {
+		message.Items = append(message.Items, &Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	return message
+}
+
+// storedReceiptToProto returns a stored receipt, its points, status and flags as a message.
+func storedReceiptToProto(stored models.StoredReceipt) *StoredReceipt {
+	return &StoredReceipt{
+		Id:      stored.ID,
+		Receipt: receiptToProto(stored.Receipt),
+		Points:  int64(stored.Receipt.Points),
+		Status:  stored.Receipt.Status,
+		Flags:   stored.Receipt.Flags,
+	}
+}
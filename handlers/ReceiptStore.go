@@ -1,26 +1,428 @@
 package handlers
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/graphql-go/graphql"
+	"github.com/praveensundaram1/receipt-processor-challenge/audit"
+	"github.com/praveensundaram1/receipt-processor-challenge/auth"
+	"github.com/praveensundaram1/receipt-processor-challenge/dedup"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/expiry"
+	"github.com/praveensundaram1/receipt-processor-challenge/feed"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
 	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/ratelimit"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptcsv"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/receipttext"
+	"github.com/praveensundaram1/receipt-processor-challenge/replication"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+	"github.com/praveensundaram1/receipt-processor-challenge/risk"
+	"github.com/praveensundaram1/receipt-processor-challenge/sinks"
+	"github.com/praveensundaram1/receipt-processor-challenge/storage"
+	"github.com/praveensundaram1/receipt-processor-challenge/webhooks"
 )
 
 /*
 ReceiptStore is a struct that represents the receipt store.
-It contains a map of receipts and a mutex for synchronizing access to shared resources.
+It contains the receipts, sharded by ID, and the indexes over them.
+Locks are always taken in the order owner lock, record lock, store lock, shard lock, so they cannot deadlock.
 */
 
 type ReceiptStore struct {
-	// receipts is a map that stores receipts by their unique identifier.
-	// The key is a string representing the identifier, and the value is an instance of model.Receipt.
-	receipts map[string]models.Receipt //maybe change to pointer
-	// used for synchronizing access to shared resources.
+	// eventLog records every step of every receipt's lifecycle. The receipts, their indexes and their ledger entries
+	// are projections of it, so they can be rebuilt by replaying it.
+	eventLog *events.Log
+	// recordLock is held while a change is applied and its events appended, so the event log orders changes as they
+	// were made, whichever receipts or users they are about. It guards unaudited and catalogChanges, which hold the
+	// transactions and catalog changes of the change being made until its events are appended.
+	recordLock     sync.Mutex
+	unaudited      []ledger.Transaction
+	catalogChanges []events.Event
+	// auditLog chains every ledger transaction into a tamper-evident log. A nil log disables auditing.
+	auditLog *audit.Log
+	// restoring is set while the store replays events, whose transactions were audited and whose catalog changes were
+	// recorded when first made.
+	restoring atomic.Bool
+	// receipts stores receipts by their unique identifier, spread over independently locked shards.
+	receipts *receiptShards
+	// memoryLimits bounds the receipts held in memory, and spillBackend receives the ones evicted. Without a
+	// backend evicted receipts are dropped.
+	memoryLimits MemoryLimits
+	spillBackend storage.Backend
+	// ownerLocks serializes changes to one user's receipts, so scoring sees a consistent history.
+	ownerLocks ownerLocks
+	// idStrategy issues the IDs receipts are stored under.
+	idStrategy receiptid.Strategy
+	// contentIndex maps the content ID of every receipt in memory to the ID of the first receipt stored with it, so
+	// exact duplicates are found whatever the ID strategy. Spilled receipts leave it, and legacyIDs, until reloaded.
+	contentIndex map[string]string
+	// exactDuplicatePolicy decides what happens to receipts identical to a stored one.
+	exactDuplicatePolicy dedup.Policy
+	// legacyIDs maps the decimal IDs issued before receipts were content-addressed to their current IDs.
+	legacyIDs map[string]string
+	// userReceipts indexes receipt IDs by the user that submitted them.
+	userReceipts map[string][]string
+	// refundedItems tracks the items already returned against each receipt, keyed by the original receipt ID.
+	refundedItems map[string][]models.Item
+	// ledger is the double-entry journal that every user balance is derived from.
+	ledger *ledger.Ledger
+	// catalog holds the rewards that points can be redeemed for.
+	catalog *rewards.Catalog
+	// clock is the source of "now" for everything the store timestamps.
+	clock clock.Clock
+	// expiryPolicy decides when earned points expire. A nil policy means points never expire.
+	expiryPolicy expiry.Policy
+	// tierPolicy assigns loyalty tiers that multiply receipt points. A nil policy disables tiers.
+	tierPolicy *loyalty.TierPolicy
+	// historyRules award cross-receipt bonuses such as streaks. A nil rule set disables them.
+	historyRules loyalty.HistoryRules
+	// historyRecomputeWindow bounds how far after an out-of-order receipt the later receipts are re-scored.
+	historyRecomputeWindow time.Duration
+	// duplicates finds near-duplicates of stored receipts, and duplicatePolicy decides what happens to them.
+	duplicates      *dedup.Detector
+	duplicatePolicy dedup.Policy
+	// risk scores each receipt for abuse. Receipts at or above its threshold are held for review.
+	risk *risk.Pipeline
+	// reviewQueue holds the IDs of receipts pending review and when they were submitted.
+	reviewQueue map[string]time.Time
+	// rateLimiter throttles clients per route. A nil limiter leaves every route unlimited.
+	rateLimiter *ratelimit.Limiter
+	// replicationLog records every write so followers can tail it. A nil log disables replication.
+	replicationLog *replication.Log
+	// leaderURL and leaderToken locate and authenticate the leader a follower tails.
+	leaderURL   string
+	leaderToken string
+	// following is set while the store is a read-only follower, until it is promoted.
+	following atomic.Bool
+	// follower tails the leader, and replicationLock serializes starting and promoting it.
+	follower        *replication.Follower
+	replicationLock sync.Mutex
+	// feed streams each receipt to live consumers as it is stored, and keeps the most recent ones for consumers that
+	// reconnect. feedBuffer is how many it keeps.
+	feed       *feed.Hub
+	feedBuffer int
+	// webhooks delivers recorded receipt events to their subscribers. A nil dispatcher disables webhooks.
+	webhooks *webhooks.Dispatcher
+	// textParser reads receipts sent as printed text, and minTextConfidence is the confidence they need to be
+	// processed.
+	textParser        *receipttext.Parser
+	minTextConfidence float64
+	// csvMapping names the columns of CSV imports and exports, unless a request overrides them.
+	csvMapping receiptcsv.Mapping
+	// graphqlSchema answers GET and POST /graphql over this store.
+	graphqlSchema graphql.Schema
+	// eventSinks publish recorded receipt events to the data pipeline, each at its own pace.
+	eventSinks []*sinks.Forwarder
+	// deprecatedAt and sunsetAt are announced on responses from the unversioned paths. Zero times are not announced.
+	deprecatedAt time.Time
+	sunsetAt     time.Time
+	// adminToken guards the operator endpoints. When it is empty those endpoints are disabled.
+	adminToken string
+	// userTokens verifies the bearer tokens users act on their own points with, and issues them for userTokenTTL.
+	// When it is nil only the operator can act on a user's points.
+	userTokens   *auth.Signer
+	userTokenTTL time.Duration
+	// apiKeys maps each API key to the client it belongs to. A client's requests share one rate limit, whichever
+	// users they act for.
+	apiKeys map[string]string
+	// lock guards the indexes above: contentIndex, legacyIDs, userReceipts, refundedItems and reviewQueue.
+	// It is only held while they are read or updated, never while a receipt is hashed or scored.
 	lock sync.RWMutex // RWMutex is a reader/writer mutex that allows multiple readers or a single writer.
 }
 
-func NewReceiptStore() *ReceiptStore {
-	return &ReceiptStore{
-		receipts: make(map[string]models.Receipt),
+// StoreOption configures optional behaviour of a ReceiptStore.
+type StoreOption func(*ReceiptStore)
+
+// WithAdminToken enables the operator endpoints for requests that present the given token in the X-Admin-Token header.
+func WithAdminToken(token string) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.adminToken = token
+	}
+}
+
+// WithUserTokens lets users authenticate with bearer tokens signed under the given secret, which the operator issues
+// for ttl.
+func WithUserTokens(secret string, ttl time.Duration) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.userTokens = auth.NewSigner(secret)
+		receiptStore.userTokenTTL = ttl
+	}
+}
+
+// WithAPIKeys counts requests that present one of the given keys in the X-API-Key header against the key's client,
+// see ratelimit.ParseAPIKeys.
+func WithAPIKeys(apiKeys map[string]string) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.apiKeys = apiKeys
+	}
+}
+
+// WithClock replaces the system clock, which lets tests control time.
+func WithClock(c clock.Clock) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.clock = c
+	}
+}
+
+// WithExpiryPolicy makes earned points expire according to the given policy.
+func WithExpiryPolicy(policy expiry.Policy) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.expiryPolicy = policy
+	}
+}
+
+// WithTierPolicy multiplies the points of receipts submitted by users according to their loyalty tier.
+func WithTierPolicy(policy *loyalty.TierPolicy) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.tierPolicy = policy
+	}
+}
+
+// WithHistoryRules awards cross-receipt bonuses. When a receipt arrives out of order, the user's receipts purchased
+// up to recomputeWindow after it are re-scored so their bonuses match the order the purchases were made in.
+func WithHistoryRules(rules loyalty.HistoryRules, recomputeWindow time.Duration) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.historyRules = rules
+		receiptStore.historyRecomputeWindow = recomputeWindow
+	}
+}
+
+// WithDuplicatePolicy configures near-duplicate detection. Receipts from the same retailer at the same date and time
+// whose totals are no more than toleranceCents apart are rejected or held for review according to the policy.
+func WithDuplicatePolicy(policy dedup.Policy, toleranceCents int64) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.duplicatePolicy = policy
+		receiptStore.duplicates = dedup.NewDetector(toleranceCents)
+	}
+}
+
+// WithIDStrategy replaces the content-addressed receipt IDs, e.g. with time-ordered UUIDv7s or ULIDs.
+func WithIDStrategy(strategy receiptid.Strategy) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.idStrategy = strategy
+	}
+}
+
+// WithExactDuplicatePolicy decides whether receipts identical to a stored one are rejected, held for review or
+// allowed. Identical receipts share a content-addressed ID, so they are always rejected under that ID strategy.
+func WithExactDuplicatePolicy(policy dedup.Policy) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.exactDuplicatePolicy = policy
+	}
+}
+
+// WithRiskPipeline scores every receipt for abuse and holds the points of risky receipts for manual review.
+func WithRiskPipeline(pipeline *risk.Pipeline) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.risk = pipeline
+	}
+}
+
+// WithMemoryLimits bounds the receipts held in memory. Receipts evicted to stay within the limits are written to
+// the backend and reloaded when they are next read, or dropped when the backend is nil.
+func WithMemoryLimits(limits MemoryLimits, backend storage.Backend) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.memoryLimits = limits
+		receiptStore.spillBackend = backend
+	}
+}
+
+// WithEventLog records receipt events in the given log, e.g. one backed by a file, instead of in memory.
+func WithEventLog(eventLog *events.Log) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.eventLog = eventLog
+	}
+}
+
+// WithAuditLog appends every points change to the given hash-chained audit log as it is posted to the ledger.
+func WithAuditLog(auditLog *audit.Log) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.auditLog = auditLog
+	}
+}
+
+// WithAPIDeprecation sets the dates announced in the Deprecation and Sunset headers of responses from the unversioned
+// paths. A zero deprecation date is announced as "true", and a zero sunset date is left out.
+func WithAPIDeprecation(deprecatedAt time.Time, sunsetAt time.Time) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.deprecatedAt = deprecatedAt
+		receiptStore.sunsetAt = sunsetAt
+	}
+}
+
+// WithTextParser reads receipts sent as text/plain with the given parser, and refuses those parsed with less than
+// minConfidence, between 0 and 1.
+func WithTextParser(parser *receipttext.Parser, minConfidence float64) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.textParser = parser
+		receiptStore.minTextConfidence = minConfidence
+	}
+}
+
+// WithCSVMapping sets the columns CSV imports and exports use for each field.
+func WithCSVMapping(mapping receiptcsv.Mapping) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.csvMapping = mapping
+	}
+}
+
+// WithFeedBuffer sets how many recent receipts the live receipt feed keeps for consumers that reconnect.
+func WithFeedBuffer(size int) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.feedBuffer = size
+	}
+}
+
+// WithWebhooks delivers the receipt events recorded from now on to the dispatcher's subscribers. They are fed to
+// the dispatcher from the event log by feed, see NewWebhookFeed, which runs with the event sinks.
+func WithWebhooks(dispatcher *webhooks.Dispatcher, feed *sinks.Forwarder) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.webhooks = dispatcher
+		receiptStore.eventSinks = append(receiptStore.eventSinks, feed)
+	}
+}
+
+// WithEventSinks offers every recorded receipt event to the given forwarders, which should read back from the
+// store's event log what they miss.
+func WithEventSinks(forwarders ...*sinks.Forwarder) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.eventSinks = append(receiptStore.eventSinks, forwarders...)
+	}
+}
+
+// WithRateLimiter throttles the routes wrapped in RateLimited with the given limiter.
+func WithRateLimiter(limiter *ratelimit.Limiter) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.rateLimiter = limiter
+	}
+}
+
+// WithReplicationLog records every write in a log that followers can tail, which makes the store a leader unless it
+// follows a leader itself.
+func WithReplicationLog(replicationLog *replication.Log) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.replicationLog = replicationLog
+	}
+}
+
+// WithLeader makes the store a read-only follower of the leader at leaderURL, which it authenticates to with the
+// leader's admin token. A follower keeps its own write log, so it can be promoted and tailed in turn. Without
+// WithReplicationLog that log is kept in memory.
+func WithLeader(leaderURL string, leaderToken string) StoreOption {
+	return func(receiptStore *ReceiptStore) {
+		receiptStore.leaderURL = leaderURL
+		receiptStore.leaderToken = leaderToken
+	}
+}
+
+func NewReceiptStore(opts ...StoreOption) *ReceiptStore {
+	receiptStore := &ReceiptStore{
+		idStrategy:           receiptid.ContentHash{},
+		contentIndex:         make(map[string]string),
+		exactDuplicatePolicy: dedup.PolicyReject,
+		legacyIDs:            make(map[string]string),
+		userReceipts:         make(map[string][]string),
+		refundedItems:        make(map[string][]models.Item),
+		clock:                clock.Real{},
+		duplicates:           dedup.NewDetector(0),
+		duplicatePolicy:      dedup.PolicyReject,
+		reviewQueue:          make(map[string]time.Time),
+		feedBuffer:           defaultFeedBuffer,
+		textParser:           receipttext.NewParser(),
+		minTextConfidence:    defaultMinTextConfidence,
+		csvMapping:           receiptcsv.DefaultMapping(),
+		userTokenTTL:         defaultUserTokenTTL,
+	}
+	for _, opt := range opts {
+		opt(receiptStore)
+	}
+	if receiptStore.eventLog == nil {
+		receiptStore.eventLog = events.NewLog(receiptStore.clock)
+	}
+	hooks := shardHooks{dropped: receiptStore.forgetDroppedReceipt, spilled: receiptStore.unindexSpilledReceipt, reloaded: receiptStore.reindexReloadedReceipt}
+	receiptStore.receipts = newReceiptShards(receiptStore.memoryLimits, receiptStore.spillBackend, hooks, receiptStore.clock)
+	receiptStore.ledger = ledger.NewWithClock(receiptStore.clock)
+	receiptStore.catalog = rewards.NewCatalog(receiptStore.ledger, receiptStore.clock)
+	receiptStore.catalog.OnChange(receiptStore.recordCatalogChange)
+	receiptStore.feed = feed.NewHub(receiptStore.feedBuffer, feedSubscriberBuffer)
+	receiptStore.graphqlSchema = receiptStore.newGraphQLSchema()
+	if receiptStore.leaderURL != "" {
+		if receiptStore.replicationLog == nil {
+			receiptStore.replicationLog = replication.NewLog(receiptStore.clock)
+		}
+		receiptStore.following.Store(true)
+	}
+	if receiptStore.auditLog != nil {
+		receiptStore.ledger.OnAppend(receiptStore.queueAudit)
+	}
+	if receiptStore.replicationLog != nil {
+		receiptStore.ledger.OnAppend(func(transaction ledger.Transaction) {
+			receiptStore.logEntry(replication.Entry{Kind: replication.KindTransaction, Transaction: &transaction})
+		})
+		receiptStore.catalog.OnChange(func(change rewards.Change) {
+			receiptStore.logEntry(replication.Entry{Kind: replication.KindCatalog, Catalog: &change})
+		})
+	}
+	return receiptStore
+}
+
+/*
+*
+StartExpirySweeper expires due points every interval until the context is cancelled. It does nothing when no
+expiry policy is configured. A follower skips its sweeps until it is promoted, since it applies the leader's expiries.
+*
+*/
+func (receiptStore *ReceiptStore) StartExpirySweeper(ctx context.Context, interval time.Duration) {
+	if receiptStore.expiryPolicy == nil {
+		return
+	}
+	go receiptStore.newExpirySweeper().Run(ctx, interval)
+}
+
+/*
+*
+newExpirySweeper returns a sweeper for the store's expiry policy that records every expiry it posts in the event log.
+Each expiry is posted and recorded under the record lock.
+*
+*/
+func (receiptStore *ReceiptStore) newExpirySweeper() *expiry.Sweeper {
+	sweeper := expiry.NewSweeper(receiptStore.ledger, receiptStore.expiryPolicy, receiptStore.clock)
+	sweeper.PauseWhile(receiptStore.following.Load)
+	sweeper.LockWith(&receiptStore.recordLock)
+	sweeper.OnExpire(func(transaction ledger.Transaction) error {
+		return receiptStore.recordApplied(events.Event{Type: events.Posted, Transaction: &transaction})
+	})
+	return sweeper
+}
+
+/*
+*
+StartEvictionSweeper evicts receipts that have been idle for longer than the TTL every interval until the context
+is cancelled. Idle receipts are also evicted whenever their shard is used; the sweeper catches shards that are not.
+It does nothing when no TTL is configured.
+*
+*/
+func (receiptStore *ReceiptStore) StartEvictionSweeper(ctx context.Context, interval time.Duration) {
+	if receiptStore.memoryLimits.TTL <= 0 {
+		return
 	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				receiptStore.receipts.sweep()
+			}
+		}
+	}()
 }
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {get} /admin/audit Fetch Audit Status
+* @apiDescription This operator endpoint reports the head of the audit chain, the ledger transactions still waiting to
+* be written to it and, while writes are failing, the last error.
+**/
+func (receiptStore *ReceiptStore) FetchAuditStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.auditStatus()); err != nil {
+		handleErr(w, err, "Error marshaling audit status response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/*
+*
+This function holds a ledger transaction back from the audit log until the events of the change that posted it are
+in the event log. Replayed transactions were audited when first posted and are skipped. The poster must hold the
+record lock.
+*
+*/
+func (receiptStore *ReceiptStore) queueAudit(transaction ledger.Transaction) {
+	if receiptStore.restoring.Load() {
+		return
+	}
+	receiptStore.unaudited = append(receiptStore.unaudited, transaction)
+}
+
+/*
+*
+This function appends the transactions held back by queueAudit to the audit log once their change is recorded, or
+drops them when it could not be, so the audit log never holds a transaction the event log does not. A transaction
+that cannot be written stays pending in the audit log and is retried with the next one, and the failure is reported
+by GET /admin/audit until then. The caller must hold the record lock.
+*
+*/
+func (receiptStore *ReceiptStore) settleAudit(recorded bool) {
+	unaudited := receiptStore.unaudited
+	receiptStore.unaudited = nil
+	if !recorded {
+		if len(unaudited) > 0 {
+			log.Printf("Not auditing %d ledger transactions whose events were not recorded", len(unaudited))
+		}
+		return
+	}
+	for _, transaction := range unaudited {
+		if err := receiptStore.auditLog.Record(transaction); err != nil {
+			log.Printf("Error auditing ledger transaction %d: %v", transaction.ID, err)
+		}
+	}
+}
+
+/*
+*
+This function checks that the audit log holds no transaction the ledger does not. A leader whose ledger lost
+transactions, e.g. because its event log was kept in memory, would otherwise audit new transactions under IDs the
+chain has already used. A follower is not checked, since it copies the leader's ledger after starting.
+*
+*/
+func (receiptStore *ReceiptStore) checkAuditLog() error {
+	if receiptStore.auditLog == nil || receiptStore.following.Load() {
+		return nil
+	}
+	audited, last := receiptStore.auditLog.Status().LastTransactionID, receiptStore.ledger.LastID()
+	if audited > last {
+		return errors.Errorf("the audit log records transaction %d but the ledger ends at %d; restore the event log it was written with", audited, last)
+	}
+	return nil
+}
+
+/*
+*
+This function moves the audit log's chain aside and starts a new one, for a ledger that starts over from its first
+transaction.
+*
+*/
+func (receiptStore *ReceiptStore) restartAuditChain() error {
+	if receiptStore.auditLog == nil {
+		return nil
+	}
+	archived, err := receiptStore.auditLog.Restart()
+	if err != nil {
+		return errors.Wrap(err, "restartAuditChain")
+	}
+	if archived != "" {
+		log.Printf("Started a new audit chain; the previous one was moved to %s", archived)
+	}
+	return nil
+}
+
+/*
+*
+This function reports the audit log's head and any writes to it that are failing.
+*
+*/
+func (receiptStore *ReceiptStore) auditStatus() models.AuditStatusResponse {
+	response := models.AuditStatusResponse{LedgerLastID: receiptStore.ledger.LastID()}
+	if receiptStore.auditLog == nil {
+		return response
+	}
+	status := receiptStore.auditLog.Status()
+	response.Enabled = true
+	response.Seq = status.Seq
+	response.LastTransactionID = status.LastTransactionID
+	response.Pending = status.Pending
+	response.Failures = status.Failures
+	response.LastError = status.LastError
+	if !status.LastFailure.IsZero() {
+		lastFailure := status.LastFailure
+		response.LastFailure = &lastFailure
+	}
+	return response
+}
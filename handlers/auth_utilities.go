@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/ratelimit"
+)
+
+// defaultUserTokenTTL is how long an issued user token lasts, unless configured otherwise.
+const defaultUserTokenTTL = 24 * time.Hour
+
+// caller is who a request authenticated as: the operator holding the admin token, a user with a valid token, or
+// nobody. client names the API key the request presented, if any; it only decides which rate limit the request draws
+// on and grants no access.
+type caller struct {
+	admin  bool
+	userID string
+	client string
+}
+
+/*
+*
+Helper function to tell whether a request carries the admin token. It never does while the admin token is unset.
+*
+*/
+func (receiptStore *ReceiptStore) isAdmin(r *http.Request) bool {
+	return receiptStore.isAdminToken(r.Header.Get("X-Admin-Token"))
+}
+
+/*
+*
+Helper function to tell whether a token is the admin token.
+*
+*/
+func (receiptStore *ReceiptStore) isAdminToken(token string) bool {
+	if receiptStore.adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(receiptStore.adminToken)) == 1
+}
+
+/*
+*
+This function finds who a request authenticated as. Users authenticate with a bearer token in the Authorization
+header, which is only verified when user tokens are configured. Clients present their API key in the X-API-Key
+header.
+*
+*/
+func (receiptStore *ReceiptStore) authenticate(r *http.Request) caller {
+	return receiptStore.authenticateCredentials(r.Header.Get("X-Admin-Token"), r.Header.Get("Authorization"), r.Header.Get("X-API-Key"))
+}
+
+/*
+*
+This function finds who an admin token, an Authorization value and an API key authenticate as, for requests and gRPC
+calls alike.
+*
+*/
+func (receiptStore *ReceiptStore) authenticateCredentials(adminToken string, authorization string, apiKey string) caller {
+	authenticated := caller{admin: receiptStore.isAdminToken(adminToken), client: receiptStore.apiKeyClient(apiKey)}
+	if receiptStore.userTokens == nil {
+		return authenticated
+	}
+	scheme, token, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return authenticated
+	}
+	if userID, err := receiptStore.userTokens.Verify(strings.TrimSpace(token), receiptStore.clock.Now()); err == nil {
+		authenticated.userID = userID
+	}
+	return authenticated
+}
+
+/*
+*
+Helper function to find the client an API key belongs to, or "" when it is not a configured key. Every key is
+compared in constant time, so the time taken does not reveal how much of a key was right.
+*
+*/
+func (receiptStore *ReceiptStore) apiKeyClient(apiKey string) string {
+	client := ""
+	if apiKey == "" {
+		return client
+	}
+	for key, keyClient := range receiptStore.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			client = keyClient
+		}
+	}
+	return client
+}
+
+/*
+*
+Helper function to tell whether a caller may act for a user. The operator may act for anyone.
+*
+*/
+func (authenticated caller) mayActFor(userID string) bool {
+	return authenticated.admin || (authenticated.userID != "" && authenticated.userID == userID)
+}
+
+/*
+*
+This function lets a request act on a user's points only when it is authenticated as that user or as the operator.
+Otherwise it answers 401 when the request carries no valid credentials and 403 when they belong to another user, and
+returns false.
+*
+*/
+func (receiptStore *ReceiptStore) authorizeUser(w http.ResponseWriter, r *http.Request, userID string, action string) bool {
+	authenticated := receiptStore.authenticate(r)
+	switch {
+	case authenticated.mayActFor(userID):
+		return true
+	case authenticated.userID == "":
+		w.Header().Set("WWW-Authenticate", `Bearer realm="receipt-processor"`)
+		handleErr(w, nil, fmt.Sprintf("%s: authentication required", action), http.StatusUnauthorized)
+	default:
+		handleErr(w, nil, fmt.Sprintf("%s: %s may not act for %s", action, authenticated.userID, userID), http.StatusForbidden)
+	}
+	return false
+}
+
+/*
+*
+This function lets a request change a receipt only when it is authenticated as the receipt's owner or as the
+operator. An anonymous receipt belongs to no user, so only the operator may change it.
+*
+*/
+func (receiptStore *ReceiptStore) authorizeReceipt(w http.ResponseWriter, r *http.Request, receipt models.Receipt, action string) bool {
+	if receipt.UserID != "" {
+		return receiptStore.authorizeUser(w, r, receipt.UserID, action)
+	}
+	authenticated := receiptStore.authenticate(r)
+	switch {
+	case authenticated.admin:
+		return true
+	case authenticated.userID == "":
+		w.Header().Set("WWW-Authenticate", `Bearer realm="receipt-processor"`)
+		handleErr(w, nil, fmt.Sprintf("%s: authentication required", action), http.StatusUnauthorized)
+	default:
+		handleErr(w, nil, fmt.Sprintf("%s: %s may not act for anonymous receipts", action, authenticated.userID), http.StatusForbidden)
+	}
+	return false
+}
+
+/*
+*
+Helper function to find the rate limit bucket of a request: the client of its API key, else its verified user or the
+operator, else its IP address.
+*
+*/
+func (receiptStore *ReceiptStore) rateLimitKey(r *http.Request) string {
+	return receiptStore.authenticate(r).rateLimitKey(r.RemoteAddr)
+}
+
+/*
+*
+Helper function to find the rate limit bucket of a caller connecting from the given address.
+*
+*/
+func (authenticated caller) rateLimitKey(remoteAddr string) string {
+	identity := ""
+	switch {
+	case authenticated.client != "":
+		identity = "client:" + authenticated.client
+	case authenticated.admin:
+		identity = "admin"
+	case authenticated.userID != "":
+		identity = "user:" + authenticated.userID
+	}
+	return ratelimit.ClientKey(identity, remoteAddr)
+}
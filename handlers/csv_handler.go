@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	json "github.com/json-iterator/go"
+	"github.com/julienschmidt/httprouter"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptcsv"
+)
+
+/**
+* @api {get} /admin/receipts/csv Export Receipts CSV
+* @apiDescription This operator endpoint streams stored receipts and their points as CSV in ID order: one row per item
+* with "shape=items" (the default), which can be imported again, or one row per receipt with "shape=summary".
+* "userId" exports only one user's receipts and "columns" overrides the column mapping.
+**/
+func (receiptStore *ReceiptStore) ExportReceiptsCSV(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mapping, err := receiptStore.checkCSVMappingValidity(r)
+	if err != nil {
+		handleErr(w, err, "ExportReceiptsCSV validation error", http.StatusBadRequest)
+		return
+	}
+	shape, err := checkCSVShapeValidity(r)
+	if err != nil {
+		handleErr(w, err, "ExportReceiptsCSV validation error", http.StatusBadRequest)
+		return
+	}
+	userID := strings.TrimSpace(r.URL.Query().Get("userId"))
+	page, err := receiptStore.listReceipts(userID, maxReceiptPageSize, "")
+	if err != nil {
+		handleErr(w, err, "ExportReceiptsCSV validation error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="receipts-`+shape+`.csv"`)
+	writer, err := receiptcsv.NewWriter(w, mapping, shape)
+	//Once streaming starts the status is already sent, so failures are only logged
+	for err == nil {
+		for _, stored := range page.Receipts {
+			if err = writer.Write(stored); err != nil {
+				break
+			}
+		}
+		if err != nil || page.NextPageToken == "" {
+			break
+		}
+		page, err = receiptStore.listReceipts(userID, maxReceiptPageSize, page.NextPageToken)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Printf("ExportReceiptsCSV: streaming failed: %v", err)
+	}
+}
+
+/**
+* @api {post} /admin/receipts/csv Import Receipts CSV
+* @apiDescription This operator endpoint imports receipts from a CSV file with one row per item, grouped into receipts
+* by the receipt key column. Each receipt is validated, scored and stored like one sent to /receipts/process, and
+* errors are reported per row. "columns" overrides the column mapping.
+**/
+func (receiptStore *ReceiptStore) ImportReceiptsCSV(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mapping, err := receiptStore.checkCSVMappingValidity(r)
+	if err != nil {
+		handleErr(w, err, "ImportReceiptsCSV validation error", http.StatusBadRequest)
+		return
+	}
+	groups, rowErrors, err := receiptcsv.Read(http.MaxBytesReader(w, r.Body, maxCSVImportBytes), mapping)
+	if err != nil {
+		handleErr(w, err, "ImportReceiptsCSV validation error", http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(receiptStore.importCSVReceipts(groups, rowErrors, mapping))
+	if err != nil {
+		handleErr(w, err, "Error marshaling import report", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, data)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptcsv"
+)
+
+// maxCSVImportBytes bounds a CSV import, about a hundred thousand item rows.
+const maxCSVImportBytes = 10 << 20
+
+/*
+*
+This function checks the column mapping of a CSV request: the configured mapping, overridden by the field=header
+pairs of the "columns" query parameter, e.g. "receipt=Invoice,retailer=Store".
+*
+*/
+func (receiptStore *ReceiptStore) checkCSVMappingValidity(r *http.Request) (receiptcsv.Mapping, error) {
+	mapping, err := receiptStore.csvMapping.Override(r.URL.Query().Get("columns"))
+	if err != nil {
+		return nil, errors.Wrap(err, "checkCSVMappingValidity: columns validation failed")
+	}
+	return mapping, nil
+}
+
+/*
+*
+This function checks the shape of a CSV export, items (the default) or summary.
+*
+*/
+func checkCSVShapeValidity(r *http.Request) (string, error) {
+	switch shape := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("shape"))); shape {
+	case "", receiptcsv.ShapeItems:
+		return receiptcsv.ShapeItems, nil
+	case receiptcsv.ShapeSummary:
+		return shape, nil
+	default:
+		return "", errors.Errorf("checkCSVShapeValidity: unknown shape %q", shape)
+	}
+}
+
+/*
+*
+This function validates and stores the receipts read from a CSV import. A receipt that fails validation or is
+rejected as a duplicate is reported against its rows: item errors against the item's row, the rest against the
+receipt's first row. The report lists the row errors Read found too.
+*
+*/
+func (receiptStore *ReceiptStore) importCSVReceipts(groups []receiptcsv.Group, rowErrors []models.CSVRowError, mapping receiptcsv.Mapping) models.CSVImportReport {
+	report := models.CSVImportReport{Receipts: []models.CSVImportedReceipt{}, Errors: append([]models.CSVRowError{}, rowErrors...)}
+	for _, group := range groups {
+		if err := validateCSVGroup(group, mapping); err != nil {
+			report.Errors = append(report.Errors, *err)
+			continue
+		}
+		receipt := group.Receipt
+		receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			report.Errors = append(report.Errors, models.CSVRowError{Row: group.Row, Receipt: group.Key, Error: err.Error()})
+			continue
+		}
+		report.Receipts = append(report.Receipts, models.CSVImportedReceipt{Key: group.Key, ID: receiptID, Points: receipt.Points, Status: receipt.Status})
+	}
+
+	failed := make(map[string]bool)
+	for _, rowError := range report.Errors {
+		if rowError.Receipt != "" {
+			failed[rowError.Receipt] = true
+		}
+	}
+	report.Imported, report.Failed = len(report.Receipts), len(failed)
+	return report
+}
+
+/*
+*
+Helper function to validate a receipt read from CSV, pointing at the row and column of the first error.
+*
+*/
+func validateCSVGroup(group receiptcsv.Group, mapping receiptcsv.Mapping) *models.CSVRowError {
+	for i, item := range group.Receipt.Items {
+		if err := validateItemData(item); err != nil {
+			column := mapping[receiptcsv.FieldDescription]
+			if !priceRegex.MatchString(item.Price) {
+				column = mapping[receiptcsv.FieldPrice]
+			}
+			return &models.CSVRowError{Row: group.ItemRows[i], Receipt: group.Key, Column: column, Error: err.Error()}
+		}
+	}
+	if err := validateReceiptData(group.Receipt); err != nil {
+		return &models.CSVRowError{Row: group.Row, Receipt: group.Key, Error: err.Error()}
+	}
+	return nil
+}
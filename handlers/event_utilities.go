@@ -0,0 +1,588 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/replication"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+/*
+*
+This function applies the events of one change to the store and appends them to the event log. The events are the
+record of the change; the receipts, their indexes and their ledger entries are projections of them. Both happen
+under the record lock, so the log holds changes in the order they were made and never holds one that was not. When
+an event cannot be applied, the events applied before it are still recorded, since they changed the store, and the
+rest are dropped. The caller must hold the owner lock of the receipt the events are about, but not the store lock.
+Once recorded, the receipts they score go on the live feed and the events are offered to the event sinks and the
+webhook feed, none of which waits on a consumer.
+*
+*/
+func (receiptStore *ReceiptStore) recordEvents(changes ...events.Event) error {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	now := receiptStore.clock.Now().UTC()
+	building := make(map[string]*models.Receipt)
+	applied := len(changes)
+	var applyErr error
+	for i := range changes {
+		if changes[i].At.IsZero() {
+			changes[i].At = now
+		}
+		if err := receiptStore.applyEvent(changes[i], building, false); err != nil {
+			applied, applyErr = i, errors.Wrapf(err, "recordEvents: applying the %s event of %s failed", changes[i].Type, changes[i].ReceiptID)
+			break
+		}
+	}
+	if applied == 0 {
+		receiptStore.settleAudit(false)
+		return applyErr
+	}
+	appended, err := receiptStore.eventLog.Append(changes[:applied]...)
+	receiptStore.settleAudit(err == nil)
+	if err != nil {
+		return errors.Wrap(err, "recordEvents")
+	}
+	receiptStore.publishFeed(appended)
+	for _, forwarder := range receiptStore.eventSinks {
+		forwarder.Offer(appended)
+	}
+	return applyErr
+}
+
+/*
+*
+This function records the events of a change to balances or the catalog outside any receipt: an adjustment, an
+expiry, a reward or a redemption. The change has already been made, so the events are only appended to the event
+log and offered to the event sinks, and the ledger transactions it posted are audited once the events are appended.
+Replaying the log makes the change again. The caller must hold the record lock, from before the change was made.
+*
+*/
+func (receiptStore *ReceiptStore) recordApplied(changes ...events.Event) error {
+	appended, err := receiptStore.eventLog.Append(changes...)
+	receiptStore.settleAudit(err == nil)
+	if err != nil {
+		return errors.Wrap(err, "recordApplied")
+	}
+	for _, forwarder := range receiptStore.eventSinks {
+		forwarder.Offer(appended)
+	}
+	return nil
+}
+
+/*
+*
+This function records the catalog changes made since it was last called. The caller must hold the record lock.
+*
+*/
+func (receiptStore *ReceiptStore) recordCatalogChanges() error {
+	changes := receiptStore.catalogChanges
+	receiptStore.catalogChanges = nil
+	if len(changes) == 0 {
+		return nil
+	}
+	return receiptStore.recordApplied(changes...)
+}
+
+/*
+*
+This function turns a catalog change into a Listed or Redeemed event for recordCatalogChanges. It is called under
+the catalog's lock, and the caller that made the change holds the record lock. Changes replayed from events or
+copied from a leader are not recorded again.
+*
+*/
+func (receiptStore *ReceiptStore) recordCatalogChange(change rewards.Change) {
+	if receiptStore.restoring.Load() || receiptStore.following.Load() {
+		return
+	}
+	receiptStore.catalogChanges = append(receiptStore.catalogChanges, catalogEvent(change))
+}
+
+/*
+*
+Helper function to describe a catalog change as a Listed or Redeemed event.
+*
+*/
+func catalogEvent(change rewards.Change) events.Event {
+	event := events.Event{Type: events.Listed, Catalog: &change}
+	if change.Redemption != nil {
+		event.Type = events.Redeemed
+		if change.Redemption.Status == models.RedemptionCancelled {
+			event.Reason = events.ReasonCancelled
+		}
+	}
+	return event
+}
+
+/*
+*
+Helper function to describe a newly scored receipt as the events of its submission.
+*
+*/
+func submissionEvents(receiptID string, contentID string, receipt *models.Receipt, reason string) []events.Event {
+	submitted := models.Receipt{
+		UserID:       receipt.UserID,
+		Retailer:     receipt.Retailer,
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+		Items:        receipt.Items,
+		Total:        receipt.Total,
+	}
+	submission := []events.Event{
+		{Type: events.Submitted, ReceiptID: receiptID, Reason: reason, Receipt: &submitted},
+		{Type: events.Validated, ReceiptID: receiptID, Reason: reason, ContentID: contentID, RiskScore: receipt.RiskScore},
+	}
+	if len(receipt.Flags) > 0 || receipt.Status == models.ReceiptPendingReview {
+		submission = append(submission, events.Event{
+			Type:      events.Flagged,
+			ReceiptID: receiptID,
+			Reason:    reason,
+			Flags:     receipt.Flags,
+			Held:      receipt.Status == models.ReceiptPendingReview,
+		})
+	}
+	return append(submission, events.Event{Type: events.Scored, ReceiptID: receiptID, Reason: reason, Score: scoreOf(receipt)})
+}
+
+/*
+*
+Helper function to record how a receipt's points were derived.
+*
+*/
+func scoreOf(receipt *models.Receipt) *events.Score {
+	return &events.Score{
+		BasePoints:     receipt.BasePoints,
+		Tier:           receipt.Tier,
+		TierMultiplier: receipt.TierMultiplier,
+		HistoryBonuses: receipt.HistoryBonuses,
+		Points:         receipt.Points,
+	}
+}
+
+/*
+*
+This function applies one event to the store. Receipts are assembled in building from their Submitted event until
+they are scored, so a receipt is never visible half-submitted. Replayed submissions also claim their duplicate
+indexes, which the live path claims before the events are recorded. The caller must not hold the store lock.
+*
+*/
+func (receiptStore *ReceiptStore) applyEvent(event events.Event, building map[string]*models.Receipt, replaying bool) error {
+	switch event.Type {
+	case events.Submitted:
+		if event.Receipt == nil {
+			return errors.Errorf("applyEvent: submitted event %d carries no receipt", event.Seq)
+		}
+		receipt := *event.Receipt
+		building[event.ReceiptID] = &receipt
+		if replaying {
+			return receiptStore.indexReceipt(event.ReceiptID, receipt)
+		}
+		return nil
+
+	case events.Validated:
+		if event.Reason == events.ReasonReviewApproved {
+			return receiptStore.applyReview(event, true)
+		}
+		if receipt, found := building[event.ReceiptID]; found {
+			receipt.RiskScore = event.RiskScore
+		}
+		return nil
+
+	case events.Flagged:
+		receipt, found := building[event.ReceiptID]
+		if !found {
+			return errors.Errorf("applyEvent: flagged event %d for unknown receipt %s", event.Seq, event.ReceiptID)
+		}
+		receipt.Flags = event.Flags
+		if event.Held {
+			receipt.Status = models.ReceiptPendingReview
+		}
+		return nil
+
+	case events.Scored:
+		receipt, found := building[event.ReceiptID]
+		if !found || event.Score == nil {
+			return errors.Errorf("applyEvent: scored event %d for unknown receipt %s", event.Seq, event.ReceiptID)
+		}
+		delete(building, event.ReceiptID)
+		return receiptStore.applyScore(event, receipt)
+
+	case events.Adjusted:
+		return receiptStore.applyAdjustment(event)
+
+	case events.Refunded:
+		return receiptStore.applyRefundEvent(event)
+
+	case events.Posted:
+		if event.Transaction == nil {
+			return errors.Errorf("applyEvent: posted event %d carries no transaction", event.Seq)
+		}
+		if !replaying {
+			return nil
+		}
+		return receiptStore.postRecorded(*event.Transaction)
+
+	case events.Listed, events.Redeemed:
+		if event.Catalog == nil {
+			return errors.Errorf("applyEvent: %s event %d carries no catalog change", event.Type, event.Seq)
+		}
+		if !replaying {
+			return nil
+		}
+		if event.Catalog.Transaction != nil {
+			if err := receiptStore.postRecorded(*event.Catalog.Transaction); err != nil {
+				return err
+			}
+		}
+		receiptStore.catalog.Apply(*event.Catalog)
+		return nil
+
+	case events.Copied:
+		if event.Receipt == nil {
+			return errors.Errorf("applyEvent: copied event %d carries no receipt", event.Seq)
+		}
+		state := replication.ReceiptState{ID: event.ReceiptID, Receipt: *event.Receipt, RefundedItems: event.Items}
+		return receiptStore.applyReceiptState(state, event.At)
+
+	case events.Reset:
+		if !replaying {
+			return nil
+		}
+		return receiptStore.resetStore()
+
+	case events.Voided:
+		if event.Reason == events.ReasonReplaced {
+			existing, found := receiptStore.receipts.get(event.ReceiptID)
+			if !found {
+				return nil
+			}
+			return receiptStore.discardReceipt(event.ReceiptID, existing, event.At)
+		}
+		return receiptStore.applyReview(event, false)
+
+	default:
+		return errors.Errorf("applyEvent: unknown event type %q", event.Type)
+	}
+}
+
+/*
+*
+Helper function to post a recorded transaction again, with its kind, memo, reference and time. The ledger numbers it
+afresh.
+*
+*/
+func (receiptStore *ReceiptStore) postRecorded(transaction ledger.Transaction) error {
+	transaction.ID = 0
+	_, err := receiptStore.ledger.Post(transaction)
+	return errors.Wrap(err, "postRecorded")
+}
+
+/*
+*
+Helper function to add a replayed, imported or reloaded receipt to the content, alias and near-duplicate indexes.
+Rejected receipts are left out of the near-duplicate detector, like they are when they are rejected.
+*
+*/
+func (receiptStore *ReceiptStore) indexReceipt(receiptID string, receipt models.Receipt) error {
+	legacyID, err := receiptid.LegacyID(receipt)
+	if err != nil {
+		return errors.Wrap(err, "indexReceipt")
+	}
+	contentID := receiptid.ContentID(receipt)
+
+	receiptStore.lock.Lock()
+	defer receiptStore.lock.Unlock()
+	if _, exists := receiptStore.contentIndex[contentID]; !exists {
+		receiptStore.contentIndex[contentID] = receiptID
+	}
+	receiptStore.aliasLegacyID(legacyID, receiptID)
+	if receipt.Status != models.ReceiptRejected {
+		receiptStore.duplicates.Add(receiptID, receipt)
+	}
+	return nil
+}
+
+/*
+*
+This function stores a scored receipt and credits its points, holding them when the receipt is pending review.
+*
+*/
+func (receiptStore *ReceiptStore) applyScore(event events.Event, receipt *models.Receipt) error {
+	receipt.BasePoints = event.Score.BasePoints
+	receipt.Tier = event.Score.Tier
+	receipt.TierMultiplier = event.Score.TierMultiplier
+	receipt.HistoryBonuses = event.Score.HistoryBonuses
+	receipt.Points = event.Score.Points
+
+	transaction := ledger.Transaction{
+		Kind:      ledger.KindEarn,
+		ReceiptID: event.ReceiptID,
+		Postings:  ledger.Transfer(ledger.IssuedAccount, pointsAccount(receipt), receipt.Points),
+		CreatedAt: event.At,
+	}
+	if event.Reason == events.ReasonImported {
+		transaction.Memo = "Imported from snapshot"
+	}
+	if _, err := receiptStore.ledger.Post(transaction); err != nil {
+		return errors.Wrap(err, "applyScore: posting earned points failed")
+	}
+	receiptStore.receipts.put(event.ReceiptID, *receipt)
+
+	receiptStore.lock.Lock()
+	if receipt.Status == models.ReceiptPendingReview {
+		receiptStore.reviewQueue[event.ReceiptID] = event.At
+	}
+	if receipt.UserID != "" {
+		receiptStore.userReceipts[receipt.UserID] = append(receiptStore.userReceipts[receipt.UserID], event.ReceiptID)
+	}
+	receiptStore.lock.Unlock()
+
+	receiptStore.logReceipt(event.ReceiptID, *receipt, nil)
+	return nil
+}
+
+/*
+*
+This function applies a re-scored history bonus to a stored receipt.
+*
+*/
+func (receiptStore *ReceiptStore) applyAdjustment(event events.Event) error {
+	receipt, found := receiptStore.receipts.get(event.ReceiptID)
+	if !found {
+		return errors.Wrapf(errReceiptNotFound, "applyAdjustment: %s", event.ReceiptID)
+	}
+	if event.Delta != 0 {
+		_, err := receiptStore.ledger.Post(ledger.Transaction{
+			Kind:      ledger.KindAdjust,
+			ReceiptID: event.ReceiptID,
+			Reference: event.Reference,
+			Memo:      "History bonus recomputed after an earlier purchase arrived",
+			Postings:  ledger.Transfer(ledger.IssuedAccount, pointsAccount(&receipt), event.Delta),
+			CreatedAt: event.At,
+		})
+		if err != nil {
+			return errors.Wrap(err, "applyAdjustment")
+		}
+	}
+	receipt.Points += event.Delta
+	if event.Score != nil {
+		receipt.HistoryBonuses = event.Score.HistoryBonuses
+	}
+	receiptStore.receipts.put(event.ReceiptID, receipt)
+	receiptStore.logStoredReceipt(event.ReceiptID)
+	return nil
+}
+
+/*
+*
+This function records items returned against a stored receipt and claws back the points the event records.
+Refunds carried over by an import change no points.
+*
+*/
+func (receiptStore *ReceiptStore) applyRefundEvent(event events.Event) error {
+	receipt, found := receiptStore.receipts.get(event.ReceiptID)
+	if !found {
+		return errors.Wrapf(errReceiptNotFound, "applyRefundEvent: %s", event.ReceiptID)
+	}
+	if event.Reason != events.ReasonImported {
+		_, err := receiptStore.ledger.Post(ledger.Transaction{
+			Kind:      ledger.KindRefund,
+			ReceiptID: event.ReceiptID,
+			Reference: event.Reference,
+			Postings:  ledger.Transfer(pointsAccount(&receipt), ledger.IssuedAccount, -event.Delta),
+			CreatedAt: event.At,
+		})
+		if err != nil {
+			return errors.Wrap(err, "applyRefundEvent: posting refund failed")
+		}
+	}
+	receipt.Points += event.Delta
+	receiptStore.receipts.put(event.ReceiptID, receipt)
+
+	receiptStore.lock.Lock()
+	refundedItems := append(append([]models.Item{}, receiptStore.refundedItems[event.ReceiptID]...), event.Items...)
+	receiptStore.refundedItems[event.ReceiptID] = refundedItems
+	receiptStore.lock.Unlock()
+
+	receiptStore.logReceipt(event.ReceiptID, receipt, refundedItems)
+	return nil
+}
+
+/*
+*
+This function settles a held receipt. Approval releases its held points to the user; rejection voids them and
+removes the receipt from the user's history. A receipt imported as rejected only changes status.
+*
+*/
+func (receiptStore *ReceiptStore) applyReview(event events.Event, approve bool) error {
+	receipt, found := receiptStore.receipts.get(event.ReceiptID)
+	if !found {
+		return errors.Wrapf(errReceiptNotFound, "applyReview: %s", event.ReceiptID)
+	}
+
+	if event.Reason != events.ReasonImported {
+		heldAccount := ledger.HeldAccount(receipt.UserID)
+		held := receiptStore.ledger.ReceiptBalance(event.ReceiptID, heldAccount)
+		transaction := ledger.Transaction{
+			Kind:      ledger.KindRelease,
+			ReceiptID: event.ReceiptID,
+			Memo:      "Released after review",
+			Postings:  ledger.Transfer(heldAccount, ledger.UserAccount(receipt.UserID), held),
+			CreatedAt: event.At,
+		}
+		if !approve {
+			transaction.Kind = ledger.KindVoid
+			transaction.Memo = "Voided after review"
+			transaction.Postings = ledger.Transfer(heldAccount, ledger.IssuedAccount, held)
+		}
+		if _, err := receiptStore.ledger.Post(transaction); err != nil {
+			return errors.Wrap(err, "applyReview")
+		}
+	}
+
+	if approve {
+		receipt.Status = ""
+	} else {
+		receipt.Status = models.ReceiptRejected
+		receipt.Points = 0
+	}
+	receiptStore.receipts.put(event.ReceiptID, receipt)
+
+	receiptStore.lock.Lock()
+	if !approve {
+		receiptStore.removeUserReceipt(receipt.UserID, event.ReceiptID)
+		receiptStore.duplicates.Remove(event.ReceiptID, receipt)
+	}
+	delete(receiptStore.reviewQueue, event.ReceiptID)
+	receiptStore.lock.Unlock()
+
+	receiptStore.logStoredReceipt(event.ReceiptID)
+	return nil
+}
+
+/*
+*
+RestoreEvents rebuilds the store from its own event log, e.g. after a restart: its receipts, its whole ledger and its
+rewards catalog. It must be called before the store serves requests. The restored ledger entries are not audited
+again, and restoring fails when the audit log holds transactions the restored ledger does not, since new
+transactions would reuse their IDs in the same chain. A follower restores nothing, since it copies its leader from
+the first transaction, and starts a new audit chain for the copy.
+*
+*/
+func (receiptStore *ReceiptStore) RestoreEvents() error {
+	if receiptStore.following.Load() {
+		return errors.Wrap(receiptStore.restartAuditChain(), "RestoreEvents")
+	}
+	receiptStore.restoring.Store(true)
+	defer receiptStore.restoring.Store(false)
+	building := make(map[string]*models.Receipt)
+	err := receiptStore.eventLog.Each(func(event events.Event) error {
+		return receiptStore.applyEvent(event, building, true)
+	})
+	if err != nil {
+		return errors.Wrap(err, "RestoreEvents")
+	}
+	return errors.Wrap(receiptStore.checkAuditLog(), "RestoreEvents")
+}
+
+/*
+*
+Rebuild replays the events from source into an empty store, recording them in the store's own event log. With
+rescore, receipts are scored again under the store's current rules: their history adjustments are derived anew and
+the points of their refunds recomputed, so a scoring bug fixed in code is fixed in the data by a rebuild. Imported
+receipts keep their recorded points. The report lists every receipt whose points changed.
+*
+*/
+func (receiptStore *ReceiptStore) Rebuild(source func(fn func(event events.Event) error) error, rescore bool) (models.RebuildReport, error) {
+	receiptStore.restoring.Store(true)
+	defer receiptStore.restoring.Store(false)
+	report := models.RebuildReport{Rescored: rescore}
+	before := events.NewPoints()
+	building := make(map[string]*models.Receipt)
+
+	err := source(func(event events.Event) error {
+		report.EventsRead++
+		if err := before.Apply(event); err != nil {
+			return err
+		}
+		replayed, err := receiptStore.replayEvent(event, building, rescore)
+		if err != nil {
+			return errors.Wrapf(err, "event %d", event.Seq)
+		}
+		for _, event := range replayed {
+			appended, err := receiptStore.eventLog.Append(event)
+			if err != nil {
+				return err
+			}
+			if err := receiptStore.applyEvent(appended[0], building, true); err != nil {
+				return errors.Wrapf(err, "event %d", event.Seq)
+			}
+		}
+		if rescore && event.Type == events.Scored && event.Reason != events.ReasonImported {
+			receipt, _ := receiptStore.receipts.get(event.ReceiptID)
+			return receiptStore.rescoreLaterReceipts(event.ReceiptID, &receipt, event.At)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, errors.Wrap(err, "Rebuild")
+	}
+
+	report.EventsWritten = receiptStore.eventLog.LastSeq()
+	receiptStore.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		report.Receipts++
+		if receipt.Points != before.Receipts[receiptID] {
+			report.Changed = append(report.Changed, models.PointsChange{ReceiptID: receiptID, Before: before.Receipts[receiptID], After: receipt.Points})
+		}
+	})
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].ReceiptID < report.Changed[j].ReceiptID })
+	return report, nil
+}
+
+/*
+*
+This function decides which events a replayed event becomes. Without rescore every event is replayed as recorded.
+With rescore, scores and refunds are derived again from the rebuilt receipts and the recorded history adjustments
+are dropped, since re-scoring derives them again.
+*
+*/
+func (receiptStore *ReceiptStore) replayEvent(event events.Event, building map[string]*models.Receipt, rescore bool) ([]events.Event, error) {
+	event.Seq = 0
+	if !rescore || event.Reason == events.ReasonImported {
+		return []events.Event{event}, nil
+	}
+
+	switch {
+	case event.Type == events.Scored:
+		receipt, found := building[event.ReceiptID]
+		if !found {
+			return nil, errors.Errorf("replayEvent: scored event for unknown receipt %s", event.ReceiptID)
+		}
+		rescored := *receipt
+		rescored.BasePoints = computeReceiptPoints(&rescored)
+		receiptStore.scoreReceipt(event.ReceiptID, &rescored, event.At)
+		event.Score = scoreOf(&rescored)
+	case event.Type == events.Adjusted && event.Reason == events.ReasonHistory:
+		return nil, nil
+	case event.Type == events.Refunded:
+		receipt, found := receiptStore.receipts.get(event.ReceiptID)
+		if !found {
+			return nil, errors.Wrapf(errReceiptNotFound, "replayEvent: %s", event.ReceiptID)
+		}
+		receiptStore.lock.RLock()
+		alreadyRefunded := receiptStore.refundedItems[event.ReceiptID]
+		receiptStore.lock.RUnlock()
+		delta, err := refundDelta(receipt, alreadyRefunded, event.Items)
+		if err != nil {
+			return nil, errors.Wrap(err, "replayEvent")
+		}
+		event.Delta = delta
+	}
+	return []events.Event{event}, nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {get} /events/receipts Stream Receipts
+* @apiDescription This operator endpoint streams each receipt as it is stored, as server-sent events named "receipt" whose
+* data is the receipt and its points. The optional retailer and minPoints query parameters filter the stream. A
+* consumer that reconnects with the Last-Event-ID header first receives the recent receipts it missed. Consumers
+* that fall too far behind are disconnected rather than slowing receipt processing down, and resume the same way.
+**/
+func (receiptStore *ReceiptStore) StreamReceipts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	request, err := checkReceiptFeedValidity(r)
+	if err != nil {
+		handleErr(w, err, "StreamReceipts validation error", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleErr(w, nil, "StreamReceipts: streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscription := receiptStore.feed.Subscribe(request.lastEventID, request.filter())
+	defer receiptStore.feed.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if subscription.Gap {
+		fmt.Fprint(w, ": some receipts after the last event ID are no longer buffered\n\n")
+	}
+	for _, item := range subscription.Backlog {
+		if err := writeFeedItem(w, item); err != nil {
+			log.Printf("StreamReceipts: %v", err)
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscription.Dropped():
+			// The consumer fell behind. It reconnects with Last-Event-ID and catches up from the buffer.
+			log.Println("StreamReceipts: dropped a slow consumer")
+			return
+		case item := <-subscription.C:
+			if err := writeFeedItem(w, item); err != nil {
+				log.Printf("StreamReceipts: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
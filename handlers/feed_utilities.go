@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/feed"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+const (
+	// defaultFeedBuffer is how many recent receipts the live feed keeps for consumers that reconnect.
+	defaultFeedBuffer = 1024
+	// feedSubscriberBuffer is how many receipts a feed consumer may fall behind before it is dropped.
+	feedSubscriberBuffer = 64
+	// feedHeartbeat is how often an idle feed stream sends a comment, so proxies keep the connection open.
+	feedHeartbeat = 15 * time.Second
+)
+
+// receiptFeedRequest is a feed consumer's filters and the ID it resumes after.
+type receiptFeedRequest struct {
+	retailer    string
+	minPoints   int
+	lastEventID uint64
+}
+
+/*
+*
+This function checks the validity of the feed filters in the query string, e.g. "?retailer=Target&minPoints=50", and
+of the Last-Event-ID header a reconnecting consumer sends. The ID can also be given as the lastEventId query
+parameter, for consumers that cannot set headers.
+*
+*/
+func checkReceiptFeedValidity(r *http.Request) (*receiptFeedRequest, error) {
+	query := r.URL.Query()
+	request := &receiptFeedRequest{retailer: strings.TrimSpace(query.Get("retailer"))}
+	if rawMinPoints := strings.TrimSpace(query.Get("minPoints")); rawMinPoints != "" {
+		minPoints, err := strconv.Atoi(rawMinPoints)
+		if err != nil || minPoints < 0 {
+			return nil, errors.New("checkReceiptFeedValidity: minPoints validation failed")
+		}
+		request.minPoints = minPoints
+	}
+
+	rawLastEventID := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if rawLastEventID == "" {
+		rawLastEventID = strings.TrimSpace(query.Get("lastEventId"))
+	}
+	if rawLastEventID != "" {
+		lastEventID, err := strconv.ParseUint(rawLastEventID, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "checkReceiptFeedValidity: Last-Event-ID validation failed")
+		}
+		request.lastEventID = lastEventID
+	}
+	return request, nil
+}
+
+/*
+*
+Helper function to turn the feed filters into a filter over feed items. Retailers match case-insensitively.
+*
+*/
+func (request *receiptFeedRequest) filter() feed.Filter {
+	if request.retailer == "" && request.minPoints == 0 {
+		return nil
+	}
+	return func(item feed.Item) bool {
+		if request.retailer != "" && !strings.EqualFold(item.Receipt.Retailer, request.retailer) {
+			return false
+		}
+		return item.Receipt.Points >= request.minPoints
+	}
+}
+
+/*
+*
+This function puts the receipts scored by the given events on the live feed. An item's ID is the sequence number of
+its Scored event, so IDs keep increasing across restarts when the event log is kept in a file.
+*
+*/
+func (receiptStore *ReceiptStore) publishFeed(recorded []events.Event) {
+	for _, event := range recorded {
+		if event.Type != events.Scored {
+			continue
+		}
+		receipt, found := receiptStore.receipts.get(event.ReceiptID)
+		if !found {
+			continue
+		}
+		receiptStore.feed.Publish(feed.Item{ID: event.Seq, Receipt: models.ReceiptFeedEvent{
+			ID:           event.ReceiptID,
+			UserID:       receipt.UserID,
+			Retailer:     receipt.Retailer,
+			PurchaseDate: receipt.PurchaseDate,
+			PurchaseTime: receipt.PurchaseTime,
+			Total:        receipt.Total,
+			Points:       receipt.Points,
+			Status:       receipt.Status,
+			StoredAt:     event.At,
+		}})
+	}
+}
+
+/*
+*
+Helper function to write a feed item as a server-sent event named "receipt".
+*
+*/
+func writeFeedItem(w io.Writer, item feed.Item) error {
+	data, err := json.Marshal(item.Receipt)
+	if err != nil {
+		return errors.Wrap(err, "writeFeedItem")
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: receipt\ndata: %s\n\n", item.ID, data)
+	return errors.Wrap(err, "writeFeedItem")
+}
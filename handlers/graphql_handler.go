@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	json "github.com/json-iterator/go"
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {post} /graphql Execute GraphQL
+* @apiDescription This endpoint executes a GraphQL query over receipts, their items and points breakdown, and their
+* users, or the processReceipt mutation. Queries may also be sent with GET, but mutations may not.
+**/
+func (receiptStore *ReceiptStore) ExecuteGraphQL(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	request, err := checkGraphQLRequestValidity(r)
+	if err != nil {
+		handleErr(w, err, "ExecuteGraphQL validation error", http.StatusBadRequest)
+		return
+	}
+	document, operation, requestErrors := receiptStore.parseGraphQLDocument(*request)
+	if len(requestErrors) > 0 {
+		sendGraphQLRequestErrors(w, &graphql.Result{Errors: requestErrors})
+		return
+	}
+	//Whatever comments or other operations the document holds, a link must never trigger a mutation
+	if r.Method == http.MethodGet && operation.Operation == ast.OperationTypeMutation {
+		handleErr(w, nil, "ExecuteGraphQL validation error: mutations must be sent with POST", http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        receiptStore.graphqlSchema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       receiptStore.newGraphQLContext(r),
+	})
+	if isGraphQLRequestError(result) {
+		sendGraphQLRequestErrors(w, result)
+		return
+	}
+	if err := sendJSON(w, result); err != nil {
+		handleErr(w, err, "Error marshaling GraphQL response", http.StatusInternalServerError)
+	}
+}
+
+/*
+*
+Helper function to answer a GraphQL request that was not executed with 400 Bad Request and the errors saying why.
+*
+*/
+func sendGraphQLRequestErrors(w http.ResponseWriter, result *graphql.Result) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		handleErr(w, err, "Error marshaling GraphQL response", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, http.StatusBadRequest, data)
+}
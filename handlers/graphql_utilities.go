@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	json "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+const (
+	// maxGraphQLBatch bounds how many receipts one receipts query may ask for.
+	maxGraphQLBatch = 500
+	// maxGraphQLDepth bounds how deeply selections may nest, since nested fields such as a user's receipts' users can
+	// otherwise make a small query produce a huge result.
+	maxGraphQLDepth = 10
+)
+
+// graphQLContextKey carries the graphQLRequestState of the request a resolver runs for.
+type graphQLContextKey struct{}
+
+// graphQLRequestState is what the resolvers of one GraphQL request share.
+type graphQLRequestState struct {
+	// caller is who the request authenticated as, and rateLimitKey who its processReceipt mutations are charged to.
+	caller       caller
+	rateLimitKey string
+	// users and userReceipts batch the lookups of users and of their receipts, level by level.
+	users        *graphQLBatch
+	userReceipts *graphQLBatch
+}
+
+/*
+graphQLBatch collects the keys resolvers ask for at one level of a GraphQL result and loads them together. A resolver
+asks with get and returns the thunk it gets back. The executor only runs thunks once every resolver of their level
+has run, so the first thunk run loads every key asked for so far.
+*/
+type graphQLBatch struct {
+	load    func(keys []string) []interface{}
+	pending []string
+	loaded  map[string]interface{}
+}
+
+/*
+*
+Helper function to create a batch that loads its keys with the given function, which returns one value per key.
+*
+*/
+func newGraphQLBatch(load func(keys []string) []interface{}) *graphQLBatch {
+	return &graphQLBatch{load: load, loaded: make(map[string]interface{})}
+}
+
+/*
+*
+Helper function to ask a batch for the value of a key. It returns a thunk for a resolver to return.
+*
+*/
+func (batch *graphQLBatch) get(key string) func() (interface{}, error) {
+	if _, found := batch.loaded[key]; !found {
+		batch.pending = append(batch.pending, key)
+	}
+	return func() (interface{}, error) {
+		if _, found := batch.loaded[key]; !found {
+			keys := batch.pending
+			batch.pending = nil
+			for i, value := range batch.load(keys) {
+				batch.loaded[keys[i]] = value
+			}
+		}
+		return batch.loaded[key], nil
+	}
+}
+
+/*
+*
+This function reads a GraphQL request from a POST body, or from the query string of a GET.
+*
+*/
+func checkGraphQLRequestValidity(r *http.Request) (*models.GraphQLRequest, error) {
+	var request models.GraphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if raw := query.Get("variables"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &request.Variables); err != nil {
+				return nil, errors.Wrap(err, "checkGraphQLRequestValidity: variables validation failed")
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errors.Wrap(err, "checkGraphQLRequestValidity: unmarshaling failed")
+	}
+
+	if strings.TrimSpace(request.Query) == "" {
+		return nil, errors.New("checkGraphQLRequestValidity: query validation failed")
+	}
+	return &request, nil
+}
+
+/*
+*
+This function parses and validates a GraphQL request's document against the schema, and returns it with the
+operation the request selects. The errors say why a document cannot be executed.
+*
+*/
+func (receiptStore *ReceiptStore) parseGraphQLDocument(request models.GraphQLRequest) (*ast.Document, *ast.OperationDefinition, []gqlerrors.FormattedError) {
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return nil, nil, gqlerrors.FormatErrors(err)
+	}
+	if validation := graphql.ValidateDocument(&receiptStore.graphqlSchema, document, nil); !validation.IsValid {
+		return nil, nil, validation.Errors
+	}
+
+	var operation *ast.OperationDefinition
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.OperationDefinition:
+			if request.OperationName == "" && operation != nil {
+				return nil, nil, gqlerrors.FormatErrors(errors.New("an operationName is required when the document has several operations"))
+			}
+			if request.OperationName == "" || (definition.Name != nil && definition.Name.Value == request.OperationName) {
+				operation = definition
+			}
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		}
+	}
+	if operation == nil {
+		return nil, nil, gqlerrors.FormatErrors(errors.Errorf("the document has no operation named %q", request.OperationName))
+	}
+	if depth := graphQLSelectionDepth(operation.SelectionSet, fragments, make(map[string]int)); depth > maxGraphQLDepth {
+		return nil, nil, gqlerrors.FormatErrors(errors.Errorf("selections nest %d levels deep, more than the %d allowed", depth, maxGraphQLDepth))
+	}
+	return document, operation, nil
+}
+
+/*
+*
+Helper function to find how many levels of fields a selection set nests, through its fragments too. Validation has
+ruled out fragments that spread themselves, and each fragment's depth is only counted once.
+*
+*/
+func graphQLSelectionDepth(selectionSet *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, fragmentDepths map[string]int) int {
+	if selectionSet == nil {
+		return 0
+	}
+	depth := 0
+	for _, selection := range selectionSet.Selections {
+		nested := 0
+		switch selection := selection.(type) {
+		case *ast.Field:
+			nested = 1 + graphQLSelectionDepth(selection.SelectionSet, fragments, fragmentDepths)
+		case *ast.InlineFragment:
+			nested = graphQLSelectionDepth(selection.SelectionSet, fragments, fragmentDepths)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragmentDepth, counted := fragmentDepths[name]
+			if fragment, found := fragments[name]; found && !counted {
+				fragmentDepth = graphQLSelectionDepth(fragment.SelectionSet, fragments, fragmentDepths)
+				fragmentDepths[name] = fragmentDepth
+			}
+			nested = fragmentDepth
+		}
+		if nested > depth {
+			depth = nested
+		}
+	}
+	return depth
+}
+
+/*
+*
+Helper function to tell a request that failed before it was executed, e.g. for invalid variables, from one whose
+fields failed. Only field errors have a path.
+*
+*/
+func isGraphQLRequestError(result *graphql.Result) bool {
+	if result.Data != nil || len(result.Errors) == 0 {
+		return false
+	}
+	for _, err := range result.Errors {
+		if len(err.Path) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+*
+This function returns the context a GraphQL request's resolvers run in, carrying the state they share.
+*
+*/
+func (receiptStore *ReceiptStore) newGraphQLContext(r *http.Request) context.Context {
+	authenticated := receiptStore.authenticate(r)
+	return context.WithValue(r.Context(), graphQLContextKey{}, receiptStore.newGraphQLState(authenticated, authenticated.rateLimitKey(r.RemoteAddr)))
+}
+
+/*
+*
+Helper function to create the state the resolvers of one GraphQL request share, for the given caller charging its
+mutations to the given rate limit key.
+*
+*/
+func (receiptStore *ReceiptStore) newGraphQLState(authenticated caller, rateLimitKey string) *graphQLRequestState {
+	return &graphQLRequestState{
+		caller:       authenticated,
+		rateLimitKey: rateLimitKey,
+		users: newGraphQLBatch(func(userIDs []string) []interface{} {
+			users := receiptStore.loadUsers(userIDs)
+			values := make([]interface{}, len(users))
+			for i, user := range users {
+				values[i] = user
+			}
+			return values
+		}),
+		userReceipts: newGraphQLBatch(func(userIDs []string) []interface{} {
+			receiptIDs := make([][]string, len(userIDs))
+			var allReceiptIDs []string
+			receiptStore.lock.RLock()
+			for i, userID := range userIDs {
+				receiptIDs[i] = append([]string{}, receiptStore.userReceipts[userID]...)
+				allReceiptIDs = append(allReceiptIDs, receiptIDs[i]...)
+			}
+			receiptStore.lock.RUnlock()
+			found := receiptStore.loadReceipts(allReceiptIDs)
+			values := make([]interface{}, len(userIDs))
+			offset := 0
+			for i := range userIDs {
+				receipts := []*models.StoredReceipt{}
+				for range receiptIDs[i] {
+					if found[offset] != nil {
+						receipts = append(receipts, found[offset])
+					}
+					offset++
+				}
+				values[i] = receipts
+			}
+			return values
+		}),
+	}
+}
+
+/*
+*
+Helper function to find the state shared by the resolvers of a GraphQL request. A context without one, which only
+happens outside ExecuteGraphQL, gets a fresh state that batches nothing across resolvers.
+*
+*/
+func (receiptStore *ReceiptStore) graphQLState(ctx context.Context) *graphQLRequestState {
+	if state, ok := ctx.Value(graphQLContextKey{}).(*graphQLRequestState); ok {
+		return state
+	}
+	return receiptStore.newGraphQLState(caller{}, "")
+}
+
+/*
+*
+Helper function to tell whether the caller of a GraphQL request may read a user's balance and receipts. Like the REST
+endpoints, only the user and the operator may.
+*
+*/
+func (state *graphQLRequestState) authorizeUser(userID string) error {
+	switch {
+	case state.caller.mayActFor(userID):
+		return nil
+	case state.caller.userID == "":
+		return errors.Errorf("authentication required to read user %s", userID)
+	default:
+		return errors.Errorf("%s may not read user %s", state.caller.userID, userID)
+	}
+}
+
+/*
+*
+This function builds the GraphQL schema over the store. The user and receipts fields of each level of the result are
+looked up in one batch, so a query for many receipts and their users costs one lookup per level rather than one per
+receipt.
+*
+*/
+func (receiptStore *ReceiptStore) newGraphQLSchema() graphql.Schema {
+	nonNullString := graphql.NewNonNull(graphql.String)
+	nonNullInt := graphql.NewNonNull(graphql.Int)
+	stored := func(get func(receipt *models.StoredReceipt) interface{}) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) { return get(p.Source.(*models.StoredReceipt)), nil }
+	}
+	optional := func(value string) interface{} {
+		if value == "" {
+			return nil
+		}
+		return value
+	}
+
+	itemType := graphql.NewObject(graphql.ObjectConfig{Name: "Item", Fields: graphql.Fields{
+		"shortDescription": &graphql.Field{Type: nonNullString, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(models.Item).ShortDescription, nil
+		}},
+		"price": &graphql.Field{Type: nonNullString, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(models.Item).Price, nil
+		}},
+	}})
+	contributionType := graphql.NewObject(graphql.ObjectConfig{Name: "RuleContribution", Fields: graphql.Fields{
+		"rule": &graphql.Field{Type: nonNullString, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(models.RuleBonus).Rule, nil
+		}},
+		"points": &graphql.Field{Type: nonNullInt, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(models.RuleBonus).Points, nil
+		}},
+	}})
+
+	//Receipts and users refer to each other, so their fields are only built once both types exist
+	var receiptType, userType *graphql.Object
+	receiptType = graphql.NewObject(graphql.ObjectConfig{Name: "Receipt", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.ID })},
+			"userId":       &graphql.Field{Type: graphql.ID, Resolve: stored(func(r *models.StoredReceipt) interface{} { return optional(r.Receipt.UserID) })},
+			"retailer":     &graphql.Field{Type: nonNullString, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.Retailer })},
+			"purchaseDate": &graphql.Field{Type: nonNullString, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.PurchaseDate })},
+			"purchaseTime": &graphql.Field{Type: nonNullString, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.PurchaseTime })},
+			"total":        &graphql.Field{Type: nonNullString, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.Total })},
+			"points":       &graphql.Field{Type: nonNullInt, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.Points })},
+			"basePoints":   &graphql.Field{Type: nonNullInt, Resolve: stored(func(r *models.StoredReceipt) interface{} { return r.Receipt.BasePoints })},
+			"tier":         &graphql.Field{Type: graphql.String, Resolve: stored(func(r *models.StoredReceipt) interface{} { return optional(r.Receipt.Tier) })},
+			"status":       &graphql.Field{Type: graphql.String, Resolve: stored(func(r *models.StoredReceipt) interface{} { return optional(r.Receipt.Status) })},
+			"flags": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(nonNullString)), Resolve: stored(func(r *models.StoredReceipt) interface{} {
+				return append([]string{}, r.Receipt.Flags...)
+			})},
+			"items": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(itemType))), Resolve: stored(func(r *models.StoredReceipt) interface{} {
+				return append([]models.Item{}, r.Receipt.Items...)
+			})},
+			"breakdown": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(contributionType))), Resolve: stored(func(r *models.StoredReceipt) interface{} {
+				return receiptBreakdown(r.Receipt)
+			})},
+			"user": &graphql.Field{Type: userType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				userID, state := p.Source.(*models.StoredReceipt).Receipt.UserID, receiptStore.graphQLState(p.Context)
+				if userID == "" {
+					return nil, nil
+				}
+				if err := state.authorizeUser(userID); err != nil {
+					return nil, err
+				}
+				return state.users.get(userID), nil
+			}},
+		}
+	})})
+	userType = graphql.NewObject(graphql.ObjectConfig{Name: "User", Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*models.BalanceResponse).UserID, nil
+			}},
+			"balance": &graphql.Field{Type: nonNullInt, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*models.BalanceResponse).Balance, nil
+			}},
+			"tier": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return optional(p.Source.(*models.BalanceResponse).Tier), nil
+			}},
+			"receipts": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(receiptType))), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return receiptStore.graphQLState(p.Context).userReceipts.get(p.Source.(*models.BalanceResponse).UserID), nil
+			}},
+		}
+	})})
+
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"receipt": &graphql.Field{
+			Type: receiptType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return receiptStore.loadReceipts([]string{p.Args["id"].(string)})[0], nil
+			}},
+		"receipts": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(receiptType)),
+			Args: graphql.FieldConfigArgument{"ids": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID)))}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ids := p.Args["ids"].([]interface{})
+				if len(ids) > maxGraphQLBatch {
+					return nil, fmt.Errorf("at most %d receipts can be fetched at once", maxGraphQLBatch)
+				}
+				receiptIDs := make([]string, len(ids))
+				for i, id := range ids {
+					receiptIDs[i] = id.(string)
+				}
+				return receiptStore.loadReceipts(receiptIDs), nil
+			}},
+		"user": &graphql.Field{
+			Type: userType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				userID, err := checkUserID(p.Args["id"].(string))
+				if err != nil {
+					return nil, errors.New("user ID validation failed")
+				}
+				state := receiptStore.graphQLState(p.Context)
+				if err := state.authorizeUser(userID); err != nil {
+					return nil, err
+				}
+				return state.users.get(userID), nil
+			}},
+	}})
+
+	itemInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "ItemInput", Fields: graphql.InputObjectConfigFieldMap{
+		"shortDescription": &graphql.InputObjectFieldConfig{Type: nonNullString},
+		"price":            &graphql.InputObjectFieldConfig{Type: nonNullString},
+	}})
+	receiptInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "ReceiptInput", Fields: graphql.InputObjectConfigFieldMap{
+		"userId":       &graphql.InputObjectFieldConfig{Type: graphql.ID},
+		"retailer":     &graphql.InputObjectFieldConfig{Type: nonNullString},
+		"purchaseDate": &graphql.InputObjectFieldConfig{Type: nonNullString},
+		"purchaseTime": &graphql.InputObjectFieldConfig{Type: nonNullString},
+		"items":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(itemInput)))},
+		"total":        &graphql.InputObjectFieldConfig{Type: nonNullString},
+	}})
+	mutation := graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: graphql.Fields{
+		"processReceipt": &graphql.Field{
+			Type: graphql.NewNonNull(receiptType),
+			Args: graphql.FieldConfigArgument{"receipt": &graphql.ArgumentConfig{Type: graphql.NewNonNull(receiptInput)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return receiptStore.processGraphQLReceipt(p.Context, p.Args["receipt"].(map[string]interface{}))
+			}},
+	}})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+/*
+*
+This function charges a processReceipt mutation to the rate limit of POST /receipts/process, so a document that
+processes many receipts, e.g. under aliases, is limited like as many REST requests.
+*
+*/
+func (receiptStore *ReceiptStore) limitGraphQLMutation(ctx context.Context) error {
+	if receiptStore.rateLimiter == nil {
+		return nil
+	}
+	const route = "POST /receipts/process"
+	allowed, retryAfter, err := receiptStore.rateLimiter.Allow(route, receiptStore.graphQLState(ctx).rateLimitKey)
+	if err != nil {
+		log.Printf("limitGraphQLMutation: %s: %v", route, err)
+	}
+	if !allowed {
+		return fmt.Errorf("%s limit exceeded, retry in %ds", route, int(math.Max(1, math.Ceil(retryAfter.Seconds()))))
+	}
+	return nil
+}
+
+/*
+*
+This function validates, scores and stores a receipt submitted through the processReceipt mutation, exactly as
+POST /receipts/process does, and returns it as stored. Each receipt counts against the caller's rate limit.
+*
+*/
+func (receiptStore *ReceiptStore) processGraphQLReceipt(ctx context.Context, input map[string]interface{}) (*models.StoredReceipt, error) {
+	if receiptStore.following.Load() {
+		return nil, fmt.Errorf("this instance is a read-only follower of %s", receiptStore.leaderURL)
+	}
+	if err := receiptStore.limitGraphQLMutation(ctx); err != nil {
+		return nil, err
+	}
+	//The input was already coerced to the schema, so it converts to a receipt field for field
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "processReceipt: encoding receipt failed")
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(encoded, &receipt); err != nil {
+		return nil, errors.Wrap(err, "processReceipt: decoding receipt failed")
+	}
+	if err := validateReceiptData(receipt); err != nil {
+		return nil, err
+	}
+
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		return nil, err
+	}
+	if stored := receiptStore.loadReceipts([]string{receiptID})[0]; stored != nil {
+		return stored, nil
+	}
+	return &models.StoredReceipt{ID: receiptID, Receipt: receipt}, nil
+}
+
+/*
+*
+This function looks up a batch of receipts at once. Legacy IDs are translated under a single acquisition of the
+store lock, and each receipt is read once however often it is asked for. Missing receipts are nil.
+*
+*/
+func (receiptStore *ReceiptStore) loadReceipts(rawReceiptIDs []string) []*models.StoredReceipt {
+	receiptIDs := make([]string, len(rawReceiptIDs))
+	receiptStore.lock.RLock()
+	for i, rawReceiptID := range rawReceiptIDs {
+		receiptIDs[i] = strings.TrimSpace(rawReceiptID)
+		if currentID, isLegacy := receiptStore.legacyIDs[receiptIDs[i]]; isLegacy {
+			receiptIDs[i] = currentID
+		}
+	}
+	receiptStore.lock.RUnlock()
+
+	loaded := make(map[string]*models.StoredReceipt, len(receiptIDs))
+	results := make([]*models.StoredReceipt, len(receiptIDs))
+	for i, receiptID := range receiptIDs {
+		stored, seen := loaded[receiptID]
+		if !seen {
+			if receipt, found := receiptStore.receipts.get(receiptID); found {
+				stored = &models.StoredReceipt{ID: receiptID, Receipt: receipt}
+			}
+			loaded[receiptID] = stored
+		}
+		results[i] = stored
+	}
+	return results
+}
+
+/*
+*
+This function looks up the balances and tiers of a batch of users at once, reading each user's ledger account once
+however many receipts they own. Anonymous receipts have no user, so their entries are nil.
+*
+*/
+func (receiptStore *ReceiptStore) loadUsers(userIDs []string) []*models.BalanceResponse {
+	loaded := make(map[string]*models.BalanceResponse, len(userIDs))
+	results := make([]*models.BalanceResponse, len(userIDs))
+	for i, userID := range userIDs {
+		if userID == "" {
+			continue
+		}
+		user, seen := loaded[userID]
+		if !seen {
+			balance := receiptStore.userBalance(userID)
+			user = &balance
+			loaded[userID] = user
+		}
+		results[i] = user
+	}
+	return results
+}
+
+/*
+*
+This function explains a receipt's points rule by rule: the base scoring rules, the loyalty tier multiplier, the
+history bonuses, and any points later refunded or voided. The contributions add up to the receipt's points.
+*
+*/
+func receiptBreakdown(receipt models.Receipt) []models.RuleBonus {
+	itemPairs := 5 * (len(receipt.Items) / 2)
+	roundDollar, quarterMultiple := 0, 0
+	if bonus := computeBonusForTotal(receipt.Total); bonus >= pointsForRoundDollarTotal {
+		roundDollar, quarterMultiple = pointsForRoundDollarTotal, bonus-pointsForRoundDollarTotal
+	} else {
+		quarterMultiple = bonus
+	}
+	breakdown := []models.RuleBonus{
+		{Rule: "retailer-name", Points: computePointsFromRetailerName(receipt.Retailer)},
+		{Rule: "round-dollar-total", Points: roundDollar},
+		{Rule: "quarter-multiple-total", Points: quarterMultiple},
+		{Rule: "item-pairs", Points: itemPairs},
+		{Rule: "item-descriptions", Points: computeItemPoints(receipt.Items) - itemPairs},
+		{Rule: "odd-day", Points: computeDateBonus(receipt.PurchaseDate)},
+		{Rule: "afternoon-purchase", Points: computeTimeBonus(receipt.PurchaseTime)},
+	}
+	basePoints := loyalty.TotalBonus(breakdown)
+	if receipt.BasePoints != 0 {
+		basePoints = receipt.BasePoints
+	}
+	if receipt.TierMultiplier != 0 {
+		breakdown = append(breakdown, models.RuleBonus{
+			Rule:   "tier-multiplier",
+			Points: loyalty.ApplyMultiplier(basePoints, receipt.TierMultiplier) - basePoints,
+		})
+	}
+	breakdown = append(breakdown, receipt.HistoryBonuses...)
+	if remainder := receipt.Points - loyalty.TotalBonus(breakdown); remainder != 0 {
+		breakdown = append(breakdown, models.RuleBonus{Rule: "refunds-and-reviews", Points: remainder})
+	}
+	return breakdown
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/grpcapi"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultReceiptPageSize and maxReceiptPageSize bound a page of listed receipts.
+	defaultReceiptPageSize = 50
+	maxReceiptPageSize     = 500
+)
+
+// grpcRoutes names the REST routes whose rate limits gRPC methods share. Every receipt sent to BatchScore counts
+// against the limit of POST /receipts/process; methods not listed share the default limit under their own name.
+var grpcRoutes = map[string]string{
+	grpcapi.ReceiptService_ProcessReceipt_FullMethodName: "POST /receipts/process",
+	grpcapi.ReceiptService_BatchScore_FullMethodName:     "POST /receipts/process",
+	grpcapi.ReceiptService_GetPoints_FullMethodName:      "GET /receipts/:id/points",
+}
+
+// callerContextKey carries the caller a gRPC call authenticated as.
+type callerContextKey struct{}
+
+/*
+*
+GRPCServerOptions returns the interceptors the gRPC server needs. Every call must authenticate like a REST request,
+with the admin token in x-admin-token metadata or a user token in authorization metadata, and is rate limited like
+the REST route it mirrors, against the client of any API key in x-api-key metadata.
+*
+*/
+func (receiptStore *ReceiptStore) GRPCServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := receiptStore.authenticateGRPC(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := receiptStore.limitGRPC(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, request)
+		}),
+		grpc.ChainStreamInterceptor(func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := receiptStore.authenticateGRPC(stream.Context())
+			if err != nil {
+				return err
+			}
+			return handler(server, &limitedStream{ServerStream: stream, ctx: ctx, receiptStore: receiptStore, method: info.FullMethod})
+		}),
+	}
+}
+
+// limitedStream authenticates a stream's context and rate limits every message received on it.
+type limitedStream struct {
+	grpc.ServerStream
+	ctx          context.Context
+	receiptStore *ReceiptStore
+	method       string
+}
+
+func (s *limitedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *limitedStream) RecvMsg(message interface{}) error {
+	if err := s.ServerStream.RecvMsg(message); err != nil {
+		return err
+	}
+	return s.receiptStore.limitGRPC(s.ctx, s.method)
+}
+
+/*
+*
+This function authenticates a gRPC call from its metadata and returns its context carrying the caller. Calls without
+valid credentials fail with Unauthenticated.
+*
+*/
+func (receiptStore *ReceiptStore) authenticateGRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	authenticated := receiptStore.authenticateCredentials(first("x-admin-token"), first("authorization"), first("x-api-key"))
+	if !authenticated.admin && authenticated.userID == "" {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return context.WithValue(ctx, callerContextKey{}, authenticated), nil
+}
+
+/*
+*
+This function charges a gRPC call to its caller's rate limit, failing it with ResourceExhausted when the caller is
+over the limit.
+*
+*/
+func (receiptStore *ReceiptStore) limitGRPC(ctx context.Context, method string) error {
+	if receiptStore.rateLimiter == nil {
+		return nil
+	}
+	route, found := grpcRoutes[method]
+	if !found {
+		route = method
+	}
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	allowed, retryAfter, err := receiptStore.rateLimiter.Allow(route, grpcCaller(ctx).rateLimitKey(remoteAddr))
+	if err != nil {
+		log.Printf("limitGRPC: %s: %v", route, err)
+	}
+	if !allowed {
+		return status.Errorf(codes.ResourceExhausted, "%s limit exceeded, retry in %ds", route, int(math.Max(1, math.Ceil(retryAfter.Seconds()))))
+	}
+	return nil
+}
+
+/*
+*
+Helper function to find who a gRPC call authenticated as. A call that did not pass through the interceptors is
+nobody.
+*
+*/
+func grpcCaller(ctx context.Context) caller {
+	authenticated, _ := ctx.Value(callerContextKey{}).(caller)
+	return authenticated
+}
+
+/*
+*
+SubmitReceipt validates, scores and stores a receipt for the gRPC API, exactly as POST /receipts/process does. A user
+may only submit their own receipts or anonymous ones.
+*
+*/
+func (receiptStore *ReceiptStore) SubmitReceipt(ctx context.Context, receipt models.Receipt) (models.ReceiptResponse, error) {
+	if receiptStore.following.Load() {
+		return models.ReceiptResponse{}, status.Errorf(codes.FailedPrecondition, "this instance is a read-only follower of %s", receiptStore.leaderURL)
+	}
+	if authenticated := grpcCaller(ctx); receipt.UserID != "" && !authenticated.mayActFor(receipt.UserID) {
+		return models.ReceiptResponse{}, status.Errorf(codes.PermissionDenied, "%s may not submit receipts for %s", authenticated.userID, receipt.UserID)
+	}
+	if err := validateReceiptData(receipt); err != nil {
+		return models.ReceiptResponse{}, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if errors.Is(err, errDuplicateReceipt) {
+		return models.ReceiptResponse{}, status.Errorf(codes.AlreadyExists, "%v", err)
+	}
+	if err != nil {
+		return models.ReceiptResponse{}, err
+	}
+	return models.ReceiptResponse{Id: receiptID, Status: receipt.Status}, nil
+}
+
+/*
+*
+ReceiptPoints returns a stored receipt's points for the gRPC API, as GET /receipts/:id/points does.
+*
+*/
+func (receiptStore *ReceiptStore) ReceiptPoints(ctx context.Context, receiptID string) (models.PointsResponse, error) {
+	stored, err := receiptStore.StoredReceipt(ctx, receiptID)
+	if err != nil {
+		return models.PointsResponse{}, err
+	}
+	return models.PointsResponse{Points: stored.Receipt.Points}, nil
+}
+
+/*
+*
+StoredReceipt returns a stored receipt for the gRPC API. Decimal IDs issued before receipts were content-addressed
+are found too. A user may only read their own receipts and anonymous ones.
+*
+*/
+func (receiptStore *ReceiptStore) StoredReceipt(ctx context.Context, rawReceiptID string) (models.StoredReceipt, error) {
+	rawReceiptID = strings.TrimSpace(rawReceiptID)
+	if rawReceiptID == "" {
+		return models.StoredReceipt{}, status.Errorf(codes.InvalidArgument, "no receipt ID provided")
+	}
+	receiptID, receipt, found := receiptStore.lookupReceipt(rawReceiptID)
+	if !found {
+		return models.StoredReceipt{}, status.Errorf(codes.NotFound, "receipt %s not found", rawReceiptID)
+	}
+	if authenticated := grpcCaller(ctx); receipt.UserID != "" && !authenticated.mayActFor(receipt.UserID) {
+		return models.StoredReceipt{}, status.Errorf(codes.PermissionDenied, "%s may not read receipt %s", authenticated.userID, rawReceiptID)
+	}
+	return models.StoredReceipt{ID: receiptID, Receipt: receipt}, nil
+}
+
+/*
+*
+ListReceipts returns a page of stored receipts in ID order for the gRPC API, only the given user's when a user ID
+is given. The page token is the last ID of the previous page. A user may only list their own receipts; listing
+every receipt takes the admin token.
+*
+*/
+func (receiptStore *ReceiptStore) ListReceipts(ctx context.Context, rawUserID string, pageSize int, pageToken string) (models.ReceiptPage, error) {
+	authenticated, userID := grpcCaller(ctx), strings.TrimSpace(rawUserID)
+	if userID == "" && !authenticated.admin {
+		return models.ReceiptPage{}, status.Errorf(codes.PermissionDenied, "listing every user's receipts requires the admin token")
+	}
+	if userID != "" && !authenticated.mayActFor(userID) {
+		return models.ReceiptPage{}, status.Errorf(codes.PermissionDenied, "%s may not list the receipts of %s", authenticated.userID, userID)
+	}
+	return receiptStore.listReceipts(userID, pageSize, pageToken)
+}
+
+/*
+*
+This function returns a page of stored receipts in ID order, only the given user's when a user ID is given. The page
+token is the last ID of the previous page.
+*
+*/
+func (receiptStore *ReceiptStore) listReceipts(rawUserID string, pageSize int, pageToken string) (models.ReceiptPage, error) {
+	switch {
+	case pageSize < 0:
+		return models.ReceiptPage{}, status.Errorf(codes.InvalidArgument, "page size validation failed")
+	case pageSize == 0:
+		pageSize = defaultReceiptPageSize
+	case pageSize > maxReceiptPageSize:
+		pageSize = maxReceiptPageSize
+	}
+
+	var receiptIDs []string
+	if rawUserID != "" {
+		userID, err := checkUserID(rawUserID)
+		if err != nil {
+			return models.ReceiptPage{}, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		receiptStore.lock.RLock()
+		receiptIDs = append(receiptIDs, receiptStore.userReceipts[userID]...)
+		receiptStore.lock.RUnlock()
+	} else {
+		receiptStore.receipts.forEach(func(receiptID string, _ models.Receipt) {
+			receiptIDs = append(receiptIDs, receiptID)
+		})
+	}
+	sort.Strings(receiptIDs)
+
+	page := models.ReceiptPage{Receipts: []models.StoredReceipt{}}
+	start := sort.SearchStrings(receiptIDs, pageToken)
+	if start < len(receiptIDs) && pageToken != "" && receiptIDs[start] == pageToken {
+		start++
+	}
+	for _, receiptID := range receiptIDs[start:] {
+		if len(page.Receipts) == pageSize {
+			page.NextPageToken = page.Receipts[pageSize-1].ID
+			break
+		}
+		if receipt, found := receiptStore.receipts.get(receiptID); found {
+			page.Receipts = append(page.Receipts, models.StoredReceipt{ID: receiptID, Receipt: receipt})
+		}
+	}
+	return page, nil
+}
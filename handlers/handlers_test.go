@@ -1,11 +1,51 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/audit"
+	"github.com/praveensundaram1/receipt-processor-challenge/dedup"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/expiry"
+	"github.com/praveensundaram1/receipt-processor-challenge/grpcapi"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
+	"github.com/praveensundaram1/receipt-processor-challenge/ratelimit"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/receipttext"
+	"github.com/praveensundaram1/receipt-processor-challenge/replication"
+	"github.com/praveensundaram1/receipt-processor-challenge/risk"
+	"github.com/praveensundaram1/receipt-processor-challenge/sinks"
+	"github.com/praveensundaram1/receipt-processor-challenge/snapshot"
+	"github.com/praveensundaram1/receipt-processor-challenge/storage"
+	"github.com/praveensundaram1/receipt-processor-challenge/webhooks"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 
 	json "github.com/json-iterator/go"
+	"github.com/julienschmidt/httprouter"
 	"github.com/praveensundaram1/receipt-processor-challenge/models"
 )
 
@@ -117,3 +157,1999 @@ func TestComputeReceiptPoints(t *testing.T) {
 		})
 	}
 }
+
+// TestApplyRefund tests the applyRefund function.
+func TestApplyRefund(t *testing.T) {
+	receiptStore := NewReceiptStore()
+	receipt := GetSampleReceipt()
+	receipt.Points = 0
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		items         []models.Item
+		expectedDelta int
+		expectErr     error
+	}{
+		{"Partial refund", []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}, -55, nil},
+		{"Unknown item", []models.Item{{ShortDescription: "Pepsi", Price: "2.25"}}, 0, errRefundExceedsPurchase},
+		{"Refund of remaining items", nil, -54, nil},
+		{"Refund more than bought", []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}, 0, errRefundExceedsPurchase},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := receiptStore.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptID, Items: tc.items})
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("applyRefund() expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyRefund() failed: %v", err)
+			}
+			if response.PointsDelta != tc.expectedDelta {
+				t.Errorf("applyRefund() got delta = %d, expected %d", response.PointsDelta, tc.expectedDelta)
+			}
+		})
+	}
+
+	if balance := receiptStore.ledger.ReceiptBalance(receiptID, ledger.UnclaimedAccount); balance != 0 {
+		t.Errorf("ledger balance after full refund got = %d, expected 0", balance)
+	}
+}
+
+// TestUserLedgerConcurrentSubmissions tests that a user's balance matches their receipts under concurrent submissions.
+func TestUserLedgerConcurrentSubmissions(t *testing.T) {
+	receiptStore := NewReceiptStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(minute int) {
+			defer wg.Done()
+			receipt := GetSampleReceipt()
+			receipt.Points = 0
+			receipt.UserID = "alice"
+			receipt.PurchaseTime = fmt.Sprintf("14:%02d", minute)
+			if _, err := receiptStore.generateAndStoreReceipt(&receipt); err != nil {
+				t.Errorf("generateAndStoreReceipt failed: %v", err)
+			}
+		}(i + 10)
+	}
+	wg.Wait()
+
+	response := receiptStore.userLedger("alice")
+	if len(response.Entries) != 20 {
+		t.Fatalf("userLedger() got %d entries, expected 20", len(response.Entries))
+	}
+	if response.Balance != 20*109 {
+		t.Errorf("userLedger() got balance = %d, expected %d", response.Balance, 20*109)
+	}
+	if balance := receiptStore.ledger.Balance(ledger.UserAccount("alice")); balance != response.Balance {
+		t.Errorf("ledger balance got = %d, expected %d", balance, response.Balance)
+	}
+}
+
+// TestTierMultiplierIsRecordedOnReceipt tests that tier multipliers apply and stay fixed once a receipt is scored.
+func TestTierMultiplierIsRecordedOnReceipt(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tierPolicy, err := loyalty.NewTierPolicy([]loyalty.Tier{
+		{Name: "Bronze", Threshold: 0, Multiplier: 100},
+		{Name: "Silver", Threshold: 200, Multiplier: 125},
+	}, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewTierPolicy() failed: %v", err)
+	}
+	receiptStore := NewReceiptStore(WithClock(fakeClock), WithTierPolicy(tierPolicy))
+
+	expected := []struct {
+		tier   string
+		points int
+	}{{"Bronze", 109}, {"Bronze", 109}, {"Silver", 136}}
+	var receiptIDs []string
+	for i, tc := range expected {
+		receipt := GetSampleReceipt()
+		receipt.Points = 0
+		receipt.UserID = "alice"
+		receipt.PurchaseTime = fmt.Sprintf("14:%02d", 10+i)
+		receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			t.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		if receipt.Tier != tc.tier || receipt.Points != tc.points {
+			t.Errorf("receipt %d got tier %q with %d points, expected %q with %d", i, receipt.Tier, receipt.Points, tc.tier, tc.points)
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+		fakeClock.Advance(time.Minute)
+	}
+
+	fakeClock.Advance(48 * time.Hour)
+	if tier := receiptStore.userBalance("alice").Tier; tier != "Bronze" {
+		t.Errorf("userBalance() after the window got tier %q, expected Bronze", tier)
+	}
+	if stored, _ := receiptStore.receipts.get(receiptIDs[2]); stored.Points != 136 || stored.Tier != "Silver" {
+		t.Errorf("stored receipt got tier %q with %d points, expected Silver with 136", stored.Tier, stored.Points)
+	}
+}
+
+// TestHistoryBonusesIgnoreArrivalOrder tests that receipts submitted out of order end up with the same points.
+func TestHistoryBonusesIgnoreArrivalOrder(t *testing.T) {
+	var receipts []models.Receipt
+	for _, date := range []string{"2022-03-20", "2022-03-21", "2022-03-22"} {
+		receipt := GetSampleReceipt()
+		receipt.Points = 0
+		receipt.UserID = "alice"
+		receipt.PurchaseDate = date
+		receipts = append(receipts, receipt)
+	}
+
+	pointsByOrder := make(map[string]int)
+	for _, order := range [][]int{{0, 1, 2}, {2, 0, 1}} {
+		receiptStore := NewReceiptStore(WithHistoryRules(loyalty.DefaultHistoryRules, 7*24*time.Hour))
+		for _, i := range order {
+			receipt := receipts[i]
+			if _, err := receiptStore.generateAndStoreReceipt(&receipt); err != nil {
+				t.Fatalf("generateAndStoreReceipt failed: %v", err)
+			}
+		}
+		receiptStore.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+			if previous, found := pointsByOrder[receiptID]; found && previous != receipt.Points {
+				t.Errorf("receipt %s got %d points out of order, expected %d", receipt.PurchaseDate, receipt.Points, previous)
+			}
+			pointsByOrder[receiptID] = receipt.Points
+		})
+		// 109 + 10 new retailer, 109 + 6 odd day, 109 + 30 for three consecutive days
+		if balance := receiptStore.userBalance("alice").Balance; balance != 119+115+139 {
+			t.Errorf("userBalance() got %d for order %v", balance, order)
+		}
+	}
+}
+
+// TestNearDuplicateReceipts tests that cosmetic variants are exact duplicates and near-duplicates follow the policy.
+func TestNearDuplicateReceipts(t *testing.T) {
+	variant := GetSampleReceipt()
+	variant.Points = 0
+	variant.Items[0].ShortDescription = "Gatorade "
+	variant.Items = append(variant.Items[1:], variant.Items[0])
+
+	nearDuplicate := GetSampleReceipt()
+	nearDuplicate.Points = 0
+	nearDuplicate.Items = nearDuplicate.Items[:3]
+	nearDuplicate.Total = "9.25"
+
+	testCases := []struct {
+		name           string
+		policy         dedup.Policy
+		expectedErr    error
+		expectedStatus string
+	}{
+		{"Reject policy", dedup.PolicyReject, errDuplicateReceipt, ""},
+		{"Review policy", dedup.PolicyReview, nil, models.ReceiptPendingReview},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receiptStore := NewReceiptStore(WithDuplicatePolicy(tc.policy, 25))
+			original := GetSampleReceipt()
+			original.Points = 0
+			if _, err := receiptStore.generateAndStoreReceipt(&original); err != nil {
+				t.Fatalf("generateAndStoreReceipt failed: %v", err)
+			}
+
+			cosmetic := variant
+			if _, err := receiptStore.generateAndStoreReceipt(&cosmetic); !errors.Is(err, errDuplicateReceipt) {
+				t.Errorf("generateAndStoreReceipt() of a reordered, re-spaced receipt expected errDuplicateReceipt, got %v", err)
+			}
+
+			similar := nearDuplicate
+			_, err := receiptStore.generateAndStoreReceipt(&similar)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("generateAndStoreReceipt() of a near-duplicate expected %v, got %v", tc.expectedErr, err)
+			}
+			if similar.Status != tc.expectedStatus {
+				t.Errorf("near-duplicate got status %q, expected %q", similar.Status, tc.expectedStatus)
+			}
+			if tc.policy == dedup.PolicyReview {
+				if held := receiptStore.ledger.Balance(ledger.HeldAccount("")); held != similar.Points {
+					t.Errorf("held balance got = %d, expected %d", held, similar.Points)
+				}
+				reviews := receiptStore.pendingReviews()
+				if len(reviews) != 1 {
+					t.Fatalf("pendingReviews() got %d reviews, expected the held near-duplicate", len(reviews))
+				}
+				if _, err := receiptStore.decideReview(reviews[0].ReceiptID, true); err != nil {
+					t.Fatalf("decideReview(approve) failed: %v", err)
+				}
+				if held := receiptStore.ledger.Balance(ledger.HeldAccount("")); held != 0 {
+					t.Errorf("held balance after review got = %d, expected 0", held)
+				}
+			}
+
+			otherUser := nearDuplicate
+			otherUser.UserID = "bob"
+			if _, err := receiptStore.generateAndStoreReceipt(&otherUser); err != nil || otherUser.Status == models.ReceiptPendingReview {
+				t.Errorf("generateAndStoreReceipt() of another user's similar receipt got status %q and %v, expected it stored unflagged", otherUser.Status, err)
+			}
+		})
+	}
+}
+
+// TestRiskyReceiptsAreHeldForReview tests that risky receipts hold their points until a review releases or voids them.
+func TestRiskyReceiptsAreHeldForReview(t *testing.T) {
+	pipeline := risk.NewPipeline(50, risk.TotalMismatchSignal{ToleranceCents: 0, Score: 50})
+	receiptStore := NewReceiptStore(WithRiskPipeline(pipeline))
+
+	var receiptIDs []string
+	for _, total := range []string{"9.25", "9.50"} {
+		receipt := GetSampleReceipt()
+		receipt.Points = 0
+		receipt.UserID = "alice"
+		receipt.Total = total
+		receipt.PurchaseTime = "14:" + total[2:]
+		receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			t.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		if receipt.Status != models.ReceiptPendingReview || receipt.RiskScore != 50 {
+			t.Fatalf("receipt got status %q and risk %d, expected a held receipt", receipt.Status, receipt.RiskScore)
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+	}
+
+	if reviews := receiptStore.pendingReviews(); len(reviews) != 2 {
+		t.Fatalf("pendingReviews() got %d reviews, expected 2", len(reviews))
+	}
+	if balance := receiptStore.userBalance("alice").Balance; balance != 0 {
+		t.Errorf("balance before review got = %d, expected 0", balance)
+	}
+
+	approved, err := receiptStore.decideReview(receiptIDs[0], true)
+	if err != nil {
+		t.Fatalf("decideReview(approve) failed: %v", err)
+	}
+	if _, err := receiptStore.decideReview(receiptIDs[1], false); err != nil {
+		t.Fatalf("decideReview(reject) failed: %v", err)
+	}
+	if _, err := receiptStore.decideReview(receiptIDs[1], true); !errors.Is(err, errNotPendingReview) {
+		t.Errorf("decideReview() of a settled receipt expected errNotPendingReview, got %v", err)
+	}
+	rejected, _ := receiptStore.receipts.get(receiptIDs[1])
+	if similarID, found := receiptStore.duplicates.FindSimilar(rejected); found {
+		t.Errorf("FindSimilar() after rejection found %s, expected the rejected receipt forgotten", similarID)
+	}
+
+	if balance := receiptStore.userBalance("alice").Balance; balance != approved.Points {
+		t.Errorf("balance after review got = %d, expected %d", balance, approved.Points)
+	}
+	if held := receiptStore.ledger.Balance(ledger.HeldAccount("alice")); held != 0 {
+		t.Errorf("held balance after review got = %d, expected 0", held)
+	}
+	if reviews := receiptStore.pendingReviews(); len(reviews) != 0 {
+		t.Errorf("pendingReviews() after review got %d reviews, expected 0", len(reviews))
+	}
+}
+
+// TestRateLimitedRoute tests that clients over their limit get a 429 with a Retry-After header, that naming another
+// user in an unverified header does not get a client a fresh bucket, and that API keys are limited by client.
+func TestRateLimitedRoute(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), fakeClock, nil, map[string]ratelimit.Limit{
+		"POST /receipts/process": {Rate: 1.0 / 30, Burst: 1},
+	})
+	receiptStore := NewReceiptStore(WithClock(fakeClock), WithRateLimiter(limiter))
+	handle := receiptStore.RateLimited("POST /receipts/process", receiptStore.ProcessReceipt)
+
+	statuses := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		receipt := GetSampleReceipt()
+		receipt.PurchaseTime = fmt.Sprintf("14:%02d", i)
+		request, err := SimulateReceiptPostRequest(receipt)
+		if err != nil {
+			t.Fatalf("SimulateReceiptPostRequest failed: %v", err)
+		}
+		request.Header.Set("X-User-ID", fmt.Sprintf("user-%d", i))
+		recorder := httptest.NewRecorder()
+		handle(recorder, request, nil)
+		statuses = append(statuses, recorder.Code)
+		if i == 1 && recorder.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After = %q, expected \"30\"", recorder.Header().Get("Retry-After"))
+		}
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, expected [200 429]", statuses)
+	}
+
+	//A configured API key draws on its client's bucket from any address, and an unknown one on the address's
+	receiptStore.apiKeys = map[string]string{"k1": "kiosk"}
+	for i, test := range []struct {
+		apiKey     string
+		remoteAddr string
+		expected   int
+	}{
+		{"k1", "", http.StatusOK},
+		{"k1", "198.51.100.7:1234", http.StatusTooManyRequests},
+		{"guess", "", http.StatusTooManyRequests},
+	} {
+		receipt := GetSampleReceipt()
+		receipt.PurchaseTime = fmt.Sprintf("15:%02d", i)
+		request, err := SimulateReceiptPostRequest(receipt)
+		if err != nil {
+			t.Fatalf("SimulateReceiptPostRequest failed: %v", err)
+		}
+		request.Header.Set("X-API-Key", test.apiKey)
+		request.RemoteAddr = test.remoteAddr
+		recorder := httptest.NewRecorder()
+		handle(recorder, request, nil)
+		if recorder.Code != test.expected {
+			t.Errorf("request %d with key %q from %s got %d, expected %d", i, test.apiKey, test.remoteAddr, recorder.Code, test.expected)
+		}
+	}
+}
+
+// TestLegacyReceiptIDsResolve tests that receipts can still be fetched by the decimal IDs issued before receipts
+// were content-addressed.
+func TestLegacyReceiptIDsResolve(t *testing.T) {
+	receiptStore := NewReceiptStore()
+	receipt := GetSampleReceipt()
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt() failed: %v", err)
+	}
+	if !strings.HasPrefix(receiptID, receiptid.ContentPrefix) {
+		t.Errorf("receipt ID %q does not start with %q", receiptID, receiptid.ContentPrefix)
+	}
+	legacyID, err := receiptid.LegacyID(GetSampleReceipt())
+	if err != nil {
+		t.Fatalf("LegacyID() failed: %v", err)
+	}
+
+	for _, id := range []string{receiptID, legacyID} {
+		recorder := httptest.NewRecorder()
+		receiptStore.FetchPoints(recorder, nil, httprouter.Params{{Key: "id", Value: id}})
+		var response models.PointsResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Points != 109 {
+			t.Errorf("FetchPoints(%q) = %d %q, expected 109 points", id, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+// TestExactDuplicatePolicies tests that identical receipts get distinct time-ordered IDs and are handled by the
+// exact duplicate policy rather than by an ID collision.
+func TestExactDuplicatePolicies(t *testing.T) {
+	testCases := []struct {
+		policy         dedup.Policy
+		expectErr      bool
+		expectedStatus string
+	}{
+		{dedup.PolicyReject, true, ""},
+		{dedup.PolicyReview, false, models.ReceiptPendingReview},
+		{dedup.PolicyAllow, false, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			receiptStore := NewReceiptStore(
+				WithClock(fakeClock),
+				WithIDStrategy(receiptid.NewUUIDv7(fakeClock)),
+				WithExactDuplicatePolicy(tc.policy),
+			)
+			first := GetSampleReceipt()
+			firstID, err := receiptStore.generateAndStoreReceipt(&first)
+			if err != nil {
+				t.Fatalf("generateAndStoreReceipt() failed: %v", err)
+			}
+
+			second := GetSampleReceipt()
+			secondID, err := receiptStore.generateAndStoreReceipt(&second)
+			if tc.expectErr {
+				if !errors.Is(err, errDuplicateReceipt) {
+					t.Errorf("generateAndStoreReceipt() error = %v, expected a duplicate", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generateAndStoreReceipt() failed: %v", err)
+			}
+			if secondID == firstID || second.Status != tc.expectedStatus {
+				t.Errorf("second receipt got ID %q status %q, expected a new ID and status %q", secondID, second.Status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+// TestConcurrentIdenticalReceipts tests that only one of many identical receipts submitted at once is stored, even
+// with time-ordered IDs and with the receipts submitted without a user.
+func TestConcurrentIdenticalReceipts(t *testing.T) {
+	receiptStore := NewReceiptStore(WithIDStrategy(receiptid.NewULID(clock.Real{})))
+	var wg sync.WaitGroup
+	var accepted, rejected int
+	var lock sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			receipt := GetSampleReceipt()
+			_, err := receiptStore.generateAndStoreReceipt(&receipt)
+			lock.Lock()
+			defer lock.Unlock()
+			if errors.Is(err, errDuplicateReceipt) {
+				rejected++
+			} else if err == nil {
+				accepted++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 || rejected != 19 {
+		t.Errorf("got %d accepted and %d rejected, expected 1 and 19", accepted, rejected)
+	}
+}
+
+// benchmarkReceipt returns a distinct receipt for the nth submission, spread over 100 users.
+func benchmarkReceipt(n int64) models.Receipt {
+	receipt := GetSampleReceipt()
+	receipt.Points = 0
+	receipt.UserID = fmt.Sprintf("user-%d", n%100)
+	receipt.Retailer = fmt.Sprintf("Retailer %d", n)
+	return receipt
+}
+
+// BenchmarkProcessReceiptParallel measures submission throughput. Run with -cpu 1,2,4,8 to see it scale with GOMAXPROCS.
+func BenchmarkProcessReceiptParallel(b *testing.B) {
+	receiptStore := NewReceiptStore()
+	var counter atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			receipt := benchmarkReceipt(counter.Add(1))
+			if _, err := receiptStore.generateAndStoreReceipt(&receipt); err != nil {
+				b.Errorf("generateAndStoreReceipt failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkFetchPointsParallel measures point lookups, which only take shard read locks. Run with -cpu 1,2,4,8 to
+// see it scale with GOMAXPROCS.
+func BenchmarkFetchPointsParallel(b *testing.B) {
+	receiptStore := NewReceiptStore()
+	receiptIDs := make([]string, 1000)
+	for i := range receiptIDs {
+		receipt := benchmarkReceipt(int64(i))
+		receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			b.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		receiptIDs[i] = receiptID
+	}
+
+	var counter atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			receiptID := receiptIDs[counter.Add(1)%int64(len(receiptIDs))]
+			params := httprouter.Params{{Key: "id", Value: receiptID}}
+			receiptStore.FetchPoints(httptest.NewRecorder(), nil, params)
+		}
+	})
+}
+
+// TestBoundedStoreEviction tests that a bounded store holds no more receipts than its limit across all its shards,
+// drops or spills its least recently used receipts, reloads spilled receipts on FetchPoints, evicts idle receipts
+// after the TTL and counts every eviction. A dropped receipt is forgotten entirely, so it can be submitted again,
+// and a spilled one leaves the content and alias tables until it is reloaded.
+func TestBoundedStoreEviction(t *testing.T) {
+	backend, err := storage.NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	testCases := []struct {
+		name    string
+		backend storage.Backend
+	}{
+		{"Cache mode", nil},
+		{"Spill mode", backend},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			limits := MemoryLimits{MaxEntries: 5, TTL: time.Hour}
+			receiptStore := NewReceiptStore(WithClock(fakeClock), WithMemoryLimits(limits, tc.backend))
+
+			var receiptIDs []string
+			for i := 0; i < 4*receiptShardCount; i++ {
+				receipt := benchmarkReceipt(int64(i))
+				receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+				if err != nil {
+					t.Fatalf("generateAndStoreReceipt failed: %v", err)
+				}
+				receiptIDs = append(receiptIDs, receiptID)
+			}
+			stats := receiptStore.receipts.stats()
+			if stats.Entries != limits.MaxEntries || stats.Evictions.Capacity != int64(4*receiptShardCount-stats.Entries) {
+				t.Fatalf("stats() = %+v, expected %d entries and the rest evicted", stats, limits.MaxEntries)
+			}
+			checkIndexes := func() {
+				t.Helper()
+				receiptStore.lock.RLock()
+				defer receiptStore.lock.RUnlock()
+				history := 0
+				for _, userReceiptIDs := range receiptStore.userReceipts {
+					history += len(userReceiptIDs)
+				}
+				entries := receiptStore.receipts.stats().Entries
+				if len(receiptStore.contentIndex) != entries || len(receiptStore.legacyIDs) != entries {
+					t.Errorf("%d content and %d alias entries for %d receipts in memory, expected one each", len(receiptStore.contentIndex), len(receiptStore.legacyIDs), entries)
+				}
+				if tc.backend == nil && history != entries || tc.backend != nil && history != len(receiptIDs) {
+					t.Errorf("user histories hold %d receipts, expected only the ones still stored", history)
+				}
+			}
+			checkIndexes()
+
+			found := 0
+			for _, receiptID := range receiptIDs {
+				recorder := httptest.NewRecorder()
+				receiptStore.FetchPoints(recorder, nil, httprouter.Params{{Key: "id", Value: receiptID}})
+				if recorder.Code == http.StatusOK {
+					found++
+				}
+			}
+			stats = receiptStore.receipts.stats()
+			if tc.backend == nil && (found != stats.Entries || stats.Dropped != stats.Evictions.Capacity) {
+				t.Errorf("cache mode found %d receipts with stats %+v, expected only the ones in memory", found, stats)
+			}
+			if tc.backend != nil && (found != len(receiptIDs) || stats.Reloaded == 0 || stats.Dropped != 0) {
+				t.Errorf("spill mode found %d of %d receipts with stats %+v, expected every one", found, len(receiptIDs), stats)
+			}
+			if stats.Entries != limits.MaxEntries {
+				t.Errorf("stats() after reading every receipt = %+v, expected %d entries", stats, limits.MaxEntries)
+			}
+			checkIndexes()
+
+			fakeClock.Advance(time.Hour)
+			receiptStore.receipts.sweep()
+			if stats := receiptStore.receipts.stats(); stats.Entries != 0 || stats.Evictions.Expired == 0 {
+				t.Errorf("stats() after the TTL = %+v, expected every receipt expired", stats)
+			}
+
+			resubmitted := benchmarkReceipt(0)
+			_, err := receiptStore.generateAndStoreReceipt(&resubmitted)
+			if tc.backend == nil && err != nil {
+				t.Errorf("generateAndStoreReceipt() of a dropped receipt failed: %v", err)
+			}
+			if tc.backend != nil && !errors.Is(err, errDuplicateReceipt) {
+				t.Errorf("generateAndStoreReceipt() of a spilled receipt expected errDuplicateReceipt, got %v", err)
+			}
+		})
+	}
+}
+
+// TestSnapshotExportImport tests that a snapshot moves receipts, balances and the rewards catalog between stores,
+// keeping the times points were credited and the idempotency keys of redemptions, that ID conflicts follow the
+// conflict policy, and that invalid records are rejected individually.
+func TestSnapshotExportImport(t *testing.T) {
+	source := NewReceiptStore(WithClock(clock.NewFake(time.Date(2023, 6, 1, 9, 30, 0, 0, time.UTC))))
+	var receiptIDs []string
+	for i := 0; i < 3; i++ {
+		receipt := benchmarkReceipt(int64(i))
+		receiptID, err := source.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			t.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+	}
+	if _, err := source.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptIDs[0], Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Fatalf("applyRefund failed: %v", err)
+	}
+	if _, err := source.adjustUserPoints("user-2", &models.AdjustmentRequest{Points: 7, Memo: "Goodwill"}); err != nil {
+		t.Fatalf("adjustUserPoints failed: %v", err)
+	}
+	source.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5})
+	redemption, err := source.redeem(models.RedemptionRequest{UserID: "user-1", RewardID: "mug"}, "retry-1")
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := snapshot.WriteArchive(&buf, time.Now(), source.exportSnapshot); err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+	contents, err := snapshot.Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	target := NewReceiptStore(WithClock(clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))))
+	report, err := target.importSnapshot(contents, importOptions{conflict: importSkip})
+	if err != nil || report.Imported != 3 || report.Transactions != 2 || report.Catalog != 2 {
+		t.Fatalf("importSnapshot() = %+v, %v; expected 3 receipts, 2 transactions and a reward and redemption imported", report, err)
+	}
+	isEarn := func(transaction ledger.Transaction) bool { return transaction.Kind == ledger.KindEarn }
+	for _, transaction := range target.ledger.Transactions(isEarn) {
+		if !transaction.CreatedAt.Equal(time.Date(2023, 6, 1, 9, 30, 0, 0, time.UTC)) {
+			t.Errorf("imported earn transaction %+v, expected the time the source credited it", transaction)
+		}
+	}
+	if listed := target.catalog.Rewards(); len(listed) != 1 || listed[0].Inventory != 4 {
+		t.Errorf("imported rewards = %+v, expected the mug with 4 left", listed)
+	}
+	balanceBefore := target.userBalance("user-1").Balance
+	if retried, err := target.redeem(models.RedemptionRequest{UserID: "user-1", RewardID: "mug"}, "retry-1"); err != nil || retried.Id != redemption.Id {
+		t.Errorf("retrying the redemption after the import = %+v, %v; expected redemption %s", retried, err, redemption.Id)
+	}
+	if balance := target.userBalance("user-1").Balance; balance != balanceBefore {
+		t.Errorf("balance after retrying the redemption got %d, expected %d", balance, balanceBefore)
+	}
+	for _, receiptID := range receiptIDs {
+		sourceReceipt, _ := source.receipts.get(receiptID)
+		targetReceipt, found := target.receipts.get(receiptID)
+		if !found || targetReceipt.Points != sourceReceipt.Points {
+			t.Errorf("imported receipt %s got %d points, expected %d", receiptID, targetReceipt.Points, sourceReceipt.Points)
+		}
+	}
+	for _, userID := range []string{"user-0", "user-1", "user-2"} {
+		if got, expected := target.userBalance(userID).Balance, source.userBalance(userID).Balance; got != expected {
+			t.Errorf("balance of %s got %d, expected %d", userID, got, expected)
+		}
+	}
+	if _, err := target.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptIDs[0], Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Errorf("refunding an imported receipt failed: %v", err)
+	}
+
+	if report, _ := target.importSnapshot(contents, importOptions{conflict: importSkip}); report.Skipped != 3 || report.Transactions != 0 || report.Catalog != 0 {
+		t.Errorf("re-import with skip = %+v, expected 3 skipped and no transactions or catalog changes", report)
+	}
+	if _, err := target.importSnapshot(contents, importOptions{conflict: importFail}); !errors.Is(err, errImportConflict) {
+		t.Errorf("re-import with fail error = %v, expected a conflict", err)
+	}
+	racing := contents.Records[0]
+	if _, _, err := target.importRecord(racing, importOptions{conflict: importFail}); !errors.Is(err, errImportConflict) {
+		t.Errorf("importRecord() with fail error = %v, expected a conflict checked under the lock", err)
+	}
+	balanceBefore = target.userBalance("user-1").Balance
+	if report, _ := target.importSnapshot(contents, importOptions{conflict: importOverwrite, rescore: true}); report.Replaced != 3 {
+		t.Errorf("re-import with overwrite = %+v, expected 3 replaced", report)
+	}
+	if balance := target.userBalance("user-1").Balance; balance != balanceBefore {
+		t.Errorf("balance after overwrite got %d, expected %d", balance, balanceBefore)
+	}
+
+	invalid := snapshot.Record{ID: "r1_invalid", Receipt: GetSampleReceipt()}
+	invalid.Receipt.PurchaseDate = "yesterday"
+	if report, _ := NewReceiptStore().importSnapshot(snapshot.Snapshot{Records: []snapshot.Record{invalid, contents.Records[0]}}, importOptions{conflict: importSkip}); len(report.Rejected) != 1 || report.Imported != 1 {
+		t.Errorf("import with an invalid record = %+v, expected 1 rejected and 1 imported", report)
+	}
+}
+
+// TestLeaderFollowerReplication tests that a follower tailing a leader over HTTP serves the leader's receipts,
+// balances and redemptions, refuses writes until it is promoted, reports no lag once it has caught up, and starts
+// over when the leader restarts.
+func TestLeaderFollowerReplication(t *testing.T) {
+	newRouter := func(receiptStore *ReceiptStore) *httprouter.Router {
+		router := httprouter.New()
+		router.POST("/receipts/process", receiptStore.LeaderOnly(receiptStore.ProcessReceipt))
+		router.GET("/receipts/:id/points", receiptStore.FetchPoints)
+		router.GET("/admin/replication/stream", receiptStore.AdminOnly(receiptStore.StreamReplication))
+		return router
+	}
+	newServer := func(receiptStore *ReceiptStore) *httptest.Server {
+		return httptest.NewServer(newRouter(receiptStore))
+	}
+	postReceipt := func(server *httptest.Server, receipt models.Receipt) (*http.Response, models.ReceiptResponse) {
+		body, _ := json.Marshal(receipt)
+		response, err := http.Post(server.URL+"/receipts/process", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /receipts/process failed: %v", err)
+		}
+		defer response.Body.Close()
+		var created models.ReceiptResponse
+		_ = json.NewDecoder(response.Body).Decode(&created)
+		return response, created
+	}
+
+	//The leader's server can be pointed at a new store, as if the leader restarted
+	leader := NewReceiptStore(WithAdminToken("secret"), WithReplicationLog(replication.NewLog(clock.Real{})))
+	var leaderRouter atomic.Pointer[httprouter.Router]
+	leaderRouter.Store(newRouter(leader))
+	leaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaderRouter.Load().ServeHTTP(w, r)
+	}))
+	defer leaderServer.Close()
+	follower := NewReceiptStore(WithLeader(leaderServer.URL, "secret"))
+	followerServer := newServer(follower)
+	defer followerServer.Close()
+	follower.StartReplication(context.Background())
+	defer follower.promote()
+	waitForFollower := func(leader *ReceiptStore) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status := follower.replicationStatus()
+			if status.LeaderEpoch == leader.replicationLog.Epoch() && status.AppliedSeq >= leader.replicationLog.LastSeq() {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("follower did not catch up: %+v, leader at %d", status, leader.replicationLog.LastSeq())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	var receiptIDs []string
+	for i := 0; i < 5; i++ {
+		response, created := postReceipt(leaderServer, benchmarkReceipt(int64(i)))
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("leader POST status = %d, expected 200", response.StatusCode)
+		}
+		receiptIDs = append(receiptIDs, created.Id)
+	}
+	if _, err := leader.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptIDs[0], Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Fatalf("applyRefund failed: %v", err)
+	}
+	leader.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5})
+	redemption, err := leader.redeem(models.RedemptionRequest{UserID: "user-1", RewardID: "mug"}, "retry-1")
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+
+	waitForFollower(leader)
+	if status := follower.replicationStatus(); status.Role != models.ReplicationRoleFollower || status.LagEntries != 0 || !status.Connected {
+		t.Errorf("replicationStatus() = %+v, expected a connected follower with no lag", status)
+	}
+
+	for _, receiptID := range receiptIDs {
+		response, err := http.Get(followerServer.URL + "/receipts/" + receiptID + "/points")
+		if err != nil {
+			t.Fatalf("GET points failed: %v", err)
+		}
+		var points models.PointsResponse
+		_ = json.NewDecoder(response.Body).Decode(&points)
+		response.Body.Close()
+		expected, _ := leader.receipts.get(receiptID)
+		if response.StatusCode != http.StatusOK || points.Points != expected.Points {
+			t.Errorf("follower points of %s = %d (status %d), expected %d", receiptID, points.Points, response.StatusCode, expected.Points)
+		}
+	}
+	for _, userID := range []string{"user-0", "user-1"} {
+		if got, expected := follower.userBalance(userID).Balance, leader.userBalance(userID).Balance; got != expected {
+			t.Errorf("follower balance of %s = %d, expected %d", userID, got, expected)
+		}
+	}
+	if rewards := follower.catalog.Rewards(); len(rewards) != 1 || rewards[0].Inventory != 4 {
+		t.Errorf("follower rewards = %+v, expected the mug with 4 left", rewards)
+	}
+	if replicated, err := follower.catalog.Redemption(redemption.Id); err != nil || replicated.Code != redemption.Code {
+		t.Errorf("follower redemption = %+v, %v; expected %+v", replicated, err, redemption)
+	}
+
+	//A restarted leader starts a new epoch with only what it restored, and the follower starts over
+	restarted := NewReceiptStore(WithAdminToken("secret"), WithReplicationLog(replication.NewLog(clock.Real{})))
+	restartedReceipt := benchmarkReceipt(50)
+	restartedID, err := restarted.generateAndStoreReceipt(&restartedReceipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+	leaderRouter.Store(newRouter(restarted))
+	leaderServer.CloseClientConnections()
+	waitForFollower(restarted)
+	if _, found := follower.receipts.get(receiptIDs[1]); found {
+		t.Errorf("follower kept receipt %s from before the leader restarted", receiptIDs[1])
+	}
+	if _, found := follower.receipts.get(restartedID); !found {
+		t.Errorf("follower is missing receipt %s stored after the leader restarted", restartedID)
+	}
+	if got, expected := follower.userBalance("user-0").Balance, restarted.userBalance("user-0").Balance; got != expected {
+		t.Errorf("follower balance after the restart = %d, expected %d", got, expected)
+	}
+	if _, err := follower.catalog.Redemption(redemption.Id); err == nil {
+		t.Errorf("follower kept redemption %s from before the leader restarted", redemption.Id)
+	}
+
+	response, _ := postReceipt(followerServer, benchmarkReceipt(100))
+	if response.StatusCode != http.StatusMisdirectedRequest || response.Header.Get("X-Leader-URL") != leaderServer.URL {
+		t.Errorf("follower POST status = %d, leader %q; expected 421 naming the leader", response.StatusCode, response.Header.Get("X-Leader-URL"))
+	}
+	if err := follower.promote(); err != nil {
+		t.Fatalf("promote() error = %v", err)
+	}
+	if response, _ := postReceipt(followerServer, benchmarkReceipt(100)); response.StatusCode != http.StatusOK {
+		t.Errorf("promoted POST status = %d, expected 200", response.StatusCode)
+	}
+}
+
+// TestPromotedFollowerRestoresWhatItCopied tests that a promoted follower records the state it copied in its event
+// log, so it restores the same receipts, ledger and catalog after a restart, and that a follower starting a new copy
+// of its leader starts a new audit chain.
+func TestPromotedFollowerRestoresWhatItCopied(t *testing.T) {
+	leader := NewReceiptStore(WithReplicationLog(replication.NewLog(clock.Real{})))
+	var receiptIDs []string
+	for i := 0; i < 3; i++ {
+		receipt := benchmarkReceipt(int64(i))
+		receiptID, err := leader.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			t.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		receiptIDs = append(receiptIDs, receiptID)
+	}
+	if _, err := leader.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptIDs[0], Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Fatalf("applyRefund failed: %v", err)
+	}
+	if err := leader.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5}); err != nil {
+		t.Fatalf("putReward failed: %v", err)
+	}
+	redemption, err := leader.redeem(models.RedemptionRequest{UserID: "user-1", RewardID: "mug"}, "retry-1")
+	if err != nil {
+		t.Fatalf("redeem failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	eventLog, err := events.OpenLog(filepath.Join(dir, "events.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	auditLog, err := audit.OpenLog(filepath.Join(dir, "audit.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	follower := NewReceiptStore(WithLeader("http://leader", "secret"), WithReplicationLog(replication.NewLog(clock.Real{})), WithEventLog(eventLog), WithAuditLog(auditLog))
+	copyLeader := func() {
+		entries, _, err := leader.replicationLog.Since(0, 1<<20)
+		if err != nil {
+			t.Fatalf("Since() error = %v", err)
+		}
+		for _, entry := range entries {
+			if err := follower.ApplyEntry(entry); err != nil {
+				t.Fatalf("ApplyEntry() error = %v", err)
+			}
+		}
+	}
+	copyLeader()
+	if err := follower.ResetReplica(); err != nil {
+		t.Fatalf("ResetReplica() error = %v", err)
+	}
+	copyLeader()
+	archived, _ := filepath.Glob(filepath.Join(dir, "audit.ndjson.*"))
+	contents, _ := os.ReadFile(filepath.Join(dir, "audit.ndjson"))
+	if result, err := audit.VerifyChain(bytes.NewReader(contents)); len(archived) != 1 || err != nil || result.Records != int(leader.ledger.LastID()) {
+		t.Errorf("audit chain after copying the leader again = %+v, %v with %d archived; expected a new chain of %d beside the old one", result, err, len(archived), leader.ledger.LastID())
+	}
+
+	if err := follower.promote(); err != nil {
+		t.Fatalf("promote() error = %v", err)
+	}
+	receipt := benchmarkReceipt(10)
+	promotedID, err := follower.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt on the promoted follower failed: %v", err)
+	}
+	eventLog.Close()
+	auditLog.Close()
+
+	reopenedEvents, err := events.OpenLog(filepath.Join(dir, "events.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopenedEvents.Close()
+	reopenedAudit, err := audit.OpenLog(filepath.Join(dir, "audit.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopenedAudit.Close()
+	restarted := NewReceiptStore(WithEventLog(reopenedEvents), WithAuditLog(reopenedAudit))
+	if err := restarted.RestoreEvents(); err != nil {
+		t.Fatalf("RestoreEvents() error = %v", err)
+	}
+	if got, expected := restarted.ledger.LastID(), follower.ledger.LastID(); got != expected {
+		t.Errorf("restored ledger ends at %d, expected %d", got, expected)
+	}
+	for _, receiptID := range append(receiptIDs, promotedID) {
+		got, _ := restarted.receipts.get(receiptID)
+		expected, _ := follower.receipts.get(receiptID)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("restored receipt %s = %+v, expected %+v", receiptID, got, expected)
+		}
+	}
+	for _, userID := range []string{"user-0", "user-1", "user-10"} {
+		if got, expected := restarted.userBalance(userID).Balance, follower.userBalance(userID).Balance; got != expected {
+			t.Errorf("restored balance of %s = %d, expected %d", userID, got, expected)
+		}
+	}
+	if retried, err := restarted.redeem(models.RedemptionRequest{UserID: "user-1", RewardID: "mug"}, "retry-1"); err != nil || retried.Id != redemption.Id {
+		t.Errorf("retried redemption = %+v, %v; expected the copied redemption %s", retried, err, redemption.Id)
+	}
+}
+
+// TestRebuildFromEvents tests that a store restored from its event log matches the original, and that a rebuild
+// under new scoring rules matches a store that applied those rules from the start.
+func TestRebuildFromEvents(t *testing.T) {
+	var receipts []models.Receipt
+	for _, date := range []string{"2022-03-22", "2022-03-20", "2022-03-21"} {
+		receipt := GetSampleReceipt()
+		receipt.Points = 0
+		receipt.UserID = "alice"
+		receipt.PurchaseDate = date
+		receipts = append(receipts, receipt)
+	}
+	submit := func(receiptStore *ReceiptStore) {
+		var receiptIDs []string
+		for _, receipt := range receipts {
+			receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+			if err != nil {
+				t.Fatalf("generateAndStoreReceipt failed: %v", err)
+			}
+			receiptIDs = append(receiptIDs, receiptID)
+		}
+		if _, err := receiptStore.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptIDs[1], Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+			t.Fatalf("applyRefund failed: %v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	eventLog, err := events.OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	original := NewReceiptStore(WithEventLog(eventLog))
+	submit(original)
+	eventLog.Close()
+
+	reopened, err := events.OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopened.Close()
+	restored := NewReceiptStore(WithEventLog(reopened))
+	if err := restored.RestoreEvents(); err != nil {
+		t.Fatalf("RestoreEvents() error = %v", err)
+	}
+	if got, expected := restored.userBalance("alice").Balance, original.userBalance("alice").Balance; got != expected {
+		t.Errorf("restored balance = %d, expected %d", got, expected)
+	}
+	original.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		if restoredReceipt, found := restored.receipts.get(receiptID); !found || restoredReceipt.Points != receipt.Points {
+			t.Errorf("restored receipt %s got %d points, expected %d", receiptID, restoredReceipt.Points, receipt.Points)
+		}
+	})
+
+	withHistory := WithHistoryRules(loyalty.DefaultHistoryRules, 7*24*time.Hour)
+	expected := NewReceiptStore(withHistory)
+	submit(expected)
+	rebuilt := NewReceiptStore(withHistory)
+	report, err := rebuilt.Rebuild(reopened.Each, true)
+	if err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	if len(report.Changed) == 0 || report.Receipts != 3 {
+		t.Errorf("Rebuild() report = %+v, expected 3 receipts with changes", report)
+	}
+	expected.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		if rebuiltReceipt, found := rebuilt.receipts.get(receiptID); !found || rebuiltReceipt.Points != receipt.Points {
+			t.Errorf("rebuilt receipt %s got %d points, expected %d", receiptID, rebuiltReceipt.Points, receipt.Points)
+		}
+	})
+	if got, want := rebuilt.userBalance("alice").Balance, expected.userBalance("alice").Balance; got != want {
+		t.Errorf("rebuilt balance = %d, expected %d", got, want)
+	}
+}
+
+// TestRestoreEventsReplaysLedgerAndCatalogChanges tests that adjustments, redemptions, cancellations and expiries are
+// recorded as events and restored from them alone.
+func TestRestoreEventsReplaysLedgerAndCatalogChanges(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2022, 3, 20, 12, 0, 0, 0, time.UTC))
+	eventLog := events.NewLog(fakeClock)
+	policy := WithExpiryPolicy(expiry.FIFOPolicy{Lifetime: 30 * 24 * time.Hour})
+	original := NewReceiptStore(WithEventLog(eventLog), WithClock(fakeClock), policy)
+
+	receipt := GetSampleReceipt()
+	receipt.Points = 0
+	receipt.UserID = "alice"
+	if _, err := original.generateAndStoreReceipt(&receipt); err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+	fakeClock.Advance(24 * time.Hour)
+	if _, err := original.adjustUserPoints("alice", &models.AdjustmentRequest{Points: 5, Memo: "Goodwill"}); err != nil {
+		t.Fatalf("adjustUserPoints failed: %v", err)
+	}
+	original.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5})
+	kept, err := original.redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, "")
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	cancelled, err := original.redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, "")
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	if _, err := original.cancelRedemption(cancelled.Id); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	fakeClock.Advance(30 * 24 * time.Hour)
+	if expired, err := original.newExpirySweeper().Sweep(); err != nil || expired == 0 {
+		t.Fatalf("Sweep() = %d, %v, expected expired points", expired, err)
+	}
+	recorded := eventLog.LastSeq()
+
+	restored := NewReceiptStore(WithEventLog(eventLog), WithClock(fakeClock), policy)
+	if err := restored.RestoreEvents(); err != nil {
+		t.Fatalf("RestoreEvents() error = %v", err)
+	}
+	if eventLog.LastSeq() != recorded {
+		t.Errorf("RestoreEvents() appended %d events, expected none", eventLog.LastSeq()-recorded)
+	}
+	if got, expected := restored.userBalance("alice"), original.userBalance("alice"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("restored balance = %+v, expected %+v", got, expected)
+	}
+	if got, expected := restored.catalog.Rewards(), original.catalog.Rewards(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("restored rewards = %+v, expected %+v", got, expected)
+	}
+	for _, redemption := range []models.Redemption{kept, cancelled} {
+		got, err := restored.catalog.Redemption(redemption.Id)
+		expected, _ := original.catalog.Redemption(redemption.Id)
+		if err != nil || got.Status != expected.Status {
+			t.Errorf("restored redemption %s = %+v, %v, expected status %s", redemption.Id, got, err, expected.Status)
+		}
+	}
+}
+
+// TestEventsThatFailToApplyAreNotRecorded tests that only the events of a change that were applied reach the event
+// log, so restoring from it does not meet the failure again.
+func TestEventsThatFailToApplyAreNotRecorded(t *testing.T) {
+	eventLog := events.NewLog(clock.Real{})
+	receiptStore := NewReceiptStore(WithEventLog(eventLog))
+	receipt := GetSampleReceipt()
+	receipt.UserID = "alice"
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+	recorded := eventLog.LastSeq()
+
+	err = receiptStore.recordEvents(
+		events.Event{Type: events.Adjusted, ReceiptID: receiptID, Reason: events.ReasonHistory, Delta: 5},
+		events.Event{Type: events.Adjusted, ReceiptID: "missing", Reason: events.ReasonHistory, Delta: 5},
+	)
+	if err == nil {
+		t.Fatal("recordEvents() with an event for a missing receipt succeeded")
+	}
+	if eventLog.LastSeq() != recorded+1 {
+		t.Errorf("recordEvents() appended %d events, expected only the applied one", eventLog.LastSeq()-recorded)
+	}
+
+	restored := NewReceiptStore(WithEventLog(eventLog))
+	if err := restored.RestoreEvents(); err != nil {
+		t.Fatalf("RestoreEvents() error = %v", err)
+	}
+	if got, expected := restored.userBalance("alice"), receiptStore.userBalance("alice"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("restored balance = %+v, expected %+v", got, expected)
+	}
+}
+
+// TestAuditLogChainsPointChanges tests that scoring, refunds and manual adjustments are appended to the audit chain.
+func TestAuditLogChainsPointChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	auditLog, err := audit.OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	receiptStore := NewReceiptStore(WithAuditLog(auditLog))
+	receipt := GetSampleReceipt()
+	receipt.UserID = "alice"
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+	if _, err := receiptStore.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptID, Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Fatalf("applyRefund failed: %v", err)
+	}
+	if _, err := receiptStore.adjustUserPoints("alice", &models.AdjustmentRequest{Points: 5, Memo: "Goodwill"}); err != nil {
+		t.Fatalf("adjustUserPoints failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	receiptStore.FetchAuditStatus(rr, httptest.NewRequest(http.MethodGet, "/admin/audit", nil), nil)
+	var status models.AuditStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || !status.Enabled || status.Seq != 3 || status.Pending != 0 || status.LastTransactionID != status.LedgerLastID {
+		t.Errorf("FetchAuditStatus() = %s, expected 3 records and none pending", rr.Body.String())
+	}
+	auditLog.Close()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	result, err := audit.VerifyChain(bytes.NewReader(contents))
+	if err != nil || result.Broken != nil || result.Records != 3 {
+		t.Errorf("VerifyChain() = %+v, %v; expected 3 intact records", result, err)
+	}
+
+	//Restarting without the events behind the chain would reuse its transaction IDs
+	reopened, err := audit.OpenLog(path, clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopened.Close()
+	if err := NewReceiptStore(WithAuditLog(reopened)).RestoreEvents(); err == nil {
+		t.Error("RestoreEvents() with an audit log ahead of the ledger succeeded")
+	}
+}
+
+// TestAuditWaitsForTheEventLog tests that a points change whose events cannot be recorded is not audited either, so
+// the audit log never runs ahead of the event log it is restored from.
+func TestAuditWaitsForTheEventLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.OpenLog(filepath.Join(dir, "audit.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer auditLog.Close()
+	eventLog, err := events.OpenLog(filepath.Join(dir, "events.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	receiptStore := NewReceiptStore(WithAuditLog(auditLog), WithEventLog(eventLog))
+	if _, err := receiptStore.adjustUserPoints("alice", &models.AdjustmentRequest{Points: 5, Memo: "Goodwill"}); err != nil {
+		t.Fatalf("adjustUserPoints failed: %v", err)
+	}
+
+	eventLog.Close()
+	if _, err := receiptStore.adjustUserPoints("alice", &models.AdjustmentRequest{Points: 5, Memo: "Goodwill"}); err == nil {
+		t.Fatal("adjustUserPoints() with a closed event log succeeded")
+	}
+	receiptStore.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 5, Inventory: 1})
+	if _, err := receiptStore.redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, ""); err == nil {
+		t.Error("redeem() with a closed event log succeeded")
+	}
+	if status := auditLog.Status(); status.Seq != 1 || status.LastTransactionID != 1 {
+		t.Errorf("audit status = %+v, expected only the recorded adjustment", status)
+	}
+}
+
+// TestWebhooksDeliverSignedReceiptEvents tests that a subscription made through the API receives a signed delivery
+// for each subscribed event of a processed and then refunded receipt, and that invalid subscriptions are refused.
+func TestWebhooksDeliverSignedReceiptEvents(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var deliveries []received
+	var deliveriesLock sync.Mutex
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveriesLock.Lock()
+		defer deliveriesLock.Unlock()
+		deliveries = append(deliveries, received{header: r.Header, body: body})
+	}))
+	defer receiver.Close()
+
+	dispatcher, err := webhooks.NewDispatcher(webhooks.NewMemoryStore(), webhooks.DefaultBackoff, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	eventLog := events.NewLog(clock.Real{})
+	feed, err := NewWebhookFeed(dispatcher, eventLog, "", clock.Real{})
+	if err != nil {
+		t.Fatalf("NewWebhookFeed() error = %v", err)
+	}
+	receiptStore := NewReceiptStore(WithEventLog(eventLog), WithWebhooks(dispatcher, feed))
+	subscribe := func(body string) (int, models.WebhookSubscription) {
+		rr := httptest.NewRecorder()
+		receiptStore.CreateWebhookSubscription(rr, httptest.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(body)), nil)
+		var subscription models.WebhookSubscription
+		_ = json.Unmarshal(rr.Body.Bytes(), &subscription)
+		return rr.Code, subscription
+	}
+	for _, invalid := range []string{
+		`{"url":"ftp://example.com","eventTypes":["receipt.scored"]}`,
+		`{"url":"/relative","eventTypes":["receipt.scored"]}`,
+		`{"url":"https://example.com","eventTypes":["receipt.unknown"]}`,
+		`{"url":"https://example.com","eventTypes":[]}`,
+	} {
+		if status, _ := subscribe(invalid); status != http.StatusBadRequest {
+			t.Errorf("subscribing with %s returned %d, expected 400", invalid, status)
+		}
+	}
+	status, subscription := subscribe(`{"url":"` + receiver.URL + `","eventTypes":["receipt.scored","receipt.refunded"]}`)
+	if status != http.StatusOK || subscription.Secret == "" {
+		t.Fatalf("subscribing returned %d, %+v; expected 200 with a generated secret", status, subscription)
+	}
+	if status, _ := subscribe(`{"url":"http://127.0.0.1:1/hook","eventTypes":["*"]}`); status != http.StatusOK {
+		t.Fatalf("subscribing to every event type returned %d", status)
+	}
+
+	receipt := GetSampleReceipt()
+	receipt.UserID = "alice"
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed: %v", err)
+	}
+	if _, err := receiptStore.applyRefund(&models.RefundReceipt{OriginalReceiptID: receiptID, Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}}}); err != nil {
+		t.Fatalf("applyRefund failed: %v", err)
+	}
+	if len(dispatcher.Pending()) != 0 {
+		t.Fatalf("deliveries were queued before the feed forwarded their events")
+	}
+	if err := receiptStore.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5}); err != nil {
+		t.Fatalf("putReward failed: %v", err)
+	}
+	if err := feed.Forward(context.Background()); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	for _, delivery := range dispatcher.Pending() {
+		if !webhookEventTypes[delivery.EventType] {
+			t.Errorf("queued a %s delivery, expected receipt events only", delivery.EventType)
+		}
+	}
+	if delivered, _ := dispatcher.DeliverDue(context.Background()); delivered != 2 {
+		t.Fatalf("DeliverDue() delivered %d; expected 2 deliveries", delivered)
+	}
+
+	for i, eventType := range []events.Type{events.Scored, events.Refunded} {
+		delivery := deliveries[i]
+		if got := delivery.header.Get(webhooks.EventHeader); got != webhookEventType(eventType) {
+			t.Errorf("delivery %d event = %q, expected %q", i, got, webhookEventType(eventType))
+		}
+		if err := webhooks.Verify(subscription.Secret, delivery.header.Get(webhooks.SignatureHeader), delivery.body, time.Minute, time.Now()); err != nil {
+			t.Errorf("delivery %d signature: %v", i, err)
+		}
+		var event events.Event
+		if err := json.Unmarshal(delivery.body, &event); err != nil || event.ReceiptID != receiptID || event.Type != eventType {
+			t.Errorf("delivery %d body = %s, expected the %s event of %s", i, delivery.body, eventType, receiptID)
+		}
+	}
+}
+
+// TestReceiptFeedStreamsFilteredReceipts tests that the live feed streams the stored receipts that pass its filters,
+// and that a consumer reconnecting with Last-Event-ID first receives the ones it missed.
+func TestReceiptFeedStreamsFilteredReceipts(t *testing.T) {
+	receiptStore := NewReceiptStore(WithAdminToken("admin"))
+	router := httprouter.New()
+	router.GET("/events/receipts", receiptStore.AdminOnly(receiptStore.StreamReceipts))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/events/receipts")
+	if err != nil {
+		t.Fatalf("GET /events/receipts failed: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET /events/receipts without the admin token = %d, expected 401", response.StatusCode)
+	}
+
+	type sseEvent struct {
+		id      string
+		receipt models.ReceiptFeedEvent
+	}
+	connect := func(query string, lastEventID string) (*http.Response, <-chan sseEvent) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/events/receipts"+query, nil)
+		request.Header.Set("X-Admin-Token", "admin")
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("GET /events/receipts failed: %v", err)
+		}
+		received := make(chan sseEvent, 10)
+		go func() {
+			defer close(received)
+			scanner := bufio.NewScanner(response.Body)
+			var event sseEvent
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case strings.HasPrefix(line, "id: "):
+					event.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.receipt)
+				case line == "" && event.id != "":
+					received <- event
+					event = sseEvent{}
+				}
+			}
+		}()
+		return response, received
+	}
+	next := func(received <-chan sseEvent) sseEvent {
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event received")
+			return sseEvent{}
+		}
+	}
+	stored := 0
+	store := func(retailer string) string {
+		stored++
+		receipt := GetSampleReceipt()
+		receipt.Retailer = retailer
+		receipt.PurchaseDate = fmt.Sprintf("2022-03-%02d", stored)
+		receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+		if err != nil {
+			t.Fatalf("generateAndStoreReceipt failed: %v", err)
+		}
+		return receiptID
+	}
+
+	if response, _ := connect("?minPoints=many", ""); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid minPoints status = %d, expected 400", response.StatusCode)
+	}
+
+	response, received := connect("?retailer=target&minPoints=1", "")
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, expected text/event-stream", response.Header.Get("Content-Type"))
+	}
+	store("Walgreens")
+	firstID := store("Target")
+	first := next(received)
+	if first.receipt.ID != firstID || first.receipt.Retailer != "Target" || first.receipt.Points == 0 {
+		t.Errorf("first event = %+v, expected receipt %s from Target", first.receipt, firstID)
+	}
+	response.Body.Close()
+
+	// Receipts stored while the consumer is away are replayed from the buffer when it reconnects.
+	secondID := store("Target")
+	store("Walgreens")
+	response, received = connect("?retailer=Target", first.id)
+	defer response.Body.Close()
+	second := next(received)
+	if second.receipt.ID != secondID {
+		t.Errorf("resumed event = %+v, expected receipt %s", second.receipt, secondID)
+	}
+	thirdID := store("Target")
+	if third := next(received); third.receipt.ID != thirdID {
+		t.Errorf("live event after resuming = %+v, expected receipt %s", third.receipt, thirdID)
+	}
+}
+
+// failingSink is an event sink whose every publish fails.
+type failingSink struct{}
+
+func (failingSink) Name() string { return "failing" }
+
+func (failingSink) Publish(context.Context, []events.Event) error {
+	return errors.New("pipeline unavailable")
+}
+
+func (failingSink) Close() error { return nil }
+
+// TestEventSinksReceiveRecordedEvents tests that recorded events reach a working sink in order, and that a failing
+// sink neither fails submission nor holds up the working one.
+func TestEventSinksReceiveRecordedEvents(t *testing.T) {
+	eventLog := events.NewLog(clock.Real{})
+	channel := sinks.NewChannelSink("pipeline", 100)
+	working, err := sinks.NewForwarder(channel, eventLog, "", clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	failing, err := sinks.NewForwarder(failingSink{}, eventLog, "", clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	receiptStore := NewReceiptStore(WithEventLog(eventLog), WithEventSinks(failing, working))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiptStore.StartEventSinks(ctx)
+
+	receipt := GetSampleReceipt()
+	receiptID, err := receiptStore.generateAndStoreReceipt(&receipt)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt failed with a failing sink: %v", err)
+	}
+	for seq := uint64(1); seq <= eventLog.LastSeq(); seq++ {
+		select {
+		case event := <-channel.Events():
+			if event.Seq != seq || event.ReceiptID != receiptID {
+				t.Errorf("received event %d of %s, expected event %d of %s", event.Seq, event.ReceiptID, seq, receiptID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d not received", seq)
+		}
+	}
+
+	statuses := receiptStore.eventSinkStatuses()
+	if len(statuses) != 2 || statuses[0].Name != "failing" || statuses[0].LagEvents != eventLog.LastSeq() {
+		t.Errorf("eventSinkStatuses() = %+v, expected the failing sink behind by every event", statuses)
+	}
+}
+
+// TestGRPCSharesTheStore tests that receipts processed over gRPC are authenticated, validated, scored, stored and
+// rate limited like REST ones.
+func TestGRPCSharesTheStore(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), fakeClock, nil, map[string]ratelimit.Limit{
+		"POST /receipts/process": {Rate: 1.0 / 30, Burst: 6},
+	})
+	receiptStore := NewReceiptStore(WithClock(fakeClock), WithRateLimiter(limiter), WithAdminToken("admin"),
+		WithUserTokens("a-secret-of-at-least-thirty-two-bytes", time.Hour))
+	listener := bufconn.Listen(1 << 20)
+	server := grpcapi.NewServer(receiptStore, receiptStore.GRPCServerOptions()...)
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	client := grpcapi.NewReceiptServiceClient(conn)
+	userToken := receiptStore.userTokens.Sign("user-1", fakeClock.Now().Add(time.Hour))
+	asUser := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+userToken)
+	asAdmin := metadata.AppendToOutgoingContext(context.Background(), "x-admin-token", "admin")
+
+	receipt := GetSampleReceipt()
+	receipt.UserID = "user-1"
+	submitted := &grpcapi.Receipt{UserId: receipt.UserID, Retailer: receipt.Retailer, PurchaseDate: receipt.PurchaseDate, PurchaseTime: receipt.PurchaseTime, Total: receipt.Total}
+	for _, item := range receipt.Items {
+		submitted.Items = append(submitted.Items, &grpcapi.Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	if _, err := client.ProcessReceipt(context.Background(), submitted); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ProcessReceipt() without credentials error = %v, expected Unauthenticated", err)
+	}
+	created, err := client.ProcessReceipt(asUser, submitted)
+	if err != nil {
+		t.Fatalf("ProcessReceipt() error = %v", err)
+	}
+	points, err := client.GetPoints(asUser, &grpcapi.GetPointsRequest{Id: created.GetId()})
+	if err != nil || points.GetPoints() != 109 {
+		t.Errorf("GetPoints() = %v, %v; expected 109 points", points, err)
+	}
+	//The REST handler reads the same store
+	rr := httptest.NewRecorder()
+	receiptStore.FetchPoints(rr, httptest.NewRequest(http.MethodGet, "/dummy-url", nil), httprouter.Params{{Key: "id", Value: created.GetId()}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"points":109`) {
+		t.Errorf("FetchPoints after gRPC submission = %d %s", rr.Code, rr.Body.String())
+	}
+
+	if _, err := client.ProcessReceipt(asUser, submitted); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("ProcessReceipt() of a duplicate error = %v, expected AlreadyExists", err)
+	}
+	invalid := &grpcapi.Receipt{Retailer: receipt.Retailer, PurchaseDate: receipt.PurchaseDate, PurchaseTime: receipt.PurchaseTime, Items: submitted.Items, Total: "nine"}
+	if _, err := client.ProcessReceipt(asUser, invalid); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ProcessReceipt() of an invalid receipt error = %v, expected InvalidArgument", err)
+	}
+	someoneElses := &grpcapi.Receipt{UserId: "user-2", Retailer: receipt.Retailer, PurchaseDate: receipt.PurchaseDate, PurchaseTime: receipt.PurchaseTime, Items: submitted.Items, Total: receipt.Total}
+	if _, err := client.ProcessReceipt(asUser, someoneElses); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ProcessReceipt() for another user error = %v, expected PermissionDenied", err)
+	}
+	if _, err := client.GetReceipt(asUser, &grpcapi.GetReceiptRequest{Id: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetReceipt() of an unknown receipt error = %v, expected NotFound", err)
+	}
+
+	stream, err := client.BatchScore(asUser)
+	if err != nil {
+		t.Fatalf("BatchScore() error = %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		batched := &grpcapi.Receipt{UserId: "user-1", Retailer: receipt.Retailer, PurchaseDate: fmt.Sprintf("2022-04-%02d", i), PurchaseTime: receipt.PurchaseTime, Items: submitted.Items, Total: receipt.Total}
+		if i == 2 {
+			batched.Total = "nine"
+		}
+		if err := stream.Send(&grpcapi.BatchScoreRequest{RequestId: fmt.Sprint(i), Receipt: batched}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() %d error = %v", i, err)
+		}
+		if response.GetRequestId() != fmt.Sprint(i) || (i == 2) != (codes.Code(response.GetCode()) == codes.InvalidArgument) {
+			t.Errorf("response %d = %v", i, response)
+		}
+	}
+	//Every receipt on the stream counts against the user's limit of POST /receipts/process, which six calls have spent
+	if err := stream.Send(&grpcapi.BatchScoreRequest{RequestId: "3", Receipt: submitted}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Recv() over the rate limit error = %v, expected ResourceExhausted", err)
+	}
+
+	if _, err := client.ListReceipts(asUser, &grpcapi.ListReceiptsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ListReceipts() of every receipt as a user error = %v, expected PermissionDenied", err)
+	}
+	//The user now has two receipts; list them one at a time
+	var listed []string
+	pageToken := ""
+	for pages := 0; pages < 3; pages++ {
+		page, err := client.ListReceipts(asUser, &grpcapi.ListReceiptsRequest{UserId: "user-1", PageSize: 1, PageToken: pageToken})
+		if err != nil {
+			t.Fatalf("ListReceipts() error = %v", err)
+		}
+		for _, stored := range page.GetReceipts() {
+			listed = append(listed, stored.GetId())
+		}
+		if pageToken = page.GetNextPageToken(); pageToken == "" {
+			break
+		}
+	}
+	if len(listed) != 2 || pageToken != "" {
+		t.Errorf("ListReceipts() listed %v, expected the user's 2 receipts", listed)
+	}
+	if page, err := client.ListReceipts(asAdmin, &grpcapi.ListReceiptsRequest{}); err != nil || len(page.GetReceipts()) != 2 {
+		t.Errorf("ListReceipts() of every receipt as the admin = %v, %v", page, err)
+	}
+}
+
+// TestGraphQLReceiptsWithUsers tests the processReceipt mutation and a query for receipts with their items, points
+// breakdown and owner's balance.
+func TestGraphQLReceiptsWithUsers(t *testing.T) {
+	receiptStore := NewReceiptStore(WithUserTokens("a-secret-of-at-least-thirty-two-bytes", time.Hour))
+	bearer := func(userID string) string {
+		return "Bearer " + receiptStore.userTokens.Sign(userID, receiptStore.clock.Now().Add(time.Hour))
+	}
+	authorization := bearer("user-1")
+	execute := func(method string, body string, query string) (int, map[string]interface{}) {
+		request := httptest.NewRequest(method, "/graphql"+query, strings.NewReader(body))
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		receiptStore.ExecuteGraphQL(rr, request, nil)
+		var response map[string]interface{}
+		if rr.Body.Len() > 0 {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding %s failed: %v", rr.Body.String(), err)
+			}
+		}
+		return rr.Code, response
+	}
+
+	var receiptIDs []string
+	for day := 20; day <= 22; day++ {
+		receipt := GetSampleReceipt()
+		receipt.UserID = "user-1"
+		receipt.PurchaseDate = fmt.Sprintf("2022-03-%d", day)
+		body, _ := json.Marshal(map[string]interface{}{
+			"query": "mutation ($receipt: ReceiptInput!) { processReceipt(receipt: $receipt) { id points } }",
+			"variables": map[string]interface{}{"receipt": map[string]interface{}{
+				"userId": receipt.UserID, "retailer": receipt.Retailer, "purchaseDate": receipt.PurchaseDate,
+				"purchaseTime": receipt.PurchaseTime, "total": receipt.Total, "items": receipt.Items,
+			}},
+		})
+		code, response := execute(http.MethodPost, string(body), "")
+		if code != http.StatusOK || response["errors"] != nil {
+			t.Fatalf("processReceipt = %d %v", code, response)
+		}
+		processed := response["data"].(map[string]interface{})["processReceipt"].(map[string]interface{})
+		receiptIDs = append(receiptIDs, processed["id"].(string))
+	}
+
+	query, _ := json.Marshal(map[string]interface{}{
+		"query":     `query ($ids: [ID!]!) { receipts(ids: $ids) { id points items { price } breakdown { rule points } user { id balance receipts { id } } } }`,
+		"variables": map[string]interface{}{"ids": append(receiptIDs, "missing")},
+	})
+	code, response := execute(http.MethodPost, string(query), "")
+	if code != http.StatusOK || response["errors"] != nil {
+		t.Fatalf("receipts query = %d %v", code, response)
+	}
+	receipts := response["data"].(map[string]interface{})["receipts"].([]interface{})
+	if len(receipts) != 4 || receipts[3] != nil {
+		t.Fatalf("receipts = %v, expected 3 receipts and a null for the missing one", receipts)
+	}
+	totalPoints := 0
+	for i, raw := range receipts[:3] {
+		receipt := raw.(map[string]interface{})
+		points := int(receipt["points"].(float64))
+		totalPoints += points
+		breakdownPoints := 0
+		for _, contribution := range receipt["breakdown"].([]interface{}) {
+			breakdownPoints += int(contribution.(map[string]interface{})["points"].(float64))
+		}
+		if receipt["id"] != receiptIDs[i] || breakdownPoints != points || len(receipt["items"].([]interface{})) != 4 {
+			t.Errorf("receipt %d = %v, expected its breakdown to add up to its points", i, receipt)
+		}
+	}
+	user := receipts[0].(map[string]interface{})["user"].(map[string]interface{})
+	if user["id"] != "user-1" || int(user["balance"].(float64)) != totalPoints || len(user["receipts"].([]interface{})) != 3 {
+		t.Errorf("user = %v, expected user-1 with a balance of %d and 3 receipts", user, totalPoints)
+	}
+
+	//Only the user and the operator may read a user's balance and receipts
+	for _, other := range []string{"", bearer("mallory")} {
+		authorization = other
+		userQuery := url.QueryEscape(`{ user(id: "user-1") { receipts { id total } } receipt(id: "` + receiptIDs[0] + `") { user { balance } } }`)
+		code, response := execute(http.MethodGet, "", "?query="+userQuery)
+		data, _ := response["data"].(map[string]interface{})
+		if code != http.StatusOK || data == nil || data["user"] != nil || data["receipt"].(map[string]interface{})["user"] != nil || len(response["errors"].([]interface{})) != 2 {
+			t.Errorf("user query as %q = %d %v, expected both users refused", other, code, response)
+		}
+	}
+	authorization = bearer("user-1")
+
+	//The same receipt again is a duplicate, reported as a field error
+	receipt := GetSampleReceipt()
+	receipt.UserID = "user-1"
+	mutation := fmt.Sprintf(`{"query":"mutation { processReceipt(receipt: {userId: \"user-1\", retailer: \"%s\", purchaseDate: \"2022-03-20\", purchaseTime: \"%s\", total: \"%s\", items: [{shortDescription: \"Gatorade\", price: \"2.25\"}, {shortDescription: \"Gatorade\", price: \"2.25\"}, {shortDescription: \"Gatorade\", price: \"2.25\"}, {shortDescription: \"Gatorade\", price: \"2.25\"}]}) { id } }"}`,
+		receipt.Retailer, receipt.PurchaseTime, receipt.Total)
+	if code, response := execute(http.MethodPost, mutation, ""); code != http.StatusOK || response["errors"] == nil || response["data"] != nil {
+		t.Errorf("duplicate processReceipt = %d %v, expected a field error", code, response)
+	}
+	//However the document is written, GET never runs a mutation
+	processAnother := `processReceipt(receipt: {retailer: "Target", purchaseDate: "2022-01-01", purchaseTime: "13:01", total: "1.00", items: [{shortDescription: "Pepsi", price: "1.00"}]}) { id }`
+	for _, query := range []string{
+		"mutation { " + processAnother + " }",
+		"# a comment first\nmutation { " + processAnother + " }",
+		`query A { receipt(id: "x") { id } } mutation B { ` + processAnother + " }&operationName=B",
+	} {
+		target := "?query=" + url.QueryEscape(query)
+		if name, found := strings.CutSuffix(query, "&operationName=B"); found {
+			target = "?query=" + url.QueryEscape(name) + "&operationName=B"
+		}
+		if code, _ := execute(http.MethodGet, "", target); code != http.StatusBadRequest {
+			t.Errorf("GET %q = %d, expected 400", query, code)
+		}
+	}
+	if page, _ := receiptStore.listReceipts("", 0, ""); len(page.Receipts) != 3 {
+		t.Errorf("%d receipts stored, expected no more than the 3 processed with POST", len(page.Receipts))
+	}
+	if code, response := execute(http.MethodGet, "", "?query="+url.QueryEscape(`{ receipt(id: "`+receiptIDs[0]+`") { nope } }`)); code != http.StatusBadRequest || response["errors"] == nil {
+		t.Errorf("invalid query = %d %v, expected 400 with errors", code, response)
+	}
+	if code, response := execute(http.MethodGet, "", "?query="+url.QueryEscape(`{ receipt(id: "`+receiptIDs[0]+`") { retailer } }`)); code != http.StatusOK || response["data"] == nil {
+		t.Errorf("GET query = %d %v", code, response)
+	}
+}
+
+// TestGraphQLMutationsAreRateLimited tests that every processReceipt in a document, aliased or not, counts against
+// the caller's limit for POST /receipts/process.
+func TestGraphQLMutationsAreRateLimited(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), fakeClock, nil, map[string]ratelimit.Limit{
+		"POST /receipts/process": {Rate: 1.0 / 30, Burst: 2},
+	})
+	receiptStore := NewReceiptStore(WithClock(fakeClock), WithRateLimiter(limiter))
+	var fields []string
+	for i := 0; i < 3; i++ {
+		fields = append(fields, fmt.Sprintf(`r%d: processReceipt(receipt: {retailer: "Target", purchaseDate: "2022-01-0%d", purchaseTime: "13:01", total: "1.00", items: [{shortDescription: "Pepsi", price: "1.00"}]}) { id }`, i, i+1))
+	}
+	body, _ := json.Marshal(map[string]string{"query": "mutation { " + strings.Join(fields, " ") + " }"})
+	rr := httptest.NewRecorder()
+	receiptStore.ExecuteGraphQL(rr, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)), nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "POST /receipts/process limit exceeded") {
+		t.Errorf("three processReceipt mutations = %d %s, expected the third over the limit", rr.Code, rr.Body.String())
+	}
+	if page, _ := receiptStore.listReceipts("", 0, ""); len(page.Receipts) != 2 {
+		t.Errorf("%d receipts stored, expected the 2 the limit allows", len(page.Receipts))
+	}
+}
+
+// TestAPIVersionNegotiation tests that versioned paths and the Accept header pick the response shape, and that
+// unversioned paths are marked deprecated.
+func TestAPIVersionNegotiation(t *testing.T) {
+	sunset := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+	receiptStore := NewReceiptStore(WithAPIDeprecation(time.Time{}, sunset))
+	router := httprouter.New()
+	router.POST("/receipts/process", receiptStore.Versioned(0, receiptStore.ProcessReceipt))
+	router.GET("/receipts/:id/points", receiptStore.Versioned(0, receiptStore.FetchPoints))
+	router.POST("/v2/receipts/process", receiptStore.Versioned(2, receiptStore.ProcessReceipt))
+	router.GET("/v1/receipts/:id/points", receiptStore.Versioned(1, receiptStore.FetchPoints))
+	router.GET("/v2/receipts/:id/points", receiptStore.Versioned(2, receiptStore.FetchPoints))
+	serve := func(method, path, accept string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		request := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request)
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/v2/receipts/process", "", GetSampleReceipt())
+	var processed models.ReceiptResponseV2
+	if err := json.Unmarshal(rr.Body.Bytes(), &processed); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("POST /v2/receipts/process = %d %s", rr.Code, rr.Body.String())
+	}
+	if processed.Points != 109 || processed.Status != models.ReceiptAccepted || rr.Header().Get("Deprecation") != "" {
+		t.Errorf("POST /v2/receipts/process = %+v with headers %v", processed, rr.Header())
+	}
+
+	tests := []struct {
+		name            string
+		path            string
+		accept          string
+		wantCode        int
+		wantVersion     string
+		wantContentType string
+		wantBody        string
+		wantSuccessor   string
+	}{
+		{"Unversioned", "/receipts/" + processed.ID + "/points", "", http.StatusOK, "1", "application/json", `{"points":109}`, "/v1/receipts/" + processed.ID + "/points"},
+		{"Unversioned asking for v2", "/receipts/" + processed.ID + "/points", "application/json;q=0.5, application/vnd.receiptprocessor.v2+json", http.StatusOK, "2", "application/vnd.receiptprocessor.v2+json", `"breakdown":[{"rule":"retailer-name","points":14}`, "/v2/receipts/" + processed.ID + "/points"},
+		{"Version 1", "/v1/receipts/" + processed.ID + "/points", "*/*", http.StatusOK, "1", "application/json", `{"points":109}`, ""},
+		{"Version 2", "/v2/receipts/" + processed.ID + "/points", "", http.StatusOK, "2", "application/json", `"status":"accepted"`, ""},
+		{"Version 2 error", "/v2/receipts/missing/points", "", http.StatusNotFound, "2", "application/json", `{"status":404,"error":"Not Found","message":"FetchPoints: Receipt not found"}`, ""},
+		{"Version 1 error", "/v1/receipts/missing/points", "", http.StatusNotFound, "1", "application/json", "", ""},
+		{"Conflicting Accept", "/v1/receipts/" + processed.ID + "/points", "application/vnd.receiptprocessor.v2+json", http.StatusNotAcceptable, "1", "application/json", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := serve(http.MethodGet, test.path, test.accept, nil)
+			if rr.Code != test.wantCode || rr.Header().Get("API-Version") != test.wantVersion || rr.Header().Get("Content-Type") != test.wantContentType {
+				t.Errorf("GET %s = %d, version %q, content type %q", test.path, rr.Code, rr.Header().Get("API-Version"), rr.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(rr.Body.String(), test.wantBody) || (test.wantBody == "" && rr.Body.Len() != 0) {
+				t.Errorf("GET %s body = %s, expected %s", test.path, rr.Body.String(), test.wantBody)
+			}
+			if test.wantSuccessor == "" {
+				if rr.Header().Get("Deprecation") != "" {
+					t.Errorf("GET %s is marked deprecated", test.path)
+				}
+				return
+			}
+			if rr.Header().Get("Deprecation") != "true" || rr.Header().Get("Sunset") != "Tue, 30 Jun 2026 00:00:00 GMT" ||
+				rr.Header().Get("Link") != "<"+test.wantSuccessor+`>; rel="successor-version"` {
+				t.Errorf("GET %s deprecation headers = %v", test.path, rr.Header())
+			}
+		})
+	}
+}
+
+// TestProcessReceiptText tests that a receipt sent as its printout is parsed, scored and stored like the same receipt
+// sent as JSON, and that an incomplete printout is refused.
+func TestProcessReceiptText(t *testing.T) {
+	receiptStore := NewReceiptStore()
+	printout := "M&M CORNER MARKET\n03/20/2022 2:33 PM\nGATORADE 2.25\nGATORADE 2.25\nGATORADE 2.25\nGATORADE 2.25\nTOTAL 9.00\nCASH 10.00\nCHANGE 1.00\n"
+	post := func(handle httprouter.Handle, contentType string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("X-User-ID", "user-7")
+		rr := httptest.NewRecorder()
+		handle(rr, request, nil)
+		return rr
+	}
+
+	rr := post(receiptStore.ParseReceiptText, "text/plain; charset=utf-8", printout)
+	var parsed receipttext.Result
+	if err := json.Unmarshal(rr.Body.Bytes(), &parsed); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("ParseReceiptText() = %d %s", rr.Code, rr.Body.String())
+	}
+	if parsed.Confidence < 0.9 || parsed.Receipt.Total != "9.00" || len(parsed.Receipt.Items) != 4 {
+		t.Errorf("ParseReceiptText() = %+v", parsed)
+	}
+	if _, found := receiptStore.userReceipts["user-7"]; found {
+		t.Error("ParseReceiptText() should not store the receipt")
+	}
+	if rr := post(receiptStore.ParseReceiptText, "application/json", printout); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("ParseReceiptText() of JSON = %d, want %d", rr.Code, http.StatusUnsupportedMediaType)
+	}
+
+	rr = post(receiptStore.ProcessReceipt, "text/plain", printout)
+	var response models.ReceiptResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("ProcessReceipt() of text = %d %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Parse-Confidence") != "0.97" {
+		t.Errorf("ProcessReceipt() Parse-Confidence = %q, want 0.97", rr.Header().Get("Parse-Confidence"))
+	}
+	_, receipt, found := receiptStore.lookupReceipt(response.Id)
+	if !found || receipt.Points != 109 || receipt.UserID != "user-7" || receipt.Retailer != "M&M CORNER MARKET" {
+		t.Errorf("stored receipt = %+v, want 109 points for user-7", receipt)
+	}
+
+	//Without a total or time the receipt is refused
+	rr = post(receiptStore.ProcessReceipt, "text/plain", "M&M CORNER MARKET\n2022-03-21\nGATORADE 2.25\n")
+	if rr.Code != http.StatusBadRequest || rr.Header().Get("Parse-Confidence") == "" {
+		t.Errorf("ProcessReceipt() of an incomplete printout = %d with headers %v, want %d", rr.Code, rr.Header(), http.StatusBadRequest)
+	}
+	strictStore := NewReceiptStore(WithTextParser(receipttext.NewParser(), 0.99))
+	if rr := post(strictStore.ProcessReceipt, "text/plain", printout); rr.Code != http.StatusBadRequest {
+		t.Errorf("ProcessReceipt() below the minimum confidence = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestCSVImportExport tests that a CSV import stores each valid receipt and reports errors by row, and that exports
+// carry the stored receipts and their points in both shapes.
+func TestCSVImportExport(t *testing.T) {
+	receiptStore := NewReceiptStore()
+	data := "Invoice,user_id,retailer,purchase_date,purchase_time,total,item_description,item_price\n" +
+		"INV-1,user-1,M&M Corner Market,2022-03-20,14:33,9.00,Gatorade,2.25\n" +
+		"INV-1,,,,,,Gatorade,2.25\n" +
+		"INV-1,,,,,,Gatorade,2.25\n" +
+		"INV-1,,,,,,Gatorade,2.25\n" +
+		"INV-2,user-1,Target,2022-01-02,13:13,1.25,Pepsi 12-PK,1.25\n" +
+		"INV-3,,Target,2022-01-03,13:13,1.25,Pepsi!,1.25\n" +
+		"INV-4,user-1,M&M Corner Market,2022-03-20,14:33,9.00,Gatorade,9.00\n" +
+		"INV-5,,Target,2022-01-05,13:13,,Pepsi,1.25\n"
+	request := httptest.NewRequest(http.MethodPost, "/admin/receipts/csv?columns=receipt%3DInvoice", strings.NewReader(data))
+	rr := httptest.NewRecorder()
+	receiptStore.ImportReceiptsCSV(rr, request, nil)
+	var report models.CSVImportReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("ImportReceiptsCSV() = %d %s", rr.Code, rr.Body.String())
+	}
+	if report.Imported != 2 || report.Failed != 3 || len(report.Receipts) != 2 || report.Receipts[0].Key != "INV-1" || report.Receipts[0].Points != 109 {
+		t.Fatalf("ImportReceiptsCSV() report = %+v", report)
+	}
+	wantErrors := []models.CSVRowError{
+		{Row: 7, Receipt: "INV-3", Column: "item_description", Error: "validateReceiptData: description validation failed"},
+		{Row: 8, Receipt: "INV-4", Error: "ProcessReceipt: near-duplicate of receipt " + report.Receipts[0].ID + ": duplicate receipt"},
+		{Row: 9, Receipt: "INV-5", Error: "validateReceiptData: total validation failed"},
+	}
+	if !reflect.DeepEqual(report.Errors, wantErrors) {
+		t.Errorf("ImportReceiptsCSV() errors = %+v, want %+v", report.Errors, wantErrors)
+	}
+
+	if rr := serveRequest(receiptStore.ImportReceiptsCSV, http.MethodPost, "/admin/receipts/csv?columns=oops", strings.NewReader(data)); rr.Code != http.StatusBadRequest {
+		t.Errorf("ImportReceiptsCSV() with a bad mapping = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serveRequest(receiptStore.ImportReceiptsCSV, http.MethodPost, "/admin/receipts/csv", strings.NewReader(data)); rr.Code != http.StatusBadRequest {
+		t.Errorf("ImportReceiptsCSV() without the receipt column = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = serveRequest(receiptStore.ExportReceiptsCSV, http.MethodGet, "/admin/receipts/csv?shape=summary&userId=user-1", nil)
+	summary := rr.Body.String()
+	if rr.Code != http.StatusOK || strings.Count(summary, "\n") != 3 || !strings.Contains(summary, report.Receipts[0].ID+",user-1,M&M Corner Market,2022-03-20,14:33,9.00,4,109,accepted\n") {
+		t.Errorf("ExportReceiptsCSV() summary = %d\n%s", rr.Code, summary)
+	}
+
+	rr = serveRequest(receiptStore.ExportReceiptsCSV, http.MethodGet, "/admin/receipts/csv", nil)
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\n") != 6 || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("ExportReceiptsCSV() items = %d\n%s", rr.Code, rr.Body.String())
+	}
+	//The export reads back, and its receipts are already stored
+	rr = serveRequest(receiptStore.ImportReceiptsCSV, http.MethodPost, "/admin/receipts/csv", rr.Body)
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil || report.Imported != 0 || report.Failed != 2 {
+		t.Errorf("ImportReceiptsCSV() of the export = %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// serveRequest serves a request without headers straight to a handler.
+func serveRequest(handle httprouter.Handle, method string, target string, body io.Reader) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handle(rr, httptest.NewRequest(method, target, body), nil)
+	return rr
+}
+
+// TestRedemptionsRequireTheUser tests that only the user, with their token, or the operator can spend, fetch and
+// cancel the user's redemptions.
+func TestRedemptionsRequireTheUser(t *testing.T) {
+	receiptStore := NewReceiptStore(WithAdminToken("admin"), WithUserTokens("a-secret-of-at-least-thirty-two-bytes", time.Hour))
+	receiptStore.putReward(models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5})
+	receipt := GetSampleReceipt()
+	receipt.UserID = "alice"
+	if _, err := receiptStore.generateAndStoreReceipt(&receipt); err != nil {
+		t.Fatalf("generateAndStoreReceipt() error = %v", err)
+	}
+	tokenFor := func(userID string) string {
+		rr := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/users/"+userID+"/tokens", nil)
+		request.Header.Set("X-Admin-Token", "admin")
+		receiptStore.AdminOnly(receiptStore.IssueUserToken)(rr, request, httprouter.Params{{Key: "id", Value: userID}})
+		var response models.UserTokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("IssueUserToken() = %d %s", rr.Code, rr.Body.String())
+		}
+		return response.Token
+	}
+	serve := func(handle httprouter.Handle, method, target, token string, body string, params httprouter.Params) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handle(rr, request, params)
+		return rr
+	}
+	redeem := `{"userId":"alice","rewardId":"mug"}`
+
+	if rr := serve(receiptStore.RedeemReward, http.MethodPost, "/redemptions", "", redeem, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("RedeemReward() without a token = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(receiptStore.RedeemReward, http.MethodPost, "/redemptions", tokenFor("mallory"), redeem, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("RedeemReward() with another user's token = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	rr := serve(receiptStore.RedeemReward, http.MethodPost, "/redemptions", tokenFor("alice"), redeem, nil)
+	var redemption models.Redemption
+	if err := json.Unmarshal(rr.Body.Bytes(), &redemption); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("RedeemReward() with the user's token = %d %s", rr.Code, rr.Body.String())
+	}
+
+	params := httprouter.Params{{Key: "id", Value: redemption.Id}}
+	if rr := serve(receiptStore.CancelRedemption, http.MethodPost, "/redemptions/x/cancel", tokenFor("mallory"), "", params); rr.Code != http.StatusForbidden {
+		t.Errorf("CancelRedemption() with another user's token = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := serve(receiptStore.FetchRedemption, http.MethodGet, "/redemptions/x", "u1.forged", "", params); rr.Code != http.StatusUnauthorized {
+		t.Errorf("FetchRedemption() with a forged token = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(receiptStore.CancelRedemption, http.MethodPost, "/redemptions/x/cancel", tokenFor("alice"), "", params); rr.Code != http.StatusOK {
+		t.Errorf("CancelRedemption() with the user's token = %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// TestUserPointsRequireTheUser tests that a user's balance, ledger and expiring points can only be read by the user
+// or the operator.
+func TestUserPointsRequireTheUser(t *testing.T) {
+	receiptStore := NewReceiptStore(WithAdminToken("admin"), WithUserTokens("a-secret-of-at-least-thirty-two-bytes", time.Hour))
+	bearer := func(userID string) string {
+		return "Bearer " + receiptStore.userTokens.Sign(userID, receiptStore.clock.Now().Add(time.Hour))
+	}
+	routes := []struct {
+		name   string
+		handle httprouter.Handle
+	}{
+		{"FetchBalance", receiptStore.FetchBalance},
+		{"FetchLedger", receiptStore.FetchLedger},
+		{"FetchExpiringPoints", receiptStore.FetchExpiringPoints},
+	}
+	credentials := []struct {
+		name     string
+		header   string
+		value    string
+		wantCode int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"another user's token", "Authorization", bearer("mallory"), http.StatusForbidden},
+		{"the user's token", "Authorization", bearer("alice"), http.StatusOK},
+		{"the admin token", "X-Admin-Token", "admin", http.StatusOK},
+	}
+	for _, route := range routes {
+		for _, credential := range credentials {
+			request := httptest.NewRequest(http.MethodGet, "/users/alice", nil)
+			if credential.header != "" {
+				request.Header.Set(credential.header, credential.value)
+			}
+			rr := httptest.NewRecorder()
+			route.handle(rr, request, httprouter.Params{{Key: "id", Value: "alice"}})
+			if rr.Code != credential.wantCode {
+				t.Errorf("%s() with %s = %d, want %d", route.name, credential.name, rr.Code, credential.wantCode)
+			}
+		}
+	}
+}
+
+// TestRefundsRequireTheOwner tests that only a receipt's owner or the operator can refund it, and only the operator an
+// anonymous receipt.
+func TestRefundsRequireTheOwner(t *testing.T) {
+	receiptStore := NewReceiptStore(WithAdminToken("admin"), WithUserTokens("a-secret-of-at-least-thirty-two-bytes", time.Hour))
+	owned := GetSampleReceipt()
+	owned.UserID = "alice"
+	ownedID, err := receiptStore.generateAndStoreReceipt(&owned)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt() error = %v", err)
+	}
+	anonymous := GetSampleReceipt()
+	anonymous.PurchaseDate = "2022-03-21"
+	anonymousID, err := receiptStore.generateAndStoreReceipt(&anonymous)
+	if err != nil {
+		t.Fatalf("generateAndStoreReceipt() error = %v", err)
+	}
+	refund := func(receiptID string, header string, value string) int {
+		body := `{"originalReceiptId":"` + receiptID + `","items":[{"shortDescription":"Gatorade","price":"2.25"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/refunds/process", strings.NewReader(body))
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		receiptStore.ProcessRefund(rr, request, nil)
+		return rr.Code
+	}
+	bearer := func(userID string) string {
+		return "Bearer " + receiptStore.userTokens.Sign(userID, receiptStore.clock.Now().Add(time.Hour))
+	}
+
+	tests := []struct {
+		name      string
+		receiptID string
+		header    string
+		value     string
+		wantCode  int
+	}{
+		{"Owned without a token", ownedID, "", "", http.StatusUnauthorized},
+		{"Owned with another user's token", ownedID, "Authorization", bearer("mallory"), http.StatusForbidden},
+		{"Owned with the owner's token", ownedID, "Authorization", bearer("alice"), http.StatusOK},
+		{"Owned with the admin token", ownedID, "X-Admin-Token", "admin", http.StatusOK},
+		{"Anonymous with a user's token", anonymousID, "Authorization", bearer("mallory"), http.StatusForbidden},
+		{"Anonymous with the admin token", anonymousID, "X-Admin-Token", "admin", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := refund(test.receiptID, test.header, test.value); code != test.wantCode {
+				t.Errorf("ProcessRefund() = %d, want %d", code, test.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/*
+*
+Helper function to describe a stored receipt as a purchase for the cross-receipt rules.
+*
+*/
+func purchaseOf(receiptID string, receipt *models.Receipt) loyalty.Purchase {
+	purchasedAt, err := time.Parse(time.DateOnly+" 15:04", receipt.PurchaseDate+" "+receipt.PurchaseTime)
+	if err != nil {
+		purchasedAt = time.Time{}
+	}
+	return loyalty.Purchase{ReceiptID: receiptID, Retailer: receipt.Retailer, PurchasedAt: purchasedAt}
+}
+
+/*
+*
+This function returns every purchase a user has stored, in no particular order. Receipts that can no longer be
+loaded are skipped. The caller must hold the user's owner lock, so their receipts cannot change underneath it.
+*
+*/
+func (receiptStore *ReceiptStore) userPurchases(userID string) []loyalty.Purchase {
+	receiptStore.lock.RLock()
+	receiptIDs := append([]string(nil), receiptStore.userReceipts[userID]...)
+	receiptStore.lock.RUnlock()
+
+	purchases := make([]loyalty.Purchase, 0, len(receiptIDs))
+	for _, receiptID := range receiptIDs {
+		receipt, found := receiptStore.receipts.get(receiptID)
+		if !found {
+			continue
+		}
+		purchases = append(purchases, purchaseOf(receiptID, &receipt))
+	}
+	return purchases
+}
+
+/*
+*
+This function computes the cross-receipt bonuses a receipt earns from its user's history. The caller must hold the
+user's owner lock.
+*
+*/
+func (receiptStore *ReceiptStore) historyBonuses(receiptID string, receipt *models.Receipt) []models.RuleBonus {
+	if receiptStore.historyRules == nil || receipt.UserID == "" {
+		return nil
+	}
+	return receiptStore.historyRules.Score(purchaseOf(receiptID, receipt), receiptStore.userPurchases(receipt.UserID))
+}
+
+/*
+*
+This function re-scores the history bonuses of a user's receipts that were purchased after a newly stored receipt,
+within the recompute window. A receipt that arrives out of order can change the streaks and counts of the
+receipts that follow it, so each changed receipt gets an Adjusted event for the difference, recorded at the given
+time. The caller must hold the user's owner lock.
+*
+*/
+func (receiptStore *ReceiptStore) rescoreLaterReceipts(receiptID string, receipt *models.Receipt, at time.Time) error {
+	if receiptStore.historyRules == nil || receipt.UserID == "" {
+		return nil
+	}
+
+	inserted := purchaseOf(receiptID, receipt)
+	for _, purchase := range receiptStore.userPurchases(receipt.UserID) {
+		if purchase.ReceiptID == receiptID || !inserted.Before(purchase) {
+			continue
+		}
+		if purchase.PurchasedAt.Sub(inserted.PurchasedAt) > receiptStore.historyRecomputeWindow {
+			continue
+		}
+
+		later, found := receiptStore.receipts.get(purchase.ReceiptID)
+		if !found {
+			continue
+		}
+		bonuses := receiptStore.historyBonuses(purchase.ReceiptID, &later)
+		delta := loyalty.TotalBonus(bonuses) - loyalty.TotalBonus(later.HistoryBonuses)
+		if delta == 0 && reflect.DeepEqual(bonuses, later.HistoryBonuses) {
+			continue
+		}
+		err := receiptStore.recordEvents(events.Event{
+			Type:      events.Adjusted,
+			ReceiptID: purchase.ReceiptID,
+			At:        at,
+			Reason:    events.ReasonHistory,
+			Score:     &events.Score{HistoryBonuses: bonuses},
+			Delta:     delta,
+			Reference: receiptID,
+		})
+		if err != nil {
+			return errors.Wrap(err, "rescoreLaterReceipts")
+		}
+	}
+	return nil
+}
@@ -8,33 +8,68 @@ import (
 
 	json "github.com/json-iterator/go"
 	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
 )
 
 /**
 * @api {post} /receipts/process Process Receipt
-* @apiDescription This endpoint processes a receipt and stores it in the receipt store.
+* @apiDescription This endpoint processes a receipt and stores it in the receipt store. From version 2 on the response
+* carries the receipt's points. A receipt sent as text/plain is parsed from its printout first.
 **/
 func (receiptStore *ReceiptStore) ProcessReceipt(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	receipt, err := checkReceiptValidity(r)
+	var receipt *models.Receipt
+	var err error
+	if isReceiptTextRequest(r) {
+		receipt, err = receiptStore.checkReceiptTextValidity(w, r)
+	} else {
+		receipt, err = checkReceiptValidity(r)
+	}
 	if err != nil {
 		handleErr(w, err, "ProcessReceipt validation error", http.StatusBadRequest)
 		return
 	}
 
 	receiptID, err := receiptStore.generateAndStoreReceipt(receipt)
+	if errors.Is(err, errDuplicateReceipt) {
+		handleErr(w, err, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		handleErr(w, err, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := sendReceiptResponse(w, receiptID); err != nil {
+	if err := sendReceiptResponse(w, receiptID, receipt); err != nil {
 		handleErr(w, err, "Error marshaling receipt response", http.StatusInternalServerError)
 	}
 }
 
+/**
+* @api {post} /receipts/parse Parse Receipt Text
+* @apiDescription This endpoint parses the text/plain printout of a receipt without processing it, and reports the
+* receipt read, how confident the parser is of each field and what it skipped.
+**/
+func (receiptStore *ReceiptStore) ParseReceiptText(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !isReceiptTextRequest(r) {
+		handleErr(w, nil, "ParseReceiptText: the receipt must be sent as text/plain", http.StatusUnsupportedMediaType)
+		return
+	}
+	result, err := receiptStore.parseReceiptText(r)
+	if err != nil {
+		handleErr(w, err, "ParseReceiptText: parsing failed", http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		handleErr(w, err, "Error marshaling parse response", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, data)
+}
+
 /**
 * @api {get} /receipts/:id/points Fetch Points
-* @apiDescription This endpoint fetches the points for a receipt.
+* @apiDescription This endpoint fetches the points for a receipt. From version 2 on it also explains them rule by rule.
 **/
 func (receiptStore *ReceiptStore) FetchPoints(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	receiptID := strings.TrimSpace(params.ByName("id"))
@@ -42,14 +77,23 @@ func (receiptStore *ReceiptStore) FetchPoints(w http.ResponseWriter, r *http.Req
 		handleErr(w, nil, "FetchPoints: No receipt ID provided", http.StatusBadRequest)
 		return
 	}
-	receiptStore.lock.Lock()
-	defer receiptStore.lock.Unlock()
-	receipt, found := receiptStore.receipts[receiptID]
+	storedID, receipt, found := receiptStore.lookupReceipt(receiptID)
 	if !found {
 		handleErr(w, nil, "FetchPoints: Receipt not found", http.StatusNotFound)
 		return
 	}
-	response := models.PointsResponse{Points: receipt.Points}
+	var response interface{} = models.PointsResponse{Points: receipt.Points}
+	if apiVersionOf(w) >= apiVersion2 {
+		response = models.PointsResponseV2{
+			ID:             storedID,
+			Points:         receipt.Points,
+			BasePoints:     receipt.BasePoints,
+			Tier:           receipt.Tier,
+			TierMultiplier: receipt.TierMultiplier,
+			Status:         receiptStatusV2(receipt.Status),
+			Breakdown:      receiptBreakdown(receipt),
+		}
+	}
 	data, err := json.Marshal(response)
 	if err != nil {
 		handleErr(w, err, "Error marshaling points response", http.StatusInternalServerError)
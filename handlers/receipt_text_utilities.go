@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receipttext"
+)
+
+// defaultMinTextConfidence is the confidence a receipt parsed from text needs to be processed, unless configured
+// otherwise.
+const defaultMinTextConfidence = 0.6
+
+// maxReceiptTextBytes bounds the text of a receipt, which even a long printout stays far below.
+const maxReceiptTextBytes = 64 << 10
+
+/*
+*
+Helper function to tell whether a request sends the raw text of a receipt rather than JSON.
+*
+*/
+func isReceiptTextRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/plain"
+}
+
+/*
+*
+This function parses the receipt text in the request body. The receipt belongs to the user named in the X-User-ID
+header, if any.
+*
+*/
+func (receiptStore *ReceiptStore) parseReceiptText(r *http.Request) (receipttext.Result, error) {
+	requestBody, err := io.ReadAll(io.LimitReader(r.Body, maxReceiptTextBytes+1))
+	if err != nil {
+		return receipttext.Result{}, errors.Wrap(err, "parseReceiptText: reading body failed")
+	}
+	if len(requestBody) > maxReceiptTextBytes {
+		return receipttext.Result{}, errors.Errorf("parseReceiptText: the receipt text is over %d bytes", maxReceiptTextBytes)
+	}
+	result, err := receiptStore.textParser.Parse(string(requestBody))
+	if err != nil {
+		return receipttext.Result{}, errors.Wrap(err, "parseReceiptText: parsing failed")
+	}
+	result.Receipt.UserID = strings.TrimSpace(r.Header.Get("X-User-ID"))
+	return result, nil
+}
+
+/*
+*
+This function checks the validity of a receipt sent as text. Besides passing the usual validation, the parse must
+reach the configured confidence. The confidence and the template used are reported in the Parse-Confidence and
+Parse-Template headers, so a client can tell why a receipt was refused.
+*
+*/
+func (receiptStore *ReceiptStore) checkReceiptTextValidity(w http.ResponseWriter, r *http.Request) (*models.Receipt, error) {
+	result, err := receiptStore.parseReceiptText(r)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("Parse-Confidence", strconv.FormatFloat(result.Confidence, 'f', 2, 64))
+	if result.Template != "" {
+		w.Header().Set("Parse-Template", result.Template)
+	}
+	if result.Confidence < receiptStore.minTextConfidence {
+		return nil, errors.Errorf("checkReceiptTextValidity: parse confidence %.2f is below %.2f", result.Confidence, receiptStore.minTextConfidence)
+	}
+	if err := validateReceiptData(result.Receipt); err != nil {
+		return nil, err
+	}
+	return &result.Receipt, nil
+}
@@ -10,11 +10,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/mitchellh/hashstructure"
 	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/dedup"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
 	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
 )
 
 // Precompiling regular expressions for efficiency, readability, and reusability
@@ -25,6 +29,7 @@ var (
 	totalRegex       = regexp.MustCompile(`^\d+\.\d{2}$`)
 	descriptionRegex = regexp.MustCompile(`^[\w\s\-]+$`)
 	priceRegex       = regexp.MustCompile(`^\d+\.\d{2}$`)
+	userIDRegex      = regexp.MustCompile(`^[\w\-]{1,64}$`)
 )
 
 /*
@@ -74,18 +79,34 @@ func validateReceiptData(receipt models.Receipt) error {
 		}
 	}
 
+	if receipt.UserID != "" && !userIDRegex.MatchString(receipt.UserID) {
+		return errors.New("validateReceiptData: user ID validation failed")
+	}
+
 	for _, item := range receipt.Items {
-		if !priceRegex.MatchString(item.Price) {
-			return errors.New("validateReceiptData: price validation failed")
-		}
-		if !descriptionRegex.MatchString(item.ShortDescription) {
-			return errors.New("validateReceiptData: description validation failed")
+		if err := validateItemData(item); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+/*
+*
+Helper function to validate an item's Price & Description
+*
+*/
+func validateItemData(item models.Item) error {
+	if !priceRegex.MatchString(item.Price) {
+		return errors.New("validateReceiptData: price validation failed")
+	}
+	if !descriptionRegex.MatchString(item.ShortDescription) {
+		return errors.New("validateReceiptData: description validation failed")
+	}
+	return nil
+}
+
 const (
 	pointsForRoundDollarTotal         = 50
 	pointsForTotalInCentsMultipleOf25 = 25
@@ -214,33 +235,305 @@ func computeTimeBonus(purchaseTimeStr string) int {
 
 /*
 *
-This function generates a receipt ID and stores the receipt in the receipt store.
+This function generates a receipt ID and stores the receipt in the receipt store. Exact duplicates and
+near-duplicates are rejected, held for review or allowed depending on their duplicate policies, and risky receipts
+are held for review.
+Hashing, ID generation and base scoring happen before any lock is taken. The user's lock is then held while their
+history is scored, and the store lock only while the duplicate indexes are checked and updated.
 *
 */
 func (receiptStore *ReceiptStore) generateAndStoreReceipt(receipt *models.Receipt) (string, error) {
-	receiptStore.lock.Lock()
-	defer receiptStore.lock.Unlock()
-
-	receiptHash, err := hashstructure.Hash(receipt, nil) //Hashing receipt to generate a unique receipt ID
+	//Hashing the canonical form of the receipt to recognise it again, so cosmetic differences such as item order or
+	//trailing spaces do not hide an exact duplicate
+	contentID := receiptid.ContentID(*receipt)
+	legacyID, err := receiptid.LegacyID(*receipt)
 	if err != nil {
 		log.Println("Error hashing receipt")
 		return "", fmt.Errorf("error hashing receipt")
 	}
+	receiptID, err := receiptStore.idStrategy.NewID(*receipt)
+	if err != nil {
+		log.Println("Error generating receipt ID")
+		return "", errors.Wrap(err, "ProcessReceipt: generating receipt ID failed")
+	}
+	receipt.Status = ""
+	receipt.Flags = nil
+	receipt.BasePoints = computeReceiptPoints(receipt)
 
-	receiptID := strconv.FormatUint(receiptHash, 10) //Converting the hash to a string
-	if _, exists := receiptStore.receipts[receiptID]; exists {
-		log.Println("Duplicate receipt submission")
-		return "", fmt.Errorf("ProcessReceipt: Duplicate receipt submission")
+	if receipt.UserID != "" {
+		ownerLock := receiptStore.ownerLocks.forOwner(receipt.UserID, receiptID)
+		ownerLock.Lock()
+		defer ownerLock.Unlock()
+	}
+
+	if err := receiptStore.reserveReceipt(receiptID, contentID, legacyID, receipt); err != nil {
+		return "", err
 	}
+	receiptStore.assessRisk(receipt)
 
-	receipt.Points = computeReceiptPoints(receipt)
-	receiptStore.receipts[receiptID] = *receipt
+	now := receiptStore.clock.Now()
+	receiptStore.scoreReceipt(receiptID, receipt, now)
+	if err := receiptStore.recordEvents(submissionEvents(receiptID, contentID, receipt, "")...); err != nil {
+		log.Println("Error recording receipt events")
+		receiptStore.releaseReservation(receiptID, contentID, legacyID, receipt)
+		return "", errors.Wrap(err, "ProcessReceipt: recording receipt events failed")
+	}
+
+	if err := receiptStore.rescoreLaterReceipts(receiptID, receipt, now); err != nil {
+		log.Printf("Error re-scoring later receipts: %v", err)
+	}
 
 	return receiptID, nil
 }
 
-func sendReceiptResponse(w http.ResponseWriter, receiptID string) error {
-	response := models.ReceiptResponse{Id: receiptID}
+/*
+*
+This function applies the duplicate policies to a receipt and, unless it is rejected, claims its ID and indexes its
+content under the store lock, so two identical receipts submitted at once cannot both pass as originals.
+*
+*/
+func (receiptStore *ReceiptStore) reserveReceipt(receiptID string, contentID string, legacyID string, receipt *models.Receipt) error {
+	//Looked up before taking the store lock, since reading a bounded store can evict and drop receipts, which
+	//takes the store lock to forget them
+	_, stored := receiptStore.receipts.get(receiptID)
+
+	receiptStore.lock.Lock()
+	defer receiptStore.lock.Unlock()
+
+	originalID, exists := receiptStore.contentIndex[contentID]
+	if exists {
+		if err := applyDuplicatePolicy(receiptStore.exactDuplicatePolicy, receipt, "duplicate-of:"+originalID); err != nil {
+			log.Println("Duplicate receipt submission")
+			return errors.Wrapf(err, "ProcessReceipt: duplicate of receipt %s", originalID)
+		}
+	} else if similarID, found := receiptStore.duplicates.FindSimilar(*receipt); found {
+		if err := applyDuplicatePolicy(receiptStore.duplicatePolicy, receipt, "near-duplicate-of:"+similarID); err != nil {
+			log.Println("Near-duplicate receipt submission")
+			return errors.Wrapf(err, "ProcessReceipt: near-duplicate of receipt %s", similarID)
+		}
+	}
+
+	//An ID already in the content index was issued to an identical receipt that may not be stored yet
+	if stored || (exists && originalID == receiptID) {
+		log.Println("Duplicate receipt submission")
+		return errors.Wrapf(errDuplicateReceipt, "ProcessReceipt: receipt ID %s already issued", receiptID)
+	}
+
+	if !exists {
+		receiptStore.contentIndex[contentID] = receiptID
+	}
+	receiptStore.aliasLegacyID(legacyID, receiptID)
+	receiptStore.duplicates.Add(receiptID, *receipt)
+	return nil
+}
+
+/*
+*
+This function undoes reserveReceipt for a receipt that could not be stored.
+*
+*/
+func (receiptStore *ReceiptStore) releaseReservation(receiptID string, contentID string, legacyID string, receipt *models.Receipt) {
+	receiptStore.lock.Lock()
+	defer receiptStore.lock.Unlock()
+	receiptStore.unindexContent(receiptID, contentID, legacyID, *receipt)
+}
+
+/*
+*
+Helper function to take a receipt out of the content and alias tables and the near-duplicate detector. The caller
+must hold the lock.
+*
+*/
+func (receiptStore *ReceiptStore) unindexContent(receiptID string, contentID string, legacyID string, receipt models.Receipt) {
+	if receiptStore.contentIndex[contentID] == receiptID {
+		delete(receiptStore.contentIndex, contentID)
+	}
+	if receiptStore.legacyIDs[legacyID] == receiptID {
+		delete(receiptStore.legacyIDs, legacyID)
+	}
+	receiptStore.duplicates.Remove(receiptID, receipt)
+}
+
+/*
+*
+This function takes a receipt out of every index over it: its user's history, the review queue, its refunded items,
+the content and alias tables and the near-duplicate detector.
+*
+*/
+func (receiptStore *ReceiptStore) unindexReceipt(receiptID string, receipt models.Receipt) error {
+	contentID := receiptid.ContentID(receipt)
+	legacyID, err := receiptid.LegacyID(receipt)
+	if err != nil {
+		return errors.Wrap(err, "unindexReceipt")
+	}
+
+	receiptStore.lock.Lock()
+	defer receiptStore.lock.Unlock()
+	receiptStore.removeUserReceipt(receipt.UserID, receiptID)
+	delete(receiptStore.reviewQueue, receiptID)
+	delete(receiptStore.refundedItems, receiptID)
+	receiptStore.unindexContent(receiptID, contentID, legacyID, receipt)
+	return nil
+}
+
+/*
+*
+Helper function to forget a receipt the bounded store dropped from memory without spilling it, so it is neither
+listed in its user's history nor refused as a duplicate when it is submitted again.
+*
+*/
+func (receiptStore *ReceiptStore) forgetDroppedReceipt(receiptID string, receipt models.Receipt) {
+	if err := receiptStore.unindexReceipt(receiptID, receipt); err != nil {
+		log.Printf("Error forgetting dropped receipt %s: %v", receiptID, err)
+	}
+}
+
+/*
+*
+Helper function to take a receipt the bounded store spilled to disk out of the content and alias tables and the
+near-duplicate detector, so they only hold the receipts in memory. Its user's history keeps it, since scoring reads
+the whole history. The entries come back when the receipt is reloaded.
+*
+*/
+func (receiptStore *ReceiptStore) unindexSpilledReceipt(receiptID string, receipt models.Receipt) {
+	legacyID, err := receiptid.LegacyID(receipt)
+	if err != nil {
+		log.Printf("Error unindexing spilled receipt %s: %v", receiptID, err)
+		return
+	}
+	contentID := receiptid.ContentID(receipt)
+
+	receiptStore.lock.Lock()
+	defer receiptStore.lock.Unlock()
+	receiptStore.unindexContent(receiptID, contentID, legacyID, receipt)
+}
+
+/*
+*
+Helper function to index a receipt the bounded store reloaded from disk again.
+*
+*/
+func (receiptStore *ReceiptStore) reindexReloadedReceipt(receiptID string, receipt models.Receipt) {
+	if err := receiptStore.indexReceipt(receiptID, receipt); err != nil {
+		log.Printf("Error indexing reloaded receipt %s: %v", receiptID, err)
+	}
+}
+
+/*
+*
+Helper function to resolve the ID earlier versions issued for a receipt to the ID it is stored under. Earlier
+versions hashed receipts as submitted, so cosmetic variants of one purchase each had their own ID; each variant that
+is stored gets its alias, and the first receipt to claim an alias keeps it. The caller must hold the lock.
+*
+*/
+func (receiptStore *ReceiptStore) aliasLegacyID(legacyID string, receiptID string) {
+	if _, aliased := receiptStore.legacyIDs[legacyID]; !aliased {
+		receiptStore.legacyIDs[legacyID] = receiptID
+	}
+}
+
+/*
+*
+Helper function to apply a duplicate policy to a receipt that duplicates a stored one. Rejected receipts return
+errDuplicateReceipt; receipts held for review are marked pending with the given flag.
+*
+*/
+func applyDuplicatePolicy(policy dedup.Policy, receipt *models.Receipt, flag string) error {
+	switch policy {
+	case dedup.PolicyAllow:
+		return nil
+	case dedup.PolicyReview:
+		receipt.Status = models.ReceiptPendingReview
+		receipt.Flags = append(receipt.Flags, flag)
+		return nil
+	default:
+		return errDuplicateReceipt
+	}
+}
+
+/*
+*
+This function finds a stored receipt by the ID from a request. Decimal IDs issued before receipts were
+content-addressed are translated through the alias table. It returns the ID the receipt is stored under.
+*
+*/
+func (receiptStore *ReceiptStore) lookupReceipt(receiptID string) (string, models.Receipt, bool) {
+	if receipt, found := receiptStore.receipts.get(receiptID); found {
+		return receiptID, receipt, true
+	}
+
+	receiptStore.lock.RLock()
+	currentID, found := receiptStore.legacyIDs[receiptID]
+	receiptStore.lock.RUnlock()
+	if !found {
+		return receiptID, models.Receipt{}, false
+	}
+	receipt, found := receiptStore.receipts.get(currentID)
+	return currentID, receipt, found
+}
+
+/*
+*
+This function finds a stored receipt and locks its owner, so the receipt can be read and changed without racing
+another change to it. The caller must unlock the returned mutex when it is not nil.
+*
+*/
+func (receiptStore *ReceiptStore) lockReceipt(rawReceiptID string) (string, models.Receipt, *sync.Mutex, bool) {
+	receiptID, receipt, found := receiptStore.lookupReceipt(rawReceiptID)
+	if !found {
+		return receiptID, models.Receipt{}, nil, false
+	}
+	ownerLock := receiptStore.ownerLocks.forOwner(receipt.UserID, receiptID)
+	ownerLock.Lock()
+	//Reading the receipt again, since it may have changed while waiting for the lock
+	receipt, _ = receiptStore.receipts.get(receiptID)
+	return receiptID, receipt, ownerLock, true
+}
+
+/*
+*
+This function applies the user's loyalty tier multiplier to a receipt's base points and adds the cross-receipt
+bonuses from the user's history. The tier is evaluated as of the given time, which is when the receipt was
+submitted, and recorded on the receipt so its points never change when the user's tier changes later. The caller
+must hold the user's owner lock.
+*
+*/
+func (receiptStore *ReceiptStore) scoreReceipt(receiptID string, receipt *models.Receipt, at time.Time) {
+	receipt.Tier = ""
+	receipt.TierMultiplier = 0
+	if receiptStore.tierPolicy != nil && receipt.UserID != "" {
+		account := ledger.UserAccount(receipt.UserID)
+		tier := receiptStore.tierPolicy.Evaluate(account, receiptStore.ledger.AccountTransactions(account), at)
+		receipt.Tier = tier.Name
+		receipt.TierMultiplier = tier.Multiplier
+	}
+	receipt.HistoryBonuses = receiptStore.historyBonuses(receiptID, receipt)
+	receipt.Points = loyalty.ApplyMultiplier(receipt.BasePoints, receipt.TierMultiplier) + loyalty.TotalBonus(receipt.HistoryBonuses)
+}
+
+/*
+*
+Helper function to pick the account a receipt's points are posted to. Points of receipts under review are held
+until the review releases or voids them.
+*
+*/
+func pointsAccount(receipt *models.Receipt) string {
+	if receipt.Status == models.ReceiptPendingReview {
+		return ledger.HeldAccount(receipt.UserID)
+	}
+	return ledger.UserAccount(receipt.UserID)
+}
+
+/*
+*
+Helper function to send the response to a processed receipt: its ID and status, and from version 2 on its points.
+*
+*/
+func sendReceiptResponse(w http.ResponseWriter, receiptID string, receipt *models.Receipt) error {
+	var response interface{} = models.ReceiptResponse{Id: receiptID, Status: receipt.Status}
+	if apiVersionOf(w) >= apiVersion2 {
+		response = models.ReceiptResponseV2{ID: receiptID, Status: receiptStatusV2(receipt.Status), Points: receipt.Points, Flags: receipt.Flags}
+	}
 	data, err := json.Marshal(response)
 	if err != nil {
 		log.Println("Error marshaling receipt response")
@@ -249,3 +542,16 @@ func sendReceiptResponse(w http.ResponseWriter, receiptID string) error {
 	writeJSONResponse(w, http.StatusOK, data)
 	return nil
 }
+
+/*
+*
+Helper function to name a receipt's status for version 2, which calls accepted receipts accepted rather than
+leaving their status empty.
+*
+*/
+func receiptStatusV2(status string) string {
+	if status == "" {
+		return models.ReceiptAccepted
+	}
+	return status
+}
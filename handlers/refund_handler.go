@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+/**
+* @api {post} /refunds/process Process Refund
+* @apiDescription This endpoint processes a return against a stored receipt and claws back the points it no longer earns.
+* Only the receipt's owner or the operator may refund it.
+**/
+func (receiptStore *ReceiptStore) ProcessRefund(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	refund, err := checkRefundValidity(r)
+	if err != nil {
+		handleErr(w, err, "ProcessRefund validation error", http.StatusBadRequest)
+		return
+	}
+	//Refunding takes points from the receipt's owner, so knowing the receipt ID is not enough
+	if _, receipt, found := receiptStore.lookupReceipt(refund.OriginalReceiptID); found && !receiptStore.authorizeReceipt(w, r, receipt, "ProcessRefund") {
+		return
+	}
+
+	response, err := receiptStore.applyRefund(refund)
+	switch {
+	case errors.Is(err, errReceiptNotFound):
+		handleErr(w, err, "ProcessRefund: Receipt not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errRefundExceedsPurchase):
+		handleErr(w, err, "ProcessRefund: Refund exceeds purchase", http.StatusConflict)
+		return
+	case err != nil:
+		handleErr(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendRefundResponse(w, response); err != nil {
+		handleErr(w, err, "Error marshaling refund response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+var (
+	errReceiptNotFound       = errors.New("receipt not found")
+	errRefundExceedsPurchase = errors.New("refund exceeds purchased items")
+	errDuplicateReceipt      = errors.New("duplicate receipt")
+)
+
+/*
+*
+This function checks the validity of the refund data in the request body.
+*
+*/
+func checkRefundValidity(r *http.Request) (*models.RefundReceipt, error) {
+	var parsedRefund models.RefundReceipt
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkRefundValidity: reading body failed")
+	}
+
+	if err := json.Unmarshal(requestBody, &parsedRefund); err != nil {
+		return nil, errors.Wrap(err, "checkRefundValidity: unmarshaling failed")
+	}
+
+	parsedRefund.OriginalReceiptID = strings.TrimSpace(parsedRefund.OriginalReceiptID)
+	if parsedRefund.OriginalReceiptID == "" {
+		return nil, errors.New("checkRefundValidity: original receipt ID validation failed")
+	}
+
+	for _, item := range parsedRefund.Items {
+		if !priceRegex.MatchString(item.Price) {
+			return nil, errors.New("checkRefundValidity: price validation failed")
+		}
+		if !descriptionRegex.MatchString(item.ShortDescription) {
+			return nil, errors.New("checkRefundValidity: description validation failed")
+		}
+	}
+
+	return &parsedRefund, nil
+}
+
+/*
+*
+This function applies a refund to the original receipt. The points are recomputed as if the returned items had
+never been bought and the difference is clawed back through a negative ledger entry. A refund never awards points.
+*
+*/
+func (receiptStore *ReceiptStore) applyRefund(refund *models.RefundReceipt) (models.RefundResponse, error) {
+	receiptID, receipt, ownerLock, found := receiptStore.lockReceipt(refund.OriginalReceiptID)
+	if !found {
+		return models.RefundResponse{}, errors.Wrap(errReceiptNotFound, "applyRefund")
+	}
+	defer ownerLock.Unlock()
+
+	receiptStore.lock.RLock()
+	alreadyRefunded := receiptStore.refundedItems[receiptID]
+	receiptStore.lock.RUnlock()
+	stillHeld, err := subtractItems(receipt.Items, alreadyRefunded)
+	if err != nil {
+		return models.RefundResponse{}, errors.Wrap(err, "applyRefund")
+	}
+
+	returning := refund.Items
+	if len(returning) == 0 {
+		returning = stillHeld
+	}
+	if len(returning) == 0 {
+		return models.RefundResponse{}, errors.Wrap(errRefundExceedsPurchase, "applyRefund: every item was already refunded")
+	}
+	pointsDelta, err := refundDelta(receipt, alreadyRefunded, returning)
+	if err != nil {
+		return models.RefundResponse{}, errors.Wrap(err, "applyRefund")
+	}
+
+	refundID, err := newRefundID()
+	if err != nil {
+		log.Println("Error generating refund ID")
+		return models.RefundResponse{}, fmt.Errorf("error generating refund ID")
+	}
+
+	err = receiptStore.recordEvents(events.Event{
+		Type:      events.Refunded,
+		ReceiptID: receiptID,
+		Delta:     pointsDelta,
+		Reference: refundID,
+		Items:     returning,
+	})
+	if err != nil {
+		return models.RefundResponse{}, errors.Wrap(err, "applyRefund: recording refund failed")
+	}
+
+	return models.RefundResponse{Id: refundID, PointsDelta: pointsDelta, RemainingPoints: receipt.Points + pointsDelta}, nil
+}
+
+/*
+*
+Helper function to compute the points clawed back when items are returned against a receipt that already had the
+given items refunded. The result is never positive.
+*
+*/
+func refundDelta(receipt models.Receipt, alreadyRefunded []models.Item, returning []models.Item) (int, error) {
+	stillHeld, err := subtractItems(receipt.Items, alreadyRefunded)
+	if err != nil {
+		return 0, err
+	}
+	remaining, err := subtractItems(stillHeld, returning)
+	if err != nil {
+		return 0, err
+	}
+
+	refundedItems := append(append([]models.Item{}, alreadyRefunded...), returning...)
+	remainingPoints, err := computeRemainingPoints(receipt, remaining, refundedItems)
+	if err != nil {
+		return 0, err
+	}
+	pointsDelta := remainingPoints - receipt.Points
+	if pointsDelta > 0 {
+		pointsDelta = 0
+	}
+	return pointsDelta, nil
+}
+
+/*
+*
+Helper function to compute the points a receipt would have earned with only the remaining items on it.
+The tier multiplier and history bonuses recorded on the receipt still apply until every item is returned.
+*
+*/
+func computeRemainingPoints(receipt models.Receipt, remaining []models.Item, refunded []models.Item) (int, error) {
+	if len(remaining) == 0 {
+		return 0, nil
+	}
+
+	totalCents, err := priceToCents(receipt.Total)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range refunded {
+		itemCents, err := priceToCents(item.Price)
+		if err != nil {
+			return 0, err
+		}
+		totalCents -= itemCents
+	}
+	if totalCents < 0 {
+		totalCents = 0
+	}
+
+	receipt.Items = remaining
+	receipt.Total = centsToPrice(totalCents)
+	return loyalty.ApplyMultiplier(computeReceiptPoints(&receipt), receipt.TierMultiplier) + loyalty.TotalBonus(receipt.HistoryBonuses), nil
+}
+
+/*
+*
+Helper function to remove returned items from a list of purchased items. Items are matched on their trimmed short
+description and price, and an error is returned if more of an item is returned than was purchased.
+*
+*/
+func subtractItems(purchased []models.Item, returned []models.Item) ([]models.Item, error) {
+	returnCounts := make(map[string]int)
+	for _, item := range returned {
+		returnCounts[itemKey(item)]++
+	}
+
+	var remaining []models.Item
+	for _, item := range purchased {
+		key := itemKey(item)
+		if returnCounts[key] > 0 {
+			returnCounts[key]--
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+
+	for key, count := range returnCounts {
+		if count > 0 {
+			return nil, errors.Wrapf(errRefundExceedsPurchase, "%d too many of %q", count, strings.SplitN(key, "\x00", 2)[0])
+		}
+	}
+	return remaining, nil
+}
+
+/*
+*
+Helper function to build the key used to match a returned item with a purchased item.
+*
+*/
+func itemKey(item models.Item) string {
+	return strings.TrimSpace(item.ShortDescription) + "\x00" + item.Price
+}
+
+/*
+*
+Helper function to convert a price such as "6.49" to cents. We know from the regex that the price is in the correct format.
+*
+*/
+func priceToCents(price string) (int64, error) {
+	cents, err := strconv.ParseInt(strings.ReplaceAll(price, ".", ""), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "priceToCents: parsing %q failed", price)
+	}
+	return cents, nil
+}
+
+/*
+*
+Helper function to convert cents back to a price string such as "6.49".
+*
+*/
+func centsToPrice(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+/*
+*
+Helper function to generate a random identifier for a refund. Unlike receipts, two identical refunds are both valid.
+*
+*/
+func newRefundID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func sendRefundResponse(w http.ResponseWriter, response models.RefundResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshaling refund response")
+		return err
+	}
+	writeJSONResponse(w, http.StatusOK, data)
+	return nil
+}
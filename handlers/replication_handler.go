@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {get} /admin/replication/stream Stream Write Log
+* @apiDescription This operator endpoint streams the write log after the "since" sequence number as NDJSON and keeps
+* the connection open, sending new entries as they are written and a heartbeat every second. Followers tail it.
+**/
+func (receiptStore *ReceiptStore) StreamReplication(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if receiptStore.replicationLog == nil {
+		handleErr(w, errReplicationDisabled, "StreamReplication", http.StatusNotFound)
+		return
+	}
+	since := uint64(0)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			handleErr(w, err, "StreamReplication: invalid since", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleErr(w, nil, "StreamReplication: streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if err := receiptStore.replicationLog.Stream(r.Context(), w, flusher.Flush, since, replicationHeartbeat); err != nil {
+		// The stream has started, so the follower notices the break and reconnects.
+		log.Printf("StreamReplication: stream ended: %v", err)
+	}
+}
+
+/**
+* @api {get} /admin/replication/status Fetch Replication Status
+* @apiDescription This operator endpoint reports the instance's replication role and, on a follower, how many
+* entries and seconds it is behind its leader.
+**/
+func (receiptStore *ReceiptStore) FetchReplicationStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.replicationStatus()); err != nil {
+		handleErr(w, err, "Error marshaling replication status response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /admin/replication/promote Promote Follower
+* @apiDescription This operator endpoint stops a follower tailing its leader and makes it accept writes. Promoting
+* a leader has no effect.
+**/
+func (receiptStore *ReceiptStore) PromoteReplica(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := receiptStore.promote(); err != nil {
+		handleErr(w, err, "Error promoting replica", http.StatusInternalServerError)
+		return
+	}
+	if err := sendJSON(w, receiptStore.replicationStatus()); err != nil {
+		handleErr(w, err, "Error marshaling replication status response", http.StatusInternalServerError)
+	}
+}
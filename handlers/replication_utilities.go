@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/replication"
+)
+
+// replicationHeartbeat is how often an idle replication stream tells followers the leader's position.
+const replicationHeartbeat = time.Second
+
+var errReplicationDisabled = errors.New("replication is disabled")
+
+/*
+*
+StartReplication starts tailing the leader when the store is a follower, until the context is cancelled or the
+store is promoted. It does nothing on a leader.
+*
+*/
+func (receiptStore *ReceiptStore) StartReplication(ctx context.Context) {
+	receiptStore.replicationLock.Lock()
+	defer receiptStore.replicationLock.Unlock()
+	if !receiptStore.following.Load() || receiptStore.follower != nil {
+		return
+	}
+	receiptStore.follower = replication.NewFollower(receiptStore.leaderURL, receiptStore.leaderToken, receiptStore, receiptStore.clock)
+	receiptStore.follower.Start(ctx)
+}
+
+/*
+*
+This function turns a follower into a leader. It stops tailing first, so no leader entry is applied after the store
+starts accepting writes of its own, then records the state it copied in its event log, so the store restores it after
+a restart. When that fails the store stays a follower, no longer tailing, and promoting it can be retried. Promoting
+a leader is a no-op.
+*
+*/
+func (receiptStore *ReceiptStore) promote() error {
+	receiptStore.replicationLock.Lock()
+	defer receiptStore.replicationLock.Unlock()
+	if !receiptStore.following.Load() {
+		return nil
+	}
+	if receiptStore.follower != nil {
+		receiptStore.follower.Stop()
+	}
+	if err := receiptStore.recordCopiedState(); err != nil {
+		return errors.Wrap(err, "promote")
+	}
+	receiptStore.following.Store(false)
+	return nil
+}
+
+/*
+*
+This function records the state a follower copied from its leader as events, after a Reset event that drops whatever
+the event log held before, e.g. from when the store last led. Every ledger transaction becomes a Posted event, in
+order, so replaying them numbers them as the leader did and as the audit log holds them. Receipts are recorded in
+their copied state and the catalog as the changes that rebuild it.
+*
+*/
+func (receiptStore *ReceiptStore) recordCopiedState() error {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	copied := []events.Event{{Type: events.Reset}}
+	for _, transaction := range receiptStore.ledger.Transactions(func(ledger.Transaction) bool { return true }) {
+		copied = append(copied, events.Event{Type: events.Posted, Transaction: &transaction})
+	}
+	var receiptIDs []string
+	stored := make(map[string]models.Receipt)
+	receiptStore.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		receiptIDs = append(receiptIDs, receiptID)
+		stored[receiptID] = receipt
+	})
+	sort.Strings(receiptIDs)
+	receiptStore.lock.RLock()
+	for _, receiptID := range receiptIDs {
+		receipt := stored[receiptID]
+		//A receipt pending review is queued again from the time it was first queued
+		copied = append(copied, events.Event{
+			Type:      events.Copied,
+			ReceiptID: receiptID,
+			At:        receiptStore.reviewQueue[receiptID],
+			Receipt:   &receipt,
+			Items:     receiptStore.refundedItems[receiptID],
+		})
+	}
+	receiptStore.lock.RUnlock()
+	for _, change := range receiptStore.catalog.Changes() {
+		copied = append(copied, catalogEvent(change))
+	}
+	return errors.Wrap(receiptStore.recordApplied(copied...), "recordCopiedState")
+}
+
+/*
+*
+This function describes the store's replication role and, on a follower, its lag behind the leader.
+*
+*/
+func (receiptStore *ReceiptStore) replicationStatus() models.ReplicationStatusResponse {
+	receiptStore.replicationLock.Lock()
+	defer receiptStore.replicationLock.Unlock()
+
+	response := models.ReplicationStatusResponse{Role: models.ReplicationRoleLeader}
+	if receiptStore.replicationLog != nil {
+		response.Epoch = receiptStore.replicationLog.Epoch()
+		response.Seq = receiptStore.replicationLog.LastSeq()
+	}
+	if !receiptStore.following.Load() {
+		return response
+	}
+
+	response.Role = models.ReplicationRoleFollower
+	response.LeaderURL = receiptStore.leaderURL
+	if receiptStore.follower == nil {
+		return response
+	}
+	status := receiptStore.follower.Status()
+	response.LeaderEpoch = status.LeaderEpoch
+	response.AppliedSeq = status.AppliedSeq
+	response.LeaderSeq = status.LeaderSeq
+	if status.LeaderSeq > status.AppliedSeq {
+		response.LagEntries = status.LeaderSeq - status.AppliedSeq
+	}
+	response.LagSeconds = status.Lag.Seconds()
+	response.Connected = status.Connected
+	if !status.LastContact.IsZero() {
+		response.LastContact = status.LastContact.Format(time.RFC3339)
+	}
+	return response
+}
+
+/*
+*
+This function records the state of a receipt after a write, so followers see it. The caller must hold the
+receipt's owner lock, which keeps the entries of one receipt in the order they were written.
+*
+*/
+func (receiptStore *ReceiptStore) logReceipt(receiptID string, receipt models.Receipt, refundedItems []models.Item) {
+	receiptStore.logEntry(replication.Entry{
+		Kind:    replication.KindReceipt,
+		Receipt: &replication.ReceiptState{ID: receiptID, Receipt: receipt, RefundedItems: append([]models.Item(nil), refundedItems...)},
+	})
+}
+
+/*
+*
+Helper function to append an entry to the write log. A write that cannot be logged has already been applied, so
+the failure is logged and the store's followers fall behind until they resynchronize.
+*
+*/
+func (receiptStore *ReceiptStore) logEntry(entry replication.Entry) {
+	if receiptStore.replicationLog == nil {
+		return
+	}
+	if _, err := receiptStore.replicationLog.Append(entry); err != nil {
+		log.Printf("Error logging %s write for replication: %v", entry.Kind, err)
+	}
+}
+
+/*
+*
+Helper function to record the stored state of a receipt. The caller must hold the receipt's owner lock but not the
+store lock.
+*
+*/
+func (receiptStore *ReceiptStore) logStoredReceipt(receiptID string) {
+	if receiptStore.replicationLog == nil {
+		return
+	}
+	receipt, found := receiptStore.receipts.get(receiptID)
+	if !found {
+		return
+	}
+	receiptStore.lock.RLock()
+	refundedItems := receiptStore.refundedItems[receiptID]
+	receiptStore.lock.RUnlock()
+	receiptStore.logReceipt(receiptID, receipt, refundedItems)
+}
+
+/*
+*
+ApplyEntry applies one entry of the leader's write log. Transactions are copied into the ledger with their IDs,
+rewards and redemptions into the catalog, and receipts are upserted together with the indexes derived from them.
+Applying an entry records it in the follower's own log as well, and audits the transactions it copies.
+*
+*/
+func (receiptStore *ReceiptStore) ApplyEntry(entry replication.Entry) error {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+	defer receiptStore.settleAudit(true)
+
+	switch {
+	case entry.Kind == replication.KindTransaction && entry.Transaction != nil:
+		return errors.Wrap(receiptStore.ledger.Replicate(*entry.Transaction), "ApplyEntry")
+	case entry.Kind == replication.KindCatalog && entry.Catalog != nil:
+		receiptStore.catalog.Apply(*entry.Catalog)
+		return nil
+	case entry.Kind == replication.KindReceipt && entry.Receipt != nil:
+		return errors.Wrap(receiptStore.applyReceiptState(*entry.Receipt, entry.At), "ApplyEntry")
+	default:
+		return errors.Errorf("ApplyEntry: malformed %q entry %d", entry.Kind, entry.Seq)
+	}
+}
+
+/*
+*
+ResetReplica empties a follower before it copies a leader that started a new epoch: its receipts and their indexes,
+its ledger, its catalog and its own write log, whose followers start over in turn. The ledger starts over from its
+first transaction, so the audit log starts a new chain.
+*
+*/
+func (receiptStore *ReceiptStore) ResetReplica() error {
+	if err := receiptStore.resetStore(); err != nil {
+		return errors.Wrap(err, "ResetReplica")
+	}
+	if err := receiptStore.restartAuditChain(); err != nil {
+		return errors.Wrap(err, "ResetReplica")
+	}
+	return errors.Wrap(receiptStore.replicationLog.Reset(), "ResetReplica")
+}
+
+/*
+*
+This function empties the store: its receipts and their indexes, its ledger and its catalog.
+*
+*/
+func (receiptStore *ReceiptStore) resetStore() error {
+	stored := make(map[string]models.Receipt)
+	receiptStore.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		stored[receiptID] = receipt
+	})
+	for receiptID, receipt := range stored {
+		if err := receiptStore.receipts.erase(receiptID); err != nil {
+			return errors.Wrap(err, "resetStore")
+		}
+		if err := receiptStore.unindexReceipt(receiptID, receipt); err != nil {
+			return errors.Wrap(err, "resetStore")
+		}
+	}
+	receiptStore.ledger.Reset()
+	receiptStore.catalog.Reset()
+	return nil
+}
+
+/*
+*
+This function upserts a replicated receipt and brings the indexes over it in line with its new state.
+*
+*/
+func (receiptStore *ReceiptStore) applyReceiptState(state replication.ReceiptState, writtenAt time.Time) error {
+	receipt := state.Receipt
+	legacyID, err := receiptid.LegacyID(receipt)
+	if err != nil {
+		return errors.Wrap(err, "applyReceiptState")
+	}
+	contentID := receiptid.ContentID(receipt)
+
+	ownerLock := receiptStore.ownerLocks.forOwner(receipt.UserID, state.ID)
+	ownerLock.Lock()
+	defer ownerLock.Unlock()
+
+	previous, existed := receiptStore.receipts.get(state.ID)
+	receiptStore.receipts.put(state.ID, receipt)
+
+	receiptStore.lock.Lock()
+	if _, exists := receiptStore.contentIndex[contentID]; !exists {
+		receiptStore.contentIndex[contentID] = state.ID
+	}
+	receiptStore.aliasLegacyID(legacyID, state.ID)
+	if existed {
+		receiptStore.duplicates.Remove(state.ID, previous)
+	}
+	receiptStore.duplicates.Add(state.ID, receipt)
+	if len(state.RefundedItems) > 0 {
+		receiptStore.refundedItems[state.ID] = state.RefundedItems
+	} else {
+		delete(receiptStore.refundedItems, state.ID)
+	}
+	if receipt.Status == models.ReceiptPendingReview {
+		if _, queued := receiptStore.reviewQueue[state.ID]; !queued {
+			receiptStore.reviewQueue[state.ID] = writtenAt
+		}
+	} else {
+		delete(receiptStore.reviewQueue, state.ID)
+	}
+	if receipt.UserID != "" {
+		receiptStore.removeUserReceipt(receipt.UserID, state.ID)
+		if receipt.Status != models.ReceiptRejected {
+			receiptStore.userReceipts[receipt.UserID] = append(receiptStore.userReceipts[receipt.UserID], state.ID)
+		}
+	}
+	receiptStore.lock.Unlock()
+
+	receiptStore.logReceipt(state.ID, receipt, state.RefundedItems)
+	return nil
+}
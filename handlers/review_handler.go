@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+/**
+* @api {get} /admin/reviews List Reviews
+* @apiDescription This operator endpoint lists the receipts whose points are held for manual review.
+**/
+func (receiptStore *ReceiptStore) ListReviews(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.pendingReviews()); err != nil {
+		handleErr(w, err, "Error marshaling reviews response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /admin/reviews/:id/approve Approve Review
+* @apiDescription This operator endpoint approves a held receipt and releases its points to the user.
+**/
+func (receiptStore *ReceiptStore) ApproveReview(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	receiptStore.handleReviewDecision(w, params, true)
+}
+
+/**
+* @api {post} /admin/reviews/:id/reject Reject Review
+* @apiDescription This operator endpoint rejects a held receipt and voids its points.
+**/
+func (receiptStore *ReceiptStore) RejectReview(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	receiptStore.handleReviewDecision(w, params, false)
+}
+
+/*
+*
+Helper function shared by the approve and reject endpoints.
+*
+*/
+func (receiptStore *ReceiptStore) handleReviewDecision(w http.ResponseWriter, params httprouter.Params, approve bool) {
+	receiptID := strings.TrimSpace(params.ByName("id"))
+	if receiptID == "" {
+		handleErr(w, nil, "Review: No receipt ID provided", http.StatusBadRequest)
+		return
+	}
+
+	response, err := receiptStore.decideReview(receiptID, approve)
+	switch {
+	case errors.Is(err, errReceiptNotFound):
+		handleErr(w, err, "Review: Receipt not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNotPendingReview):
+		handleErr(w, err, "Review: Receipt is not pending review", http.StatusConflict)
+		return
+	case err != nil:
+		handleErr(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendJSON(w, response); err != nil {
+		handleErr(w, err, "Error marshaling review response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+var errNotPendingReview = errors.New("receipt is not pending review")
+
+// Review decisions reported by the review endpoints.
+const (
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+/*
+*
+This function runs the risk pipeline over a receipt and flags it for review when it is too risky. It only changes
+the receipt it is given, so it needs no lock; the pipeline's signals guard their own state.
+*
+*/
+func (receiptStore *ReceiptStore) assessRisk(receipt *models.Receipt) {
+	receipt.RiskScore = 0
+	if receiptStore.risk == nil {
+		return
+	}
+
+	assessment := receiptStore.risk.Assess(*receipt)
+	receipt.RiskScore = assessment.Score
+	if !receiptStore.risk.ShouldHold(assessment) {
+		return
+	}
+	receipt.Status = models.ReceiptPendingReview
+	for _, finding := range assessment.Findings {
+		receipt.Flags = append(receipt.Flags, "risk:"+finding.Signal)
+	}
+}
+
+/*
+*
+This function lists the receipts waiting for review, oldest first.
+*
+*/
+func (receiptStore *ReceiptStore) pendingReviews() []models.ReviewItem {
+	receiptStore.lock.RLock()
+	queue := make(map[string]time.Time, len(receiptStore.reviewQueue))
+	for receiptID, submittedAt := range receiptStore.reviewQueue {
+		queue[receiptID] = submittedAt
+	}
+	receiptStore.lock.RUnlock()
+
+	reviews := make([]models.ReviewItem, 0, len(queue))
+	for receiptID, submittedAt := range queue {
+		receipt, _ := receiptStore.receipts.get(receiptID)
+		reviews = append(reviews, models.ReviewItem{
+			ReceiptID:   receiptID,
+			HeldPoints:  receiptStore.ledger.ReceiptBalance(receiptID, ledger.HeldAccount(receipt.UserID)),
+			Receipt:     receipt,
+			SubmittedAt: submittedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		if reviews[i].SubmittedAt != reviews[j].SubmittedAt {
+			return reviews[i].SubmittedAt < reviews[j].SubmittedAt
+		}
+		return reviews[i].ReceiptID < reviews[j].ReceiptID
+	})
+	return reviews
+}
+
+/*
+*
+This function settles a held receipt by recording a Validated event for an approval or a Voided event for a
+rejection. Approving releases its held points to the user; rejecting voids them and removes the receipt from the
+user's history.
+*
+*/
+func (receiptStore *ReceiptStore) decideReview(receiptID string, approve bool) (models.ReviewDecisionResponse, error) {
+	receiptID, receipt, ownerLock, found := receiptStore.lockReceipt(receiptID)
+	if !found {
+		return models.ReviewDecisionResponse{}, errors.Wrap(errReceiptNotFound, "decideReview")
+	}
+	defer ownerLock.Unlock()
+	if receipt.Status != models.ReceiptPendingReview {
+		return models.ReviewDecisionResponse{}, errors.Wrapf(errNotPendingReview, "decideReview: %s", receiptID)
+	}
+
+	held := receiptStore.ledger.ReceiptBalance(receiptID, ledger.HeldAccount(receipt.UserID))
+	event := events.Event{Type: events.Validated, ReceiptID: receiptID, Reason: events.ReasonReviewApproved}
+	decision := reviewApproved
+	if !approve {
+		event = events.Event{Type: events.Voided, ReceiptID: receiptID, Reason: events.ReasonReviewRejected}
+		decision = reviewRejected
+	}
+	if err := receiptStore.recordEvents(event); err != nil {
+		return models.ReviewDecisionResponse{}, errors.Wrap(err, "decideReview")
+	}
+
+	return models.ReviewDecisionResponse{ReceiptID: receiptID, Status: decision, Points: held}, nil
+}
+
+/*
+*
+Helper function to drop a receipt from a user's history. The caller must hold the lock.
+*
+*/
+func (receiptStore *ReceiptStore) removeUserReceipt(userID string, receiptID string) {
+	receiptIDs := receiptStore.userReceipts[userID]
+	for i, storedID := range receiptIDs {
+		if storedID == receiptID {
+			receiptStore.userReceipts[userID] = append(receiptIDs[:i:i], receiptIDs[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+/**
+* @api {get} /rewards List Rewards
+* @apiDescription This endpoint lists the rewards that are currently available and in stock.
+**/
+func (receiptStore *ReceiptStore) ListRewards(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.catalog.Rewards()); err != nil {
+		handleErr(w, err, "Error marshaling rewards response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {put} /rewards/:id Put Reward
+* @apiDescription This operator endpoint adds a reward to the catalog or replaces an existing one.
+**/
+func (receiptStore *ReceiptStore) PutReward(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	reward, err := checkRewardValidity(r, params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "PutReward validation error", http.StatusBadRequest)
+		return
+	}
+
+	if err := receiptStore.putReward(*reward); err != nil {
+		handleErr(w, err, "PutReward error", http.StatusInternalServerError)
+		return
+	}
+	if err := sendJSON(w, reward); err != nil {
+		handleErr(w, err, "Error marshaling reward response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /redemptions Redeem Reward
+* @apiDescription This endpoint spends a user's points on a reward and returns a redemption code. Only the user, with
+* their bearer token, or the operator may spend them. Retries that send the same Idempotency-Key header return the
+* original redemption.
+**/
+func (receiptStore *ReceiptStore) RedeemReward(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	request, err := checkRedemptionValidity(r)
+	if err != nil {
+		handleErr(w, err, "RedeemReward validation error", http.StatusBadRequest)
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, request.UserID, "RedeemReward") {
+		return
+	}
+
+	redemption, err := receiptStore.redeem(*request, strings.TrimSpace(r.Header.Get("Idempotency-Key")))
+	if err != nil {
+		handleErr(w, err, "RedeemReward error", redemptionErrorStatus(err))
+		return
+	}
+
+	if err := sendJSON(w, redemption); err != nil {
+		handleErr(w, err, "Error marshaling redemption response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {get} /redemptions/:id Fetch Redemption
+* @apiDescription This endpoint fetches a redemption by ID, for the user who made it or the operator.
+**/
+func (receiptStore *ReceiptStore) FetchRedemption(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	redemption, err := receiptStore.catalog.Redemption(strings.TrimSpace(params.ByName("id")))
+	if err != nil {
+		handleErr(w, err, "FetchRedemption error", redemptionErrorStatus(err))
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, redemption.UserID, "FetchRedemption") {
+		return
+	}
+
+	if err := sendJSON(w, redemption); err != nil {
+		handleErr(w, err, "Error marshaling redemption response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /redemptions/:id/cancel Cancel Redemption
+* @apiDescription This endpoint cancels a redemption, re-crediting its points and restoring the reward's inventory.
+* Only the user who made it or the operator may cancel it.
+**/
+func (receiptStore *ReceiptStore) CancelRedemption(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	redemptionID := strings.TrimSpace(params.ByName("id"))
+	existing, err := receiptStore.catalog.Redemption(redemptionID)
+	if err != nil {
+		handleErr(w, err, "CancelRedemption error", redemptionErrorStatus(err))
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, existing.UserID, "CancelRedemption") {
+		return
+	}
+	redemption, err := receiptStore.cancelRedemption(redemptionID)
+	if err != nil {
+		handleErr(w, err, "CancelRedemption error", redemptionErrorStatus(err))
+		return
+	}
+
+	if err := sendJSON(w, redemption); err != nil {
+		handleErr(w, err, "Error marshaling redemption response", http.StatusInternalServerError)
+	}
+}
+
+/*
+*
+Helper function to map catalog and ledger errors to HTTP status codes.
+*
+*/
+func redemptionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, rewards.ErrRewardNotFound), errors.Is(err, rewards.ErrRedemptionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, rewards.ErrRewardUnavailable), errors.Is(err, rewards.ErrIdempotencyConflict),
+		errors.Is(err, ledger.ErrInsufficientBalance):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
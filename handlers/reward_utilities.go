@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/*
+*
+This function checks the validity of the reward data in the request body. The reward ID comes from the path.
+*
+*/
+func checkRewardValidity(r *http.Request, rewardID string) (*models.Reward, error) {
+	var parsedReward models.Reward
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkRewardValidity: reading body failed")
+	}
+
+	if err := json.Unmarshal(requestBody, &parsedReward); err != nil {
+		return nil, errors.Wrap(err, "checkRewardValidity: unmarshaling failed")
+	}
+
+	parsedReward.Id = strings.TrimSpace(rewardID)
+	parsedReward.Name = strings.TrimSpace(parsedReward.Name)
+	switch {
+	case !userIDRegex.MatchString(parsedReward.Id):
+		return nil, errors.New("checkRewardValidity: reward ID validation failed")
+	case parsedReward.Name == "":
+		return nil, errors.New("checkRewardValidity: name validation failed")
+	case parsedReward.Cost <= 0:
+		return nil, errors.New("checkRewardValidity: cost validation failed")
+	case parsedReward.Inventory < 0:
+		return nil, errors.New("checkRewardValidity: inventory validation failed")
+	case parsedReward.AvailableFrom != nil && parsedReward.AvailableUntil != nil &&
+		!parsedReward.AvailableFrom.Before(*parsedReward.AvailableUntil):
+		return nil, errors.New("checkRewardValidity: availability window validation failed")
+	}
+
+	return &parsedReward, nil
+}
+
+/*
+*
+This function checks the validity of the redemption data in the request body.
+*
+*/
+func checkRedemptionValidity(r *http.Request) (*models.RedemptionRequest, error) {
+	var parsedRequest models.RedemptionRequest
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkRedemptionValidity: reading body failed")
+	}
+
+	if err := json.Unmarshal(requestBody, &parsedRequest); err != nil {
+		return nil, errors.Wrap(err, "checkRedemptionValidity: unmarshaling failed")
+	}
+
+	if !userIDRegex.MatchString(parsedRequest.UserID) {
+		return nil, errors.New("checkRedemptionValidity: user ID validation failed")
+	}
+	if strings.TrimSpace(parsedRequest.RewardID) == "" {
+		return nil, errors.New("checkRedemptionValidity: reward ID validation failed")
+	}
+
+	return &parsedRequest, nil
+}
+
+/*
+*
+This function adds a reward to the catalog, or replaces one, and records the change in the event log.
+*
+*/
+func (receiptStore *ReceiptStore) putReward(reward models.Reward) error {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	receiptStore.catalog.PutReward(reward)
+	return errors.Wrap(receiptStore.recordCatalogChanges(), "putReward")
+}
+
+/*
+*
+This function spends a user's points on a reward and records the redemption in the event log.
+*
+*/
+func (receiptStore *ReceiptStore) redeem(request models.RedemptionRequest, idempotencyKey string) (models.Redemption, error) {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	redemption, err := receiptStore.catalog.Redeem(request, idempotencyKey)
+	if recordErr := receiptStore.recordCatalogChanges(); recordErr != nil {
+		return models.Redemption{}, errors.Wrap(recordErr, "redeem")
+	}
+	return redemption, err
+}
+
+/*
+*
+This function cancels a redemption and records the cancellation in the event log.
+*
+*/
+func (receiptStore *ReceiptStore) cancelRedemption(redemptionID string) (models.Redemption, error) {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	redemption, err := receiptStore.catalog.Cancel(redemptionID)
+	if recordErr := receiptStore.recordCatalogChanges(); recordErr != nil {
+		return models.Redemption{}, errors.Wrap(recordErr, "cancelRedemption")
+	}
+	return redemption, err
+}
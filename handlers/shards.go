@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"container/list"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/storage"
+)
+
+// receiptShardCount is how many independently locked partitions the receipts are spread over.
+const receiptShardCount = 64
+
+// MemoryLimits bounds the receipts held in memory. Zero values leave that dimension unbounded.
+type MemoryLimits struct {
+	// MaxEntries and MaxBytes bound the receipts in memory across all the shards together.
+	MaxEntries int
+	MaxBytes   int64
+	// TTL evicts receipts that have not been read or written for that long.
+	TTL time.Duration
+}
+
+// bounded reports whether any limit is set.
+func (limits MemoryLimits) bounded() bool {
+	return limits.MaxEntries > 0 || limits.MaxBytes > 0 || limits.TTL > 0
+}
+
+/*
+shardEntry is a receipt held in memory and the bookkeeping needed to evict it.
+*/
+type shardEntry struct {
+	receiptID  string
+	receipt    models.Receipt
+	size       int64
+	lastAccess time.Time
+}
+
+/*
+receiptShard is one partition of the stored receipts, guarded by its own lock so readers and writers of different
+partitions never wait on each other. recency orders the entries from most to least recently used. spilling holds
+the evicted entries that are being written to the spill backend, which happens outside the lock, so they can still
+be read meanwhile. spillLock orders those writes, so an older version of a receipt never overwrites a newer one.
+*/
+type receiptShard struct {
+	entries   map[string]*list.Element
+	recency   *list.List
+	spilling  map[string]*shardEntry
+	lock      sync.RWMutex
+	spillLock sync.Mutex
+}
+
+/*
+evictionCounters count what happened to receipts that left memory, for capacity planning.
+*/
+type evictionCounters struct {
+	capacity atomic.Int64
+	expired  atomic.Int64
+	spilled  atomic.Int64
+	dropped  atomic.Int64
+	reloaded atomic.Int64
+}
+
+/*
+shardHooks tell the owner of the shards about receipts that leave or re-enter memory, outside the shard locks, so
+the indexes over them can follow. dropped is told about every receipt evicted without being spilled, spilled about
+every receipt written to the spill backend and reloaded about every receipt read back from it. Calls for one shard
+are serialized, so a receipt's spill and reload are never reported out of order. Any hook may be nil.
+*/
+type shardHooks struct {
+	dropped  func(receiptID string, receipt models.Receipt)
+	spilled  func(receiptID string, receipt models.Receipt)
+	reloaded func(receiptID string, receipt models.Receipt)
+}
+
+/*
+receiptShards spreads receipts over partitions by a hash of their ID. When limits are set, the least recently used
+receipts across all the shards are evicted until the receipts in memory fit them; evicted receipts are spilled to
+the backend and reloaded on demand, or dropped when there is no backend. entries and bytes total the receipts in
+memory over every shard.
+*/
+type receiptShards struct {
+	shards   [receiptShardCount]*receiptShard
+	limits   MemoryLimits
+	spill    storage.Backend
+	hooks    shardHooks
+	clock    clock.Clock
+	counters evictionCounters
+	entries  atomic.Int64
+	bytes    atomic.Int64
+}
+
+func newReceiptShards(limits MemoryLimits, spill storage.Backend, hooks shardHooks, c clock.Clock) *receiptShards {
+	shards := &receiptShards{limits: limits, spill: spill, hooks: hooks, clock: c}
+	for i := range shards.shards {
+		shards.shards[i] = &receiptShard{entries: make(map[string]*list.Element), recency: list.New(), spilling: make(map[string]*shardEntry)}
+	}
+	return shards
+}
+
+/*
+*
+Helper function to pick the partition that holds a receipt ID.
+*
+*/
+func (s *receiptShards) shardFor(receiptID string) *receiptShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(receiptID))
+	return s.shards[hash.Sum32()%receiptShardCount]
+}
+
+/*
+*
+This function returns a copy of a stored receipt. Unbounded shards only take their read lock; bounded shards take
+the write lock to record the access. A receipt that was spilled is reloaded from the backend, outside the lock.
+*
+*/
+func (s *receiptShards) get(receiptID string) (models.Receipt, bool) {
+	shard := s.shardFor(receiptID)
+	if !s.limits.bounded() {
+		shard.lock.RLock()
+		defer shard.lock.RUnlock()
+		if element, found := shard.entries[receiptID]; found {
+			return element.Value.(*shardEntry).receipt, true
+		}
+		return models.Receipt{}, false
+	}
+
+	shard.lock.Lock()
+	now := s.clock.Now()
+	evicted := s.evictExpired(shard, now)
+	receipt, found := models.Receipt{}, false
+	if element, inMemory := shard.entries[receiptID]; inMemory {
+		entry := element.Value.(*shardEntry)
+		entry.lastAccess = now
+		shard.recency.MoveToFront(element)
+		receipt, found = entry.receipt, true
+	} else if entry, inFlight := shard.spilling[receiptID]; inFlight {
+		receipt, found = entry.receipt, true
+	}
+	shard.lock.Unlock()
+	s.release(shard, evicted)
+	if found || s.spill == nil {
+		return receipt, found
+	}
+
+	//Holding the spill lock keeps the reload from being reported before an earlier spill of the receipt
+	shard.spillLock.Lock()
+	receipt, found, err := s.spill.Load(receiptID)
+	if err != nil {
+		shard.spillLock.Unlock()
+		log.Printf("receiptShards: reloading receipt %s failed: %v", receiptID, err)
+		return models.Receipt{}, false
+	}
+	if !found {
+		shard.spillLock.Unlock()
+		return models.Receipt{}, false
+	}
+
+	shard.lock.Lock()
+	//Another caller may have stored a newer version while the receipt was loading
+	element, inMemory := shard.entries[receiptID]
+	if inMemory {
+		receipt = element.Value.(*shardEntry).receipt
+	} else {
+		s.insert(shard, receiptID, receipt, now)
+	}
+	shard.lock.Unlock()
+	if !inMemory {
+		s.counters.reloaded.Add(1)
+		if s.hooks.reloaded != nil {
+			s.hooks.reloaded(receiptID, receipt)
+		}
+	}
+	shard.spillLock.Unlock()
+	s.trim(receiptID)
+	return receipt, true
+}
+
+/*
+*
+This function stores or replaces a receipt, evicting the least recently used receipts if that takes the shards over
+their limits.
+*
+*/
+func (s *receiptShards) put(receiptID string, receipt models.Receipt) {
+	shard := s.shardFor(receiptID)
+	shard.lock.Lock()
+	now := s.clock.Now()
+	if element, found := shard.entries[receiptID]; found {
+		s.remove(shard, element)
+	}
+	s.insert(shard, receiptID, receipt, now)
+	evicted := s.evictExpired(shard, now)
+	shard.lock.Unlock()
+	s.release(shard, evicted)
+	s.trim(receiptID)
+}
+
+/*
+*
+Helper function to add an entry to the front of a shard. The caller must hold the shard's write lock, and trim the
+shards once it has unlocked it.
+*
+*/
+func (s *receiptShards) insert(shard *receiptShard, receiptID string, receipt models.Receipt, now time.Time) {
+	entry := &shardEntry{receiptID: receiptID, receipt: receipt, size: receiptSize(receipt), lastAccess: now}
+	shard.entries[receiptID] = shard.recency.PushFront(entry)
+	s.entries.Add(1)
+	s.bytes.Add(entry.size)
+}
+
+/*
+*
+Helper function to report whether the receipts in memory exceed the limits.
+*
+*/
+func (s *receiptShards) overCapacity() bool {
+	if s.limits.MaxEntries > 0 && s.entries.Load() > int64(s.limits.MaxEntries) {
+		return true
+	}
+	return s.limits.MaxBytes > 0 && s.bytes.Load() > s.limits.MaxBytes
+}
+
+/*
+*
+This function evicts the least recently used receipts across all the shards until the receipts in memory fit the
+limits again. The receipt just stored or reloaded, keep, is never evicted, so a receipt larger than MaxBytes can
+still be read. Each shard is locked on its own, so the victim is checked again under its shard's lock.
+*
+*/
+func (s *receiptShards) trim(keep string) {
+	for s.overCapacity() {
+		shard, victim := s.leastRecentlyUsed(keep)
+		if victim == nil {
+			return
+		}
+		shard.lock.Lock()
+		element, found := shard.entries[victim.receiptID]
+		if !found || element.Value.(*shardEntry) != victim || !s.overCapacity() {
+			shard.lock.Unlock()
+			continue
+		}
+		evicted := s.evict(shard, element, &s.counters.capacity)
+		shard.lock.Unlock()
+		s.release(shard, []*shardEntry{evicted})
+	}
+}
+
+/*
+*
+Helper function to find the least recently used entry over all the shards, other than keep, and the shard holding
+it. It returns a nil entry when there is none.
+*
+*/
+func (s *receiptShards) leastRecentlyUsed(keep string) (*receiptShard, *shardEntry) {
+	var oldestShard *receiptShard
+	var oldest *shardEntry
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		element := shard.recency.Back()
+		if element != nil && element.Value.(*shardEntry).receiptID == keep {
+			element = element.Prev()
+		}
+		if element != nil {
+			entry := element.Value.(*shardEntry)
+			if oldest == nil || entry.lastAccess.Before(oldest.lastAccess) {
+				oldestShard, oldest = shard, entry
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	return oldestShard, oldest
+}
+
+/*
+*
+Helper function to evict the entries at the back of a shard that have been idle for longer than the TTL. It returns
+the evicted entries, which the caller must release once it has unlocked the shard. The caller must hold the shard's
+write lock.
+*
+*/
+func (s *receiptShards) evictExpired(shard *receiptShard, now time.Time) []*shardEntry {
+	if s.limits.TTL <= 0 {
+		return nil
+	}
+	var evicted []*shardEntry
+	for element := shard.recency.Back(); element != nil; element = shard.recency.Back() {
+		if now.Sub(element.Value.(*shardEntry).lastAccess) < s.limits.TTL {
+			break
+		}
+		evicted = append(evicted, s.evict(shard, element, &s.counters.expired))
+	}
+	return evicted
+}
+
+/*
+*
+Helper function to take an entry out of memory. It stays readable from the shard's spilling entries until release
+has written it to the spill backend. The caller must hold the shard's write lock.
+*
+*/
+func (s *receiptShards) evict(shard *receiptShard, element *list.Element, reason *atomic.Int64) *shardEntry {
+	entry := element.Value.(*shardEntry)
+	s.remove(shard, element)
+	reason.Add(1)
+	if s.spill != nil {
+		shard.spilling[entry.receiptID] = entry
+	}
+	return entry
+}
+
+/*
+*
+Helper function to finish evicting entries once the shard is unlocked, so the disk I/O never blocks the shard:
+each is spilled to the backend when there is one, or dropped, and the hooks are told. An entry superseded by a later
+eviction of the same receipt is not written, and a receipt stored again in the meantime is not reported.
+*
+*/
+func (s *receiptShards) release(shard *receiptShard, evicted []*shardEntry) {
+	for _, entry := range evicted {
+		shard.spillLock.Lock()
+		dropped, spilled := s.spill == nil, false
+		if !dropped {
+			shard.lock.RLock()
+			current := shard.spilling[entry.receiptID] == entry
+			shard.lock.RUnlock()
+			if current {
+				if err := s.spill.Save(entry.receiptID, entry.receipt); err != nil {
+					log.Printf("receiptShards: spilling receipt %s failed, dropping it: %v", entry.receiptID, err)
+					dropped = true
+				} else {
+					s.counters.spilled.Add(1)
+					spilled = true
+				}
+			}
+		}
+
+		shard.lock.Lock()
+		if shard.spilling[entry.receiptID] == entry {
+			delete(shard.spilling, entry.receiptID)
+		}
+		_, storedAgain := shard.entries[entry.receiptID]
+		shard.lock.Unlock()
+
+		if dropped && !storedAgain {
+			s.counters.dropped.Add(1)
+			if s.hooks.dropped != nil {
+				s.hooks.dropped(entry.receiptID, entry.receipt)
+			}
+		} else if spilled && !storedAgain && s.hooks.spilled != nil {
+			s.hooks.spilled(entry.receiptID, entry.receipt)
+		}
+		shard.spillLock.Unlock()
+	}
+}
+
+/*
+*
+Helper function to unlink an entry from a shard. The caller must hold the shard's write lock.
+*
+*/
+func (s *receiptShards) remove(shard *receiptShard, element *list.Element) {
+	entry := element.Value.(*shardEntry)
+	shard.recency.Remove(element)
+	delete(shard.entries, entry.receiptID)
+	s.entries.Add(-1)
+	s.bytes.Add(-entry.size)
+}
+
+/*
+*
+This function removes a receipt from memory and from the spill backend. A spill of the receipt that is in flight is
+abandoned.
+*
+*/
+func (s *receiptShards) erase(receiptID string) error {
+	shard := s.shardFor(receiptID)
+	shard.spillLock.Lock()
+	defer shard.spillLock.Unlock()
+
+	shard.lock.Lock()
+	if element, found := shard.entries[receiptID]; found {
+		s.remove(shard, element)
+	}
+	delete(shard.spilling, receiptID)
+	shard.lock.Unlock()
+	if s.spill == nil {
+		return nil
+	}
+	return errors.Wrap(s.spill.Delete(receiptID), "erase")
+}
+
+/*
+*
+This function evicts every receipt that has been idle for longer than the TTL, one shard at a time.
+*
+*/
+func (s *receiptShards) sweep() {
+	if s.limits.TTL <= 0 {
+		return
+	}
+	now := s.clock.Now()
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+		evicted := s.evictExpired(shard, now)
+		shard.lock.Unlock()
+		s.release(shard, evicted)
+	}
+}
+
+/*
+*
+This function calls fn for every stored receipt, one partition at a time, followed by the spilled receipts that are
+not in memory. fn must not call back into the shards.
+*
+*/
+func (s *receiptShards) forEach(fn func(receiptID string, receipt models.Receipt)) {
+	inMemory := make(map[string]bool)
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		for element := shard.recency.Front(); element != nil; element = element.Next() {
+			entry := element.Value.(*shardEntry)
+			inMemory[entry.receiptID] = true
+			fn(entry.receiptID, entry.receipt)
+		}
+		for receiptID, entry := range shard.spilling {
+			if !inMemory[receiptID] {
+				inMemory[receiptID] = true
+				fn(receiptID, entry.receipt)
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	if s.spill == nil {
+		return
+	}
+
+	err := s.spill.Each(func(receiptID string, receipt models.Receipt) error {
+		if !inMemory[receiptID] {
+			fn(receiptID, receipt)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("receiptShards: reading spilled receipts failed: %v", err)
+	}
+}
+
+/*
+*
+This function reports how many receipts are in memory and what the evictions so far did with the others.
+*
+*/
+func (s *receiptShards) stats() models.StoreStatsResponse {
+	response := models.StoreStatsResponse{
+		MaxEntries: s.limits.MaxEntries,
+		MaxBytes:   s.limits.MaxBytes,
+		Mode:       models.EvictionModeCache,
+		Evictions: models.EvictionCounts{
+			Capacity: s.counters.capacity.Load(),
+			Expired:  s.counters.expired.Load(),
+		},
+		Entries:  int(s.entries.Load()),
+		Bytes:    s.bytes.Load(),
+		Spilled:  s.counters.spilled.Load(),
+		Dropped:  s.counters.dropped.Load(),
+		Reloaded: s.counters.reloaded.Load(),
+	}
+	if s.spill != nil {
+		response.Mode = models.EvictionModeSpill
+	}
+	if s.limits.TTL > 0 {
+		response.TTL = s.limits.TTL.String()
+	}
+	return response
+}
+
+/*
+*
+Helper function to estimate the memory a receipt takes, from the lengths of its strings and a fixed overhead per
+receipt and item. It only needs to be proportionate, not exact.
+*
+*/
+func receiptSize(receipt models.Receipt) int64 {
+	const receiptOverhead, itemOverhead = 256, 48
+	size := int64(receiptOverhead + len(receipt.UserID) + len(receipt.Retailer) + len(receipt.PurchaseDate) +
+		len(receipt.PurchaseTime) + len(receipt.Total) + len(receipt.Tier) + len(receipt.Status))
+	for _, item := range receipt.Items {
+		size += int64(itemOverhead + len(item.ShortDescription) + len(item.Price))
+	}
+	for _, bonus := range receipt.HistoryBonuses {
+		size += int64(itemOverhead + len(bonus.Rule))
+	}
+	for _, flag := range receipt.Flags {
+		size += int64(len(flag))
+	}
+	return size
+}
+
+// ownerLockCount is how many stripes the per-owner locks are spread over.
+const ownerLockCount = 256
+
+/*
+ownerLocks serializes changes to the receipts of one owner, a user or a single receipt submitted without one, so
+that scoring always sees a consistent history. Owners are striped over a fixed set of mutexes, so unrelated
+owners rarely contend and no per-owner state has to be cleaned up.
+*/
+type ownerLocks struct {
+	stripes [ownerLockCount]sync.Mutex
+}
+
+/*
+*
+Helper function to return the mutex for the owner of a receipt: its user, or the receipt itself when it has none.
+*
+*/
+func (o *ownerLocks) forOwner(userID string, receiptID string) *sync.Mutex {
+	key := "receipt:" + receiptID
+	if userID != "" {
+		key = "user:" + userID
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &o.stripes[hash.Sum32()%ownerLockCount]
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {get} /admin/sinks Fetch Event Sink Status
+* @apiDescription This operator endpoint reports each event sink's position in the event log, how many events it is
+* behind and, for a failing sink, its last error.
+**/
+func (receiptStore *ReceiptStore) FetchEventSinkStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.eventSinkStatuses()); err != nil {
+		handleErr(w, err, "Error marshaling event sink status response", http.StatusInternalServerError)
+	}
+}
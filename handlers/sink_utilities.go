@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/*
+*
+StartEventSinks feeds each event sink the recorded events until the context is cancelled. Each sink first catches up
+on the events in the log after its cursor.
+*
+*/
+func (receiptStore *ReceiptStore) StartEventSinks(ctx context.Context) {
+	for _, forwarder := range receiptStore.eventSinks {
+		go forwarder.Run(ctx)
+	}
+}
+
+/*
+*
+This function reports each event sink's progress through the event log.
+*
+*/
+func (receiptStore *ReceiptStore) eventSinkStatuses() []models.EventSinkStatus {
+	statuses := []models.EventSinkStatus{}
+	for _, forwarder := range receiptStore.eventSinks {
+		status := forwarder.Status()
+		response := models.EventSinkStatus{
+			Name:      status.Name,
+			Cursor:    status.Cursor,
+			LastSeq:   status.LastSeq,
+			Failures:  status.Failures,
+			LastError: status.LastError,
+		}
+		if status.LastSeq > status.Cursor {
+			response.LagEvents = status.LastSeq - status.Cursor
+		}
+		if !status.LastSuccess.IsZero() {
+			lastSuccess := status.LastSuccess
+			response.LastSuccess = &lastSuccess
+		}
+		statuses = append(statuses, response)
+	}
+	return statuses
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/snapshot"
+)
+
+/**
+* @api {get} /admin/snapshot Export Snapshot
+* @apiDescription This operator endpoint streams every stored receipt and balance adjustment as versioned NDJSON, or as a gzip-compressed
+* tar archive with a manifest and checksums when "format=archive" is requested.
+**/
+func (receiptStore *ReceiptStore) ExportSnapshot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	now := receiptStore.clock.Now()
+	switch format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); format {
+	case "", "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		//Once streaming starts the status is already sent, so failures are only logged; the missing trailer tells
+		//the reader the snapshot is incomplete
+		writer, err := snapshot.NewWriter(w, now)
+		if err == nil {
+			err = receiptStore.exportSnapshot(writer)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			log.Printf("ExportSnapshot: streaming failed: %v", err)
+		}
+	case "archive":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="receipts-snapshot.tar.gz"`)
+		if err := snapshot.WriteArchive(w, now, receiptStore.exportSnapshot); err != nil {
+			handleErr(w, err, "ExportSnapshot: writing archive failed", http.StatusInternalServerError)
+		}
+	default:
+		handleErr(w, nil, "ExportSnapshot: unknown format "+format, http.StatusBadRequest)
+	}
+}
+
+/**
+* @api {post} /admin/snapshot Import Snapshot
+* @apiDescription This operator endpoint imports an NDJSON snapshot or archive. "conflict" decides what happens to
+* receipts whose ID is already stored (skip, overwrite or fail) and "rescore=true" scores them under the current rules.
+**/
+func (receiptStore *ReceiptStore) ImportSnapshot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	options, err := checkImportOptions(r)
+	if err != nil {
+		handleErr(w, err, "ImportSnapshot validation error", http.StatusBadRequest)
+		return
+	}
+	contents, err := snapshot.Read(r.Body)
+	if err != nil {
+		handleErr(w, err, "ImportSnapshot validation error", http.StatusBadRequest)
+		return
+	}
+
+	report, err := receiptStore.importSnapshot(contents, options)
+	if errors.Is(err, errImportConflict) {
+		handleErr(w, err, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		handleErr(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendJSON(w, report); err != nil {
+		handleErr(w, err, "Error marshaling import report", http.StatusInternalServerError)
+	}
+}
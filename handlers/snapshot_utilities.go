@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/receiptid"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+	"github.com/praveensundaram1/receipt-processor-challenge/snapshot"
+)
+
+var errImportConflict = errors.New("snapshot receipt ID already stored")
+
+// Conflict policies for receipts whose ID is already stored.
+const (
+	importSkip      = "skip"
+	importOverwrite = "overwrite"
+	importFail      = "fail"
+)
+
+// importOptions control how a snapshot is applied.
+type importOptions struct {
+	conflict string
+	rescore  bool
+}
+
+/*
+*
+Helper function to read the import options from the query string, e.g. "?conflict=overwrite&rescore=true".
+*
+*/
+func checkImportOptions(r *http.Request) (importOptions, error) {
+	query := r.URL.Query()
+	options := importOptions{conflict: strings.ToLower(strings.TrimSpace(query.Get("conflict")))}
+	switch options.conflict {
+	case "":
+		options.conflict = importSkip
+	case importSkip, importOverwrite, importFail:
+	default:
+		return importOptions{}, errors.Errorf("checkImportOptions: conflict policy %q validation failed", options.conflict)
+	}
+
+	if rawRescore := strings.TrimSpace(query.Get("rescore")); rawRescore != "" {
+		rescore, err := strconv.ParseBool(rawRescore)
+		if err != nil {
+			return importOptions{}, errors.Wrap(err, "checkImportOptions: rescore validation failed")
+		}
+		options.rescore = rescore
+	}
+	return options, nil
+}
+
+/*
+*
+This function collects every stored receipt, including spilled ones, with the items refunded against it and the
+times of its transactions, sorted by ID.
+*
+*/
+func (receiptStore *ReceiptStore) snapshotRecords() []snapshot.Record {
+	var records []snapshot.Record
+	receiptStore.receipts.forEach(func(receiptID string, receipt models.Receipt) {
+		records = append(records, snapshot.Record{ID: receiptID, Receipt: receipt})
+	})
+	receiptStore.stampRecords(records)
+
+	receiptStore.lock.RLock()
+	for i := range records {
+		records[i].RefundedItems = append([]models.Item(nil), receiptStore.refundedItems[records[i].ID]...)
+		if submittedAt, queued := receiptStore.reviewQueue[records[i].ID]; queued && records[i].StoredAt == nil {
+			records[i].StoredAt = &submittedAt
+		}
+	}
+	receiptStore.lock.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+/*
+*
+Helper function to stamp snapshot records with the times of their receipts' transactions in the ledger: when their
+points were last credited, when their items were last refunded and when a review rejected them.
+*
+*/
+func (receiptStore *ReceiptStore) stampRecords(records []snapshot.Record) {
+	byID := make(map[string]*snapshot.Record, len(records))
+	for i := range records {
+		byID[records[i].ID] = &records[i]
+	}
+	for _, transaction := range receiptStore.ledger.Transactions(func(transaction ledger.Transaction) bool { return transaction.ReceiptID != "" }) {
+		record, found := byID[transaction.ReceiptID]
+		if !found {
+			continue
+		}
+		at := transaction.CreatedAt.UTC()
+		switch transaction.Kind {
+		case ledger.KindEarn:
+			record.StoredAt = &at
+		case ledger.KindRefund:
+			record.RefundedAt = &at
+		case ledger.KindVoid:
+			record.RejectedAt = &at
+		}
+	}
+}
+
+/*
+*
+Helper function to return a recorded time, or the zero time when it is unknown, which recordEvents replaces with the
+current time.
+*
+*/
+func recordedAt(at *time.Time) time.Time {
+	if at == nil {
+		return time.Time{}
+	}
+	return at.UTC()
+}
+
+/*
+*
+Helper function to select the ledger transactions that belong to no receipt, such as manual adjustments, redemptions,
+cancellations and expiries. Receipt transactions are rebuilt from the records on import, so only these are exported.
+*
+*/
+func isBalanceAdjustment(transaction ledger.Transaction) bool {
+	return transaction.ReceiptID == ""
+}
+
+/*
+*
+This function writes every stored receipt, every balance adjustment and the rewards catalog to a snapshot.
+*
+*/
+func (receiptStore *ReceiptStore) exportSnapshot(writer *snapshot.Writer) error {
+	for _, record := range receiptStore.snapshotRecords() {
+		if err := writer.Write(record); err != nil {
+			return errors.Wrap(err, "exportSnapshot")
+		}
+	}
+	for _, transaction := range receiptStore.ledger.Transactions(isBalanceAdjustment) {
+		if err := writer.WriteTransaction(transaction); err != nil {
+			return errors.Wrap(err, "exportSnapshot")
+		}
+	}
+	for _, change := range receiptStore.catalog.Changes() {
+		if err := writer.WriteCatalog(change); err != nil {
+			return errors.Wrap(err, "exportSnapshot")
+		}
+	}
+	return nil
+}
+
+/*
+*
+This function validates a snapshot record the way a submitted receipt is validated, and checks that its status and
+refunded items are consistent.
+*
+*/
+func checkSnapshotRecord(record snapshot.Record) error {
+	if strings.TrimSpace(record.ID) == "" {
+		return errors.New("checkSnapshotRecord: ID validation failed")
+	}
+	if err := validateReceiptData(record.Receipt); err != nil {
+		return errors.Wrap(err, "checkSnapshotRecord")
+	}
+	switch record.Receipt.Status {
+	case "", models.ReceiptPendingReview, models.ReceiptRejected:
+	default:
+		return errors.Errorf("checkSnapshotRecord: status %q validation failed", record.Receipt.Status)
+	}
+	if _, err := subtractItems(record.Receipt.Items, record.RefundedItems); err != nil {
+		return errors.Wrap(err, "checkSnapshotRecord: refunded items validation failed")
+	}
+	return nil
+}
+
+/*
+*
+This function applies a snapshot to the store: its records first, then its balance adjustments, then its rewards
+and redemptions. Invalid records are reported and skipped. Under the "fail" conflict policy nothing is imported if
+any record's ID, or any reward's or redemption's, is already taken when the import starts; one taken while the
+import runs stops it there. When rescoring, records are applied in purchase order so history bonuses come out as if
+the receipts had been submitted in order.
+*
+*/
+func (receiptStore *ReceiptStore) importSnapshot(contents snapshot.Snapshot, options importOptions) (models.ImportReport, error) {
+	report := models.ImportReport{Rejected: []models.ImportRejection{}}
+	records := contents.Records
+	if options.conflict == importFail {
+		for _, record := range records {
+			if _, _, found := receiptStore.lookupReceipt(record.ID); found {
+				return report, errors.Wrapf(errImportConflict, "importSnapshot: %s", record.ID)
+			}
+		}
+		held := catalogKeys(receiptStore.catalog.Changes())
+		for _, change := range contents.Catalog {
+			if held[catalogKey(change)] {
+				return report, errors.Wrapf(errImportConflict, "importSnapshot: %s", catalogKey(change))
+			}
+		}
+	}
+	if options.rescore {
+		sort.SliceStable(records, func(i, j int) bool {
+			return purchaseOf(records[i].ID, &records[i].Receipt).Before(purchaseOf(records[j].ID, &records[j].Receipt))
+		})
+	}
+
+	for _, record := range records {
+		if err := checkSnapshotRecord(record); err != nil {
+			report.Rejected = append(report.Rejected, models.ImportRejection{ID: record.ID, Error: err.Error()})
+			continue
+		}
+		replaced, imported, err := receiptStore.importRecord(record, options)
+		if err != nil {
+			return report, errors.Wrap(err, "importSnapshot")
+		}
+		switch {
+		case replaced:
+			report.Replaced++
+		case imported:
+			report.Imported++
+		default:
+			report.Skipped++
+		}
+	}
+
+	replayed, err := receiptStore.replayTransactions(contents.Transactions)
+	report.Transactions = replayed
+	if err != nil {
+		return report, errors.Wrap(err, "importSnapshot")
+	}
+	applied, err := receiptStore.importCatalog(contents.Catalog, options)
+	report.Catalog = applied
+	return report, errors.Wrap(err, "importSnapshot")
+}
+
+/*
+*
+This function applies the rewards and redemptions of a snapshot to the catalog, with the idempotency keys the
+redemptions were made with, and records them in the event log. Their transactions are among the snapshot's balance
+adjustments. Rewards and redemptions the catalog already holds follow the conflict policy. It returns how many were
+applied.
+*
+*/
+func (receiptStore *ReceiptStore) importCatalog(changes []rewards.Change, options importOptions) (int, error) {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	held := catalogKeys(receiptStore.catalog.Changes())
+	applied := 0
+	var conflict error
+	for _, change := range changes {
+		key := catalogKey(change)
+		if key == "" {
+			continue
+		}
+		if held[key] && options.conflict == importFail {
+			conflict = errors.Wrapf(errImportConflict, "importCatalog: %s", key)
+			break
+		}
+		if held[key] && options.conflict != importOverwrite {
+			continue
+		}
+		change.Transaction = nil
+		receiptStore.catalog.Apply(change)
+		applied++
+	}
+	if err := receiptStore.recordCatalogChanges(); err != nil {
+		return applied, errors.Wrap(err, "importCatalog")
+	}
+	return applied, conflict
+}
+
+/*
+*
+Helper function to name the reward or redemption a catalog change is about, e.g. "reward mug". It returns an empty
+string for a change about neither.
+*
+*/
+func catalogKey(change rewards.Change) string {
+	switch {
+	case change.Redemption != nil:
+		return "redemption " + change.Redemption.Id
+	case change.Reward != nil:
+		return "reward " + change.Reward.Id
+	default:
+		return ""
+	}
+}
+
+/*
+*
+Helper function to collect the keys of catalog changes, see catalogKey.
+*
+*/
+func catalogKeys(changes []rewards.Change) map[string]bool {
+	keys := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		keys[catalogKey(change)] = true
+	}
+	return keys
+}
+
+/*
+*
+This function posts the balance adjustments of a snapshot with their original kind, memo, reference and time, and
+records them in the event log. An adjustment the ledger already holds is skipped, so importing the same snapshot
+twice does not apply it twice. It returns how many were posted.
+*
+*/
+func (receiptStore *ReceiptStore) replayTransactions(transactions []ledger.Transaction) (int, error) {
+	existing := receiptStore.ledger.Transactions(isBalanceAdjustment)
+	replayed := 0
+	for _, transaction := range transactions {
+		if !isBalanceAdjustment(transaction) || containsTransaction(existing, transaction) {
+			continue
+		}
+		originalID := transaction.ID
+		if err := receiptStore.postApplied(transaction); err != nil {
+			return replayed, errors.Wrapf(err, "replayTransactions: transaction %d", originalID)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+/*
+*
+Helper function to post a snapshot's transaction afresh and record it, under the record lock.
+*
+*/
+func (receiptStore *ReceiptStore) postApplied(transaction ledger.Transaction) error {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	transaction.ID = 0
+	posted, err := receiptStore.ledger.Post(transaction)
+	if err != nil {
+		return err
+	}
+	return receiptStore.recordApplied(events.Event{Type: events.Posted, Transaction: &posted})
+}
+
+/*
+*
+Helper function to check whether a list holds a transaction with the same kind, memo, reference, time and postings.
+IDs are ignored since they differ between ledgers.
+*
+*/
+func containsTransaction(transactions []ledger.Transaction, transaction ledger.Transaction) bool {
+	for _, candidate := range transactions {
+		if candidate.Kind == transaction.Kind && candidate.Memo == transaction.Memo && candidate.Reference == transaction.Reference &&
+			candidate.CreatedAt.Equal(transaction.CreatedAt) && reflect.DeepEqual(candidate.Postings, transaction.Postings) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+This function stores one validated snapshot record under its own ID and credits its points. It reports whether the
+record replaced a stored receipt and whether it was imported at all.
+*
+*/
+func (receiptStore *ReceiptStore) importRecord(record snapshot.Record, options importOptions) (bool, bool, error) {
+	receipt := record.Receipt
+	contentID := receiptid.ContentID(receipt)
+
+	ownerLock := receiptStore.ownerLocks.forOwner(receipt.UserID, record.ID)
+	ownerLock.Lock()
+	defer ownerLock.Unlock()
+
+	_, replaced := receiptStore.receipts.get(record.ID)
+	if replaced {
+		if options.conflict == importFail {
+			return false, false, errors.Wrapf(errImportConflict, "importRecord: %s", record.ID)
+		}
+		if options.conflict != importOverwrite {
+			return false, false, nil
+		}
+		if err := receiptStore.recordEvents(events.Event{Type: events.Voided, ReceiptID: record.ID, Reason: events.ReasonReplaced}); err != nil {
+			return false, false, errors.Wrap(err, "importRecord")
+		}
+	}
+
+	storedAt := recordedAt(record.StoredAt)
+	if options.rescore && receipt.Status != models.ReceiptRejected {
+		scoredAt := storedAt
+		if scoredAt.IsZero() {
+			scoredAt = receiptStore.clock.Now()
+		}
+		receipt.BasePoints = computeReceiptPoints(&receipt)
+		receiptStore.scoreReceipt(record.ID, &receipt, scoredAt)
+		if len(record.RefundedItems) > 0 {
+			remaining, _ := subtractItems(receipt.Items, record.RefundedItems)
+			remainingPoints, err := computeRemainingPoints(receipt, remaining, record.RefundedItems)
+			if err != nil {
+				return false, false, errors.Wrap(err, "importRecord")
+			}
+			if remainingPoints < receipt.Points {
+				receipt.Points = remainingPoints
+			}
+		}
+	}
+
+	//The events keep the receipt's original times, so its transactions age as they did in the source environment
+	imported := submissionEvents(record.ID, contentID, &receipt, events.ReasonImported)
+	for i := range imported {
+		imported[i].At = storedAt
+	}
+	if len(record.RefundedItems) > 0 {
+		imported = append(imported, events.Event{Type: events.Refunded, ReceiptID: record.ID, At: recordedAt(record.RefundedAt), Reason: events.ReasonImported, Items: record.RefundedItems})
+	}
+	if receipt.Status == models.ReceiptRejected {
+		imported = append(imported, events.Event{Type: events.Voided, ReceiptID: record.ID, At: recordedAt(record.RejectedAt), Reason: events.ReasonImported})
+	}
+	if err := receiptStore.indexReceipt(record.ID, receipt); err != nil {
+		return false, false, errors.Wrap(err, "importRecord")
+	}
+	if err := receiptStore.recordEvents(imported...); err != nil {
+		return false, false, errors.Wrap(err, "importRecord: recording imported receipt failed")
+	}
+	return replaced, true, nil
+}
+
+/*
+*
+This function takes a stored receipt out of the store's indexes and reverses the points it still holds, so a
+snapshot record can replace it. The reversals are recorded at the given time. The caller must hold the receipt's
+owner lock.
+*
+*/
+func (receiptStore *ReceiptStore) discardReceipt(receiptID string, existing models.Receipt, at time.Time) error {
+	for _, account := range []string{ledger.UserAccount(existing.UserID), ledger.HeldAccount(existing.UserID)} {
+		balance := receiptStore.ledger.ReceiptBalance(receiptID, account)
+		if balance == 0 {
+			continue
+		}
+		_, err := receiptStore.ledger.Post(ledger.Transaction{
+			Kind:      ledger.KindAdjust,
+			ReceiptID: receiptID,
+			Memo:      "Replaced by snapshot import",
+			Postings:  ledger.Transfer(account, ledger.IssuedAccount, balance),
+			CreatedAt: at,
+		})
+		if err != nil {
+			return errors.Wrap(err, "discardReceipt")
+		}
+	}
+
+	return errors.Wrap(receiptStore.unindexReceipt(receiptID, existing), "discardReceipt")
+}
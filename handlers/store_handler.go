@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/**
+* @api {get} /admin/store/stats Fetch Store Stats
+* @apiDescription This operator endpoint reports how many receipts are held in memory and counts the evictions so far.
+**/
+func (receiptStore *ReceiptStore) FetchStoreStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := sendJSON(w, receiptStore.receipts.stats()); err != nil {
+		handleErr(w, err, "Error marshaling store stats response", http.StatusInternalServerError)
+	}
+}
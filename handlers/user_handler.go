@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/**
+* @api {get} /users/:id/balance Fetch Balance
+* @apiDescription This endpoint fetches a user's points balance, derived from the ledger, and their current tier. Only the
+* user or the operator may read it.
+**/
+func (receiptStore *ReceiptStore) FetchBalance(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, err := checkUserID(params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "FetchBalance validation error", http.StatusBadRequest)
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, userID, "FetchBalance") {
+		return
+	}
+
+	if err := sendJSON(w, receiptStore.userBalance(userID)); err != nil {
+		handleErr(w, err, "Error marshaling balance response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {get} /users/:id/ledger Fetch Ledger
+* @apiDescription This endpoint fetches every ledger entry that changed a user's balance, oldest first. Only the user or
+* the operator may read it.
+**/
+func (receiptStore *ReceiptStore) FetchLedger(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, err := checkUserID(params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "FetchLedger validation error", http.StatusBadRequest)
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, userID, "FetchLedger") {
+		return
+	}
+
+	if err := sendJSON(w, receiptStore.userLedger(userID)); err != nil {
+		handleErr(w, err, "Error marshaling ledger response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {get} /users/:id/expiring Fetch Expiring Points
+* @apiDescription This endpoint lists the points a user will lose within the "within" window (default 30 days). Only the
+* user or the operator may read it.
+**/
+func (receiptStore *ReceiptStore) FetchExpiringPoints(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, err := checkUserID(params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "FetchExpiringPoints validation error", http.StatusBadRequest)
+		return
+	}
+	if !receiptStore.authorizeUser(w, r, userID, "FetchExpiringPoints") {
+		return
+	}
+	window, err := checkExpiringWindow(r)
+	if err != nil {
+		handleErr(w, err, "FetchExpiringPoints validation error", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendJSON(w, receiptStore.expiringPoints(userID, window)); err != nil {
+		handleErr(w, err, "Error marshaling expiring points response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /users/:id/adjustments Adjust Points
+* @apiDescription This operator endpoint posts a manual correction to a user's balance.
+**/
+func (receiptStore *ReceiptStore) AdjustPoints(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, err := checkUserID(params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "AdjustPoints validation error", http.StatusBadRequest)
+		return
+	}
+	adjustment, err := checkAdjustmentValidity(r)
+	if err != nil {
+		handleErr(w, err, "AdjustPoints validation error", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := receiptStore.adjustUserPoints(userID, adjustment); err != nil {
+		handleErr(w, err, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendJSON(w, receiptStore.userBalance(userID)); err != nil {
+		handleErr(w, err, "Error marshaling balance response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /users/:id/tokens Issue User Token
+* @apiDescription This operator endpoint issues a bearer token the user can redeem and cancel rewards with, valid
+* for the configured token lifetime.
+**/
+func (receiptStore *ReceiptStore) IssueUserToken(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	userID, err := checkUserID(params.ByName("id"))
+	if err != nil {
+		handleErr(w, err, "IssueUserToken validation error", http.StatusBadRequest)
+		return
+	}
+	if receiptStore.userTokens == nil {
+		handleErr(w, nil, "IssueUserToken: user tokens are disabled", http.StatusForbidden)
+		return
+	}
+
+	expiresAt := receiptStore.clock.Now().Add(receiptStore.userTokenTTL).UTC().Truncate(time.Second)
+	response := models.UserTokenResponse{UserID: userID, Token: receiptStore.userTokens.Sign(userID, expiresAt), ExpiresAt: expiresAt}
+	if err := sendJSON(w, response); err != nil {
+		handleErr(w, err, "Error marshaling token response", http.StatusInternalServerError)
+	}
+}
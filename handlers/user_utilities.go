@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/expiry"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/*
+*
+Helper function to read and validate the user ID path parameter.
+*
+*/
+func checkUserID(rawUserID string) (string, error) {
+	userID := strings.TrimSpace(rawUserID)
+	if !userIDRegex.MatchString(userID) {
+		return "", errors.New("checkUserID: user ID validation failed")
+	}
+	return userID, nil
+}
+
+/*
+*
+This function checks the validity of the adjustment data in the request body.
+*
+*/
+func checkAdjustmentValidity(r *http.Request) (*models.AdjustmentRequest, error) {
+	var parsedAdjustment models.AdjustmentRequest
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkAdjustmentValidity: reading body failed")
+	}
+
+	if err := json.Unmarshal(requestBody, &parsedAdjustment); err != nil {
+		return nil, errors.Wrap(err, "checkAdjustmentValidity: unmarshaling failed")
+	}
+
+	if parsedAdjustment.Points == 0 {
+		return nil, errors.New("checkAdjustmentValidity: points validation failed")
+	}
+	if strings.TrimSpace(parsedAdjustment.Memo) == "" {
+		return nil, errors.New("checkAdjustmentValidity: memo validation failed")
+	}
+
+	return &parsedAdjustment, nil
+}
+
+/*
+*
+This function returns a user's balance and, when loyalty tiers are enabled, the tier they hold right now.
+*
+*/
+func (receiptStore *ReceiptStore) userBalance(userID string) models.BalanceResponse {
+	account := ledger.UserAccount(userID)
+	response := models.BalanceResponse{UserID: userID, Balance: receiptStore.ledger.Balance(account)}
+	if receiptStore.tierPolicy != nil {
+		tier := receiptStore.tierPolicy.Evaluate(account, receiptStore.ledger.AccountTransactions(account), receiptStore.clock.Now())
+		response.Tier = tier.Name
+	}
+	return response
+}
+
+/*
+*
+This function builds a user's ledger from the transactions that touched their account.
+The balance is derived from the same snapshot so the two always agree.
+*
+*/
+func (receiptStore *ReceiptStore) userLedger(userID string) models.LedgerResponse {
+	account := ledger.UserAccount(userID)
+	transactions := receiptStore.ledger.AccountTransactions(account)
+
+	response := models.LedgerResponse{UserID: userID, Entries: make([]models.LedgerEntry, 0, len(transactions))}
+	for _, transaction := range transactions {
+		points := transaction.Amount(account)
+		response.Balance += points
+		response.Entries = append(response.Entries, models.LedgerEntry{
+			ID:        transaction.ID,
+			Kind:      string(transaction.Kind),
+			ReceiptID: transaction.ReceiptID,
+			Reference: transaction.Reference,
+			Memo:      transaction.Memo,
+			Points:    points,
+			CreatedAt: transaction.CreatedAt,
+		})
+	}
+	return response
+}
+
+/*
+*
+This function posts a manual adjustment to a user's balance and records it in the event log.
+*
+*/
+func (receiptStore *ReceiptStore) adjustUserPoints(userID string, adjustment *models.AdjustmentRequest) (ledger.Transaction, error) {
+	receiptStore.recordLock.Lock()
+	defer receiptStore.recordLock.Unlock()
+
+	transaction, err := receiptStore.ledger.Post(ledger.Transaction{
+		Kind:     ledger.KindAdjust,
+		Memo:     strings.TrimSpace(adjustment.Memo),
+		Postings: ledger.Transfer(ledger.IssuedAccount, ledger.UserAccount(userID), adjustment.Points),
+	})
+	if err != nil {
+		return ledger.Transaction{}, errors.Wrap(err, "adjustUserPoints")
+	}
+	if err := receiptStore.recordApplied(events.Event{Type: events.Posted, Transaction: &transaction}); err != nil {
+		return ledger.Transaction{}, errors.Wrap(err, "adjustUserPoints")
+	}
+	return transaction, nil
+}
+
+/*
+*
+Helper function to marshal any response body and write it with a 200 status code.
+*
+*/
+func sendJSON(w http.ResponseWriter, response interface{}) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshaling response")
+		return err
+	}
+	writeJSONResponse(w, http.StatusOK, data)
+	return nil
+}
+
+// defaultExpiringWindow is how far ahead the expiring points query looks when no window is requested.
+const defaultExpiringWindow = 30 * 24 * time.Hour
+
+/*
+*
+This function lists the points a user will lose within the given window under the configured expiry policy.
+*
+*/
+func (receiptStore *ReceiptStore) expiringPoints(userID string, window time.Duration) models.ExpiringPointsResponse {
+	response := models.ExpiringPointsResponse{UserID: userID, Within: window.String(), Expirations: []models.PointsExpiration{}}
+	if receiptStore.expiryPolicy == nil {
+		return response
+	}
+
+	account := ledger.UserAccount(userID)
+	schedule := receiptStore.expiryPolicy.Schedule(account, receiptStore.ledger.AccountTransactions(account))
+	for _, expiration := range expiry.Within(schedule, receiptStore.clock.Now(), window) {
+		response.Total += expiration.Points
+		response.Expirations = append(response.Expirations, models.PointsExpiration{
+			Points:    expiration.Points,
+			ExpiresAt: expiration.ExpiresAt.UTC(),
+		})
+	}
+	return response
+}
+
+/*
+*
+Helper function to read the optional "within" query parameter, e.g. "720h".
+*
+*/
+func checkExpiringWindow(r *http.Request) (time.Duration, error) {
+	rawWindow := strings.TrimSpace(r.URL.Query().Get("within"))
+	if rawWindow == "" {
+		return defaultExpiringWindow, nil
+	}
+	window, err := time.ParseDuration(rawWindow)
+	if err != nil || window <= 0 {
+		return 0, errors.Errorf("checkExpiringWindow: window %q validation failed", rawWindow)
+	}
+	return window, nil
+}
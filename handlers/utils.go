@@ -2,7 +2,14 @@ package handlers
 
 import (
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+
+	json "github.com/json-iterator/go"
+	"github.com/julienschmidt/httprouter"
 )
 
 /*
@@ -11,7 +18,7 @@ Helper function to write JSON response with status code.
 *
 */
 func writeJSONResponse(w http.ResponseWriter, statusCode int, data []byte) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", jsonContentType(w))
 	w.WriteHeader(statusCode)
 	if len(data) == 0 {
 		return
@@ -23,7 +30,8 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, data []byte) {
 
 /*
 *
-Helper function to handle errors.
+Helper function to handle errors. Version 1 answers with an empty body. Later versions describe the error in the
+body, with the message for client errors; server errors only name their status, since their messages are internal.
 *
 */
 func handleErr(w http.ResponseWriter, err error, errorMessage string, statusCode int) {
@@ -32,5 +40,78 @@ func handleErr(w http.ResponseWriter, err error, errorMessage string, statusCode
 	} else {
 		log.Println(errorMessage)
 	}
-	writeJSONResponse(w, statusCode, nil)
+	if apiVersionOf(w) == apiVersion1 {
+		writeJSONResponse(w, statusCode, nil)
+		return
+	}
+	response := models.ErrorResponse{Status: statusCode, Error: http.StatusText(statusCode)}
+	if statusCode < http.StatusInternalServerError {
+		response.Message = errorMessage
+	}
+	data, _ := json.Marshal(response)
+	writeJSONResponse(w, statusCode, data)
+}
+
+/*
+*
+AdminOnly wraps an operator endpoint so that it is only reachable with the configured admin token.
+*
+*/
+func (receiptStore *ReceiptStore) AdminOnly(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if receiptStore.adminToken == "" {
+			handleErr(w, nil, "AdminOnly: admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if !receiptStore.isAdmin(r) {
+			handleErr(w, nil, "AdminOnly: invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		handle(w, r, params)
+	}
+}
+
+/*
+*
+RateLimited wraps an endpoint so that each client is limited to the rate configured for the route, e.g.
+"POST /receipts/process". Clients over their limit get a 429 with a Retry-After header in seconds.
+*
+*/
+func (receiptStore *ReceiptStore) RateLimited(route string, handle httprouter.Handle) httprouter.Handle {
+	if receiptStore.rateLimiter == nil {
+		return handle
+	}
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		allowed, retryAfter, err := receiptStore.rateLimiter.Allow(route, receiptStore.rateLimitKey(r))
+		if err != nil {
+			log.Printf("RateLimited: %s: %v", route, err)
+		}
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			handleErr(w, nil, "RateLimited: "+route+" limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		handle(w, r, params)
+	}
+}
+
+/*
+*
+LeaderOnly wraps an endpoint that writes, so that a follower refuses it with a 421 naming its leader in the
+X-Leader-URL header. Writes are accepted again once the follower is promoted.
+*
+*/
+func (receiptStore *ReceiptStore) LeaderOnly(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if receiptStore.following.Load() {
+			w.Header().Set("X-Leader-URL", receiptStore.leaderURL)
+			handleErr(w, nil, "LeaderOnly: this instance is a read-only follower", http.StatusMisdirectedRequest)
+			return
+		}
+		handle(w, r, params)
+	}
 }
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// API versions. Unversioned paths answer as the version the Accept header asks for, and as version 1 otherwise.
+const (
+	apiVersion1 = 1
+	apiVersion2 = 2
+)
+
+// LatestAPIVersion is the newest API version. The router serves every version from 1 up to it.
+const LatestAPIVersion = apiVersion2
+
+// apiMediaType is the vendor media type a client can put in its Accept header to ask for a version, e.g.
+// application/vnd.receiptprocessor.v2+json.
+const apiMediaType = "application/vnd.receiptprocessor.v%d+json"
+
+// versionedWriter carries the API version a request was negotiated to, so that the response helpers shape their
+// output for it.
+type versionedWriter struct {
+	http.ResponseWriter
+	version int
+	// mediaType is the vendor media type the client asked for, echoed as the Content-Type of JSON responses. It is
+	// empty when the client accepted plain JSON.
+	mediaType string
+}
+
+// Flush lets streaming endpoints flush through the wrapper.
+func (w *versionedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer.
+func (w *versionedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+/*
+*
+Helper function to find the API version a response is written for. Responses written outside a versioned route are
+version 1.
+*
+*/
+func apiVersionOf(w http.ResponseWriter) int {
+	if versioned, ok := w.(*versionedWriter); ok {
+		return versioned.version
+	}
+	return apiVersion1
+}
+
+/*
+*
+Helper function to pick the content type of a JSON response: the vendor media type the client asked for, or plain
+JSON.
+*
+*/
+func jsonContentType(w http.ResponseWriter) string {
+	if versioned, ok := w.(*versionedWriter); ok && versioned.mediaType != "" {
+		return versioned.mediaType
+	}
+	return "application/json"
+}
+
+/*
+*
+This function negotiates the API version of a request from its Accept header. A path version (1 or 2) is fixed, and
+the request is refused when the header only accepts other versions. Without one (0) the most preferred vendor media
+type wins, and version 1 is the default. It returns the version, the vendor media type chosen if any, and whether the
+request is acceptable.
+*
+*/
+func negotiateAPIVersion(accept string, pathVersion int) (int, string, bool) {
+	type mediaRange struct {
+		version int
+		quality float64
+	}
+	var ranges []mediaRange
+	acceptsAnyVersion := strings.TrimSpace(accept) == ""
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if raw, found := params["q"]; found {
+			if quality, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		var version int
+		if _, err := fmt.Sscanf(mediaType, apiMediaType, &version); err == nil && mediaType == fmt.Sprintf(apiMediaType, version) {
+			if version >= apiVersion1 && version <= LatestAPIVersion {
+				ranges = append(ranges, mediaRange{version: version, quality: quality})
+			}
+			continue
+		}
+		//Anything else, such as application/json, */* or text/event-stream, leaves the version to the path
+		acceptsAnyVersion = true
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	if pathVersion != 0 {
+		for _, r := range ranges {
+			if r.version == pathVersion {
+				return pathVersion, fmt.Sprintf(apiMediaType, pathVersion), true
+			}
+		}
+		return pathVersion, "", acceptsAnyVersion
+	}
+	if len(ranges) > 0 {
+		return ranges[0].version, fmt.Sprintf(apiMediaType, ranges[0].version), true
+	}
+	return apiVersion1, "", acceptsAnyVersion
+}
+
+/*
+*
+Versioned wraps an endpoint served under a version prefix such as /v2, or unversioned when the version is 0. It
+negotiates the version with the Accept header, answers 406 when the client accepts none it can have, and tells the
+handler which version to answer with. Every response names its version in the API-Version header.
+*
+*/
+func (receiptStore *ReceiptStore) Versioned(pathVersion int, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		version, mediaType, acceptable := negotiateAPIVersion(r.Header.Get("Accept"), pathVersion)
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		versioned := &versionedWriter{ResponseWriter: w, version: version, mediaType: mediaType}
+		if !acceptable {
+			handleErr(versioned, nil, fmt.Sprintf("Versioned: %s does not accept version %d", r.URL.Path, version), http.StatusNotAcceptable)
+			return
+		}
+		if pathVersion == 0 {
+			receiptStore.markDeprecated(w, r, version)
+		}
+		handle(versioned, r, params)
+	}
+}
+
+/*
+*
+This function marks a response from an unversioned path as deprecated. The Deprecation header carries the configured
+deprecation date, or true when none is set, and the Sunset header the date the path will be removed, if set. The
+Link header points to the same resource under the version the request was answered with.
+*
+*/
+func (receiptStore *ReceiptStore) markDeprecated(w http.ResponseWriter, r *http.Request, version int) {
+	if receiptStore.deprecatedAt.IsZero() {
+		w.Header().Set("Deprecation", "true")
+	} else {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(receiptStore.deprecatedAt.Unix(), 10))
+	}
+	if !receiptStore.sunsetAt.IsZero() {
+		w.Header().Set("Sunset", receiptStore.sunsetAt.UTC().Format(http.TimeFormat))
+	}
+	successor := fmt.Sprintf("/v%d%s", version, r.URL.EscapedPath())
+	if r.URL.RawQuery != "" {
+		successor += "?" + r.URL.RawQuery
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+/**
+* @api {post} /admin/webhooks Create Webhook Subscription
+* @apiDescription This operator endpoint subscribes a URL to receipt events. The response is the only time the
+* signing secret is returned.
+**/
+func (receiptStore *ReceiptStore) CreateWebhookSubscription(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if receiptStore.webhooks == nil {
+		handleErr(w, errWebhooksDisabled, "CreateWebhookSubscription", http.StatusNotFound)
+		return
+	}
+	request, err := checkWebhookSubscriptionValidity(r)
+	if err != nil {
+		handleErr(w, err, "CreateWebhookSubscription validation error", http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := receiptStore.webhooks.Subscribe(request.URL, request.EventTypes, request.Secret)
+	if err != nil {
+		handleErr(w, err, "CreateWebhookSubscription error", http.StatusInternalServerError)
+		return
+	}
+	response := webhookSubscriptionOf(subscription)
+	response.Secret = subscription.Secret
+	if err := sendJSON(w, response); err != nil {
+		handleErr(w, err, "Error marshaling webhook subscription response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {get} /admin/webhooks List Webhook Subscriptions
+* @apiDescription This operator endpoint lists the webhook subscriptions, oldest first, without their secrets.
+**/
+func (receiptStore *ReceiptStore) ListWebhookSubscriptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	response := []models.WebhookSubscription{}
+	if receiptStore.webhooks != nil {
+		for _, subscription := range receiptStore.webhooks.Subscriptions() {
+			response = append(response, webhookSubscriptionOf(subscription))
+		}
+	}
+	if err := sendJSON(w, response); err != nil {
+		handleErr(w, err, "Error marshaling webhook subscriptions response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {delete} /admin/webhooks/:id Delete Webhook Subscription
+* @apiDescription This operator endpoint removes a webhook subscription and drops the deliveries still queued for it.
+**/
+func (receiptStore *ReceiptStore) DeleteWebhookSubscription(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if receiptStore.webhooks == nil {
+		handleErr(w, errWebhooksDisabled, "DeleteWebhookSubscription", http.StatusNotFound)
+		return
+	}
+	if err := receiptStore.webhooks.Unsubscribe(strings.TrimSpace(params.ByName("id"))); err != nil {
+		handleErr(w, err, "DeleteWebhookSubscription error", webhookErrorStatus(err))
+		return
+	}
+	writeJSONResponse(w, http.StatusNoContent, nil)
+}
+
+/**
+* @api {get} /admin/webhook-dead-letters List Webhook Dead Letters
+* @apiDescription This operator endpoint lists the deliveries that ran out of attempts, oldest first.
+**/
+func (receiptStore *ReceiptStore) ListWebhookDeadLetters(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	response := []models.WebhookDelivery{}
+	if receiptStore.webhooks != nil {
+		for _, delivery := range receiptStore.webhooks.DeadLetters() {
+			response = append(response, webhookDeliveryOf(delivery))
+		}
+	}
+	if err := sendJSON(w, response); err != nil {
+		handleErr(w, err, "Error marshaling webhook dead letters response", http.StatusInternalServerError)
+	}
+}
+
+/**
+* @api {post} /admin/webhook-dead-letters/:id/replay Replay Webhook Dead Letter
+* @apiDescription This operator endpoint queues a dead letter for delivery again with a fresh set of attempts.
+**/
+func (receiptStore *ReceiptStore) ReplayWebhookDeadLetter(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if receiptStore.webhooks == nil {
+		handleErr(w, errWebhooksDisabled, "ReplayWebhookDeadLetter", http.StatusNotFound)
+		return
+	}
+	delivery, err := receiptStore.webhooks.Replay(strings.TrimSpace(params.ByName("id")))
+	if err != nil {
+		handleErr(w, err, "ReplayWebhookDeadLetter error", webhookErrorStatus(err))
+		return
+	}
+	if err := sendJSON(w, webhookDeliveryOf(delivery)); err != nil {
+		handleErr(w, err, "Error marshaling webhook delivery response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/sinks"
+	"github.com/praveensundaram1/receipt-processor-challenge/webhooks"
+)
+
+var errWebhooksDisabled = errors.New("webhooks are disabled")
+
+// webhookEventTypes are the receipt events that can be subscribed to, one per step of a receipt's lifecycle.
+var webhookEventTypes = map[string]bool{
+	webhookEventType(events.Submitted): true,
+	webhookEventType(events.Validated): true,
+	webhookEventType(events.Flagged):   true,
+	webhookEventType(events.Scored):    true,
+	webhookEventType(events.Adjusted):  true,
+	webhookEventType(events.Refunded):  true,
+	webhookEventType(events.Voided):    true,
+}
+
+/*
+*
+Helper function to name the webhook event type of a receipt event, e.g. "receipt.scored".
+*
+*/
+func webhookEventType(eventType events.Type) string {
+	return "receipt." + string(eventType)
+}
+
+/*
+*
+This function checks the validity of the webhook subscription in the request body: an absolute http(s) URL and at
+least one known event type, or "*" for all of them.
+*
+*/
+func checkWebhookSubscriptionValidity(r *http.Request) (*models.WebhookSubscriptionRequest, error) {
+	var parsedRequest models.WebhookSubscriptionRequest
+	requestBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkWebhookSubscriptionValidity: reading body failed")
+	}
+
+	if err := json.Unmarshal(requestBody, &parsedRequest); err != nil {
+		return nil, errors.Wrap(err, "checkWebhookSubscriptionValidity: unmarshaling failed")
+	}
+
+	parsedRequest.URL = strings.TrimSpace(parsedRequest.URL)
+	target, err := url.Parse(parsedRequest.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, errors.New("checkWebhookSubscriptionValidity: URL validation failed")
+	}
+	if len(parsedRequest.EventTypes) == 0 {
+		return nil, errors.New("checkWebhookSubscriptionValidity: event types validation failed")
+	}
+	for i, eventType := range parsedRequest.EventTypes {
+		eventType = strings.TrimSpace(eventType)
+		if eventType != webhooks.AllEvents && !webhookEventTypes[eventType] {
+			return nil, errors.Errorf("checkWebhookSubscriptionValidity: event type %q validation failed", eventType)
+		}
+		parsedRequest.EventTypes[i] = eventType
+	}
+
+	return &parsedRequest, nil
+}
+
+/*
+*
+NewWebhookFeed returns the forwarder that queues the events of the log for delivery to the dispatcher's subscribers.
+Its cursor is kept at cursorPath and only moves past events whose deliveries are in the outbox, so an event recorded
+just before a crash is queued after the restart instead of being lost. Without a saved cursor it starts after the
+events already in the log, since they were recorded before webhooks were set up.
+*
+*/
+func NewWebhookFeed(dispatcher *webhooks.Dispatcher, source sinks.Source, cursorPath string, c clock.Clock) (*sinks.Forwarder, error) {
+	feed, err := sinks.NewForwarder(&webhookSink{dispatcher: dispatcher}, source, cursorPath, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewWebhookFeed")
+	}
+	if err := feed.StartAfter(source.LastSeq()); err != nil {
+		return nil, errors.Wrap(err, "NewWebhookFeed")
+	}
+	return feed, nil
+}
+
+// webhookSink queues the events it is handed for delivery by the dispatcher.
+type webhookSink struct {
+	dispatcher *webhooks.Dispatcher
+	// published is the newest event queued, so a batch retried after a failure does not queue its first events twice.
+	published uint64
+}
+
+// Name identifies the sink.
+func (s *webhookSink) Name() string {
+	return "webhooks"
+}
+
+// Publish queues every receipt event of the batch that is not queued yet for the subscriptions that want it. Changes
+// to balances or the catalog outside any receipt are not webhook events.
+func (s *webhookSink) Publish(_ context.Context, batch []events.Event) error {
+	for _, event := range batch {
+		eventType := webhookEventType(event.Type)
+		if event.Seq <= s.published || !webhookEventTypes[eventType] {
+			continue
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrapf(err, "marshaling event %d", event.Seq)
+		}
+		if err := s.dispatcher.Publish(eventType, body); err != nil {
+			return errors.Wrapf(err, "queueing event %d", event.Seq)
+		}
+		s.published = event.Seq
+	}
+	return nil
+}
+
+// Close does nothing; the dispatcher outlives the sink.
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+/*
+*
+StartWebhookDelivery delivers queued webhooks as they are published, retrying failures every interval, until the
+context is cancelled. It does nothing when webhooks are disabled.
+*
+*/
+func (receiptStore *ReceiptStore) StartWebhookDelivery(ctx context.Context, interval time.Duration) {
+	if receiptStore.webhooks == nil {
+		return
+	}
+	go receiptStore.webhooks.Run(ctx, interval)
+}
+
+/*
+*
+Helper function to describe a subscription without its secret.
+*
+*/
+func webhookSubscriptionOf(subscription webhooks.Subscription) models.WebhookSubscription {
+	return models.WebhookSubscription{
+		ID:         subscription.ID,
+		URL:        subscription.URL,
+		EventTypes: subscription.EventTypes,
+		CreatedAt:  subscription.CreatedAt,
+	}
+}
+
+/*
+*
+Helper function to describe a delivery without its body.
+*
+*/
+func webhookDeliveryOf(delivery webhooks.Delivery) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastError:      delivery.LastError,
+		NextAttempt:    delivery.NextAttempt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
+
+/*
+*
+Helper function to map webhook errors to HTTP status codes.
+*
+*/
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errWebhooksDisabled), errors.Is(err, webhooks.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
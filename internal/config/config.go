@@ -0,0 +1,60 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/handlers"
+	"github.com/praveensundaram1/receipt-processor-challenge/loyalty"
+)
+
+// ScoringOptions reads the rules that score receipts from the environment. The server and the rebuild command
+// share them, so a rebuild scores receipts exactly as the server would.
+func ScoringOptions() ([]handlers.StoreOption, error) {
+	var options []handlers.StoreOption
+	if os.Getenv("LOYALTY_TIERS_ENABLED") == "true" {
+		tiers, err := loyalty.ParseTiers(os.Getenv("LOYALTY_TIERS"))
+		if err != nil {
+			return nil, errors.Wrap(err, "configuring loyalty tiers")
+		}
+		tierPolicy, err := loyalty.NewTierPolicy(tiers, DurationEnv("LOYALTY_TIER_WINDOW", 365*24*time.Hour))
+		if err != nil {
+			return nil, errors.Wrap(err, "configuring loyalty tiers")
+		}
+		options = append(options, handlers.WithTierPolicy(tierPolicy))
+	}
+	if os.Getenv("HISTORY_BONUSES_ENABLED") == "true" {
+		recomputeWindow := DurationEnv("HISTORY_RECOMPUTE_WINDOW", 7*24*time.Hour)
+		options = append(options, handlers.WithHistoryRules(loyalty.DefaultHistoryRules, recomputeWindow))
+	}
+	return options, nil
+}
+
+// DurationEnv reads a duration such as "720h" from the environment, falling back when it is unset or invalid.
+func DurationEnv(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %s", name, raw, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// DateEnv reads a date such as "2025-06-30" or an RFC 3339 time from the environment. It is the zero time when unset.
+func DateEnv(name string) (time.Time, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.DateOnly, raw); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, raw)
+	return date, errors.Wrapf(err, "parsing %s", name)
+}
@@ -1,6 +1,9 @@
 package routes
 
 import (
+	"fmt"
+	"net/http"
+
 	"github.com/julienschmidt/httprouter"
 	"github.com/praveensundaram1/receipt-processor-challenge/handlers"
 )
@@ -22,6 +25,51 @@ SetUpRoutes sets up the routes for the router.
 *
 */
 func SetUpRoutes(router *httprouter.Router, receiptStore *handlers.ReceiptStore) {
-	router.POST("/receipts/process", receiptStore.ProcessReceipt)
-	router.GET("/receipts/:id/points", receiptStore.FetchPoints)
+	// handle registers a route under each API version prefix, e.g. /v2/receipts/process, and at its deprecated
+	// unversioned path. Every version shares the rate limit configured for the unversioned route.
+	handle := func(method, path string, h httprouter.Handle) {
+		limited := receiptStore.RateLimited(method+" "+path, h)
+		router.Handle(method, path, receiptStore.Versioned(0, limited))
+		for version := 1; version <= handlers.LatestAPIVersion; version++ {
+			router.Handle(method, fmt.Sprintf("/v%d%s", version, path), receiptStore.Versioned(version, limited))
+		}
+	}
+	// write marks a route that changes the store, which a follower refuses.
+	write := receiptStore.LeaderOnly
+
+	handle(http.MethodPost, "/receipts/process", write(receiptStore.ProcessReceipt))
+	handle(http.MethodPost, "/receipts/parse", receiptStore.ParseReceiptText)
+	handle(http.MethodGet, "/receipts/:id/points", receiptStore.FetchPoints)
+	handle(http.MethodGet, "/events/receipts", receiptStore.AdminOnly(receiptStore.StreamReceipts))
+	handle(http.MethodGet, "/graphql", receiptStore.ExecuteGraphQL)
+	handle(http.MethodPost, "/graphql", write(receiptStore.ExecuteGraphQL))
+	handle(http.MethodPost, "/refunds/process", write(receiptStore.ProcessRefund))
+	handle(http.MethodGet, "/users/:id/balance", receiptStore.FetchBalance)
+	handle(http.MethodGet, "/users/:id/ledger", receiptStore.FetchLedger)
+	handle(http.MethodGet, "/users/:id/expiring", receiptStore.FetchExpiringPoints)
+	handle(http.MethodPost, "/users/:id/adjustments", write(receiptStore.AdminOnly(receiptStore.AdjustPoints)))
+	handle(http.MethodPost, "/users/:id/tokens", receiptStore.AdminOnly(receiptStore.IssueUserToken))
+	handle(http.MethodGet, "/rewards", receiptStore.ListRewards)
+	handle(http.MethodPut, "/rewards/:id", write(receiptStore.AdminOnly(receiptStore.PutReward)))
+	handle(http.MethodPost, "/redemptions", write(receiptStore.RedeemReward))
+	handle(http.MethodGet, "/redemptions/:id", receiptStore.FetchRedemption)
+	handle(http.MethodPost, "/redemptions/:id/cancel", write(receiptStore.CancelRedemption))
+	handle(http.MethodGet, "/admin/reviews", receiptStore.AdminOnly(receiptStore.ListReviews))
+	handle(http.MethodPost, "/admin/reviews/:id/approve", write(receiptStore.AdminOnly(receiptStore.ApproveReview)))
+	handle(http.MethodPost, "/admin/reviews/:id/reject", write(receiptStore.AdminOnly(receiptStore.RejectReview)))
+	handle(http.MethodGet, "/admin/store/stats", receiptStore.AdminOnly(receiptStore.FetchStoreStats))
+	handle(http.MethodGet, "/admin/snapshot", receiptStore.AdminOnly(receiptStore.ExportSnapshot))
+	handle(http.MethodPost, "/admin/snapshot", write(receiptStore.AdminOnly(receiptStore.ImportSnapshot)))
+	handle(http.MethodGet, "/admin/receipts/csv", receiptStore.AdminOnly(receiptStore.ExportReceiptsCSV))
+	handle(http.MethodPost, "/admin/receipts/csv", write(receiptStore.AdminOnly(receiptStore.ImportReceiptsCSV)))
+	handle(http.MethodGet, "/admin/replication/stream", receiptStore.AdminOnly(receiptStore.StreamReplication))
+	handle(http.MethodGet, "/admin/replication/status", receiptStore.AdminOnly(receiptStore.FetchReplicationStatus))
+	handle(http.MethodPost, "/admin/replication/promote", receiptStore.AdminOnly(receiptStore.PromoteReplica))
+	handle(http.MethodGet, "/admin/sinks", receiptStore.AdminOnly(receiptStore.FetchEventSinkStatus))
+	handle(http.MethodGet, "/admin/audit", receiptStore.AdminOnly(receiptStore.FetchAuditStatus))
+	handle(http.MethodPost, "/admin/webhooks", write(receiptStore.AdminOnly(receiptStore.CreateWebhookSubscription)))
+	handle(http.MethodGet, "/admin/webhooks", receiptStore.AdminOnly(receiptStore.ListWebhookSubscriptions))
+	handle(http.MethodDelete, "/admin/webhooks/:id", write(receiptStore.AdminOnly(receiptStore.DeleteWebhookSubscription)))
+	handle(http.MethodGet, "/admin/webhook-dead-letters", receiptStore.AdminOnly(receiptStore.ListWebhookDeadLetters))
+	handle(http.MethodPost, "/admin/webhook-dead-letters/:id/replay", write(receiptStore.AdminOnly(receiptStore.ReplayWebhookDeadLetter)))
 }
@@ -0,0 +1,356 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// Kind describes why a ledger transaction was recorded.
+type Kind string
+
+const (
+	// KindEarn is recorded when a receipt is scored and its points are awarded.
+	KindEarn Kind = "earn"
+	// KindAdjust is recorded when an operator manually corrects a balance.
+	KindAdjust Kind = "adjust"
+	// KindRedeem is recorded when points are spent.
+	KindRedeem Kind = "redeem"
+	// KindExpire is recorded when unused points expire.
+	KindExpire Kind = "expire"
+	// KindRefund is recorded when a refund claws back points from a receipt.
+	KindRefund Kind = "refund"
+	// KindCancel is recorded when a redemption is cancelled and its points are re-credited.
+	KindCancel Kind = "cancel"
+	// KindRelease is recorded when a review approves a receipt and its held points are released to the user.
+	KindRelease Kind = "release"
+	// KindVoid is recorded when a review rejects a receipt and its held points are voided.
+	KindVoid Kind = "void"
+)
+
+// System accounts that balance the user side of every transaction.
+const (
+	// IssuedAccount is debited whenever points are created for a user and credited when they are clawed back.
+	IssuedAccount = "system:issued"
+	// RedeemedAccount is credited whenever a user spends points.
+	RedeemedAccount = "system:redeemed"
+	// ExpiredAccount is credited whenever a user's points expire.
+	ExpiredAccount = "system:expired"
+	// UnclaimedAccount holds the points of receipts that were submitted without a user.
+	UnclaimedAccount = "system:unclaimed"
+)
+
+var (
+	// ErrUnbalanced is returned when the postings of a transaction do not sum to zero.
+	ErrUnbalanced = errors.New("transaction postings do not balance")
+	// ErrInsufficientBalance is returned when a transaction would overdraw a guarded account.
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// Posting is one side of a transaction. Amount is positive for credits and negative for debits.
+type Posting struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+// Transaction is a single, immutable, balanced change to one or more accounts.
+type Transaction struct {
+	ID        int64     `json:"id"`
+	Kind      Kind      `json:"kind"`
+	ReceiptID string    `json:"receiptId,omitempty"`
+	Reference string    `json:"reference,omitempty"` //ex. the refund ID for a KindRefund transaction
+	Memo      string    `json:"memo,omitempty"`
+	Postings  []Posting `json:"postings"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Amount returns the net amount the transaction posted to the given account.
+func (t Transaction) Amount(account string) int {
+	amount := 0
+	for _, posting := range t.Postings {
+		if posting.Account == account {
+			amount += posting.Amount
+		}
+	}
+	return amount
+}
+
+// userAccountPrefix prefixes every account that belongs to a user.
+const userAccountPrefix = "user:"
+
+// UserAccount returns the ledger account that holds a user's points.
+// Receipts submitted without a user are credited to UnclaimedAccount.
+func UserAccount(userID string) string {
+	if userID == "" {
+		return UnclaimedAccount
+	}
+	return userAccountPrefix + userID
+}
+
+// heldAccountPrefix prefixes every account that holds a user's points while their receipt is under review.
+const heldAccountPrefix = "held:"
+
+// HeldAccount returns the ledger account that holds a user's points until a review releases or voids them.
+func HeldAccount(userID string) string {
+	if userID == "" {
+		return heldAccountPrefix + UnclaimedAccount
+	}
+	return heldAccountPrefix + userID
+}
+
+// IsUserAccount reports whether an account belongs to a user rather than the system.
+func IsUserAccount(account string) bool {
+	return strings.HasPrefix(account, userAccountPrefix)
+}
+
+// Transfer returns the postings that move amount points from one account to another.
+func Transfer(from, to string, amount int) []Posting {
+	return []Posting{{Account: from, Amount: -amount}, {Account: to, Amount: amount}}
+}
+
+// Ledger is an append-only, concurrency safe, double-entry journal of points transactions.
+// Balances are derived from the journal as it grows: every append adds its postings to a running balance per
+// account, and indexes the transaction under each account and receipt it touches, so reads never scan the journal.
+type Ledger struct {
+	transactions []Transaction
+	// balances holds the running balance of every account posted to. byAccount and byReceipt hold the positions in
+	// transactions of the transactions that touched each account and each receipt, oldest first.
+	balances  map[string]int
+	byAccount map[string][]int
+	byReceipt map[string][]int
+	clock     clock.Clock
+	// observers see every transaction as it is recorded.
+	observers []func(Transaction)
+	lock      sync.RWMutex
+}
+
+// New returns an empty ledger that timestamps transactions with the system clock.
+func New() *Ledger {
+	return NewWithClock(clock.Real{})
+}
+
+// NewWithClock returns an empty ledger that timestamps transactions with the given clock.
+func NewWithClock(c clock.Clock) *Ledger {
+	return &Ledger{clock: c, balances: make(map[string]int), byAccount: make(map[string][]int), byReceipt: make(map[string][]int)}
+}
+
+// OnAppend calls fn with every transaction recorded from now on, after the observers added before it. It is called
+// under the ledger's write lock, so it sees transactions in ID order and must not call back into the ledger.
+func (l *Ledger) OnAppend(fn func(Transaction)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.observers = append(l.observers, fn)
+}
+
+// Replicate records a transaction copied from another ledger, keeping its ID and timestamp. Transactions already
+// recorded are ignored, so a replica can safely be sent the same transaction twice, but one that skips an ID is
+// rejected.
+func (l *Ledger) Replicate(transaction Transaction) error {
+	if err := checkBalanced(transaction.Postings); err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	next := int64(len(l.transactions)) + 1
+	if transaction.ID < next {
+		return nil
+	}
+	if transaction.ID > next {
+		return errors.Errorf("Replicate: expected transaction %d, got %d", next, transaction.ID)
+	}
+	l.append(transaction)
+	return nil
+}
+
+// Reset empties the ledger, e.g. before a replica copies its leader's ledger again. Observers are not told.
+func (l *Ledger) Reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.transactions = nil
+	l.balances = make(map[string]int)
+	l.byAccount = make(map[string][]int)
+	l.byReceipt = make(map[string][]int)
+}
+
+// Post records a balanced transaction, assigning it the next ID and a timestamp if one is not set.
+func (l *Ledger) Post(transaction Transaction) (Transaction, error) {
+	if err := checkBalanced(transaction.Postings); err != nil {
+		return Transaction{}, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.append(transaction), nil
+}
+
+// PostWithinBalance records a balanced transaction only if it leaves the guarded account with a non-negative balance.
+// The check and the append happen under the same lock, so concurrent debits can never overdraw the account.
+func (l *Ledger) PostWithinBalance(transaction Transaction, guardedAccount string) (Transaction, error) {
+	if err := checkBalanced(transaction.Postings); err != nil {
+		return Transaction{}, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	balance := l.balance(guardedAccount)
+	if balance+transaction.Amount(guardedAccount) < 0 {
+		return Transaction{}, errors.Wrapf(ErrInsufficientBalance, "%s holds %d", guardedAccount, balance)
+	}
+	return l.append(transaction), nil
+}
+
+// PostFor builds and records a transaction from the current history of an account under the ledger's write lock,
+// so nothing can change the account between the decision and the posting. The build function returns nil when
+// there is nothing to post.
+func (l *Ledger) PostFor(account string, build func(history []Transaction) *Transaction) (*Transaction, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	transaction := build(l.accountTransactions(account))
+	if transaction == nil {
+		return nil, nil
+	}
+	if err := checkBalanced(transaction.Postings); err != nil {
+		return nil, err
+	}
+	posted := l.append(*transaction)
+	return &posted, nil
+}
+
+// append records a transaction. The caller must hold the write lock.
+func (l *Ledger) append(transaction Transaction) Transaction {
+	transaction.ID = int64(len(l.transactions)) + 1
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = l.clock.Now().UTC()
+	}
+	transaction.Postings = append([]Posting(nil), transaction.Postings...)
+	position := len(l.transactions)
+	l.transactions = append(l.transactions, transaction)
+	for i, posting := range transaction.Postings {
+		l.balances[posting.Account] += posting.Amount
+		if !postedBefore(transaction.Postings[:i], posting.Account) {
+			l.byAccount[posting.Account] = append(l.byAccount[posting.Account], position)
+		}
+	}
+	if transaction.ReceiptID != "" {
+		l.byReceipt[transaction.ReceiptID] = append(l.byReceipt[transaction.ReceiptID], position)
+	}
+	for _, observer := range l.observers {
+		observer(transaction)
+	}
+	return transaction
+}
+
+// Balance returns the current balance of an account.
+func (l *Ledger) Balance(account string) int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.balance(account)
+}
+
+// balance returns the running balance of an account. The caller must hold the lock.
+func (l *Ledger) balance(account string) int {
+	return l.balances[account]
+}
+
+// AccountTransactions returns a copy of every transaction that touched the given account, oldest first.
+func (l *Ledger) AccountTransactions(account string) []Transaction {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.accountTransactions(account)
+}
+
+// accountTransactions collects the transactions that touched an account. The caller must hold the lock.
+func (l *Ledger) accountTransactions(account string) []Transaction {
+	return l.at(l.byAccount[account])
+}
+
+// at returns the transactions at the given positions. The caller must hold the lock.
+func (l *Ledger) at(positions []int) []Transaction {
+	var transactions []Transaction
+	for _, position := range positions {
+		transactions = append(transactions, l.transactions[position])
+	}
+	return transactions
+}
+
+// Transactions returns a copy of every transaction the match function accepts, oldest first.
+func (l *Ledger) Transactions(match func(Transaction) bool) []Transaction {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	var transactions []Transaction
+	for _, transaction := range l.transactions {
+		if match(transaction) {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions
+}
+
+// LastID returns the ID of the newest transaction, or 0 when the ledger is empty.
+func (l *Ledger) LastID() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return int64(len(l.transactions))
+}
+
+// Accounts returns every account that has ever been posted to, sorted by name.
+func (l *Ledger) Accounts() []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	accounts := make([]string, 0, len(l.byAccount))
+	for account := range l.byAccount {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// ReceiptBalance returns the net points the given receipt currently holds in the given account.
+func (l *Ledger) ReceiptBalance(receiptID string, account string) int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	balance := 0
+	for _, position := range l.byReceipt[receiptID] {
+		balance += l.transactions[position].Amount(account)
+	}
+	return balance
+}
+
+// postedBefore reports whether any of the postings is to the given account.
+func postedBefore(postings []Posting, account string) bool {
+	for _, posting := range postings {
+		if posting.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBalanced verifies that a transaction has postings and that they sum to zero.
+func checkBalanced(postings []Posting) error {
+	if len(postings) == 0 {
+		return errors.Wrap(ErrUnbalanced, "no postings")
+	}
+	sum := 0
+	for _, posting := range postings {
+		if posting.Account == "" {
+			return errors.New("posting has no account")
+		}
+		sum += posting.Amount
+	}
+	if sum != 0 {
+		return errors.Wrapf(ErrUnbalanced, "postings sum to %d", sum)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// TestPostRejectsUnbalancedTransactions tests that the ledger only accepts postings that sum to zero.
+func TestPostRejectsUnbalancedTransactions(t *testing.T) {
+	l := New()
+	_, err := l.Post(Transaction{Kind: KindAdjust, Postings: []Posting{{Account: UserAccount("alice"), Amount: 10}}})
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Fatalf("Post() expected ErrUnbalanced, got %v", err)
+	}
+	if balance := l.Balance(UserAccount("alice")); balance != 0 {
+		t.Errorf("Balance() got = %d, expected 0", balance)
+	}
+}
+
+// TestBalancesAreDerivedFromPostings tests that balances stay consistent under concurrent posting.
+func TestBalancesAreDerivedFromPostings(t *testing.T) {
+	l := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Post(Transaction{Kind: KindEarn, Postings: Transfer(IssuedAccount, UserAccount("alice"), 3)}); err != nil {
+				t.Errorf("Post() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := l.Post(Transaction{Kind: KindRedeem, Postings: Transfer(UserAccount("alice"), RedeemedAccount, 50)}); err != nil {
+		t.Fatalf("Post() failed: %v", err)
+	}
+
+	if balance := l.Balance(UserAccount("alice")); balance != 250 {
+		t.Errorf("Balance(alice) got = %d, expected 250", balance)
+	}
+	total := l.Balance(IssuedAccount) + l.Balance(RedeemedAccount) + l.Balance(UserAccount("alice"))
+	if total != 0 {
+		t.Errorf("sum of all balances got = %d, expected 0", total)
+	}
+	if entries := l.AccountTransactions(UserAccount("alice")); len(entries) != 101 {
+		t.Errorf("AccountTransactions(alice) got %d entries, expected 101", len(entries))
+	}
+}
+
+// TestIndexesMatchTheJournal tests that the running balances and the account and receipt indexes agree with the
+// journal, including transactions that post to one account twice, and that Reset clears them.
+func TestIndexesMatchTheJournal(t *testing.T) {
+	l := New()
+	alice, held := UserAccount("alice"), HeldAccount("alice")
+	if _, err := l.Post(Transaction{Kind: KindEarn, ReceiptID: "r1", Postings: Transfer(IssuedAccount, held, 40)}); err != nil {
+		t.Fatalf("Post() failed: %v", err)
+	}
+	if err := l.Replicate(Transaction{ID: 2, Kind: KindRelease, ReceiptID: "r1", Postings: Transfer(held, alice, 40)}); err != nil {
+		t.Fatalf("Replicate() failed: %v", err)
+	}
+	split := []Posting{{Account: alice, Amount: -10}, {Account: alice, Amount: -5}, {Account: RedeemedAccount, Amount: 15}}
+	if _, err := l.PostWithinBalance(Transaction{Kind: KindRedeem, Postings: split}, alice); err != nil {
+		t.Fatalf("PostWithinBalance() failed: %v", err)
+	}
+
+	if balance := l.Balance(alice); balance != 25 {
+		t.Errorf("Balance(alice) got = %d, expected 25", balance)
+	}
+	if entries := l.AccountTransactions(alice); len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+		t.Errorf("AccountTransactions(alice) got %+v, expected transactions 2 and 3 once each", entries)
+	}
+	if balance := l.ReceiptBalance("r1", held); balance != 0 {
+		t.Errorf("ReceiptBalance(r1, held) got = %d, expected 0", balance)
+	}
+	if balance := l.ReceiptBalance("r1", alice); balance != 40 {
+		t.Errorf("ReceiptBalance(r1, alice) got = %d, expected 40", balance)
+	}
+	if accounts := l.Accounts(); len(accounts) != 4 || accounts[0] != held {
+		t.Errorf("Accounts() got %v, expected the 4 accounts posted to, sorted", accounts)
+	}
+
+	l.Reset()
+	if l.Balance(alice) != 0 || len(l.AccountTransactions(alice)) != 0 || l.ReceiptBalance("r1", alice) != 0 || len(l.Accounts()) != 0 {
+		t.Errorf("Reset() left balances or indexes behind")
+	}
+}
@@ -0,0 +1,157 @@
+package loyalty
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Purchase is the part of a receipt that cross-receipt rules look at.
+type Purchase struct {
+	ReceiptID   string
+	Retailer    string
+	PurchasedAt time.Time
+}
+
+// Before orders purchases by purchase time, breaking ties by receipt ID. Rules only ever see purchases in this
+// order, never in arrival order, so a user's bonuses do not depend on when their receipts were submitted.
+func (p Purchase) Before(other Purchase) bool {
+	if !p.PurchasedAt.Equal(other.PurchasedAt) {
+		return p.PurchasedAt.Before(other.PurchasedAt)
+	}
+	return p.ReceiptID < other.ReceiptID
+}
+
+// HistoryRule awards a bonus to a receipt based on the same user's earlier purchases.
+type HistoryRule interface {
+	// Name identifies the rule in a receipt's bonus breakdown.
+	Name() string
+	// Bonus returns the points the target earns. earlier holds the user's purchases that come before the
+	// target, oldest first.
+	Bonus(target Purchase, earlier []Purchase) int
+}
+
+// HistoryRules is an ordered set of cross-receipt rules.
+type HistoryRules []HistoryRule
+
+// DefaultHistoryRules rewards frequent, consistent and exploratory shopping.
+var DefaultHistoryRules = HistoryRules{
+	NthReceiptOfWeek{N: 5, Points: 50},
+	ConsecutiveDays{Days: 3, Points: 30},
+	NewRetailer{Points: 10},
+}
+
+// Score returns the bonuses the target earns from the user's history. history may be in any order and may
+// contain the target itself and later purchases; only purchases that come before the target are considered.
+func (rules HistoryRules) Score(target Purchase, history []Purchase) []models.RuleBonus {
+	var earlier []Purchase
+	for _, purchase := range history {
+		if purchase.ReceiptID != target.ReceiptID && purchase.Before(target) {
+			earlier = append(earlier, purchase)
+		}
+	}
+	sort.Slice(earlier, func(i, j int) bool { return earlier[i].Before(earlier[j]) })
+
+	var bonuses []models.RuleBonus
+	for _, rule := range rules {
+		if points := rule.Bonus(target, earlier); points != 0 {
+			bonuses = append(bonuses, models.RuleBonus{Rule: rule.Name(), Points: points})
+		}
+	}
+	return bonuses
+}
+
+// NthReceiptOfWeek awards Points to the Nth receipt in an ISO week.
+type NthReceiptOfWeek struct {
+	N      int
+	Points int
+}
+
+// Name identifies the rule.
+func (r NthReceiptOfWeek) Name() string {
+	return "nth-receipt-of-week"
+}
+
+// Bonus awards the points when exactly N-1 earlier purchases fall in the target's ISO week.
+func (r NthReceiptOfWeek) Bonus(target Purchase, earlier []Purchase) int {
+	year, week := target.PurchasedAt.ISOWeek()
+	count := 0
+	for _, purchase := range earlier {
+		if y, w := purchase.PurchasedAt.ISOWeek(); y == year && w == week {
+			count++
+		}
+	}
+	if count == r.N-1 {
+		return r.Points
+	}
+	return 0
+}
+
+// ConsecutiveDays awards Points each time a run of shopping days reaches a multiple of Days.
+// Only the first receipt of the day that completes the run earns the bonus.
+type ConsecutiveDays struct {
+	Days   int
+	Points int
+}
+
+// Name identifies the rule.
+func (r ConsecutiveDays) Name() string {
+	return "consecutive-days"
+}
+
+// Bonus counts the run of consecutive shopping days ending on the target's day.
+func (r ConsecutiveDays) Bonus(target Purchase, earlier []Purchase) int {
+	shoppingDays := make(map[string]bool)
+	for _, purchase := range earlier {
+		shoppingDays[purchase.PurchasedAt.Format(time.DateOnly)] = true
+	}
+	if shoppingDays[target.PurchasedAt.Format(time.DateOnly)] {
+		return 0
+	}
+
+	run := 1
+	for day := target.PurchasedAt.AddDate(0, 0, -1); shoppingDays[day.Format(time.DateOnly)]; day = day.AddDate(0, 0, -1) {
+		run++
+	}
+	if r.Days > 0 && run%r.Days == 0 {
+		return r.Points
+	}
+	return 0
+}
+
+// NewRetailer awards Points to a user's first purchase at a retailer.
+type NewRetailer struct {
+	Points int
+}
+
+// Name identifies the rule.
+func (r NewRetailer) Name() string {
+	return "new-retailer"
+}
+
+// Bonus awards the points when no earlier purchase was made at the same retailer, ignoring case and spacing.
+func (r NewRetailer) Bonus(target Purchase, earlier []Purchase) int {
+	retailer := normalizeRetailer(target.Retailer)
+	for _, purchase := range earlier {
+		if normalizeRetailer(purchase.Retailer) == retailer {
+			return 0
+		}
+	}
+	return r.Points
+}
+
+// normalizeRetailer folds case and whitespace so "Target " and "target" are the same retailer.
+func normalizeRetailer(retailer string) string {
+	return strings.ToLower(strings.Join(strings.Fields(retailer), " "))
+}
+
+// TotalBonus sums a bonus breakdown.
+func TotalBonus(bonuses []models.RuleBonus) int {
+	total := 0
+	for _, bonus := range bonuses {
+		total += bonus.Points
+	}
+	return total
+}
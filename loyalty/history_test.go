@@ -0,0 +1,60 @@
+package loyalty
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// purchaseOn returns a purchase at the given day offset from a Monday.
+func purchaseOn(day int, hour int, retailer string) Purchase {
+	at := time.Date(2024, 1, 1, hour, 0, 0, 0, time.UTC).AddDate(0, 0, day)
+	return Purchase{ReceiptID: fmt.Sprintf("r-%d-%d", day, hour), Retailer: retailer, PurchasedAt: at}
+}
+
+// TestHistoryRules tests each cross-receipt rule against a small purchase history.
+func TestHistoryRules(t *testing.T) {
+	history := []Purchase{
+		purchaseOn(0, 9, "Target"),
+		purchaseOn(1, 9, "target "),
+		purchaseOn(2, 9, "Walgreens"),
+		purchaseOn(2, 18, "Target"),
+		purchaseOn(3, 9, "Target"),
+		purchaseOn(5, 9, "Target"),
+	}
+
+	testCases := []struct {
+		name     string
+		rule     HistoryRule
+		target   Purchase
+		expected int
+	}{
+		{"First visit to a retailer", NewRetailer{Points: 10}, history[0], 10},
+		{"Retailer matched ignoring case and spacing", NewRetailer{Points: 10}, history[1], 0},
+		{"Third consecutive day", ConsecutiveDays{Days: 3, Points: 30}, history[2], 30},
+		{"Second receipt on the same day", ConsecutiveDays{Days: 3, Points: 30}, history[3], 0},
+		{"Fourth consecutive day", ConsecutiveDays{Days: 3, Points: 30}, history[4], 0},
+		{"Fifth receipt of the week", NthReceiptOfWeek{N: 5, Points: 50}, history[4], 50},
+		{"Sixth receipt of the week", NthReceiptOfWeek{N: 5, Points: 50}, history[5], 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bonus := TotalBonus(HistoryRules{tc.rule}.Score(tc.target, history))
+			if bonus != tc.expected {
+				t.Errorf("Score() got %d, expected %d", bonus, tc.expected)
+			}
+		})
+	}
+}
+
+// TestScoreIgnoresArrivalOrder tests that the history order passed in does not change the result.
+func TestScoreIgnoresArrivalOrder(t *testing.T) {
+	inOrder := []Purchase{purchaseOn(0, 9, "A"), purchaseOn(1, 9, "B"), purchaseOn(2, 9, "C")}
+	shuffled := []Purchase{inOrder[2], inOrder[0], inOrder[1]}
+	for _, target := range inOrder {
+		expected := TotalBonus(DefaultHistoryRules.Score(target, inOrder))
+		if got := TotalBonus(DefaultHistoryRules.Score(target, shuffled)); got != expected {
+			t.Errorf("Score(%s) got %d with shuffled history, expected %d", target.ReceiptID, got, expected)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package loyalty
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// Tier is a loyalty level. Users qualify for a tier once they earn Threshold points inside the policy's
+// rolling window, and every receipt they submit while in the tier is multiplied by Multiplier percent.
+type Tier struct {
+	Name       string `json:"name"`       //ex. "Gold"
+	Threshold  int    `json:"threshold"`  //ex. 5000
+	Multiplier int    `json:"multiplier"` //ex. 150 for 1.5x
+}
+
+// DefaultTiers is used when no tiers are configured.
+var DefaultTiers = []Tier{
+	{Name: "Bronze", Threshold: 0, Multiplier: 100},
+	{Name: "Silver", Threshold: 1000, Multiplier: 125},
+	{Name: "Gold", Threshold: 5000, Multiplier: 150},
+}
+
+// TierPolicy evaluates a user's tier from the points they earned in a rolling window.
+type TierPolicy struct {
+	// tiers is sorted by ascending threshold and always starts at a threshold of zero.
+	tiers  []Tier
+	window time.Duration
+}
+
+// NewTierPolicy validates the tiers and returns a policy that qualifies users over the given window.
+func NewTierPolicy(tiers []Tier, window time.Duration) (*TierPolicy, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("NewTierPolicy: at least one tier is required")
+	}
+	if window <= 0 {
+		return nil, errors.New("NewTierPolicy: qualification window must be positive")
+	}
+
+	sorted := append([]Tier(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Threshold < sorted[j].Threshold })
+	if sorted[0].Threshold != 0 {
+		return nil, errors.New("NewTierPolicy: the lowest tier must have a threshold of 0")
+	}
+	for i, tier := range sorted {
+		if tier.Name == "" || tier.Multiplier <= 0 {
+			return nil, errors.Errorf("NewTierPolicy: tier %d needs a name and a positive multiplier", i)
+		}
+		if i > 0 && tier.Threshold == sorted[i-1].Threshold {
+			return nil, errors.Errorf("NewTierPolicy: tiers %q and %q share a threshold", sorted[i-1].Name, tier.Name)
+		}
+	}
+	return &TierPolicy{tiers: sorted, window: window}, nil
+}
+
+// ParseTiers reads tiers written as "Name:threshold:multiplier" pairs separated by commas,
+// e.g. "Bronze:0:100,Silver:1000:125,Gold:5000:150". An empty spec returns DefaultTiers.
+func ParseTiers(spec string) ([]Tier, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return DefaultTiers, nil
+	}
+
+	var tiers []Tier
+	for _, rawTier := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(rawTier), ":")
+		if len(parts) != 3 {
+			return nil, errors.Errorf("ParseTiers: %q is not Name:threshold:multiplier", rawTier)
+		}
+		threshold, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "ParseTiers: threshold of %q", parts[0])
+		}
+		multiplier, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "ParseTiers: multiplier of %q", parts[0])
+		}
+		tiers = append(tiers, Tier{Name: strings.TrimSpace(parts[0]), Threshold: threshold, Multiplier: multiplier})
+	}
+	return tiers, nil
+}
+
+// Evaluate returns the tier an account holds at the given time. Only points earned from receipts in the window
+// (at-window, at] qualify, net of refunds, so the result depends only on the history and the time, never on
+// when the evaluation runs. The points of a receipt held for review qualify when a review releases them.
+func (p *TierPolicy) Evaluate(account string, history []ledger.Transaction, at time.Time) Tier {
+	windowStart := at.Add(-p.window)
+	qualifying := 0
+	for _, transaction := range history {
+		switch transaction.Kind {
+		case ledger.KindEarn, ledger.KindRelease, ledger.KindRefund:
+		default:
+			continue
+		}
+		if !transaction.CreatedAt.After(windowStart) || transaction.CreatedAt.After(at) {
+			continue
+		}
+		qualifying += transaction.Amount(account)
+	}
+
+	tier := p.tiers[0]
+	for _, candidate := range p.tiers[1:] {
+		if qualifying >= candidate.Threshold {
+			tier = candidate
+		}
+	}
+	return tier
+}
+
+// ApplyMultiplier scales points by a percentage multiplier, rounding down. A zero multiplier means 100%.
+func ApplyMultiplier(points int, multiplier int) int {
+	if multiplier == 0 {
+		return points
+	}
+	return points * multiplier / 100
+}
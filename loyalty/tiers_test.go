@@ -0,0 +1,89 @@
+package loyalty
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// TestEvaluateUsesRollingWindow tests that only earnings inside the window count towards a tier.
+func TestEvaluateUsesRollingWindow(t *testing.T) {
+	policy, err := NewTierPolicy(DefaultTiers, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewTierPolicy() failed: %v", err)
+	}
+	alice := ledger.UserAccount("alice")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := []ledger.Transaction{
+		{Kind: ledger.KindEarn, CreatedAt: start, Postings: ledger.Transfer(ledger.IssuedAccount, alice, 4000)},
+		{Kind: ledger.KindEarn, CreatedAt: start.AddDate(0, 0, 20), Postings: ledger.Transfer(ledger.IssuedAccount, alice, 1500)},
+		{Kind: ledger.KindAdjust, CreatedAt: start.AddDate(0, 0, 21), Postings: ledger.Transfer(ledger.IssuedAccount, alice, 9000)},
+		{Kind: ledger.KindRefund, CreatedAt: start.AddDate(0, 0, 22), Postings: ledger.Transfer(alice, ledger.IssuedAccount, 600)},
+	}
+
+	testCases := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{"Before any earnings", start.Add(-time.Hour), "Bronze"},
+		{"After the first receipt", start.Add(time.Hour), "Silver"},
+		{"After the second receipt", start.AddDate(0, 0, 20), "Gold"},
+		{"After the refund", start.AddDate(0, 0, 23), "Silver"},
+		{"On the last day the first receipt qualifies", start.AddDate(0, 0, 30).Add(-time.Second), "Silver"},
+		{"After the first receipt leaves the window", start.AddDate(0, 0, 30), "Bronze"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tier := policy.Evaluate(alice, history, tc.at); tier.Name != tc.expected {
+				t.Errorf("Evaluate() got %q, expected %q", tier.Name, tc.expected)
+			}
+		})
+	}
+}
+
+// TestEvaluateCountsReleasedPoints tests that the points of a receipt held for review qualify once they are released
+// to the user, and not while they are held.
+func TestEvaluateCountsReleasedPoints(t *testing.T) {
+	policy, err := NewTierPolicy(DefaultTiers, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewTierPolicy() failed: %v", err)
+	}
+	alice, held := ledger.UserAccount("alice"), ledger.HeldAccount("alice")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := []ledger.Transaction{
+		{Kind: ledger.KindEarn, ReceiptID: "r1", CreatedAt: start, Postings: ledger.Transfer(ledger.IssuedAccount, held, 1200)},
+		{Kind: ledger.KindRelease, ReceiptID: "r1", CreatedAt: start.AddDate(0, 0, 2), Postings: ledger.Transfer(held, alice, 1200)},
+	}
+	if tier := policy.Evaluate(alice, history, start.AddDate(0, 0, 1)); tier.Name != "Bronze" {
+		t.Errorf("Evaluate() while the points are held got %q, expected Bronze", tier.Name)
+	}
+	if tier := policy.Evaluate(alice, history, start.AddDate(0, 0, 2)); tier.Name != "Silver" {
+		t.Errorf("Evaluate() after the release got %q, expected Silver", tier.Name)
+	}
+}
+
+// TestParseTiers tests reading tiers from configuration.
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("Gold:500:200, Basic:0:100")
+	if err != nil {
+		t.Fatalf("ParseTiers() failed: %v", err)
+	}
+	policy, err := NewTierPolicy(tiers, time.Hour)
+	if err != nil {
+		t.Fatalf("NewTierPolicy() failed: %v", err)
+	}
+	if policy.tiers[0].Name != "Basic" || policy.tiers[1].Multiplier != 200 {
+		t.Errorf("NewTierPolicy() did not sort tiers: %+v", policy.tiers)
+	}
+
+	for _, spec := range []string{"Gold:500", "Gold:x:100"} {
+		if _, err := ParseTiers(spec); err == nil {
+			t.Errorf("ParseTiers(%q) expected an error", spec)
+		}
+	}
+	if _, err := NewTierPolicy([]Tier{{Name: "Gold", Threshold: 10, Multiplier: 100}}, time.Hour); err == nil {
+		t.Error("NewTierPolicy() without a zero threshold tier expected an error")
+	}
+}
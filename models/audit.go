@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditStatusResponse is a struct that represents how far the audit log has got and whether writing to it is failing.
+type AuditStatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	Seq               uint64     `json:"seq"`               //ex. 42, the newest record in the chain
+	LastTransactionID int64      `json:"lastTransactionId"` //ex. 42
+	LedgerLastID      int64      `json:"ledgerLastId"`      //ex. 43
+	Pending           int        `json:"pending"`           //ex. 1, transactions waiting to be written
+	Failures          int        `json:"failures"`          //ex. 3, failed writes since the server started
+	LastError         string     `json:"lastError,omitempty"`
+	LastFailure       *time.Time `json:"lastFailure,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// CSVImportReport summarizes a CSV import. Each receipt is imported or fails as a whole.
+type CSVImportReport struct {
+	Imported int                  `json:"imported"` //ex. receipts stored
+	Failed   int                  `json:"failed"`   //ex. receipts with at least one error
+	Receipts []CSVImportedReceipt `json:"receipts"`
+	Errors   []CSVRowError        `json:"errors"`
+}
+
+// CSVImportedReceipt is a receipt stored by a CSV import.
+type CSVImportedReceipt struct {
+	Key    string `json:"key"` //ex. the receipt key column, "INV-1001"
+	ID     string `json:"id"`
+	Points int    `json:"points"`           //ex. 109
+	Status string `json:"status,omitempty"` //ex. "pending_review"
+}
+
+// CSVRowError is an error in one row of a CSV import. Row counts lines as a spreadsheet does, the header being row 1.
+type CSVRowError struct {
+	Row     int    `json:"row"`               //ex. 4
+	Receipt string `json:"receipt,omitempty"` //ex. "INV-1001"
+	Column  string `json:"column,omitempty"`  //ex. "item_price"
+	Error   string `json:"error"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// PointsChange is a receipt whose points differ after a rebuild.
+type PointsChange struct {
+	ReceiptID string `json:"receiptId"`
+	Before    int    `json:"before"` //ex. 28, as recorded in the source log
+	After     int    `json:"after"`  //ex. 35, as rebuilt
+}
+
+// RebuildReport summarizes a rebuild of the store from an event log.
+type RebuildReport struct {
+	EventsRead    uint64         `json:"eventsRead"`
+	EventsWritten uint64         `json:"eventsWritten"`
+	Receipts      int            `json:"receipts"`
+	Rescored      bool           `json:"rescored"`
+	Changed       []PointsChange `json:"changed"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ReceiptFeedEvent is a struct that represents a receipt on the live receipt feed, sent as it is stored.
+type ReceiptFeedEvent struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"userId,omitempty"` //ex. "user-42"
+	Retailer     string    `json:"retailer"`         //ex. "M&M Corner Market"
+	PurchaseDate string    `json:"purchaseDate"`     //ex. "2022-03-20"
+	PurchaseTime string    `json:"purchaseTime"`     //ex. "14:33"
+	Total        string    `json:"total"`            //ex. "9.00"
+	Points       int       `json:"points"`           //ex. 109
+	Status       string    `json:"status,omitempty"` //ex. "pending_review"
+	StoredAt     time.Time `json:"storedAt"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// GraphQLRequest is a struct that represents a GraphQL request as it is posted to /graphql, or sent in the query
+// string of a GET.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`                   //ex. "{ receipt(id: \"r1_...\") { points } }"
+	OperationName string                 `json:"operationName,omitempty"` //ex. "ReceiptPoints"
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
@@ -2,12 +2,37 @@ package models
 
 // Receipt is a struct that represents a receipt.
 type Receipt struct {
-	Retailer     string `json:"retailer"`     //ex. "M&M Corner Market"
-	PurchaseDate string `json:"purchaseDate"` //ex. "2022-01-01"
-	PurchaseTime string `json:"purchaseTime"` //ex. "13:01"
+	UserID       string `json:"userId,omitempty"` //ex. "user-42", empty for anonymous receipts
+	Retailer     string `json:"retailer"`         //ex. "M&M Corner Market"
+	PurchaseDate string `json:"purchaseDate"`     //ex. "2022-01-01"
+	PurchaseTime string `json:"purchaseTime"`     //ex. "13:01"
 	Items        []Item `json:"items"`
 	Total        string `json:"total"`        //ex. "6.49"
 	Points       int    `json:"pointsEarned"` //ex. 100
+	// BasePoints, Tier and TierMultiplier record how Points was derived when the receipt was scored,
+	// so the points stay stable after the user's tier changes.
+	BasePoints     int    `json:"basePoints,omitempty"`     //ex. 80
+	Tier           string `json:"tier,omitempty"`           //ex. "Silver"
+	TierMultiplier int    `json:"tierMultiplier,omitempty"` //ex. 125 for 1.25x
+	// HistoryBonuses are the cross-receipt bonuses, such as shopping streaks, included in Points.
+	HistoryBonuses []RuleBonus `json:"historyBonuses,omitempty"`
+	// Status is empty for accepted receipts, ReceiptPendingReview while the receipt's points are held and
+	// ReceiptRejected once a review voided them.
+	Status    string   `json:"status,omitempty"`
+	Flags     []string `json:"flags,omitempty"`     //ex. "near-duplicate-of:8914691084611499817", "risk:velocity"
+	RiskScore int      `json:"riskScore,omitempty"` //ex. 60
+}
+
+// Receipt statuses.
+const (
+	ReceiptPendingReview = "pending_review"
+	ReceiptRejected      = "rejected"
+)
+
+// RuleBonus is a struct that represents the points a single scoring rule contributed to a receipt.
+type RuleBonus struct {
+	Rule   string `json:"rule"`   //ex. "consecutive-days"
+	Points int    `json:"points"` //ex. 30
 }
 
 // Item is a struct that represents an item on a receipt. It contains a short description and a price.
@@ -23,5 +48,50 @@ type PointsResponse struct {
 
 // ReceiptResponse is a struct that represents the response to a request to process a receipt. It contains the unique identifier of the receipt.
 type ReceiptResponse struct {
-	Id string `json:"id"`
+	Id     string `json:"id"`
+	Status string `json:"status,omitempty"` //ex. "pending_review"
+}
+
+// ReceiptResponseV2 is a struct that represents the version 2 response to a request to process a receipt. Besides
+// the ID it carries the points the receipt earned and its status, so clients need no second request.
+type ReceiptResponseV2 struct {
+	ID     string   `json:"id"`
+	Status string   `json:"status"` //ex. "accepted", "pending_review"
+	Points int      `json:"points"` //ex. 109
+	Flags  []string `json:"flags,omitempty"`
+}
+
+// PointsResponseV2 is a struct that represents the version 2 response to a request for points. It explains the
+// points rule by rule; the breakdown adds up to Points.
+type PointsResponseV2 struct {
+	ID             string      `json:"id"`
+	Points         int         `json:"points"`     //ex. 109
+	BasePoints     int         `json:"basePoints"` //ex. 109
+	Tier           string      `json:"tier,omitempty"`
+	TierMultiplier int         `json:"tierMultiplier,omitempty"` //ex. 125 for 1.25x
+	Status         string      `json:"status"`                   //ex. "accepted", "pending_review", "rejected"
+	Breakdown      []RuleBonus `json:"breakdown"`
+}
+
+// ReceiptAccepted is the version 2 status of a receipt whose points were awarded, which version 1 leaves empty.
+const ReceiptAccepted = "accepted"
+
+// ErrorResponse is a struct that represents the body of an error response from version 2 on.
+type ErrorResponse struct {
+	Status  int    `json:"status"`            //ex. 404
+	Error   string `json:"error"`             //ex. "Not Found"
+	Message string `json:"message,omitempty"` //ex. "FetchPoints: Receipt not found"
+}
+
+// StoredReceipt is a struct that represents a stored receipt and the ID it is stored under.
+type StoredReceipt struct {
+	ID      string  `json:"id"`
+	Receipt Receipt `json:"receipt"`
+}
+
+// ReceiptPage is a struct that represents one page of stored receipts in ID order. NextPageToken fetches the next
+// page, and is empty on the last one.
+type ReceiptPage struct {
+	Receipts      []StoredReceipt `json:"receipts"`
+	NextPageToken string          `json:"nextPageToken,omitempty"`
 }
@@ -0,0 +1,16 @@
+package models
+
+// RefundReceipt is a struct that represents a return against a previously processed receipt.
+// When Items is empty every item that has not already been returned is refunded.
+type RefundReceipt struct {
+	OriginalReceiptID string `json:"originalReceiptId"` //ex. "8914691084611499817"
+	Items             []Item `json:"items"`
+}
+
+// RefundResponse is a struct that represents the response to a refund. It contains the refund identifier,
+// the points clawed back from the original receipt (zero or negative) and the points the receipt now holds.
+type RefundResponse struct {
+	Id              string `json:"id"`
+	PointsDelta     int    `json:"pointsDelta"`
+	RemainingPoints int    `json:"remainingPoints"`
+}
@@ -0,0 +1,24 @@
+package models
+
+// Replication roles reported by the replication status endpoint.
+const (
+	// ReplicationRoleLeader accepts writes and streams them to followers.
+	ReplicationRoleLeader = "leader"
+	// ReplicationRoleFollower applies a leader's writes and only serves reads.
+	ReplicationRoleFollower = "follower"
+)
+
+// ReplicationStatusResponse describes an instance's replication role and, for a follower, how far behind it is.
+type ReplicationStatusResponse struct {
+	Role        string  `json:"role"`                  //ex. "follower"
+	Epoch       string  `json:"epoch,omitempty"`       //ex. "9f86d081884c7d65", which this instance's sequence numbers count in
+	Seq         uint64  `json:"seq"`                   //ex. 42, the newest entry in this instance's write log
+	LeaderURL   string  `json:"leaderUrl,omitempty"`   //ex. "http://leader:8080"
+	LeaderEpoch string  `json:"leaderEpoch,omitempty"` //ex. "5e884898da280471", which the leader's sequence numbers count in
+	AppliedSeq  uint64  `json:"appliedSeq,omitempty"`  //ex. 40, the newest leader entry applied
+	LeaderSeq   uint64  `json:"leaderSeq,omitempty"`   //ex. 42, the newest leader entry seen
+	LagEntries  uint64  `json:"lagEntries"`            //ex. 2
+	LagSeconds  float64 `json:"lagSeconds"`            //ex. 0.25
+	Connected   bool    `json:"connected"`             //ex. true
+	LastContact string  `json:"lastContact,omitempty"` //ex. "2024-01-01T12:00:00Z"
+}
@@ -0,0 +1,16 @@
+package models
+
+// ReviewItem is a struct that represents a receipt waiting in the manual review queue.
+type ReviewItem struct {
+	ReceiptID   string  `json:"receiptId"`
+	HeldPoints  int     `json:"heldPoints"`
+	Receipt     Receipt `json:"receipt"`
+	SubmittedAt string  `json:"submittedAt"` //ex. "2024-01-01T12:00:00Z"
+}
+
+// ReviewDecisionResponse is a struct that represents the outcome of approving or rejecting a held receipt.
+type ReviewDecisionResponse struct {
+	ReceiptID string `json:"receiptId"`
+	Status    string `json:"status"` //ex. "approved", "rejected"
+	Points    int    `json:"points"` //ex. the points released to the user, or voided
+}
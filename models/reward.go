@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Reward is a struct that represents an item in the rewards catalog that can be bought with points.
+// A nil AvailableFrom or AvailableUntil leaves that side of the availability window open.
+type Reward struct {
+	Id             string     `json:"id"`             //ex. "coffee-mug"
+	Name           string     `json:"name"`           //ex. "Fetch Coffee Mug"
+	Cost           int        `json:"cost"`           //ex. 500
+	Inventory      int        `json:"inventory"`      //ex. 25
+	AvailableFrom  *time.Time `json:"availableFrom"`  //ex. "2024-01-01T00:00:00Z"
+	AvailableUntil *time.Time `json:"availableUntil"` //ex. "2024-12-31T23:59:59Z"
+}
+
+// RedemptionRequest is a struct that represents a request to spend points on a reward.
+type RedemptionRequest struct {
+	UserID   string `json:"userId"`
+	RewardID string `json:"rewardId"`
+}
+
+// Redemption statuses.
+const (
+	RedemptionActive    = "active"
+	RedemptionCancelled = "cancelled"
+)
+
+// Redemption is a struct that represents points spent on a reward. Code is handed to the user to claim the reward.
+type Redemption struct {
+	Id          string     `json:"id"`
+	Code        string     `json:"code"` //ex. "K7QM-2XPD-9B4T"
+	UserID      string     `json:"userId"`
+	RewardID    string     `json:"rewardId"`
+	Points      int        `json:"points"`
+	Status      string     `json:"status"` //ex. "active"
+	CreatedAt   time.Time  `json:"createdAt"`
+	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// EventSinkStatus is a struct that represents how far an event sink has got through the event log.
+type EventSinkStatus struct {
+	Name        string     `json:"name"`      //ex. "nats"
+	Cursor      uint64     `json:"cursor"`    //ex. 40, the last event the sink accepted
+	LastSeq     uint64     `json:"lastSeq"`   //ex. 42, the newest event in the log
+	LagEvents   uint64     `json:"lagEvents"` //ex. 2
+	Failures    int        `json:"failures"`  //ex. 3, failed attempts since the last success
+	LastError   string     `json:"lastError,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
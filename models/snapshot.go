@@ -0,0 +1,17 @@
+package models
+
+// ImportReport summarizes a snapshot import.
+type ImportReport struct {
+	Imported     int               `json:"imported"`     //ex. receipts that were not in the store before
+	Replaced     int               `json:"replaced"`     //ex. receipts that overwrote one with the same ID
+	Skipped      int               `json:"skipped"`      //ex. receipts left alone because their ID was taken
+	Transactions int               `json:"transactions"` //ex. manual adjustments, redemptions and expiries replayed
+	Catalog      int               `json:"catalog"`      //ex. rewards and redemptions applied to the catalog
+	Rejected     []ImportRejection `json:"rejected"`
+}
+
+// ImportRejection is a snapshot record that failed validation and was not imported.
+type ImportRejection struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
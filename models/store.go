@@ -0,0 +1,29 @@
+package models
+
+// Eviction modes reported by the store stats endpoint.
+const (
+	// EvictionModeCache drops evicted receipts.
+	EvictionModeCache = "cache"
+	// EvictionModeSpill writes evicted receipts to the durable backend and reloads them on demand.
+	EvictionModeSpill = "spill"
+)
+
+// EvictionCounts counts receipts evicted from memory by reason.
+type EvictionCounts struct {
+	Capacity int64 `json:"capacity"` //ex. evicted to stay within maxEntries or maxBytes
+	Expired  int64 `json:"expired"`  //ex. evicted after being idle for the TTL
+}
+
+// StoreStatsResponse describes the receipts held in memory and the evictions so far.
+type StoreStatsResponse struct {
+	Entries    int            `json:"entries"`
+	Bytes      int64          `json:"bytes"`
+	MaxEntries int            `json:"maxEntries,omitempty"`
+	MaxBytes   int64          `json:"maxBytes,omitempty"`
+	TTL        string         `json:"ttl,omitempty"`
+	Mode       string         `json:"mode"`
+	Evictions  EvictionCounts `json:"evictions"`
+	Spilled    int64          `json:"spilled"`
+	Dropped    int64          `json:"dropped"`
+	Reloaded   int64          `json:"reloaded"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// BalanceResponse is a struct that represents the response to a request for a user's points balance.
+type BalanceResponse struct {
+	UserID  string `json:"userId"`
+	Balance int    `json:"balance"`
+	Tier    string `json:"tier,omitempty"` //ex. "Gold", empty when loyalty tiers are disabled
+}
+
+// LedgerEntry is a struct that represents one ledger transaction from the point of view of a single user.
+// Points is positive when the user's balance increased and negative when it decreased.
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"` //ex. "earn", "adjust", "redeem", "expire", "refund"
+	ReceiptID string    `json:"receiptId,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	Memo      string    `json:"memo,omitempty"`
+	Points    int       `json:"points"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// LedgerResponse is a struct that represents the response to a request for a user's ledger.
+type LedgerResponse struct {
+	UserID  string        `json:"userId"`
+	Balance int           `json:"balance"`
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// AdjustmentRequest is a struct that represents a manual correction to a user's points balance.
+type AdjustmentRequest struct {
+	Points int    `json:"points"` //ex. -25
+	Memo   string `json:"memo"`   //ex. "Goodwill credit for ticket 1234"
+}
+
+// PointsExpiration is a struct that represents a quantity of points that will expire unless spent first.
+type PointsExpiration struct {
+	Points    int       `json:"points"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ExpiringPointsResponse is a struct that represents the points a user will lose within the requested window.
+type ExpiringPointsResponse struct {
+	UserID      string             `json:"userId"`
+	Within      string             `json:"within"` //ex. "720h0m0s"
+	Total       int                `json:"total"`
+	Expirations []PointsExpiration `json:"expirations"`
+}
+
+// UserTokenResponse is a struct that represents a bearer token issued to a user.
+type UserTokenResponse struct {
+	UserID    string    `json:"userId"`
+	Token     string    `json:"token"` //ex. "u1.dXNlci00Mg.1735689600.kX2..."
+	ExpiresAt time.Time `json:"expiresAt"`
+}
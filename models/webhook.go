@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// WebhookSubscriptionRequest is a struct that represents a request to have receipt events delivered to a URL.
+// A secret is generated when none is given.
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url"`        //ex. "https://crm.example.com/hooks/receipts"
+	EventTypes []string `json:"eventTypes"` //ex. ["receipt.scored", "receipt.adjusted"], or ["*"] for every type
+	Secret     string   `json:"secret"`
+}
+
+// WebhookSubscription is a struct that represents a webhook subscription. The secret deliveries are signed with is
+// only returned when the subscription is created.
+type WebhookSubscription struct {
+	ID         string    `json:"id"` //ex. "wh_5f1c2a9e0b3d4c7a8e6f1b2c"
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is a struct that represents an event waiting to be delivered to a subscription, or one that ran
+// out of attempts.
+type WebhookDelivery struct {
+	ID             string    `json:"id"` //ex. "dlv_9a8b7c6d5e4f3a2b1c0d9e8f"
+	SubscriptionID string    `json:"subscriptionId"`
+	EventType      string    `json:"eventType"` //ex. "receipt.scored"
+	Status         string    `json:"status"`    //ex. "dead"
+	Attempts       int       `json:"attempts"`  //ex. 8
+	LastError      string    `json:"lastError,omitempty"`
+	NextAttempt    time.Time `json:"nextAttempt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
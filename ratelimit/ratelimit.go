@@ -0,0 +1,235 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// Limit is a token bucket: it refills at Rate tokens per second up to Burst tokens, and each request takes one.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit reads a limit written as "count/unit:burst", where unit is s, m or h, e.g. "10/m:5".
+// The burst defaults to the count when omitted.
+func ParseLimit(spec string) (Limit, error) {
+	spec = strings.TrimSpace(spec)
+	ratePart, burstPart, hasBurst := strings.Cut(spec, ":")
+	countPart, unitPart, found := strings.Cut(ratePart, "/")
+	if !found {
+		return Limit{}, errors.Errorf("ParseLimit: %q is not count/unit:burst", spec)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countPart))
+	if err != nil || count <= 0 {
+		return Limit{}, errors.Errorf("ParseLimit: count of %q must be a positive integer", spec)
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	unit, found := units[strings.TrimSpace(unitPart)]
+	if !found {
+		return Limit{}, errors.Errorf("ParseLimit: unit of %q must be s, m or h", spec)
+	}
+
+	burst := count
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstPart))
+		if err != nil || burst <= 0 {
+			return Limit{}, errors.Errorf("ParseLimit: burst of %q must be a positive integer", spec)
+		}
+	}
+	return Limit{Rate: float64(count) / unit.Seconds(), Burst: burst}, nil
+}
+
+// ParseRouteLimits reads per-route limits written as "METHOD /path=limit" pairs separated by semicolons,
+// e.g. "POST /receipts/process=10/m:5;POST /redemptions=5/m".
+func ParseRouteLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, rawLimit, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, errors.Errorf("ParseRouteLimits: %q is not route=limit", entry)
+		}
+		limit, err := ParseLimit(rawLimit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ParseRouteLimits: %q", route)
+		}
+		limits[strings.Join(strings.Fields(route), " ")] = limit
+	}
+	return limits, nil
+}
+
+// Bucket is the state of one token bucket. It is plain data so a shared store can persist it.
+type Bucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+	// Full is when the bucket will have refilled to its burst. From then on it is no different from a new bucket, so
+	// a store may drop it.
+	Full time.Time `json:"full"`
+}
+
+// take refills the bucket up to now and takes a token when one is available. When none is, it returns how long
+// until the next token arrives.
+func (b *Bucket) take(limit Limit, now time.Time) (bool, time.Duration) {
+	if b.Updated.IsZero() {
+		b.Tokens = float64(limit.Burst)
+	} else if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*limit.Rate)
+	}
+	b.Updated = now
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		b.Full = b.refilledAt(limit)
+		return true, 0
+	}
+	b.Full = b.refilledAt(limit)
+	wait := (1 - b.Tokens) / limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// refilledAt returns when the bucket will be full again if no more tokens are taken.
+func (b *Bucket) refilledAt(limit Limit) time.Time {
+	refill := (float64(limit.Burst) - b.Tokens) / limit.Rate
+	return b.Updated.Add(time.Duration(math.Ceil(refill * float64(time.Second))))
+}
+
+// Store holds bucket state. Instances that share a Store share their limits, so an implementation backed by a
+// shared database enforces one limit across every instance. Update must apply fn atomically for a key.
+type Store interface {
+	Update(key string, fn func(bucket *Bucket)) error
+}
+
+// memorySweepMinimum is how many buckets a MemoryStore holds before it first sweeps out full ones.
+const memorySweepMinimum = 1024
+
+// MemoryStore is a Store local to one process. Whenever it has doubled in size since it last swept, it drops the
+// buckets that have refilled, so clients that come and go do not grow it without bound.
+type MemoryStore struct {
+	buckets map[string]*Bucket
+	sweepAt int
+	lock    sync.Mutex
+}
+
+// NewMemoryStore returns an empty in-process store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*Bucket), sweepAt: memorySweepMinimum}
+}
+
+// Update applies fn to the bucket for key under the store's lock.
+func (s *MemoryStore) Update(key string, fn func(bucket *Bucket)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bucket, found := s.buckets[key]
+	if !found {
+		bucket = &Bucket{}
+		s.buckets[key] = bucket
+	}
+	fn(bucket)
+	if len(s.buckets) >= s.sweepAt {
+		s.sweep(bucket.Updated)
+	}
+	return nil
+}
+
+// sweep drops the buckets that are full by now. Waiting for the store to double between sweeps keeps their cost
+// constant per update. The caller must hold the lock.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !bucket.Full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.sweepAt = max(2*len(s.buckets), memorySweepMinimum)
+}
+
+// Len returns how many buckets the store holds.
+func (s *MemoryStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.buckets)
+}
+
+// Limiter enforces per-route token bucket limits keyed by client.
+type Limiter struct {
+	store        Store
+	clock        clock.Clock
+	defaultLimit *Limit
+	routeLimits  map[string]Limit
+}
+
+// NewLimiter returns a limiter. Routes without their own limit use defaultLimit, or are unlimited when it is nil.
+func NewLimiter(store Store, c clock.Clock, defaultLimit *Limit, routeLimits map[string]Limit) *Limiter {
+	return &Limiter{store: store, clock: c, defaultLimit: defaultLimit, routeLimits: routeLimits}
+}
+
+// Allow takes a token for a request from the client with the given key, see ClientKey, on the given route, e.g.
+// "POST /receipts/process". It returns whether the request may proceed and, if not, how long the client should wait.
+// Store failures let the request through.
+func (l *Limiter) Allow(route string, clientKey string) (bool, time.Duration, error) {
+	limit, found := l.routeLimits[route]
+	if !found {
+		if l.defaultLimit == nil {
+			return true, 0, nil
+		}
+		limit = *l.defaultLimit
+	}
+
+	var allowed bool
+	var retryAfter time.Duration
+	now := l.clock.Now()
+	err := l.store.Update(route+"\x00"+clientKey, func(bucket *Bucket) {
+		allowed, retryAfter = bucket.take(limit, now)
+	})
+	if err != nil {
+		return true, 0, errors.Wrap(err, "Allow")
+	}
+	return allowed, retryAfter, nil
+}
+
+// ClientKey identifies who a request is counted against: the caller's verified identity, e.g. the client of a valid
+// API key or the user of a valid token, else the IP address it came from. The identity must have been verified: a header anyone can set, such as
+// X-User-ID, would let a client draw on a fresh bucket with every request, or exhaust another user's.
+func ClientKey(identity string, remoteAddr string) string {
+	if identity != "" {
+		return "id:" + identity
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// ParseAPIKeys reads API keys written as "client=key" pairs separated by commas, e.g. "kiosk=k1,partner=k2", and
+// returns the client each key belongs to. Requests presenting a key are counted against its client.
+func ParseAPIKeys(spec string) (map[string]string, error) {
+	clients := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		client, key, found := strings.Cut(entry, "=")
+		client, key = strings.TrimSpace(client), strings.TrimSpace(key)
+		if !found || client == "" || key == "" {
+			return nil, errors.Errorf("ParseAPIKeys: %q is not client=key", client)
+		}
+		if _, duplicate := clients[key]; duplicate {
+			return nil, errors.Errorf("ParseAPIKeys: the key of %q is already used by %q", client, clients[key])
+		}
+		clients[key] = client
+	}
+	return clients, nil
+}
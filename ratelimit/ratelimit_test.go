@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestParseLimit tests the count/unit:burst syntax.
+func TestParseLimit(t *testing.T) {
+	testCases := []struct {
+		spec      string
+		expected  Limit
+		expectErr bool
+	}{
+		{"10/s", Limit{Rate: 10, Burst: 10}, false},
+		{"30/m:5", Limit{Rate: 0.5, Burst: 5}, false},
+		{"3600/h:1", Limit{Rate: 1, Burst: 1}, false},
+		{"10", Limit{}, true},
+		{"0/s", Limit{}, true},
+		{"10/d", Limit{}, true},
+		{"10/s:0", Limit{}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			limit, err := ParseLimit(tc.spec)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("ParseLimit(%q) error = %v, expected error %v", tc.spec, err, tc.expectErr)
+			}
+			if limit != tc.expected {
+				t.Errorf("ParseLimit(%q) = %+v, expected %+v", tc.spec, limit, tc.expected)
+			}
+		})
+	}
+
+	routeLimits, err := ParseRouteLimits("POST  /receipts/process=30/m:5; GET /rewards=10/s")
+	if err != nil {
+		t.Fatalf("ParseRouteLimits() error = %v", err)
+	}
+	if len(routeLimits) != 2 || routeLimits["POST /receipts/process"].Burst != 5 || routeLimits["GET /rewards"].Rate != 10 {
+		t.Errorf("ParseRouteLimits() = %+v", routeLimits)
+	}
+
+	apiKeys, err := ParseAPIKeys(" kiosk = k1, partner=k2,")
+	if err != nil || len(apiKeys) != 2 || apiKeys["k1"] != "kiosk" || apiKeys["k2"] != "partner" {
+		t.Errorf("ParseAPIKeys() = %v, %v", apiKeys, err)
+	}
+	for _, spec := range []string{"kiosk", "kiosk=", "kiosk=k1,partner=k1"} {
+		if _, err := ParseAPIKeys(spec); err == nil {
+			t.Errorf("ParseAPIKeys(%q) should fail", spec)
+		}
+	}
+}
+
+// TestLimiterRefillsOverTime tests that a client is limited to its burst and that tokens refill with the clock.
+func TestLimiterRefillsOverTime(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := NewLimiter(NewMemoryStore(), fakeClock, nil, map[string]Limit{
+		"POST /receipts/process": {Rate: 1, Burst: 2},
+	})
+	client := ClientKey("user:alice", "10.0.0.1:1234")
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := limiter.Allow("POST /receipts/process", client); !allowed {
+			t.Fatalf("request %d was limited within the burst", i+1)
+		}
+	}
+	allowed, retryAfter, _ := limiter.Allow("POST /receipts/process", client)
+	if allowed || retryAfter != time.Second {
+		t.Fatalf("Allow() = %v, %s; expected a limit with a 1s retry", allowed, retryAfter)
+	}
+
+	fakeClock.Advance(time.Second)
+	if allowed, _, _ := limiter.Allow("POST /receipts/process", client); !allowed {
+		t.Error("request was limited after a token refilled")
+	}
+	if allowed, _, _ := limiter.Allow("GET /rewards", client); !allowed {
+		t.Error("a route without a limit was limited")
+	}
+}
+
+// TestClientsAreLimitedSeparately tests that verified identities and IP addresses each get their own bucket.
+func TestClientsAreLimitedSeparately(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), clock.NewFake(time.Now()), &Limit{Rate: 1, Burst: 1}, nil)
+
+	clients := []string{
+		ClientKey("user:alice", "10.0.0.1:1234"),
+		ClientKey("admin", "10.0.0.1:1234"),
+		ClientKey("", "10.0.0.1:1234"),
+		ClientKey("", "10.0.0.2:1234"),
+	}
+	for _, client := range clients {
+		if allowed, _, _ := limiter.Allow("POST /receipts/process", client); !allowed {
+			t.Errorf("first request from %s was limited", client)
+		}
+	}
+	if allowed, _, _ := limiter.Allow("POST /receipts/process", ClientKey("", "10.0.0.1:5678")); allowed {
+		t.Error("second request from the same IP address was not limited")
+	}
+}
+
+// TestMemoryStoreDropsFullBuckets tests that the memory store drops the buckets of clients that have gone quiet once
+// they have refilled, and keeps the buckets that have not.
+func TestMemoryStoreDropsFullBuckets(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	limiter := NewLimiter(store, fakeClock, &Limit{Rate: 1, Burst: 2}, nil)
+	exhausted := ClientKey("", "10.0.0.1:1234")
+	for i := 0; i < 2; i++ {
+		limiter.Allow("POST /receipts/process", exhausted)
+	}
+	for i := 1; i < memorySweepMinimum; i++ {
+		limiter.Allow("POST /receipts/process", ClientKey(fmt.Sprintf("user:%d", i), ""))
+	}
+	if store.Len() != memorySweepMinimum {
+		t.Fatalf("Len() = %d before any bucket refilled, expected %d", store.Len(), memorySweepMinimum)
+	}
+
+	//The quiet clients refilled after a second, the exhausted one needs two
+	fakeClock.Advance(1500 * time.Millisecond)
+	for i := 0; i < memorySweepMinimum; i++ {
+		limiter.Allow("POST /receipts/process", ClientKey(fmt.Sprintf("user:new-%d", i), ""))
+	}
+	if store.Len() != memorySweepMinimum+1 {
+		t.Errorf("Len() = %d after the sweep, expected %d", store.Len(), memorySweepMinimum+1)
+	}
+	limiter.Allow("POST /receipts/process", exhausted)
+	if allowed, _, _ := limiter.Allow("POST /receipts/process", exhausted); allowed {
+		t.Error("the exhausted client's bucket was dropped before it refilled")
+	}
+}
+
+// TestRedisStoreSharesLimits tests that limiters on different instances draw on the same buckets through Redis.
+func TestRedisStoreSharesLimits(t *testing.T) {
+	server := miniredis.RunT(t)
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	newLimiter := func() *Limiter {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewLimiter(NewRedisStore(client, "ratelimit:"), fakeClock, &Limit{Rate: 1, Burst: 2}, nil)
+	}
+	first, second := newLimiter(), newLimiter()
+	client := ClientKey("", "10.0.0.1:1234")
+
+	for i, limiter := range []*Limiter{first, second} {
+		if allowed, _, err := limiter.Allow("POST /receipts/process", client); !allowed || err != nil {
+			t.Fatalf("request %d within the shared burst got %v, %v", i+1, allowed, err)
+		}
+	}
+	if allowed, retryAfter, err := first.Allow("POST /receipts/process", client); allowed || retryAfter != time.Second || err != nil {
+		t.Errorf("Allow() after the shared burst = %v, %s, %v; expected a limit with a 1s retry", allowed, retryAfter, err)
+	}
+	if ttl := server.TTL("ratelimit:POST /receipts/process\x00" + client); ttl != redisBucketTTL {
+		t.Errorf("bucket TTL = %s, expected %s", ttl, redisBucketTTL)
+	}
+}
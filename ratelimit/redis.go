@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisBucketTTL is how long an idle bucket is kept. A bucket idle this long has refilled under any limit the
+	// API is configured with, so dropping it changes nothing.
+	redisBucketTTL = 24 * time.Hour
+	// redisUpdateAttempts bounds how often Update retries when another instance changes the bucket concurrently.
+	redisUpdateAttempts = 10
+	// redisTimeout bounds each Update, so a slow Redis delays requests by at most this long.
+	redisTimeout = time.Second
+)
+
+// RedisStore is a Store kept in Redis, so every instance pointed at the same Redis shares its limits. Buckets are
+// updated with optimistic transactions: an update that races with another instance is retried on the new state.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore returns a store that keeps buckets under keys starting with prefix, e.g. "ratelimit:".
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Update applies fn to the bucket for key inside a WATCH/MULTI/EXEC transaction.
+func (s *RedisStore) Update(key string, fn func(bucket *Bucket)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key = s.prefix + key
+	update := func(tx *redis.Tx) error {
+		var bucket Bucket
+		data, err := tx.Get(ctx, key).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(data, &bucket); err != nil {
+				return errors.Wrapf(err, "bucket %s is corrupt", key)
+			}
+		}
+
+		fn(&bucket)
+		if data, err = json.Marshal(bucket); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, redisBucketTTL)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < redisUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, update, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return errors.Wrap(err, "RedisStore.Update")
+		}
+	}
+	return errors.Errorf("RedisStore.Update: bucket %s kept changing", key)
+}
@@ -0,0 +1,102 @@
+// Package receiptcsv imports receipts from CSV spreadsheets and exports stored receipts to them. Each row of the items
+// shape is one line item, and rows are grouped into receipts by a receipt key column. The summary shape has one row
+// per receipt. Which column holds which field is configurable with a Mapping.
+package receiptcsv
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Fields a Mapping maps to columns.
+const (
+	// FieldReceipt is the receipt key that groups item rows into receipts. Exports write the receipt ID in it.
+	FieldReceipt      = "receipt"
+	FieldUserID       = "userId"
+	FieldRetailer     = "retailer"
+	FieldPurchaseDate = "purchaseDate"
+	FieldPurchaseTime = "purchaseTime"
+	FieldTotal        = "total"
+	FieldDescription  = "shortDescription"
+	FieldPrice        = "price"
+	// FieldItemCount, FieldPoints and FieldStatus are only exported. Imports ignore them and score receipts afresh.
+	FieldItemCount = "itemCount"
+	FieldPoints    = "points"
+	FieldStatus    = "status"
+)
+
+// Shapes of a CSV file.
+const (
+	// ShapeItems has one row per line item, repeating the receipt's fields on each except its points, which are only
+	// on its first row.
+	ShapeItems = "items"
+	// ShapeSummary has one row per receipt.
+	ShapeSummary = "summary"
+)
+
+// Columns of each shape, in the order they are exported.
+var (
+	itemsFields   = []string{FieldReceipt, FieldUserID, FieldRetailer, FieldPurchaseDate, FieldPurchaseTime, FieldTotal, FieldDescription, FieldPrice, FieldPoints, FieldStatus}
+	summaryFields = []string{FieldReceipt, FieldUserID, FieldRetailer, FieldPurchaseDate, FieldPurchaseTime, FieldTotal, FieldItemCount, FieldPoints, FieldStatus}
+	// requiredFields are the columns an import cannot do without. Without a user ID column receipts are anonymous.
+	requiredFields = []string{FieldReceipt, FieldRetailer, FieldPurchaseDate, FieldPurchaseTime, FieldTotal, FieldDescription, FieldPrice}
+)
+
+// Mapping maps each field to the header of the column holding it, e.g. "retailer" to "Store".
+type Mapping map[string]string
+
+// DefaultMapping returns the mapping to snake_case headers: receipt, user_id, retailer, purchase_date, purchase_time,
+// total, item_description, item_price, item_count, points and status.
+func DefaultMapping() Mapping {
+	return Mapping{
+		FieldReceipt:      "receipt",
+		FieldUserID:       "user_id",
+		FieldRetailer:     "retailer",
+		FieldPurchaseDate: "purchase_date",
+		FieldPurchaseTime: "purchase_time",
+		FieldTotal:        "total",
+		FieldDescription:  "item_description",
+		FieldPrice:        "item_price",
+		FieldItemCount:    "item_count",
+		FieldPoints:       "points",
+		FieldStatus:       "status",
+	}
+}
+
+// ParseMapping parses a comma-separated list of field=header pairs, such as "receipt=Invoice,retailer=Store", over
+// the default mapping. An empty spec is the default mapping.
+func ParseMapping(spec string) (Mapping, error) {
+	return DefaultMapping().Override(spec)
+}
+
+// Override returns a copy of the mapping with the field=header pairs of spec applied. No two fields may map to the
+// same column.
+func (mapping Mapping) Override(spec string) (Mapping, error) {
+	overridden := make(Mapping, len(mapping))
+	for field, header := range mapping {
+		overridden[field] = header
+	}
+	if strings.TrimSpace(spec) == "" {
+		return overridden, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		field, header, found := strings.Cut(pair, "=")
+		field, header = strings.TrimSpace(field), strings.TrimSpace(header)
+		if !found || header == "" {
+			return nil, errors.Errorf("Override: %q is not a field=header pair", pair)
+		}
+		if _, known := overridden[field]; !known {
+			return nil, errors.Errorf("Override: unknown field %q", field)
+		}
+		overridden[field] = header
+	}
+	fields := make(map[string]string, len(overridden))
+	for field, header := range overridden {
+		if other, taken := fields[strings.ToLower(header)]; taken {
+			return nil, errors.Errorf("Override: fields %q and %q both map to the column %q", other, field, header)
+		}
+		fields[strings.ToLower(header)] = field
+	}
+	return overridden, nil
+}
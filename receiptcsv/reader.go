@@ -0,0 +1,216 @@
+package receiptcsv
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Layouts accepted on import, besides the receipt's own, since spreadsheets reformat dates and times.
+var (
+	dateLayouts = []string{"2006-01-02", "1/2/2006", "1/2/06", "2006/01/02"}
+	timeLayouts = []string{"15:04", "15:04:05", "3:04 PM", "3:04:05 PM", "3:04PM"}
+)
+
+// Group is the rows of a CSV import that make up one receipt.
+type Group struct {
+	// Key is the value of the receipt key column.
+	Key string
+	// Row is the first row of the receipt, and ItemRows the row of each of its items.
+	Row      int
+	ItemRows []int
+	Receipt  models.Receipt
+}
+
+// Read reads receipts from a CSV file in the items shape, grouping rows by their receipt key in the order the keys
+// first appear. Rows may repeat the receipt's fields or leave them blank after the first row. A row without a
+// description or price adds no item.
+//
+// Read fails when the header lacks a required column or the file cannot be read. Errors in single rows, including
+// malformed CSV, are returned instead, and a receipt with any error is left out of the groups.
+func Read(r io.Reader, mapping Mapping) ([]Group, []models.CSVRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("Read: the file is empty")
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Read: reading the header failed")
+	}
+	columns, err := columnIndexes(header, mapping)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var groups []*Group
+	var rowErrors []models.CSVRowError
+	byKey := make(map[string]*Group)
+	failed := make(map[string]bool)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			//The reader resumes after the malformed record, so only its receipt fails, when the key was read before
+			//the error
+			rowError := models.CSVRowError{Row: parseErr.Line, Error: parseErr.Err.Error()}
+			if index, found := columns[FieldReceipt]; found && index < len(record) {
+				rowError.Receipt = strings.TrimSpace(record[index])
+				failed[rowError.Receipt] = true
+			}
+			rowErrors = append(rowErrors, rowError)
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Read: reading a row failed")
+		}
+		row, _ := reader.FieldPos(0)
+		value := func(field string) string {
+			if index, found := columns[field]; found && index < len(record) {
+				return unescapeCell(strings.TrimSpace(record[index]))
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		key := value(FieldReceipt)
+		fail := func(field string, format string, args ...interface{}) {
+			rowError := models.CSVRowError{Row: row, Receipt: key, Error: fmt.Sprintf(format, args...)}
+			if field != "" {
+				rowError.Column = mapping[field]
+			}
+			rowErrors = append(rowErrors, rowError)
+			failed[key] = true
+		}
+		if key == "" {
+			fail(FieldReceipt, "the receipt key is blank")
+			continue
+		}
+
+		group := byKey[key]
+		if group == nil {
+			group = &Group{Key: key, Row: row}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		receiptFields := []struct {
+			field     string
+			normalize func(string) (string, error)
+			value     *string
+		}{
+			{FieldUserID, nil, &group.Receipt.UserID},
+			{FieldRetailer, nil, &group.Receipt.Retailer},
+			{FieldPurchaseDate, normalizeDate, &group.Receipt.PurchaseDate},
+			{FieldPurchaseTime, normalizeTime, &group.Receipt.PurchaseTime},
+			{FieldTotal, normalizeAmount, &group.Receipt.Total},
+		}
+		for _, receiptField := range receiptFields {
+			raw := value(receiptField.field)
+			if raw == "" {
+				continue
+			}
+			if receiptField.normalize != nil {
+				if raw, err = receiptField.normalize(raw); err != nil {
+					fail(receiptField.field, "%v", err)
+					continue
+				}
+			}
+			if *receiptField.value == "" {
+				*receiptField.value = raw
+			} else if *receiptField.value != raw {
+				fail(receiptField.field, "%q differs from %q in an earlier row of the same receipt", raw, *receiptField.value)
+			}
+		}
+
+		description, rawPrice := value(FieldDescription), value(FieldPrice)
+		if description == "" && rawPrice == "" {
+			continue
+		}
+		price, err := normalizeAmount(rawPrice)
+		if err != nil {
+			fail(FieldPrice, "%v", err)
+			continue
+		}
+		group.Receipt.Items = append(group.Receipt.Items, models.Item{ShortDescription: description, Price: price})
+		group.ItemRows = append(group.ItemRows, row)
+	}
+
+	valid := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		if !failed[group.Key] {
+			valid = append(valid, *group)
+		}
+	}
+	return valid, rowErrors, nil
+}
+
+// columnIndexes finds the column of each mapped field in the header, matching headers case-insensitively.
+func columnIndexes(header []string, mapping Mapping) (map[string]int, error) {
+	indexes := make(map[string]int, len(header))
+	for index, name := range header {
+		if index == 0 {
+			//Spreadsheets often save UTF-8 with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		indexes[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	columns := make(map[string]int, len(mapping))
+	for field, name := range mapping {
+		if index, found := indexes[strings.ToLower(name)]; found {
+			columns[field] = index
+		}
+	}
+	var missing []string
+	for _, field := range requiredFields {
+		if _, found := columns[field]; !found {
+			missing = append(missing, mapping[field])
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("Read: the header lacks the columns %s", strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// normalizeDate reads a date in one of the accepted layouts and writes it as the receipt does, e.g. "2022-03-20".
+func normalizeDate(raw string) (string, error) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, raw); err == nil {
+			return date.Format("2006-01-02"), nil
+		}
+	}
+	return "", errors.Errorf("%q is not a date", raw)
+}
+
+// normalizeTime reads a time of day in one of the accepted layouts and writes it as the receipt does, e.g. "14:33".
+func normalizeTime(raw string) (string, error) {
+	for _, layout := range timeLayouts {
+		if purchaseTime, err := time.Parse(layout, strings.ToUpper(raw)); err == nil {
+			return purchaseTime.Format("15:04"), nil
+		}
+	}
+	return "", errors.Errorf("%q is not a time", raw)
+}
+
+// normalizeAmount reads an amount such as "$1,234.5" and writes it as the receipt does, e.g. "1234.50".
+func normalizeAmount(raw string) (string, error) {
+	cleaned := strings.NewReplacer("$", "", ",", "").Replace(raw)
+	value, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) || strings.ContainsAny(cleaned, "eE") {
+		return "", errors.Errorf("%q is not an amount", raw)
+	}
+	cents := int64(math.Round(value * 100))
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100), nil
+}
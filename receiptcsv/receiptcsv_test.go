@@ -0,0 +1,149 @@
+package receiptcsv
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// TestReadGroupsRows tests that rows are grouped into receipts by key, in the order the keys first appear, with
+// custom column names and the formats spreadsheets write.
+func TestReadGroupsRows(t *testing.T) {
+	mapping, err := ParseMapping("receipt=Invoice, retailer=Store, shortDescription=Item, price=Amount")
+	if err != nil {
+		t.Fatalf("ParseMapping() error = %v", err)
+	}
+	data := "\ufeffInvoice,Store,purchase_date,purchase_time,TOTAL,Item,Amount,user_id\n" +
+		"INV-2,Target,1/1/2022,1:01 PM,$6.49,Mountain Dew 12PK,6.49,\n" +
+		"INV-1,M&M Corner Market,2022-03-20,14:33,9,Gatorade,2.25,user-1\n" +
+		"\n" +
+		"INV-1,,,,,Gatorade,\"2.25\",\n" +
+		"INV-1,M&M Corner Market,2022-03-20,14:33,9.00,Gatorade,2.25,user-1\n" +
+		"INV-1,,,,,Gatorade,2.25,\n"
+	groups, rowErrors, err := Read(strings.NewReader(data), mapping)
+	if err != nil || len(rowErrors) != 0 {
+		t.Fatalf("Read() = %v, %v", rowErrors, err)
+	}
+	if len(groups) != 2 || groups[0].Key != "INV-2" || groups[1].Key != "INV-1" {
+		t.Fatalf("Read() groups = %+v, want INV-2 then INV-1", groups)
+	}
+	want := models.Receipt{
+		UserID:       "user-1",
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total: "9.00",
+	}
+	if !reflect.DeepEqual(groups[1].Receipt, want) || !reflect.DeepEqual(groups[1].ItemRows, []int{3, 5, 6, 7}) {
+		t.Errorf("Read() INV-1 = %+v, want %+v in rows 3, 5, 6 and 7", groups[1], want)
+	}
+	if groups[0].Receipt.PurchaseDate != "2022-01-01" || groups[0].Receipt.PurchaseTime != "13:01" || groups[0].Receipt.Total != "6.49" {
+		t.Errorf("Read() INV-2 = %+v", groups[0].Receipt)
+	}
+}
+
+// TestReadRowErrors tests that errors, malformed CSV included, are reported by row and column, and only fail their
+// own receipt.
+func TestReadRowErrors(t *testing.T) {
+	data := "receipt,retailer,purchase_date,purchase_time,total,item_description,item_price\n" +
+		"A,Target,2022-01-01,13:01,1.25,Pepsi,1.25\n" +
+		"B,Walgreens,2022-01-02,08:13,2.65,Pepsi,free\n" +
+		"B,Walgreens,2022-01-02,08:13,2.65,Dasani,1.40\n" +
+		"C,Target,2022-01-03,13:01,1.25,Pepsi,1.25\n" +
+		"C,Walmart,,,,,\n" +
+		",Target,2022-01-04,13:01,1.25,Pepsi,1.25\n" +
+		"D,Target,2022-01-05,13:01,1.25,Pepsi 12\"oz,1.25\n" +
+		"E,Target,2022-01-06,13:01,1.25,Pepsi,1.25\n"
+	groups, rowErrors, err := Read(strings.NewReader(data), DefaultMapping())
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(groups) != 2 || groups[0].Key != "A" || groups[1].Key != "E" {
+		t.Errorf("Read() groups = %+v, want A and E", groups)
+	}
+	want := []models.CSVRowError{
+		{Row: 3, Receipt: "B", Column: "item_price", Error: `"free" is not an amount`},
+		{Row: 6, Receipt: "C", Column: "retailer", Error: `"Walmart" differs from "Target" in an earlier row of the same receipt`},
+		{Row: 7, Column: "receipt", Error: "the receipt key is blank"},
+		{Row: 8, Receipt: "D", Error: `bare " in non-quoted-field`},
+	}
+	if !reflect.DeepEqual(rowErrors, want) {
+		t.Errorf("Read() row errors = %+v, want %+v", rowErrors, want)
+	}
+
+	if _, _, err := Read(strings.NewReader("receipt,retailer\nA,Target\n"), DefaultMapping()); err == nil || !strings.Contains(err.Error(), "item_price") {
+		t.Errorf("Read() without required columns error = %v", err)
+	}
+	if _, _, err := Read(strings.NewReader(""), DefaultMapping()); err == nil {
+		t.Error("Read() of an empty file should fail")
+	}
+}
+
+// TestWriteShapes tests both export shapes, that an items export reads back unchanged, and invalid settings.
+func TestWriteShapes(t *testing.T) {
+	stored := []models.StoredReceipt{
+		{ID: "r1_a", Receipt: models.Receipt{UserID: "user-1", Retailer: "=HYPERLINK(1)", PurchaseDate: "2022-03-20", PurchaseTime: "14:33",
+			Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}, {ShortDescription: "-Doritos", Price: "3.50"}}, Total: "5.75", Points: 28}},
+		{ID: "r1_b", Receipt: models.Receipt{Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01",
+			Items: []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}}, Total: "1.25", Points: 31, Status: models.ReceiptPendingReview}},
+	}
+	write := func(shape string) string {
+		var buffer bytes.Buffer
+		writer, err := NewWriter(&buffer, DefaultMapping(), shape)
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		for _, receipt := range stored {
+			if err := writer.Write(receipt); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+		return buffer.String()
+	}
+
+	wantSummary := "receipt,user_id,retailer,purchase_date,purchase_time,total,item_count,points,status\n" +
+		"r1_a,user-1,'=HYPERLINK(1),2022-03-20,14:33,5.75,2,28,accepted\n" +
+		"r1_b,,Target,2022-01-01,13:01,1.25,1,31,pending_review\n"
+	if summary := write(ShapeSummary); summary != wantSummary {
+		t.Errorf("summary export =\n%s\nwant\n%s", summary, wantSummary)
+	}
+
+	items := write(ShapeItems)
+	if lines := strings.Split(strings.TrimSpace(items), "\n"); len(lines) != 4 || lines[1] != "r1_a,user-1,'=HYPERLINK(1),2022-03-20,14:33,5.75,Gatorade,2.25,28,accepted" ||
+		lines[2] != "r1_a,user-1,'=HYPERLINK(1),2022-03-20,14:33,5.75,'-Doritos,3.50,,accepted" {
+		t.Errorf("items export =\n%s", items)
+	}
+	groups, rowErrors, err := Read(strings.NewReader(items), DefaultMapping())
+	if err != nil || len(rowErrors) != 0 || len(groups) != 2 {
+		t.Fatalf("Read() of the export = %+v, %v, %v", groups, rowErrors, err)
+	}
+	for i, group := range groups {
+		want := stored[i].Receipt
+		want.Points, want.Status = 0, ""
+		if group.Key != stored[i].ID || !reflect.DeepEqual(group.Receipt, want) {
+			t.Errorf("Read() of the export = %+v, want %+v", group, want)
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, DefaultMapping(), "pdf"); err == nil {
+		t.Error("NewWriter() of an unknown shape should fail")
+	}
+	if _, err := ParseMapping("total=Amount,price=amount"); err == nil {
+		t.Error("ParseMapping() of two fields in one column should fail")
+	}
+	if _, err := ParseMapping("receipt=Invoice,item_description=Item"); err == nil {
+		t.Error("ParseMapping() of an unknown field should fail")
+	}
+}
@@ -0,0 +1,123 @@
+package receiptcsv
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// formulaPrefixes are the first characters that make a spreadsheet read a cell as a formula.
+const formulaPrefixes = "=+-@\t\r"
+
+// Writer writes stored receipts as CSV in the items or summary shape. The items shape can be read back by Read.
+type Writer struct {
+	csv     *csv.Writer
+	mapping Mapping
+	fields  []string
+	shape   string
+	started bool
+}
+
+// NewWriter returns a Writer of the given shape, ShapeItems or ShapeSummary.
+func NewWriter(w io.Writer, mapping Mapping, shape string) (*Writer, error) {
+	fields := itemsFields
+	switch shape {
+	case ShapeItems:
+	case ShapeSummary:
+		fields = summaryFields
+	default:
+		return nil, errors.Errorf("NewWriter: unknown shape %q", shape)
+	}
+	return &Writer{csv: csv.NewWriter(w), mapping: mapping, fields: fields, shape: shape}, nil
+}
+
+// Write writes a stored receipt: one row per item in the items shape, with the points on the first row only, or a
+// single row in the summary shape. The header is written before the first receipt.
+func (w *Writer) Write(stored models.StoredReceipt) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	receipt := stored.Receipt
+	status := receipt.Status
+	if status == "" {
+		status = models.ReceiptAccepted
+	}
+	values := map[string]string{
+		FieldReceipt:      stored.ID,
+		FieldUserID:       receipt.UserID,
+		FieldRetailer:     receipt.Retailer,
+		FieldPurchaseDate: receipt.PurchaseDate,
+		FieldPurchaseTime: receipt.PurchaseTime,
+		FieldTotal:        receipt.Total,
+		FieldItemCount:    strconv.Itoa(len(receipt.Items)),
+		FieldPoints:       strconv.Itoa(receipt.Points),
+		FieldStatus:       status,
+	}
+	if w.shape == ShapeSummary || len(receipt.Items) == 0 {
+		return w.writeRow(values)
+	}
+	for i, item := range receipt.Items {
+		values[FieldDescription] = item.ShortDescription
+		values[FieldPrice] = item.Price
+		if i == 1 {
+			//Points belong to the receipt, so summing the column over item rows must not count them once per item
+			values[FieldPoints] = ""
+		}
+		if err := w.writeRow(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the header if no receipt was written, and flushes the buffered rows.
+func (w *Writer) Flush() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return errors.Wrap(w.csv.Error(), "Flush: writing CSV failed")
+}
+
+// start writes the header once.
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	header := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		header[i] = w.mapping[field]
+	}
+	return errors.Wrap(w.csv.Write(header), "Write: writing the header failed")
+}
+
+// writeRow writes the values of a row in column order.
+func (w *Writer) writeRow(values map[string]string) error {
+	row := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		row[i] = escapeCell(values[field])
+	}
+	return errors.Wrap(w.csv.Write(row), "Write: writing a row failed")
+}
+
+// escapeCell quotes a cell a spreadsheet would run as a formula, such as a retailer named "=HYPERLINK(...)", with a
+// leading apostrophe.
+func escapeCell(value string) string {
+	if value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCell removes the apostrophe escapeCell adds, so exports read back unchanged.
+func unescapeCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(formulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
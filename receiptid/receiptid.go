@@ -0,0 +1,89 @@
+package receiptid
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/hashstructure"
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/dedup"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// canonicalVersion names the serialization below. Changing the serialization requires a new version, and with it
+// a new ID prefix, so IDs issued under the old version are never silently reassigned.
+const canonicalVersion = "receipt/v1"
+
+// ContentPrefix starts every content-addressed ID and names the serialization it was derived from.
+const ContentPrefix = "r1_"
+
+// Canonical returns the versioned serialization that content-addressed IDs are derived from. Only the fields a
+// customer submits are included, after dedup.Canonicalize has normalized them, so server-filled fields such as
+// points and struct changes elsewhere never change an ID. Each field is on its own line in a fixed order and text
+// is quoted, so no two different receipts serialize to the same bytes.
+func Canonical(receipt models.Receipt) []byte {
+	canonical := dedup.Canonicalize(receipt)
+
+	var builder strings.Builder
+	builder.WriteString(canonicalVersion + "\n")
+	writeField(&builder, "user", canonical.UserID)
+	writeField(&builder, "retailer", canonical.Retailer)
+	writeField(&builder, "date", canonical.PurchaseDate)
+	writeField(&builder, "time", canonical.PurchaseTime)
+	writeField(&builder, "total", canonical.Total)
+	builder.WriteString("items " + strconv.Itoa(len(canonical.Items)) + "\n")
+	for _, item := range canonical.Items {
+		builder.WriteString("item " + strconv.Quote(item.ShortDescription) + " " + strconv.Quote(item.Price) + "\n")
+	}
+	return []byte(builder.String())
+}
+
+// writeField appends one quoted field line.
+func writeField(builder *strings.Builder, name string, value string) {
+	builder.WriteString(name + " " + strconv.Quote(value) + "\n")
+}
+
+// ContentID returns the receipt's URL-safe, content-addressed ID: the prefix followed by the unpadded base64url
+// SHA-256 of its canonical serialization, e.g. "r1_3q2-7wA...".
+func ContentID(receipt models.Receipt) string {
+	sum := sha256.Sum256(Canonical(receipt))
+	return ContentPrefix + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// LegacyID returns the decimal hashstructure ID that earlier versions issued for the receipt. They hashed the fields
+// exactly as submitted, before scoring, so the ID is derived from the raw fields with no points; users did not
+// exist yet. It is only used to keep old links resolvable.
+func LegacyID(receipt models.Receipt) (string, error) {
+	//hashstructure hashes type and field names as well as values, so these mirror the structs earlier versions
+	//hashed, names, field order and all, however models.Receipt changes
+	type Item struct {
+		ShortDescription string
+		Price            string
+	}
+	type Receipt struct {
+		Retailer     string
+		PurchaseDate string
+		PurchaseTime string
+		Items        []Item
+		Total        string
+		Points       int
+	}
+
+	legacy := Receipt{
+		Retailer:     receipt.Retailer,
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+		Items:        make([]Item, 0, len(receipt.Items)),
+		Total:        receipt.Total,
+	}
+	for _, item := range receipt.Items {
+		legacy.Items = append(legacy.Items, Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	hash, err := hashstructure.Hash(&legacy, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "LegacyID")
+	}
+	return strconv.FormatUint(hash, 10), nil
+}
@@ -0,0 +1,158 @@
+package receiptid
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// sampleReceipt returns a receipt to derive IDs from.
+func sampleReceipt() models.Receipt {
+	return models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}, {ShortDescription: "Dasani", Price: "1.40"}},
+		Total:        "2.65",
+	}
+}
+
+// TestCanonicalIsPinned tests that the v1 serialization does not change; changing it would reassign every ID.
+func TestCanonicalIsPinned(t *testing.T) {
+	expected := "receipt/v1\n" +
+		"user \"\"\n" +
+		"retailer \"target\"\n" +
+		"date \"2022-01-02\"\n" +
+		"time \"13:13\"\n" +
+		"total \"2.65\"\n" +
+		"items 2\n" +
+		"item \"dasani\" \"1.40\"\n" +
+		"item \"pepsi - 12-oz\" \"1.25\"\n"
+	if got := string(Canonical(sampleReceipt())); got != expected {
+		t.Errorf("Canonical() = %q, expected %q", got, expected)
+	}
+}
+
+// TestContentID tests that IDs are URL-safe and versioned, ignore cosmetic and server-filled differences, and
+// change with anything that identifies the purchase.
+func TestContentID(t *testing.T) {
+	id := ContentID(sampleReceipt())
+	if !regexp.MustCompile(`^r1_[A-Za-z0-9_-]{43}$`).MatchString(id) {
+		t.Fatalf("ContentID() = %q, expected r1_ and 43 base64url characters", id)
+	}
+
+	cosmetic := sampleReceipt()
+	cosmetic.Retailer = "  TARGET "
+	cosmetic.Items[0], cosmetic.Items[1] = cosmetic.Items[1], cosmetic.Items[0]
+	cosmetic.Points = 31
+	cosmetic.Status = models.ReceiptPendingReview
+	if ContentID(cosmetic) != id {
+		t.Error("ContentID() changed for a cosmetically different receipt")
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(*models.Receipt)
+	}{
+		{"Different user", func(r *models.Receipt) { r.UserID = "alice" }},
+		{"Different total", func(r *models.Receipt) { r.Total = "2.66" }},
+		{"Different item", func(r *models.Receipt) { r.Items[0].Price = "1.26" }},
+		{"Text moved between fields", func(r *models.Receipt) { r.Retailer = "Target\" date \"2022-01-02" }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := sampleReceipt()
+			tc.modify(&receipt)
+			if ContentID(receipt) == id {
+				t.Errorf("ContentID() did not change")
+			}
+		})
+	}
+}
+
+// TestTimeOrderedStrategies tests that UUIDv7s and ULIDs are well formed, unique for identical receipts, and sort in
+// issue order within a millisecond, across milliseconds and when the clock steps backwards.
+func TestTimeOrderedStrategies(t *testing.T) {
+	testCases := []struct {
+		strategy string
+		format   *regexp.Regexp
+		prefix   string
+	}{
+		{"uuidv7", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), "018cc251-f400-7"},
+		{"ulid", regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`), "01HK153X00"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			strategy, err := ParseStrategy(tc.strategy, fakeClock)
+			if err != nil {
+				t.Fatalf("ParseStrategy(%q) error = %v", tc.strategy, err)
+			}
+
+			var ids []string
+			for i := 0; i < 300; i++ {
+				switch i {
+				case 100:
+					fakeClock.Advance(time.Millisecond)
+				case 200:
+					fakeClock.Advance(-time.Second)
+				}
+				id, err := strategy.NewID(sampleReceipt())
+				if err != nil {
+					t.Fatalf("NewID() error = %v", err)
+				}
+				if !tc.format.MatchString(id) {
+					t.Fatalf("NewID() = %q is malformed", id)
+				}
+				ids = append(ids, id)
+			}
+			if ids[0][:len(tc.prefix)] != tc.prefix {
+				t.Errorf("NewID() = %q, expected the timestamp prefix %q", ids[0], tc.prefix)
+			}
+			if !sort.StringsAreSorted(ids) {
+				t.Error("IDs do not sort in issue order")
+			}
+			for i := 1; i < len(ids); i++ {
+				if ids[i] == ids[i-1] {
+					t.Fatalf("NewID() issued %q twice", ids[i])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseStrategy("sequential", clock.Real{}); err == nil {
+		t.Error("ParseStrategy() accepted an unknown strategy")
+	}
+}
+
+// TestLegacyIDIsPinned tests that LegacyID reproduces the IDs earlier versions issued, e.g. the one their README
+// showed for its Target receipt, so links to them keep resolving.
+func TestLegacyIDIsPinned(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+		Total: "35.35",
+	}
+	if id, err := LegacyID(receipt); err != nil || id != "1374247687664264074" {
+		t.Errorf("LegacyID() = %q, %v, expected 1374247687664264074", id, err)
+	}
+
+	//Points are filled in after the ID was issued, so they never change it
+	receipt.Points = 28
+	receipt.BasePoints = 28
+	if id, _ := LegacyID(receipt); id != "1374247687664264074" {
+		t.Errorf("LegacyID() of a scored receipt = %q, expected 1374247687664264074", id)
+	}
+}
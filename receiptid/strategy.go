@@ -0,0 +1,156 @@
+package receiptid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Strategy issues the ID a receipt is stored under.
+type Strategy interface {
+	// Name identifies the strategy in configuration.
+	Name() string
+	// NewID returns the ID for a receipt that is about to be stored.
+	NewID(receipt models.Receipt) (string, error)
+}
+
+// ParseStrategy reads a strategy from configuration: "content" (the default), "uuidv7" or "ulid".
+// Time-ordered strategies take their timestamps from the given clock.
+func ParseStrategy(name string, c clock.Clock) (Strategy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "content":
+		return ContentHash{}, nil
+	case "uuidv7":
+		return NewUUIDv7(c), nil
+	case "ulid":
+		return NewULID(c), nil
+	default:
+		return nil, errors.Errorf("ParseStrategy: unknown receipt ID strategy %q", name)
+	}
+}
+
+// ContentHash issues content-addressed IDs, so identical receipts always get the same ID.
+type ContentHash struct{}
+
+// Name identifies the strategy.
+func (ContentHash) Name() string {
+	return "content"
+}
+
+// NewID returns the receipt's ContentID.
+func (ContentHash) NewID(receipt models.Receipt) (string, error) {
+	return ContentID(receipt), nil
+}
+
+// monotonic hands out a millisecond timestamp and 80 bits of entropy. Within one millisecond, or if the clock steps
+// backwards, the entropy is incremented instead of redrawn, so IDs issued by one process always sort in issue order.
+type monotonic struct {
+	clock clock.Clock
+	// headroom masks the high entropy bits of each fresh draw so that increments cannot overflow them.
+	headroom uint16
+	lastMS   uint64
+	hi       uint16
+	lo       uint64
+	lock     sync.Mutex
+}
+
+// next returns the timestamp and entropy for the next ID.
+func (m *monotonic) next() (uint64, uint16, uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ms := uint64(m.clock.Now().UnixMilli())
+	if ms > m.lastMS {
+		var buf [10]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return 0, 0, 0, err
+		}
+		m.lastMS = ms
+		m.hi = binary.BigEndian.Uint16(buf[0:2]) & m.headroom
+		m.lo = binary.BigEndian.Uint64(buf[2:10])
+	} else {
+		m.lo++
+		if m.lo == 0 {
+			m.hi++
+		}
+	}
+	return m.lastMS, m.hi, m.lo, nil
+}
+
+// UUIDv7 issues RFC 9562 version 7 UUIDs, which sort by creation time.
+type UUIDv7 struct {
+	source *monotonic
+}
+
+// NewUUIDv7 returns a UUIDv7 strategy that timestamps IDs with the given clock.
+func NewUUIDv7(c clock.Clock) *UUIDv7 {
+	// 74 bits of a UUIDv7 are random; the top one is kept clear for increments.
+	return &UUIDv7{source: &monotonic{clock: c, headroom: 0x01FF}}
+}
+
+// Name identifies the strategy.
+func (u *UUIDv7) Name() string {
+	return "uuidv7"
+}
+
+// NewID returns a new UUIDv7 such as "018f3c2a-7b1e-7c3d-9a4f-5e6d7c8b9a0f".
+func (u *UUIDv7) NewID(models.Receipt) (string, error) {
+	ms, hi, lo, err := u.source.next()
+	if err != nil {
+		return "", errors.Wrap(err, "UUIDv7")
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[0:8], ms<<16)
+	randA := uint16(hi&0x03FF)<<2 | uint16(lo>>62)
+	id[6] = 0x70 | byte(randA>>8)
+	id[7] = byte(randA)
+	binary.BigEndian.PutUint64(id[8:16], lo&(1<<62-1))
+	id[8] |= 0x80
+
+	encoded := hex.EncodeToString(id[:])
+	return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:32], nil
+}
+
+// ULID issues Universally Unique Lexicographically Sortable Identifiers, which sort by creation time.
+type ULID struct {
+	source *monotonic
+}
+
+// NewULID returns a ULID strategy that timestamps IDs with the given clock.
+func NewULID(c clock.Clock) *ULID {
+	return &ULID{source: &monotonic{clock: c, headroom: 0x7FFF}}
+}
+
+// Name identifies the strategy.
+func (u *ULID) Name() string {
+	return "ulid"
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID returns a new 26 character ULID such as "01HZX3J8K2M4N6P8Q0R2S4T6V8".
+func (u *ULID) NewID(models.Receipt) (string, error) {
+	ms, hi, lo, err := u.source.next()
+	if err != nil {
+		return "", errors.Wrap(err, "ULID")
+	}
+
+	// The 128 bits are the 48 bit timestamp followed by the 80 bits of entropy, encoded 5 bits at a time from the end.
+	high := ms<<16 | uint64(hi)
+	low := lo
+	var id [26]byte
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = crockford[low&0x1F]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(id[:]), nil
+}
@@ -0,0 +1,379 @@
+// Package receipttext turns the raw printed text of a point-of-sale receipt into a models.Receipt, for partners that
+// cannot send structured JSON. Generic rules cover the common layouts: a retailer header, date and time lines, item
+// lines ending in a price and a TOTAL line. Templates refine the rules for known retailers.
+package receipttext
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// headerLines is how many lines at the top of a printout are searched for the retailer and template matches.
+const headerLines = 5
+
+// Field weights of the overall confidence. The amounts weigh most because they decide the points.
+var fieldWeights = map[string]float64{
+	FieldRetailer: 0.15,
+	FieldDate:     0.15,
+	FieldTime:     0.1,
+	FieldItems:    0.3,
+	FieldTotal:    0.3,
+}
+
+// Fields of a receipt a Result rates the confidence of.
+const (
+	FieldRetailer = "retailer"
+	FieldDate     = "purchaseDate"
+	FieldTime     = "purchaseTime"
+	FieldItems    = "items"
+	FieldTotal    = "total"
+)
+
+var (
+	// priceLineRegex matches a line ending in an amount, optionally with a dollar sign and a trailing tax code.
+	priceLineRegex = regexp.MustCompile(`^(.*?)\s+\$?(-?\d{1,6}\.\d{2})(\s*-)?(?:\s+[A-Z]{1,2})?$`)
+	// totalLineRegex matches the total line, but not the subtotal.
+	totalLineRegex = regexp.MustCompile(`(?i)^(?:grand\s+|order\s+)?total(?:\s+due)?\b|^(?:amount|balance)\s+due\b`)
+	subtotalRegex  = regexp.MustCompile(`(?i)^sub[\s\-]?total\b`)
+	taxRegex       = regexp.MustCompile(`(?i)^(?:sales\s+)?tax\b|^(?:hst|gst|pst|vat)\b`)
+	// paymentRegex matches the tender and change lines printed after the total.
+	paymentRegex = regexp.MustCompile(`(?i)^(?:cash|change|change\s+due|visa|mastercard|mc|amex|discover|debit|credit|tend(?:er)?|card|payment|paid|you\s+saved|savings)\b`)
+	// discountRegex matches coupons and discounts, which a receipt cannot record as items.
+	discountRegex = regexp.MustCompile(`(?i)\b(?:coupon|discount|promo|savings)\b`)
+	// quantityRegex matches a leading quantity such as "2 @ 1.25" or "2 x".
+	quantityRegex = regexp.MustCompile(`(?i)^\d+\s*(?:@\s*\$?\d+\.\d{2}|x)\s+`)
+	// skuRegex matches an item code printed before or after the description.
+	skuRegex = regexp.MustCompile(`^\d{5,}\s+|\s+\d{5,}$`)
+	// invalidDescriptionRegex matches the characters an item description may not contain.
+	invalidDescriptionRegex = regexp.MustCompile(`[^\w\s\-]+`)
+	spacesRegex             = regexp.MustCompile(`\s+`)
+	// welcomeRegex matches the greeting some retailers print before their name.
+	welcomeRegex = regexp.MustCompile(`(?i)^(?:welcome\s+to|thank\s+you\s+for\s+shopping\s+at)\s+`)
+
+	dateCandidates = []*regexp.Regexp{
+		regexp.MustCompile(`\b\d{4}-\d{1,2}-\d{1,2}\b`),
+		regexp.MustCompile(`\b\d{1,2}[/.\-]\d{1,2}[/.\-]\d{2,4}\b`),
+		regexp.MustCompile(`(?i)\b[a-z]{3,9}\.?\s+\d{1,2},?\s+\d{4}\b`),
+		regexp.MustCompile(`(?i)\b\d{1,2}\s+[a-z]{3,9}\.?,?\s+\d{4}\b`),
+	}
+	timeCandidate = regexp.MustCompile(`(?i)\b\d{1,2}:\d{2}(?::\d{2})?(?:\s*[ap]\.?m\.?)?`)
+
+	// defaultDateLayouts are tried after the template's. Ambiguous dates such as 03/04/2022 read month first, as
+	// most US printers do.
+	defaultDateLayouts = []string{
+		"2006-01-02", "2006-1-2",
+		"01/02/2006", "1/2/2006", "01/02/06", "1/2/06",
+		"01-02-2006", "1-2-2006", "01-02-06",
+		"02.01.2006", "2.1.2006", "02.01.06",
+		"Jan 2, 2006", "Jan 2 2006", "January 2, 2006", "January 2 2006", "Jan. 2, 2006",
+		"2 Jan 2006", "2 January 2006", "02 Jan 2006",
+	}
+	defaultTimeLayouts = []string{"15:04", "15:04:05", "3:04 PM", "3:04PM", "3:04:05 PM", "3:04:05PM"}
+)
+
+// Result is a receipt parsed from text, with how sure the parser is of it.
+type Result struct {
+	Receipt models.Receipt `json:"receipt"`
+	// Confidence is between 0 and 1: the weighted confidence of the fields.
+	Confidence float64 `json:"confidence"` //ex. 0.95
+	// Fields rates each field between 0, not found, and 1, found and consistent with the rest of the receipt.
+	Fields map[string]float64 `json:"fields"`
+	// Template names the template that matched, if any.
+	Template string `json:"template,omitempty"`
+	// Warnings describe what the parser skipped or guessed, e.g. a discount it could not record.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Parser parses receipt text with its templates and the generic rules. It is safe for concurrent use.
+type Parser struct {
+	templates []Template
+}
+
+// NewParser returns a Parser that tries the templates in order before falling back to the generic rules.
+func NewParser(templates ...Template) *Parser {
+	return &Parser{templates: templates}
+}
+
+// Parse parses the text of a receipt. Fields it cannot find are left empty with a confidence of 0; it only fails when
+// the text holds no receipt at all.
+func (p *Parser) Parse(text string) (Result, error) {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(spacesRegex.ReplaceAllString(line, " ")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return Result{}, errors.New("Parse: the receipt text is empty")
+	}
+
+	template := p.match(lines)
+	result := Result{Fields: make(map[string]float64, len(fieldWeights))}
+	if template != nil {
+		result.Template = template.Name
+	}
+	parseRetailer(&result, template, lines)
+	parseDateTime(&result, template, lines)
+	parseAmounts(&result, template, lines)
+
+	for field, weight := range fieldWeights {
+		result.Confidence += weight * result.Fields[field]
+	}
+	result.Confidence = math.Round(result.Confidence*100) / 100
+	return result, nil
+}
+
+// match returns the first template that matches a header line, or nil.
+func (p *Parser) match(lines []string) *Template {
+	for i := range p.templates {
+		for _, line := range lines[:min(len(lines), headerLines)] {
+			if p.templates[i].Match.MatchString(line) {
+				return &p.templates[i]
+			}
+		}
+	}
+	return nil
+}
+
+// parseRetailer takes the retailer from the template, or else from the first header line that reads like a name
+// rather than an address, phone number or date.
+func parseRetailer(result *Result, template *Template, lines []string) {
+	if template != nil && template.Retailer != "" {
+		result.Receipt.Retailer = template.Retailer
+		result.Fields[FieldRetailer] = 1
+		return
+	}
+	for _, line := range lines[:min(len(lines), headerLines)] {
+		name := strings.Trim(welcomeRegex.ReplaceAllString(line, ""), "*=-#~ ")
+		if name == "" || !strings.ContainsFunc(name, isLetter) || priceLineRegex.MatchString(name) || containsDateTime(name) {
+			continue
+		}
+		result.Receipt.Retailer = name
+		result.Fields[FieldRetailer] = 0.8
+		if strings.ContainsFunc(name, isDigit) {
+			//Likely a store number or street address rather than the name
+			result.Fields[FieldRetailer] = 0.5
+		}
+		return
+	}
+}
+
+// parseDateTime finds the first date and time printed on the receipt.
+func parseDateTime(result *Result, template *Template, lines []string) {
+	dateLayouts, timeLayouts := defaultDateLayouts, defaultTimeLayouts
+	if template != nil {
+		dateLayouts = append(append([]string(nil), template.DateLayouts...), defaultDateLayouts...)
+		timeLayouts = append(append([]string(nil), template.TimeLayouts...), defaultTimeLayouts...)
+	}
+	for _, line := range lines {
+		if result.Receipt.PurchaseDate == "" {
+			if date, certain, found := findDate(line, dateLayouts, template); found {
+				result.Receipt.PurchaseDate = date.Format("2006-01-02")
+				result.Fields[FieldDate] = 1
+				if !certain {
+					result.Fields[FieldDate] = 0.8
+					result.Warnings = append(result.Warnings, fmt.Sprintf("read the ambiguous date in %q month first", line))
+				}
+			}
+		}
+		if result.Receipt.PurchaseTime == "" {
+			if purchaseTime, found := findTime(line, timeLayouts); found {
+				result.Receipt.PurchaseTime = purchaseTime.Format("15:04")
+				result.Fields[FieldTime] = 1
+			}
+		}
+	}
+}
+
+// findDate finds a date in a line. A date is certain unless day and month could be swapped and no template fixed
+// the order.
+func findDate(line string, layouts []string, template *Template) (time.Time, bool, bool) {
+	for _, candidate := range dateCandidates {
+		for _, raw := range candidate.FindAllString(line, -1) {
+			raw = strings.Replace(raw, "Sept ", "Sep ", 1)
+			for i, layout := range layouts {
+				date, err := time.Parse(layout, raw)
+				if err != nil {
+					continue
+				}
+				fromTemplate := template != nil && i < len(template.DateLayouts)
+				ambiguous := !strings.ContainsFunc(raw, isLetter) && !strings.HasPrefix(layout, "2006") &&
+					date.Day() <= 12 && date.Day() != int(date.Month())
+				return date, fromTemplate || !ambiguous, true
+			}
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// findTime finds a time of day in a line.
+func findTime(line string, layouts []string) (time.Time, bool) {
+	for _, raw := range timeCandidate.FindAllString(line, -1) {
+		raw = strings.NewReplacer(".", "", "am", "AM", "pm", "PM", "Am", "AM", "Pm", "PM").Replace(raw)
+		for _, layout := range layouts {
+			if purchaseTime, err := time.Parse(layout, raw); err == nil {
+				return purchaseTime, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// containsDateTime tells whether a line holds a date or a time of day.
+func containsDateTime(line string) bool {
+	if timeCandidate.MatchString(line) {
+		return true
+	}
+	for _, candidate := range dateCandidates {
+		if candidate.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAmounts reads the item, subtotal, tax and total lines and rates the items and total by how well they add up.
+func parseAmounts(result *Result, template *Template, lines []string) {
+	var subtotal, tax, total int64 = -1, 0, -1
+	var itemsSum int64
+	for _, line := range lines {
+		if template != nil && ignored(template, line) {
+			continue
+		}
+		if total < 0 && template != nil && template.Total != nil {
+			if match := template.Total.FindStringSubmatch(line); match != nil {
+				total, _ = cents(match[template.Total.SubexpIndex("total")])
+				continue
+			}
+		}
+		if template != nil && template.Item != nil {
+			if match := template.Item.FindStringSubmatch(line); match != nil {
+				description := match[template.Item.SubexpIndex("description")]
+				if price, ok := cents(match[template.Item.SubexpIndex("price")]); ok && total < 0 {
+					itemsSum += addItem(result, description, price, line)
+				}
+				continue
+			}
+		}
+
+		match := priceLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		label, amount := match[1], match[2]
+		price, ok := cents(amount)
+		if !ok {
+			continue
+		}
+		if match[3] != "" {
+			//A trailing minus marks a discount or refund
+			price = -price
+		}
+		switch {
+		case subtotalRegex.MatchString(label):
+			subtotal = price
+		case taxRegex.MatchString(label):
+			tax += price
+		case totalLineRegex.MatchString(label):
+			if total < 0 {
+				total = price
+			}
+		case paymentRegex.MatchString(label) || total >= 0:
+			//Tender, change and anything else printed after the total
+		default:
+			itemsSum += addItem(result, label, price, line)
+		}
+	}
+
+	hasItems := len(result.Receipt.Items) > 0
+	switch {
+	case total < 0 && subtotal >= 0:
+		total = subtotal + tax
+		result.Warnings = append(result.Warnings, "no total line; took the subtotal plus tax")
+		result.Fields[FieldTotal] = 0.5
+	case total < 0 && hasItems:
+		total = itemsSum
+		result.Warnings = append(result.Warnings, "no total line; took the sum of the items")
+		result.Fields[FieldTotal] = 0.3
+	case total >= 0:
+		result.Fields[FieldTotal] = 0.8
+	}
+	if total >= 0 {
+		result.Receipt.Total = formatCents(total)
+	}
+	if !hasItems {
+		return
+	}
+
+	//The items are certain when they add up to the subtotal, or to the total when there is no subtotal
+	result.Fields[FieldItems] = 0.6
+	switch {
+	case subtotal >= 0 && itemsSum == subtotal:
+		result.Fields[FieldItems] = 1
+	case subtotal < 0 && itemsSum+tax == total:
+		result.Fields[FieldItems] = 1
+	default:
+		result.Warnings = append(result.Warnings, fmt.Sprintf("the items add up to %s, not the receipt's %s", formatCents(itemsSum), formatCents(max(subtotal, total-tax))))
+	}
+	if result.Fields[FieldTotal] == 0.8 && (subtotal < 0 || subtotal+tax == total) && result.Fields[FieldItems] == 1 {
+		result.Fields[FieldTotal] = 1
+	}
+}
+
+// addItem records an item line and returns the price it adds to the items. Discounts and lines without a usable
+// description add nothing but a warning.
+func addItem(result *Result, label string, price int64, line string) int64 {
+	description := quantityRegex.ReplaceAllString(label, "")
+	description = skuRegex.ReplaceAllString(description, "")
+	description = strings.TrimSpace(spacesRegex.ReplaceAllString(invalidDescriptionRegex.ReplaceAllString(description, " "), " "))
+	switch {
+	case price < 0 || discountRegex.MatchString(label):
+		result.Warnings = append(result.Warnings, fmt.Sprintf("skipped the discount %q", line))
+		return 0
+	case description == "" || !strings.ContainsFunc(description, isLetter):
+		result.Warnings = append(result.Warnings, fmt.Sprintf("skipped the line %q without a description", line))
+		return 0
+	}
+	result.Receipt.Items = append(result.Receipt.Items, models.Item{ShortDescription: description, Price: formatCents(price)})
+	return price
+}
+
+// ignored tells whether a template ignores a line.
+func ignored(template *Template, line string) bool {
+	for _, ignore := range template.Ignore {
+		if ignore.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// cents parses an amount such as "1.25" or "$1.25" into cents.
+func cents(amount string) (int64, bool) {
+	amount = strings.TrimPrefix(strings.TrimSpace(amount), "$")
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(math.Round(value * 100)), true
+}
+
+// formatCents formats cents as an amount such as "1.25".
+func formatCents(value int64) string {
+	return fmt.Sprintf("%d.%02d", value/100, value%100)
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
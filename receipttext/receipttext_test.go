@@ -0,0 +1,124 @@
+package receipttext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// cornerMarketText is the printout of the sample receipt.
+const cornerMarketText = `
+   *** M&M CORNER MARKET ***
+   123 Main St, Springfield
+   (555) 123-4567
+03/20/2022            2:33 PM
+GATORADE                 2.25 F
+GATORADE                 2.25 F
+2 @ 2.25 GATORADE        4.50 F
+COUPON GATORADE          0.50-
+SUBTOTAL                 9.00
+TAX                      0.00
+TOTAL                   $9.00
+VISA                     9.00
+CHANGE DUE               0.00
+`
+
+// TestParseGeneric tests that the generic rules read a common layout and are sure of it when the amounts add up.
+func TestParseGeneric(t *testing.T) {
+	result, err := NewParser().Parse(cornerMarketText)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := models.Receipt{
+		Retailer:     "M&M CORNER MARKET",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []models.Item{
+			{ShortDescription: "GATORADE", Price: "2.25"},
+			{ShortDescription: "GATORADE", Price: "2.25"},
+			{ShortDescription: "GATORADE", Price: "4.50"},
+		},
+		Total: "9.00",
+	}
+	if !reflect.DeepEqual(result.Receipt, want) {
+		t.Errorf("Parse() receipt = %+v, want %+v", result.Receipt, want)
+	}
+	if result.Fields[FieldItems] != 1 || result.Fields[FieldTotal] != 1 {
+		t.Errorf("Parse() fields = %v, want certain items and total", result.Fields)
+	}
+	if result.Confidence < 0.9 || result.Confidence > 1 {
+		t.Errorf("Parse() confidence = %v, want at least 0.9", result.Confidence)
+	}
+	if len(result.Warnings) == 0 || !strings.Contains(strings.Join(result.Warnings, "\n"), "COUPON") {
+		t.Errorf("Parse() warnings = %v, want the skipped coupon", result.Warnings)
+	}
+}
+
+// TestParseUncertain tests that a receipt without a total or time parses with a lower confidence.
+func TestParseUncertain(t *testing.T) {
+	result, err := NewParser().Parse("Target\nMar 20, 2022\nMilk 3.49\nBread 2.50\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if result.Receipt.Total != "5.99" || result.Receipt.PurchaseDate != "2022-03-20" || result.Receipt.PurchaseTime != "" {
+		t.Errorf("Parse() receipt = %+v", result.Receipt)
+	}
+	if result.Confidence >= 0.8 {
+		t.Errorf("Parse() confidence = %v, want below 0.8", result.Confidence)
+	}
+
+	if _, err := NewParser().Parse(" \n\n"); err == nil {
+		t.Error("Parse() of empty text should fail")
+	}
+}
+
+// TestParseTemplate tests that a template overrides the retailer, date order, item and total layouts.
+func TestParseTemplate(t *testing.T) {
+	templates, err := ParseTemplates([]byte(`[{
+		"name": "euro-mart",
+		"match": "^EUROMART #\\d+$",
+		"retailer": "EuroMart",
+		"dateLayouts": ["02/01/2006"],
+		"item": "^(?P<description>[A-Z ]+?) x\\d+ EUR (?P<price>\\d+\\.\\d{2})$",
+		"total": "^SUMME EUR (?P<total>\\d+\\.\\d{2})$",
+		"ignore": ["^BONUS"]
+	}]`))
+	if err != nil {
+		t.Fatalf("ParseTemplates() error = %v", err)
+	}
+	text := "EUROMART #12\n05/03/2022 18:05\nAPFEL x2 EUR 1.20\nBROT x1 EUR 2.30\nBONUS POINTS 3.50\nSUMME EUR 3.50\n"
+	result, err := NewParser(templates...).Parse(text)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := models.Receipt{
+		Retailer:     "EuroMart",
+		PurchaseDate: "2022-03-05",
+		PurchaseTime: "18:05",
+		Items:        []models.Item{{ShortDescription: "APFEL", Price: "1.20"}, {ShortDescription: "BROT", Price: "2.30"}},
+		Total:        "3.50",
+	}
+	if result.Template != "euro-mart" || !reflect.DeepEqual(result.Receipt, want) {
+		t.Errorf("Parse() = %+v, want %+v from euro-mart", result, want)
+	}
+	if result.Confidence != 1 {
+		t.Errorf("Parse() confidence = %v, want 1", result.Confidence)
+	}
+}
+
+// TestParseTemplatesInvalid tests that malformed templates are rejected.
+func TestParseTemplatesInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`[{"match": "x"}]`,
+		`[{"name": "a", "match": "("}]`,
+		`[{"name": "a", "match": "x", "item": "(?P<price>\\d+)"}]`,
+		`[{"name": "a", "match": "x"}, {"name": "a", "match": "y"}]`,
+	} {
+		if _, err := ParseTemplates([]byte(data)); err == nil {
+			t.Errorf("ParseTemplates(%s) should fail", data)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package receipttext
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Template describes the printout of a known retailer, whose layout the generic rules may misread. Every pattern is
+// optional; the generic rules fill in what a template leaves out.
+type Template struct {
+	// Name identifies the template in parse results, e.g. "corner-market".
+	Name string
+	// Match selects the template when it matches one of the first lines of a printout.
+	Match *regexp.Regexp
+	// Retailer is the retailer name to use, since printed headers are often abbreviated or decorated.
+	Retailer string
+	// DateLayouts and TimeLayouts are Go time layouts tried before the generic ones, e.g. "02/01/2006" for a
+	// retailer that prints the day first.
+	DateLayouts []string
+	TimeLayouts []string
+	// Item matches an item line, with the named groups "description" and "price".
+	Item *regexp.Regexp
+	// Total matches the total line, with the named group "total".
+	Total *regexp.Regexp
+	// Ignore matches lines that are neither items nor totals, such as loyalty card or survey lines.
+	Ignore []*regexp.Regexp
+}
+
+// templateSpec is the JSON form of a Template.
+type templateSpec struct {
+	Name        string   `json:"name"`
+	Match       string   `json:"match"`
+	Retailer    string   `json:"retailer"`
+	DateLayouts []string `json:"dateLayouts"`
+	TimeLayouts []string `json:"timeLayouts"`
+	Item        string   `json:"item"`
+	Total       string   `json:"total"`
+	Ignore      []string `json:"ignore"`
+}
+
+// ParseTemplates reads templates from JSON: an array of objects with "name", "match", "retailer", "dateLayouts",
+// "timeLayouts", "item", "total" and "ignore", the patterns written as Go regular expressions.
+func ParseTemplates(data []byte) ([]Template, error) {
+	var specs []templateSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, errors.Wrap(err, "ParseTemplates: unmarshaling failed")
+	}
+	templates := make([]Template, 0, len(specs))
+	names := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		if spec.Name == "" || spec.Match == "" {
+			return nil, errors.Errorf("ParseTemplates: template %d needs a name and a match pattern", i)
+		}
+		if names[spec.Name] {
+			return nil, errors.Errorf("ParseTemplates: two templates are named %q", spec.Name)
+		}
+		names[spec.Name] = true
+		template := Template{Name: spec.Name, Retailer: spec.Retailer, DateLayouts: spec.DateLayouts, TimeLayouts: spec.TimeLayouts}
+		var err error
+		if template.Match, err = compile(spec.Name, "match", spec.Match); err != nil {
+			return nil, err
+		}
+		if template.Item, err = compile(spec.Name, "item", spec.Item, "description", "price"); err != nil {
+			return nil, err
+		}
+		if template.Total, err = compile(spec.Name, "total", spec.Total, "total"); err != nil {
+			return nil, err
+		}
+		for _, raw := range spec.Ignore {
+			ignore, err := compile(spec.Name, "ignore", raw)
+			if err != nil {
+				return nil, err
+			}
+			template.Ignore = append(template.Ignore, ignore)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// LoadTemplates reads templates from a JSON file. See ParseTemplates.
+func LoadTemplates(path string) ([]Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadTemplates: reading templates failed")
+	}
+	return ParseTemplates(data)
+}
+
+// compile compiles an optional template pattern and checks that it has the named groups its field needs.
+func compile(name, field, raw string, groups ...string) (*regexp.Regexp, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseTemplates: template %q has an invalid %s pattern", name, field)
+	}
+	for _, group := range groups {
+		if pattern.SubexpIndex(group) < 0 {
+			return nil, errors.Errorf("ParseTemplates: the %s pattern of template %q needs a %q group", field, name, group)
+		}
+	}
+	return pattern, nil
+}
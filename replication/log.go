@@ -0,0 +1,211 @@
+package replication
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+// Kind describes what a write log entry changes.
+type Kind string
+
+const (
+	// KindReceipt carries the full state of a receipt after it was stored or changed.
+	KindReceipt Kind = "receipt"
+	// KindTransaction carries a ledger transaction exactly as the leader recorded it.
+	KindTransaction Kind = "transaction"
+	// KindCatalog carries the full state of a reward, a redemption or both after a catalog write.
+	KindCatalog Kind = "catalog"
+)
+
+// ReceiptState is a receipt and the items refunded against it, as of one write.
+type ReceiptState struct {
+	ID            string         `json:"id"`
+	Receipt       models.Receipt `json:"receipt"`
+	RefundedItems []models.Item  `json:"refundedItems,omitempty"`
+}
+
+// Entry is one write, numbered by its position in the log.
+type Entry struct {
+	Seq         uint64              `json:"seq"`
+	Kind        Kind                `json:"kind"`
+	At          time.Time           `json:"at"`
+	Receipt     *ReceiptState       `json:"receipt,omitempty"`
+	Transaction *ledger.Transaction `json:"transaction,omitempty"`
+	Catalog     *rewards.Change     `json:"catalog,omitempty"`
+}
+
+// Log is an append-only log of the writes made to a store. Receipt and catalog entries are whole states rather than
+// deltas, so applying the entries of a receipt in order always converges on its latest state. An in-memory log keeps
+// its entries in memory; a log backed by a file appends every entry to it as a line of NDJSON and only keeps where
+// each line starts.
+//
+// Every log has a random epoch. Sequence numbers only mean something within an epoch: a restarted leader rebuilds
+// its log from scratch, so its followers must start over when the epoch they tail changes.
+type Log struct {
+	// entries holds the entries of an in-memory log.
+	entries []Entry
+	// file holds the entries of a file-backed log, offsets where each of them starts and size where the next goes.
+	file    *os.File
+	offsets []int64
+	size    int64
+	epoch   string
+	// appended is closed and replaced on every append, waking every reader waiting for new entries.
+	appended chan struct{}
+	clock    clock.Clock
+	lock     sync.RWMutex
+}
+
+// NewLog returns an empty in-memory log that timestamps entries with the given clock.
+func NewLog(c clock.Clock) *Log {
+	return &Log{appended: make(chan struct{}), epoch: newEpoch(), clock: c}
+}
+
+// OpenLog returns an empty log backed by the file at path, creating its directory if needed. Whatever the file held
+// is discarded, since the log starts a new epoch.
+func OpenLog(path string, c clock.Clock) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenLog")
+	}
+	return &Log{file: file, appended: make(chan struct{}), epoch: newEpoch(), clock: c}, nil
+}
+
+// Append numbers and timestamps an entry and adds it to the log.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry.Seq = l.lastSeq() + 1
+	if entry.At.IsZero() {
+		entry.At = l.clock.Now().UTC()
+	}
+	if l.file != nil {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return Entry{}, errors.Wrap(err, "Append")
+		}
+		line = append(line, '\n')
+		if _, err := l.file.WriteAt(line, l.size); err != nil {
+			return Entry{}, errors.Wrap(err, "Append")
+		}
+		l.offsets = append(l.offsets, l.size)
+		l.size += int64(len(line))
+	} else {
+		l.entries = append(l.entries, entry)
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return entry, nil
+}
+
+// Since returns up to max entries after the given sequence number, and a channel that is closed when an entry is
+// appended after the ones returned.
+func (l *Log) Since(seq uint64, max int) ([]Entry, <-chan struct{}, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	last := l.lastSeq()
+	if seq >= last {
+		return nil, l.appended, nil
+	}
+	end := last
+	if max > 0 && end-seq > uint64(max) {
+		end = seq + uint64(max)
+	}
+	if l.file == nil {
+		return append([]Entry(nil), l.entries[seq:end]...), l.appended, nil
+	}
+
+	endOffset := l.size
+	if end < last {
+		endOffset = l.offsets[end]
+	}
+	data := make([]byte, endOffset-l.offsets[seq])
+	if _, err := l.file.ReadAt(data, l.offsets[seq]); err != nil {
+		return nil, nil, errors.Wrap(err, "Since")
+	}
+	entries := make([]Entry, 0, end-seq)
+	for _, line := range bytes.SplitAfter(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, nil, errors.Wrapf(err, "Since: entry %d", seq+uint64(len(entries))+1)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, l.appended, nil
+}
+
+// LastSeq returns the sequence number of the newest entry, or 0 when the log is empty.
+func (l *Log) LastSeq() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.lastSeq()
+}
+
+// lastSeq returns the sequence number of the newest entry. The caller must hold the lock.
+func (l *Log) lastSeq() uint64 {
+	if l.file != nil {
+		return uint64(len(l.offsets))
+	}
+	return uint64(len(l.entries))
+}
+
+// Epoch returns the log's epoch.
+func (l *Log) Epoch() string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.epoch
+}
+
+// Reset empties the log and starts a new epoch, so the log's own followers start over too.
+func (l *Log) Reset() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file != nil {
+		if err := l.file.Truncate(0); err != nil {
+			return errors.Wrap(err, "Reset")
+		}
+	}
+	l.entries, l.offsets, l.size = nil, nil, 0
+	l.epoch = newEpoch()
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+// Close closes the log's file.
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// newEpoch returns a random epoch.
+func newEpoch() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		//The clock is unique enough for an epoch when the system has no randomness to offer
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,78 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+)
+
+// TestLogSinceWakesReaders tests that entries are numbered in order, in memory and in a file, that readers waiting
+// past the end of the log are woken by the next append, and that a reset empties the log under a new epoch.
+func TestLogSinceWakesReaders(t *testing.T) {
+	fileLog, err := OpenLog(filepath.Join(t.TempDir(), "replication.ndjson"), clock.Real{})
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer fileLog.Close()
+	logs := map[string]*Log{"memory": NewLog(clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))), "file": fileLog}
+	for name, l := range logs {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				if _, err := l.Append(Entry{Kind: KindTransaction, Transaction: &ledger.Transaction{ID: int64(i + 1)}}); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+
+			entries, _, err := l.Since(1, 0)
+			if err != nil || len(entries) != 2 || entries[0].Seq != 2 || entries[1].Transaction.ID != 3 {
+				t.Fatalf("Since(1) = %+v, %v; expected entries 2 and 3", entries, err)
+			}
+			if entries, _, _ := l.Since(0, 1); len(entries) != 1 || entries[0].Seq != 1 {
+				t.Fatalf("Since(0, 1) = %+v, expected entry 1", entries)
+			}
+			entries, appended, _ := l.Since(3, 0)
+			if len(entries) != 0 {
+				t.Fatalf("Since(3) = %+v, expected no entries", entries)
+			}
+			l.Append(Entry{Kind: KindTransaction, Transaction: &ledger.Transaction{ID: 4}})
+			select {
+			case <-appended:
+			default:
+				t.Fatal("Append() did not wake the waiting reader")
+			}
+
+			epoch := l.Epoch()
+			if err := l.Reset(); err != nil {
+				t.Fatalf("Reset() error = %v", err)
+			}
+			if l.LastSeq() != 0 || l.Epoch() == epoch {
+				t.Errorf("after Reset() LastSeq() = %d, epoch %s; expected an empty log under a new epoch", l.LastSeq(), l.Epoch())
+			}
+			if entry, _ := l.Append(Entry{Kind: KindTransaction, Transaction: &ledger.Transaction{ID: 1}}); entry.Seq != 1 {
+				t.Errorf("Append() after Reset() got seq %d, expected 1", entry.Seq)
+			}
+		})
+	}
+}
+
+// TestStreamSendsBacklog tests that a stream starts with the entries after the requested sequence number.
+func TestStreamSendsBacklog(t *testing.T) {
+	l := NewLog(clock.Real{})
+	for i := 0; i < 3; i++ {
+		l.Append(Entry{Kind: KindTransaction, Transaction: &ledger.Transaction{ID: int64(i + 1)}})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	if err := l.Stream(ctx, &buf, cancel, 1, time.Hour); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 2 {
+		t.Errorf("Stream() wrote %d lines, expected 2:\n%s", lines, buf.String())
+	}
+}
@@ -0,0 +1,241 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// message is one NDJSON line of the stream: an entry, or a heartbeat when Entry is nil. Every line carries the
+// leader's epoch, newest sequence number and clock, so followers can tell when the leader started over and measure
+// their lag even when nothing is written.
+type message struct {
+	Entry       *Entry    `json:"entry,omitempty"`
+	LeaderEpoch string    `json:"leaderEpoch"`
+	LeaderSeq   uint64    `json:"leaderSeq"`
+	LeaderAt    time.Time `json:"leaderAt"`
+}
+
+// streamBatch caps how many entries are read from the log at once.
+const streamBatch = 256
+
+// Stream writes the entries after since to w as NDJSON, flushing after each batch, then keeps writing new entries
+// as they are appended and a heartbeat every heartbeat interval until ctx is cancelled or a write fails.
+func (l *Log) Stream(ctx context.Context, w io.Writer, flush func(), since uint64, heartbeat time.Duration) error {
+	encoder := json.NewEncoder(w)
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		entries, appended, err := l.Since(since, streamBatch)
+		if err != nil {
+			return errors.Wrap(err, "Stream")
+		}
+		//The epoch is read after the entries, so entries read just before a reset are labelled with the new epoch
+		//and the follower discards them, rather than the other way round
+		epoch := l.Epoch()
+		leaderSeq := l.LastSeq()
+		for i := range entries {
+			if err := encoder.Encode(message{Entry: &entries[i], LeaderEpoch: epoch, LeaderSeq: leaderSeq, LeaderAt: l.clock.Now().UTC()}); err != nil {
+				return errors.Wrap(err, "Stream")
+			}
+			since = entries[i].Seq
+		}
+		if len(entries) > 0 {
+			flush()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-appended:
+		case <-ticker.C:
+			if err := encoder.Encode(message{LeaderEpoch: epoch, LeaderSeq: leaderSeq, LeaderAt: l.clock.Now().UTC()}); err != nil {
+				return errors.Wrap(err, "Stream")
+			}
+			flush()
+		}
+	}
+}
+
+// Applier applies a leader's entries to a follower's store, in order. ResetReplica empties the store when the leader
+// starts a new epoch, before its entries are applied again from the first.
+type Applier interface {
+	ApplyEntry(entry Entry) error
+	ResetReplica() error
+}
+
+// Status describes how far a follower is behind its leader.
+type Status struct {
+	// LeaderEpoch is the epoch of the leader's log that AppliedSeq counts in.
+	LeaderEpoch string
+	AppliedSeq  uint64
+	LeaderSeq   uint64
+	Lag         time.Duration
+	Connected   bool
+	LastContact time.Time
+}
+
+// Follower tails a leader's stream and applies its entries. It reconnects from the last applied entry whenever the
+// stream breaks, so each entry is applied exactly once and in order.
+type Follower struct {
+	leaderURL string
+	token     string
+	applier   Applier
+	client    *http.Client
+	clock     clock.Clock
+	// retry is how long to wait before reconnecting.
+	retry time.Duration
+
+	status Status
+	// lastAppliedAt is when the leader wrote the newest applied entry.
+	lastAppliedAt time.Time
+	lock          sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewFollower returns a follower of the leader at leaderURL, authenticating with the leader's admin token.
+func NewFollower(leaderURL string, token string, applier Applier, c clock.Clock) *Follower {
+	return &Follower{leaderURL: leaderURL, token: token, applier: applier, client: &http.Client{}, clock: c, retry: time.Second}
+}
+
+// Start tails the leader in the background until Stop is called or ctx is cancelled.
+func (f *Follower) Start(ctx context.Context) {
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		for ctx.Err() == nil {
+			if err := f.tail(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("replication follower: %v", err)
+			}
+			f.setConnected(false)
+			select {
+			case <-ctx.Done():
+			case <-time.After(f.retry):
+			}
+		}
+	}()
+}
+
+// Stop stops tailing and waits until no entry is being applied, so a promoted follower never applies another one.
+func (f *Follower) Stop() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+}
+
+// Status reports the follower's progress.
+func (f *Follower) Status() Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.status
+}
+
+// tail streams from the leader until the stream breaks.
+func (f *Follower) tail(ctx context.Context) error {
+	since := f.Status().AppliedSeq
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leaderURL+"/admin/replication/stream?since="+url.QueryEscape(strconv.FormatUint(since, 10)), nil)
+	if err != nil {
+		return errors.Wrap(err, "tail")
+	}
+	request.Header.Set("X-Admin-Token", f.token)
+	response, err := f.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "tail")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.Wrap(fmt.Errorf("leader returned %s", response.Status), "tail")
+	}
+	f.setConnected(true)
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return errors.Wrap(err, "tail: decoding message failed")
+		}
+		if err := f.checkEpoch(msg.LeaderEpoch); err != nil {
+			return err
+		}
+		if msg.Entry != nil {
+			if msg.Entry.Seq != f.Status().AppliedSeq+1 {
+				return errors.Errorf("tail: expected entry %d, got %d", f.Status().AppliedSeq+1, msg.Entry.Seq)
+			}
+			if err := f.applier.ApplyEntry(*msg.Entry); err != nil {
+				return errors.Wrapf(err, "tail: applying entry %d failed", msg.Entry.Seq)
+			}
+		}
+		f.record(msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "tail")
+	}
+	return errors.New("tail: leader closed the stream")
+}
+
+// checkEpoch adopts the leader's epoch on first contact. When the leader has since started a new epoch, e.g. because it
+// restarted, the follower's state and position mean nothing any more: it empties its store and returns an error, so
+// tail reconnects and applies the new epoch from its first entry.
+func (f *Follower) checkEpoch(epoch string) error {
+	f.lock.Lock()
+	previous := f.status.LeaderEpoch
+	if previous == "" {
+		f.status.LeaderEpoch = epoch
+	}
+	f.lock.Unlock()
+	if previous == "" || previous == epoch {
+		return nil
+	}
+
+	if err := f.applier.ResetReplica(); err != nil {
+		return errors.Wrap(err, "checkEpoch: resetting the replica failed")
+	}
+	f.lock.Lock()
+	f.status = Status{LeaderEpoch: epoch, Connected: f.status.Connected}
+	f.lastAppliedAt = time.Time{}
+	f.lock.Unlock()
+	return errors.Errorf("checkEpoch: leader epoch changed from %s to %s, resynchronizing", previous, epoch)
+}
+
+// record updates the status after a message was handled.
+func (f *Follower) record(msg message) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if msg.Entry != nil {
+		f.status.AppliedSeq = msg.Entry.Seq
+		f.lastAppliedAt = msg.Entry.At
+	}
+	f.status.LeaderSeq = msg.LeaderSeq
+	f.status.LastContact = f.clock.Now().UTC()
+	f.status.Lag = 0
+	if f.status.AppliedSeq < f.status.LeaderSeq && !f.lastAppliedAt.IsZero() {
+		f.status.Lag = msg.LeaderAt.Sub(f.lastAppliedAt)
+	}
+}
+
+// setConnected records whether the follower is connected to its leader.
+func (f *Follower) setConnected(connected bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status.Connected = connected
+}
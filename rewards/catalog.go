@@ -0,0 +1,325 @@
+package rewards
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+var (
+	// ErrRewardNotFound is returned when a reward is not in the catalog.
+	ErrRewardNotFound = errors.New("reward not found")
+	// ErrRewardUnavailable is returned when a reward is outside its availability window or out of stock.
+	ErrRewardUnavailable = errors.New("reward unavailable")
+	// ErrRedemptionNotFound is returned when a redemption does not exist.
+	ErrRedemptionNotFound = errors.New("redemption not found")
+	// ErrIdempotencyConflict is returned when an idempotency key is reused for a different request.
+	ErrIdempotencyConflict = errors.New("idempotency key reused for a different request")
+)
+
+// Change is the state of a reward, a redemption or both after a catalog write. A redemption's change carries the
+// idempotency key it was made with, if any, the state of its reward, whose inventory it changed, and the ledger
+// transaction it posted.
+type Change struct {
+	Reward         *models.Reward      `json:"reward,omitempty"`
+	Redemption     *models.Redemption  `json:"redemption,omitempty"`
+	IdempotencyKey string              `json:"idempotencyKey,omitempty"`
+	Transaction    *ledger.Transaction `json:"transaction,omitempty"`
+}
+
+// Catalog holds the rewards that can be bought with points and the redemptions made against them.
+// Redemptions debit the shared ledger, so balances stay derived from a single source of truth.
+type Catalog struct {
+	rewards     map[string]*models.Reward
+	redemptions map[string]*models.Redemption
+	// idempotencyKeys maps a user's idempotency key to the redemption it created.
+	idempotencyKeys map[string]string
+	ledger          *ledger.Ledger
+	clock           clock.Clock
+	// observers see every change as it is made.
+	observers []func(Change)
+	// lock serializes catalog changes so that a redemption's checks and effects are applied atomically.
+	lock sync.Mutex
+}
+
+// NewCatalog returns an empty catalog that settles redemptions against the given ledger.
+// Availability windows are checked against the given clock.
+func NewCatalog(pointsLedger *ledger.Ledger, c clock.Clock) *Catalog {
+	return &Catalog{
+		rewards:         make(map[string]*models.Reward),
+		redemptions:     make(map[string]*models.Redemption),
+		idempotencyKeys: make(map[string]string),
+		ledger:          pointsLedger,
+		clock:           c,
+	}
+}
+
+// OnChange calls fn with every change made from now on. It is called under the catalog's lock, so it sees changes in
+// the order they were made and must not call back into the catalog.
+func (c *Catalog) OnChange(fn func(Change)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.observers = append(c.observers, fn)
+}
+
+// PutReward adds a reward to the catalog or replaces an existing one with the same ID.
+func (c *Catalog) PutReward(reward models.Reward) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rewards[reward.Id] = &reward
+	c.notify(Change{Reward: &reward})
+}
+
+// Apply records a change copied from another catalog, such as a leader's, or replayed from a record of it. It does
+// not post the change's transaction, which is copied or replayed separately.
+func (c *Catalog) Apply(change Change) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if change.Reward != nil {
+		reward := *change.Reward
+		c.rewards[reward.Id] = &reward
+	}
+	if change.Redemption != nil {
+		redemption := *change.Redemption
+		c.redemptions[redemption.Id] = &redemption
+		if change.IdempotencyKey != "" {
+			c.idempotencyKeys[scopeKey(redemption.UserID, change.IdempotencyKey)] = redemption.Id
+		}
+	}
+	c.notify(change)
+}
+
+// Changes returns the catalog's contents as the changes that rebuild it when applied to an empty catalog: every
+// reward by ID, then every redemption with the idempotency key it was made with, oldest first. They carry no
+// transactions, since the ledger holds those.
+func (c *Catalog) Changes() []Change {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make(map[string]string, len(c.idempotencyKeys))
+	for scoped, redemptionID := range c.idempotencyKeys {
+		redemption := c.redemptions[redemptionID]
+		if redemption != nil {
+			keys[redemptionID] = strings.TrimPrefix(scoped, scopeKey(redemption.UserID, ""))
+		}
+	}
+	changes := make([]Change, 0, len(c.rewards)+len(c.redemptions))
+	for _, reward := range c.rewards {
+		reward := *reward
+		changes = append(changes, Change{Reward: &reward})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Reward.Id < changes[j].Reward.Id })
+	redemptions := make([]Change, 0, len(c.redemptions))
+	for _, redemption := range c.redemptions {
+		redemption := *redemption
+		redemptions = append(redemptions, Change{Redemption: &redemption, IdempotencyKey: keys[redemption.Id]})
+	}
+	sort.Slice(redemptions, func(i, j int) bool {
+		if !redemptions[i].Redemption.CreatedAt.Equal(redemptions[j].Redemption.CreatedAt) {
+			return redemptions[i].Redemption.CreatedAt.Before(redemptions[j].Redemption.CreatedAt)
+		}
+		return redemptions[i].Redemption.Id < redemptions[j].Redemption.Id
+	})
+	return append(changes, redemptions...)
+}
+
+// Reset empties the catalog, e.g. before a replica copies its leader's catalog again.
+func (c *Catalog) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rewards = make(map[string]*models.Reward)
+	c.redemptions = make(map[string]*models.Redemption)
+	c.idempotencyKeys = make(map[string]string)
+}
+
+// notify passes copies of a change to the observers. The caller must hold the lock.
+func (c *Catalog) notify(change Change) {
+	for _, observer := range c.observers {
+		copied := change
+		if change.Reward != nil {
+			reward := *change.Reward
+			copied.Reward = &reward
+		}
+		if change.Redemption != nil {
+			redemption := *change.Redemption
+			copied.Redemption = &redemption
+		}
+		observer(copied)
+	}
+}
+
+// Rewards returns the rewards that are in stock and inside their availability window, sorted by ID.
+func (c *Catalog) Rewards() []models.Reward {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	rewards := make([]models.Reward, 0, len(c.rewards))
+	for _, reward := range c.rewards {
+		if reward.Inventory > 0 && isAvailable(reward, now) {
+			rewards = append(rewards, *reward)
+		}
+	}
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].Id < rewards[j].Id })
+	return rewards
+}
+
+// Redeem spends a user's points on a reward. The balance check, ledger debit and inventory decrement happen
+// atomically. Retrying with the same non-empty idempotency key returns the original redemption instead of
+// spending the points twice.
+func (c *Catalog) Redeem(request models.RedemptionRequest, idempotencyKey string) (models.Redemption, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	scopedKey := ""
+	if idempotencyKey != "" {
+		scopedKey = scopeKey(request.UserID, idempotencyKey)
+		if redemptionID, found := c.idempotencyKeys[scopedKey]; found {
+			redemption := c.redemptions[redemptionID]
+			if redemption.RewardID != request.RewardID {
+				return models.Redemption{}, errors.Wrap(ErrIdempotencyConflict, "Redeem")
+			}
+			return *redemption, nil
+		}
+	}
+
+	reward, found := c.rewards[request.RewardID]
+	if !found {
+		return models.Redemption{}, errors.Wrapf(ErrRewardNotFound, "Redeem: %q", request.RewardID)
+	}
+	now := c.clock.Now()
+	if reward.Inventory <= 0 || !isAvailable(reward, now) {
+		return models.Redemption{}, errors.Wrapf(ErrRewardUnavailable, "Redeem: %q", request.RewardID)
+	}
+
+	redemptionID, err := randomHex(16)
+	if err != nil {
+		return models.Redemption{}, errors.Wrap(err, "Redeem: generating redemption ID failed")
+	}
+	code, err := newRedemptionCode()
+	if err != nil {
+		return models.Redemption{}, errors.Wrap(err, "Redeem: generating redemption code failed")
+	}
+
+	userAccount := ledger.UserAccount(request.UserID)
+	transaction, err := c.ledger.PostWithinBalance(ledger.Transaction{
+		Kind:      ledger.KindRedeem,
+		Reference: redemptionID,
+		Memo:      "Redeemed " + reward.Name,
+		Postings:  ledger.Transfer(userAccount, ledger.RedeemedAccount, reward.Cost),
+		CreatedAt: now.UTC(),
+	}, userAccount)
+	if err != nil {
+		return models.Redemption{}, errors.Wrap(err, "Redeem")
+	}
+
+	reward.Inventory--
+	redemption := &models.Redemption{
+		Id:        redemptionID,
+		Code:      code,
+		UserID:    request.UserID,
+		RewardID:  reward.Id,
+		Points:    reward.Cost,
+		Status:    models.RedemptionActive,
+		CreatedAt: now.UTC(),
+	}
+	c.redemptions[redemptionID] = redemption
+	if scopedKey != "" {
+		c.idempotencyKeys[scopedKey] = redemptionID
+	}
+	c.notify(Change{Reward: reward, Redemption: redemption, IdempotencyKey: idempotencyKey, Transaction: &transaction})
+	return *redemption, nil
+}
+
+// Cancel reverses a redemption: the points are re-credited to the user and the reward goes back into inventory.
+// Cancelling an already cancelled redemption is a no-op that returns it unchanged.
+func (c *Catalog) Cancel(redemptionID string) (models.Redemption, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	redemption, found := c.redemptions[redemptionID]
+	if !found {
+		return models.Redemption{}, errors.Wrapf(ErrRedemptionNotFound, "Cancel: %q", redemptionID)
+	}
+	if redemption.Status == models.RedemptionCancelled {
+		return *redemption, nil
+	}
+
+	now := c.clock.Now().UTC()
+	transaction, err := c.ledger.Post(ledger.Transaction{
+		Kind:      ledger.KindCancel,
+		Reference: redemption.Id,
+		Memo:      "Cancelled redemption " + redemption.Code,
+		Postings:  ledger.Transfer(ledger.RedeemedAccount, ledger.UserAccount(redemption.UserID), redemption.Points),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return models.Redemption{}, errors.Wrap(err, "Cancel")
+	}
+
+	reward, found := c.rewards[redemption.RewardID]
+	if found {
+		reward.Inventory++
+	}
+	redemption.Status = models.RedemptionCancelled
+	redemption.CancelledAt = &now
+	c.notify(Change{Reward: reward, Redemption: redemption, Transaction: &transaction})
+	return *redemption, nil
+}
+
+// Redemption returns a redemption by ID.
+func (c *Catalog) Redemption(redemptionID string) (models.Redemption, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	redemption, found := c.redemptions[redemptionID]
+	if !found {
+		return models.Redemption{}, errors.Wrapf(ErrRedemptionNotFound, "Redemption: %q", redemptionID)
+	}
+	return *redemption, nil
+}
+
+// scopeKey scopes an idempotency key to the user who sent it, so users cannot collide with each other's keys.
+func scopeKey(userID string, idempotencyKey string) string {
+	return userID + "\x00" + idempotencyKey
+}
+
+// isAvailable reports whether now falls inside the reward's availability window.
+func isAvailable(reward *models.Reward, now time.Time) bool {
+	if reward.AvailableFrom != nil && now.Before(*reward.AvailableFrom) {
+		return false
+	}
+	if reward.AvailableUntil != nil && !now.Before(*reward.AvailableUntil) {
+		return false
+	}
+	return true
+}
+
+// newRedemptionCode returns a short, human friendly code such as "K7QM-2XPD-9B4T".
+func newRedemptionCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)[:12]
+	return strings.Join([]string{raw[0:4], raw[4:8], raw[8:12]}, "-"), nil
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,135 @@
+package rewards
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// newTestCatalog returns a catalog with one reward and a user holding the given balance.
+func newTestCatalog(t *testing.T, balance int, inventory int) (*Catalog, *ledger.Ledger) {
+	pointsLedger := ledger.New()
+	if _, err := pointsLedger.Post(ledger.Transaction{
+		Kind:     ledger.KindEarn,
+		Postings: ledger.Transfer(ledger.IssuedAccount, ledger.UserAccount("alice"), balance),
+	}); err != nil {
+		t.Fatalf("Post() failed: %v", err)
+	}
+	catalog := NewCatalog(pointsLedger, clock.Real{})
+	catalog.PutReward(models.Reward{Id: "mug", Name: "Mug", Cost: 100, Inventory: inventory})
+	return catalog, pointsLedger
+}
+
+// TestRedeemIsIdempotent tests that retrying with the same idempotency key only spends points once.
+func TestRedeemIsIdempotent(t *testing.T) {
+	catalog, pointsLedger := newTestCatalog(t, 250, 5)
+	request := models.RedemptionRequest{UserID: "alice", RewardID: "mug"}
+
+	first, err := catalog.Redeem(request, "key-1")
+	if err != nil {
+		t.Fatalf("Redeem() failed: %v", err)
+	}
+	retry, err := catalog.Redeem(request, "key-1")
+	if err != nil {
+		t.Fatalf("Redeem() retry failed: %v", err)
+	}
+	if first.Id != retry.Id || first.Code != retry.Code {
+		t.Errorf("Redeem() retry returned a new redemption: %+v vs %+v", first, retry)
+	}
+	if balance := pointsLedger.Balance(ledger.UserAccount("alice")); balance != 150 {
+		t.Errorf("Balance() got = %d, expected 150", balance)
+	}
+	if _, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "other"}, "key-1"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("Redeem() with reused key expected ErrIdempotencyConflict, got %v", err)
+	}
+}
+
+// TestRedeemNeverOverdraws tests that concurrent redemptions cannot spend more than the balance or inventory.
+func TestRedeemNeverOverdraws(t *testing.T) {
+	catalog, pointsLedger := newTestCatalog(t, 350, 10)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, "")
+			if err == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			} else if !errors.Is(err, ledger.ErrInsufficientBalance) {
+				t.Errorf("Redeem() unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 3 {
+		t.Errorf("Redeem() succeeded %d times, expected 3", succeeded)
+	}
+	if balance := pointsLedger.Balance(ledger.UserAccount("alice")); balance != 50 {
+		t.Errorf("Balance() got = %d, expected 50", balance)
+	}
+	if inventory := catalog.rewards["mug"].Inventory; inventory != 7 {
+		t.Errorf("Inventory got = %d, expected 7", inventory)
+	}
+}
+
+// TestCancelRecreditsPoints tests that cancelling a redemption restores points and inventory exactly once.
+func TestCancelRecreditsPoints(t *testing.T) {
+	catalog, pointsLedger := newTestCatalog(t, 100, 1)
+
+	redemption, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, "")
+	if err != nil {
+		t.Fatalf("Redeem() failed: %v", err)
+	}
+	if _, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "mug"}, ""); !errors.Is(err, ErrRewardUnavailable) {
+		t.Errorf("Redeem() of out of stock reward expected ErrRewardUnavailable, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		cancelled, err := catalog.Cancel(redemption.Id)
+		if err != nil {
+			t.Fatalf("Cancel() failed: %v", err)
+		}
+		if cancelled.Status != models.RedemptionCancelled {
+			t.Errorf("Cancel() got status %q, expected %q", cancelled.Status, models.RedemptionCancelled)
+		}
+	}
+	if balance := pointsLedger.Balance(ledger.UserAccount("alice")); balance != 100 {
+		t.Errorf("Balance() got = %d, expected 100", balance)
+	}
+	if inventory := catalog.rewards["mug"].Inventory; inventory != 1 {
+		t.Errorf("Inventory got = %d, expected 1", inventory)
+	}
+}
+
+// TestRewardAvailabilityWindow tests that rewards can only be redeemed inside their availability window.
+func TestRewardAvailabilityWindow(t *testing.T) {
+	catalog, _ := newTestCatalog(t, 500, 5)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := from.AddDate(0, 1, 0)
+	catalog.PutReward(models.Reward{Id: "summer", Name: "Summer Hat", Cost: 100, Inventory: 5, AvailableFrom: &from, AvailableUntil: &until})
+
+	fakeClock := clock.NewFake(from.Add(-time.Hour))
+	catalog.clock = fakeClock
+	if _, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "summer"}, ""); !errors.Is(err, ErrRewardUnavailable) {
+		t.Errorf("Redeem() before window expected ErrRewardUnavailable, got %v", err)
+	}
+	if rewards := catalog.Rewards(); len(rewards) != 1 {
+		t.Errorf("Rewards() before window got %d rewards, expected 1", len(rewards))
+	}
+
+	fakeClock.Set(from.Add(time.Hour))
+	if _, err := catalog.Redeem(models.RedemptionRequest{UserID: "alice", RewardID: "summer"}, ""); err != nil {
+		t.Errorf("Redeem() inside window failed: %v", err)
+	}
+}
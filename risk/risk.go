@@ -0,0 +1,187 @@
+package risk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Finding is the contribution of one signal to a receipt's risk score.
+type Finding struct {
+	Signal string `json:"signal"` //ex. "velocity"
+	Score  int    `json:"score"`  //ex. 60
+	Reason string `json:"reason"` //ex. "31 receipts in 1m0s"
+}
+
+// Assessment is the combined risk of a receipt.
+type Assessment struct {
+	Score    int       `json:"score"`
+	Findings []Finding `json:"findings"`
+}
+
+// Signal inspects a receipt for one kind of abuse. Evaluate returns a zero score when nothing looks wrong.
+type Signal interface {
+	Name() string
+	Evaluate(receipt models.Receipt) (score int, reason string)
+}
+
+// Pipeline runs every signal over a receipt and adds up their scores.
+type Pipeline struct {
+	signals   []Signal
+	threshold int
+}
+
+// NewPipeline returns a pipeline that holds receipts scoring threshold or more.
+func NewPipeline(threshold int, signals ...Signal) *Pipeline {
+	return &Pipeline{signals: signals, threshold: threshold}
+}
+
+// DefaultHoldThreshold is the score at which the default pipeline holds a receipt. It is above the score of any one
+// of its signals, so a receipt is only held when two of them agree that something is wrong.
+const DefaultHoldThreshold = 100
+
+// DefaultPipeline catches submission floods, totals that don't match their items even allowing for sales tax, and
+// absurd item counts.
+func DefaultPipeline(c clock.Clock) *Pipeline {
+	return NewPipeline(DefaultHoldThreshold,
+		NewVelocitySignal(c, time.Minute, 10, 60),
+		TotalMismatchSignal{ToleranceCents: 1, TaxPercent: 15, Score: 50},
+		ItemCountSignal{MaxItems: 100, Score: 60},
+	)
+}
+
+// Assess scores a receipt with every signal.
+func (p *Pipeline) Assess(receipt models.Receipt) Assessment {
+	var assessment Assessment
+	for _, signal := range p.signals {
+		score, reason := signal.Evaluate(receipt)
+		if score == 0 {
+			continue
+		}
+		assessment.Score += score
+		assessment.Findings = append(assessment.Findings, Finding{Signal: signal.Name(), Score: score, Reason: reason})
+	}
+	return assessment
+}
+
+// ShouldHold reports whether an assessment is risky enough to hold the receipt's points for review.
+func (p *Pipeline) ShouldHold(assessment Assessment) bool {
+	return assessment.Score >= p.threshold
+}
+
+// VelocitySignal flags users who submit more than Max receipts inside Window.
+// Every evaluated receipt counts as a submission, whether or not it is later accepted.
+type VelocitySignal struct {
+	clock  clock.Clock
+	window time.Duration
+	max    int
+	score  int
+	// submissions holds each user's recent submission times, oldest first.
+	submissions map[string][]time.Time
+	lock        sync.Mutex
+}
+
+// NewVelocitySignal returns a velocity signal that reads the time from the given clock.
+func NewVelocitySignal(c clock.Clock, window time.Duration, max int, score int) *VelocitySignal {
+	return &VelocitySignal{clock: c, window: window, max: max, score: score, submissions: make(map[string][]time.Time)}
+}
+
+// Name identifies the signal.
+func (s *VelocitySignal) Name() string {
+	return "velocity"
+}
+
+// Evaluate records the submission and scores the user's rate. Anonymous receipts are not rate checked here.
+func (s *VelocitySignal) Evaluate(receipt models.Receipt) (int, string) {
+	if receipt.UserID == "" {
+		return 0, ""
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	cutoff := now.Add(-s.window)
+	recent := s.submissions[receipt.UserID]
+	for len(recent) > 0 && !recent[0].After(cutoff) {
+		recent = recent[1:]
+	}
+	recent = append(recent, now)
+	s.submissions[receipt.UserID] = recent
+
+	if len(recent) > s.max {
+		return s.score, fmt.Sprintf("%d receipts in %s", len(recent), s.window)
+	}
+	return 0, ""
+}
+
+// TotalMismatchSignal flags receipts whose total differs from the sum of their item prices by more than
+// ToleranceCents. A total above the item sum by up to TaxPercent of it is not a mismatch, since receipts list their
+// items before tax.
+type TotalMismatchSignal struct {
+	ToleranceCents int64
+	TaxPercent     int64
+	Score          int
+}
+
+// Name identifies the signal.
+func (s TotalMismatchSignal) Name() string {
+	return "total-mismatch"
+}
+
+// Evaluate compares the total with the item sum.
+func (s TotalMismatchSignal) Evaluate(receipt models.Receipt) (int, string) {
+	totalCents, ok := cents(receipt.Total)
+	if !ok {
+		return s.Score, "total is not a price"
+	}
+	var itemCents int64
+	for _, item := range receipt.Items {
+		price, ok := cents(item.Price)
+		if !ok {
+			return s.Score, "item price is not a price"
+		}
+		itemCents += price
+	}
+
+	difference, tolerance := totalCents-itemCents, s.ToleranceCents
+	if difference < 0 {
+		difference = -difference
+	} else {
+		tolerance += itemCents * s.TaxPercent / 100
+	}
+	if difference > tolerance {
+		return s.Score, fmt.Sprintf("items sum to %d cents but the total is %d cents", itemCents, totalCents)
+	}
+	return 0, ""
+}
+
+// ItemCountSignal flags receipts with more items than any plausible purchase, since every pair of items earns points.
+type ItemCountSignal struct {
+	MaxItems int
+	Score    int
+}
+
+// Name identifies the signal.
+func (s ItemCountSignal) Name() string {
+	return "item-count"
+}
+
+// Evaluate checks the number of items.
+func (s ItemCountSignal) Evaluate(receipt models.Receipt) (int, string) {
+	if len(receipt.Items) > s.MaxItems {
+		return s.Score, fmt.Sprintf("%d items, more than %d", len(receipt.Items), s.MaxItems)
+	}
+	return 0, ""
+}
+
+// cents converts a price such as "6.49" to cents.
+func cents(price string) (int64, bool) {
+	value, err := strconv.ParseInt(strings.ReplaceAll(strings.TrimSpace(price), ".", ""), 10, 64)
+	return value, err == nil
+}
@@ -0,0 +1,91 @@
+package risk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// sampleReceipt returns a receipt whose total matches its items.
+func sampleReceipt() models.Receipt {
+	return models.Receipt{
+		UserID:       "alice",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}, {ShortDescription: "Dasani", Price: "1.40"}},
+		Total:        "2.65",
+	}
+}
+
+// TestPipelineSignals tests each signal through the default pipeline, and that it takes more than one signal to hold
+// a receipt.
+func TestPipelineSignals(t *testing.T) {
+	absurdItemCount := func(r *models.Receipt) {
+		r.Items = make([]models.Item, 101)
+		for i := range r.Items {
+			r.Items[i] = models.Item{ShortDescription: "Gum", Price: "0.00"}
+		}
+		r.Total = "0.00"
+	}
+	testCases := []struct {
+		name     string
+		modify   func(*models.Receipt)
+		expected []string
+		hold     bool
+	}{
+		{"Clean receipt", func(r *models.Receipt) {}, nil, false},
+		{"Total includes sales tax", func(r *models.Receipt) { r.Total = "2.89" }, nil, false},
+		{"Total does not match items", func(r *models.Receipt) { r.Total = "9.00" }, []string{"total-mismatch"}, false},
+		{"Total below items", func(r *models.Receipt) { r.Total = "2.60" }, []string{"total-mismatch"}, false},
+		{"Absurd item count", absurdItemCount, []string{"item-count"}, false},
+		{"Absurd item count and total", func(r *models.Receipt) {
+			absurdItemCount(r)
+			r.Total = "9.00"
+		}, []string{"total-mismatch", "item-count"}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline := DefaultPipeline(clock.NewFake(time.Now()))
+			receipt := sampleReceipt()
+			tc.modify(&receipt)
+			assessment := pipeline.Assess(receipt)
+			var signals []string
+			for _, finding := range assessment.Findings {
+				signals = append(signals, finding.Signal)
+			}
+			if !reflect.DeepEqual(signals, tc.expected) || pipeline.ShouldHold(assessment) != tc.hold {
+				t.Errorf("Assess() got %+v, expected findings %v and hold %t", assessment, tc.expected, tc.hold)
+			}
+		})
+	}
+}
+
+// TestVelocitySignal tests that bursts are flagged and that the window slides with the clock.
+func TestVelocitySignal(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	signal := NewVelocitySignal(fakeClock, time.Minute, 3, 60)
+	receipt := sampleReceipt()
+
+	for i := 0; i < 3; i++ {
+		if score, _ := signal.Evaluate(receipt); score != 0 {
+			t.Fatalf("Evaluate() submission %d got score %d, expected 0", i+1, score)
+		}
+		fakeClock.Advance(10 * time.Second)
+	}
+	if score, _ := signal.Evaluate(receipt); score != 60 {
+		t.Errorf("Evaluate() fourth submission got score %d, expected 60", score)
+	}
+
+	fakeClock.Advance(time.Minute)
+	if score, _ := signal.Evaluate(receipt); score != 0 {
+		t.Errorf("Evaluate() after the window got score %d, expected 0", score)
+	}
+	receipt.UserID = "bob"
+	if score, _ := signal.Evaluate(receipt); score != 0 {
+		t.Errorf("Evaluate() for another user got score %d, expected 0", score)
+	}
+}
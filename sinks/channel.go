@@ -0,0 +1,47 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+)
+
+// ChannelSink hands events to an in-process consumer over a channel. A batch is accepted once every event is on the
+// channel; when the consumer stops reading, Publish fails at the context's deadline and the batch is retried.
+type ChannelSink struct {
+	name   string
+	events chan events.Event
+}
+
+// NewChannelSink returns a sink whose channel buffers up to size events.
+func NewChannelSink(name string, size int) *ChannelSink {
+	return &ChannelSink{name: name, events: make(chan events.Event, size)}
+}
+
+// Name identifies the sink.
+func (s *ChannelSink) Name() string {
+	return s.name
+}
+
+// Events is the channel the consumer reads from. It is never closed.
+func (s *ChannelSink) Events() <-chan events.Event {
+	return s.events
+}
+
+// Publish sends the batch on the channel, waiting for room until the context is done.
+func (s *ChannelSink) Publish(ctx context.Context, batch []events.Event) error {
+	for _, event := range batch {
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "ChannelSink.Publish")
+		}
+	}
+	return nil
+}
+
+// Close does nothing: the consumer may still be draining the channel.
+func (s *ChannelSink) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+)
+
+// FileSink appends events to NDJSON files in a directory, starting a new file once the current one reaches a size
+// limit. Files are named after the Seq of their first event, so they sort in order, e.g. events-00000000000000000001.ndjson.
+type FileSink struct {
+	dir      string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// NewFileSink returns a sink writing to files under dir that roll over after maxBytes. A maxBytes of 0 never rolls.
+func NewFileSink(dir string, maxBytes int64) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "NewFileSink")
+	}
+	return &FileSink{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Name identifies the sink.
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// Publish appends the batch to the current file and syncs it to disk, rolling over to a new file first when the
+// current one is full.
+func (s *FileSink) Publish(_ context.Context, batch []events.Event) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	var lines []byte
+	for _, event := range batch {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrap(err, "FileSink.Publish")
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	if s.file == nil || (s.maxBytes > 0 && s.size >= s.maxBytes) {
+		if err := s.roll(batch[0].Seq); err != nil {
+			return errors.Wrap(err, "FileSink.Publish")
+		}
+	}
+	written, err := s.file.Write(lines)
+	s.size += int64(written)
+	if err != nil {
+		return errors.Wrap(err, "FileSink.Publish")
+	}
+	return errors.Wrap(s.file.Sync(), "FileSink.Publish")
+}
+
+// roll closes the current file and opens the one starting at the given event.
+func (s *FileSink) roll(firstSeq uint64) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("events-%020d.ndjson", firstSeq))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// Close closes the current file.
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,156 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+)
+
+// natsTimeout bounds connecting and each publish when the context has no deadline.
+const natsTimeout = 10 * time.Second
+
+// NATSSink publishes events to a NATS server over its text protocol, one message per event on the subject
+// "<subject>.<type>", e.g. "receipts.scored". A batch counts as accepted once the server answers the PING that
+// follows it, which it only does after processing every message before it. Core NATS does not persist messages,
+// so a subscriber that needs every event should read through JetStream or a durable consumer.
+type NATSSink struct {
+	address string
+	subject string
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+// NewNATSSink returns a sink for the server at a URL such as "nats://localhost:4222". It connects on first use.
+func NewNATSSink(rawURL string, subject string) (*NATSSink, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "nats" || parsed.Host == "" {
+		return nil, errors.Errorf("NewNATSSink: invalid URL %q", rawURL)
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	subject = strings.TrimSpace(subject)
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, errors.Errorf("NewNATSSink: invalid subject %q", subject)
+	}
+	return &NATSSink{address: address, subject: subject}, nil
+}
+
+// Name identifies the sink.
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+// Publish sends the batch and waits for the server to confirm it. On any error the connection is dropped and the
+// next Publish reconnects.
+func (s *NATSSink) Publish(ctx context.Context, batch []events.Event) error {
+	if err := s.publish(ctx, batch); err != nil {
+		s.Close()
+		return errors.Wrap(err, "NATSSink.Publish")
+	}
+	return nil
+}
+
+// publish sends the batch over the current connection, connecting first if needed.
+func (s *NATSSink) publish(ctx context.Context, batch []events.Event) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natsTimeout)
+	}
+	if s.conn == nil {
+		if err := s.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(s.conn)
+	for _, event := range batch {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "PUB %s.%s %d\r\n", s.subject, event.Type, len(payload))
+		writer.Write(payload)
+		writer.WriteString("\r\n")
+	}
+	writer.WriteString("PING\r\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return s.awaitPong()
+}
+
+// connect dials the server, reads its INFO and introduces the client, confirming the server accepted it.
+func (s *NATSSink) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return errors.Errorf("expected INFO from the server, got %q", line)
+	}
+	if _, err := conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"receipt-processor\"}\r\nPING\r\n")); err != nil {
+		return err
+	}
+	return s.awaitPong()
+}
+
+// awaitPong reads until the server's PONG, answering its PINGs and failing on its errors.
+func (s *NATSSink) awaitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// readLine reads one protocol line without its CRLF.
+func (s *NATSSink) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Close closes the connection.
+func (s *NATSSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
@@ -0,0 +1,301 @@
+package sinks
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// EventSink receives receipt events for a data pipeline. Publish must only return nil once the whole batch is
+// handed off durably enough not to be lost; on an error the batch is published again later. A sink therefore sees
+// every event at least once, in order, and possibly more than once, so consumers deduplicate by the events' Seq.
+type EventSink interface {
+	// Name identifies the sink in logs, status reports and the name of its cursor file.
+	Name() string
+	Publish(ctx context.Context, batch []events.Event) error
+	Close() error
+}
+
+// Source is the durable log a forwarder reads the events it missed from. *events.Log is a Source.
+type Source interface {
+	// EachAfter calls fn with every event after the given sequence number, in order.
+	EachAfter(seq uint64, fn func(event events.Event) error) error
+	LastSeq() uint64
+}
+
+// Status reports how far a sink has got and whether it is failing.
+type Status struct {
+	Name string
+	// Cursor is the Seq of the last event the sink accepted, and LastSeq that of the newest event in the log.
+	Cursor  uint64
+	LastSeq uint64
+	// Failures counts the failed attempts since the last success.
+	Failures    int
+	LastError   string
+	LastSuccess time.Time
+}
+
+const (
+	// publishTimeout bounds a single Publish, so a stuck sink is retried rather than waited on forever.
+	publishTimeout = 30 * time.Second
+	// defaultBatchSize is the most events handed to a sink at once.
+	defaultBatchSize = 100
+	// maxQueued is how many live events a forwarder queues before it leaves the rest for the log.
+	maxQueued = 10000
+	// minRetry and maxRetry bound the backoff between failed attempts.
+	minRetry = time.Second
+	maxRetry = time.Minute
+)
+
+// errBatchFull stops reading the source once a batch is full.
+var errBatchFull = errors.New("batch full")
+
+// Forwarder feeds one sink the events of a log, after a cursor that only moves once the sink accepted them. Live
+// events are offered to it as they are recorded; whatever it missed, because its queue overflowed or the process
+// restarted, it reads back from the log. A failing sink is retried with backoff and never holds up the writer.
+type Forwarder struct {
+	sink   EventSink
+	source Source
+	clock  clock.Clock
+	// cursorPath is where the cursor is kept across restarts. Without one the cursor starts at 0 on every start.
+	cursorPath string
+	batchSize  int
+
+	queue  []events.Event
+	cursor uint64
+	// saved is whether the cursor was loaded from its file.
+	saved  bool
+	status Status
+	lock   sync.Mutex
+
+	// forwarding allows one pass at a time.
+	forwarding sync.Mutex
+	wake       chan struct{}
+}
+
+// NewForwarder returns a forwarder from the source to the sink. When cursorPath is set the cursor is loaded from it
+// and saved to it after every batch the sink accepts. A saved cursor ahead of the source's newest event belongs to a
+// log that is gone, e.g. one kept in memory before a restart, so the forwarder starts again from the first event.
+func NewForwarder(sink EventSink, source Source, cursorPath string, c clock.Clock) (*Forwarder, error) {
+	f := &Forwarder{
+		sink:       sink,
+		source:     source,
+		clock:      c,
+		cursorPath: cursorPath,
+		batchSize:  defaultBatchSize,
+		status:     Status{Name: sink.Name()},
+		wake:       make(chan struct{}, 1),
+	}
+	if cursorPath == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(cursorPath)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "NewForwarder")
+	}
+	if f.cursor, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+		return nil, errors.Wrapf(err, "NewForwarder: cursor file %s", cursorPath)
+	}
+	f.saved = true
+	if last := source.LastSeq(); f.cursor > last {
+		log.Printf("event sink %s: cursor %d is ahead of the event log, which ends at %d; starting over", sink.Name(), f.cursor, last)
+		f.cursor = 0
+		if err := writeCursor(cursorPath, 0); err != nil {
+			return nil, errors.Wrap(err, "NewForwarder")
+		}
+	}
+	return f, nil
+}
+
+// StartAfter moves the cursor of a forwarder that has none saved past the given event, so the sink only gets the
+// events recorded from then on, e.g. one that has no use for the history recorded before it was set up. A saved
+// cursor is kept.
+func (f *Forwarder) StartAfter(seq uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.saved {
+		return nil
+	}
+	if f.cursorPath != "" {
+		if err := writeCursor(f.cursorPath, seq); err != nil {
+			return errors.Wrap(err, "StartAfter")
+		}
+		f.saved = true
+	}
+	f.cursor = seq
+	return nil
+}
+
+// Offer queues newly recorded events for the sink without waiting for it. Events that do not fit the queue are
+// left for the forwarder to read back from the log.
+func (f *Forwarder) Offer(recorded []events.Event) {
+	f.lock.Lock()
+	if len(f.queue)+len(recorded) <= maxQueued {
+		f.queue = append(f.queue, recorded...)
+	}
+	f.lock.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Forward hands the sink every event after its cursor, a batch at a time, until it is caught up or a batch fails.
+func (f *Forwarder) Forward(ctx context.Context) error {
+	f.forwarding.Lock()
+	defer f.forwarding.Unlock()
+	for ctx.Err() == nil {
+		batch, err := f.nextBatch()
+		if err != nil {
+			return f.fail(err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err = f.sink.Publish(publishCtx, batch)
+		cancel()
+		if err != nil {
+			return f.fail(errors.Wrapf(err, "publishing events %d-%d", batch[0].Seq, batch[len(batch)-1].Seq))
+		}
+		if err := f.advance(batch[len(batch)-1].Seq); err != nil {
+			return f.fail(err)
+		}
+	}
+	return ctx.Err()
+}
+
+// nextBatch returns the events after the cursor: from the queue when it continues the cursor, otherwise from the log.
+func (f *Forwarder) nextBatch() ([]events.Event, error) {
+	f.lock.Lock()
+	cursor := f.cursor
+	skip := 0
+	for skip < len(f.queue) && f.queue[skip].Seq <= cursor {
+		skip++
+	}
+	f.queue = f.queue[skip:]
+	var batch []events.Event
+	for i := 0; i < len(f.queue) && len(batch) < f.batchSize && f.queue[i].Seq == cursor+uint64(i)+1; i++ {
+		batch = append(batch, f.queue[i])
+	}
+	f.lock.Unlock()
+	if len(batch) > 0 || f.source.LastSeq() <= cursor {
+		return batch, nil
+	}
+
+	err := f.source.EachAfter(cursor, func(event events.Event) error {
+		batch = append(batch, event)
+		if len(batch) == f.batchSize {
+			return errBatchFull
+		}
+		return nil
+	})
+	if err != nil && errors.Cause(err) != errBatchFull {
+		return nil, errors.Wrap(err, "reading the event log")
+	}
+	return batch, nil
+}
+
+// advance moves the cursor past an accepted batch and saves it.
+func (f *Forwarder) advance(seq uint64) error {
+	if f.cursorPath != "" {
+		if err := writeCursor(f.cursorPath, seq); err != nil {
+			return errors.Wrap(err, "saving the cursor")
+		}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.cursor = seq
+	f.status.Failures = 0
+	f.status.LastError = ""
+	f.status.LastSuccess = f.clock.Now().UTC()
+	return nil
+}
+
+// fail records a failed attempt and returns its error.
+func (f *Forwarder) fail(err error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status.Failures++
+	f.status.LastError = err.Error()
+	return err
+}
+
+// retryAfter returns how long to wait before retrying after the recorded failures.
+func (f *Forwarder) retryAfter() time.Duration {
+	f.lock.Lock()
+	failures := f.status.Failures
+	f.lock.Unlock()
+	delay := minRetry
+	for i := 1; i < failures && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxRetry {
+		delay = maxRetry
+	}
+	return delay
+}
+
+// Status reports the sink's progress.
+func (f *Forwarder) Status() Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	status := f.status
+	status.Cursor = f.cursor
+	status.LastSeq = f.source.LastSeq()
+	return status
+}
+
+// Run forwards events as they are offered, and catches up on the log at start, until the context is cancelled.
+// After a failure it waits with backoff before retrying. It closes the sink when it returns.
+func (f *Forwarder) Run(ctx context.Context) {
+	defer func() {
+		if err := f.sink.Close(); err != nil {
+			log.Printf("event sink %s: closing: %v", f.sink.Name(), err)
+		}
+	}()
+	for {
+		wait := time.Duration(0)
+		if err := f.Forward(ctx); err != nil && ctx.Err() == nil {
+			wait = f.retryAfter()
+			log.Printf("event sink %s: %v; retrying in %s", f.sink.Name(), err, wait)
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.wake:
+		}
+	}
+}
+
+// writeCursor saves a cursor atomically, so a crash leaves either the old or the new one.
+func writeCursor(path string, seq uint64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(strconv.FormatUint(seq, 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
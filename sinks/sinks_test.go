@@ -0,0 +1,301 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/events"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// flakySink fails its first failures publishes and records the events of the rest.
+type flakySink struct {
+	failures  int
+	published []uint64
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Publish(_ context.Context, batch []events.Event) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	for _, event := range batch {
+		s.published = append(s.published, event.Seq)
+	}
+	return nil
+}
+
+func (s *flakySink) Close() error { return nil }
+
+// appendEvents records n Scored events in the log and returns them.
+func appendEvents(t *testing.T, l *events.Log, n int) []events.Event {
+	t.Helper()
+	var batch []events.Event
+	for i := 0; i < n; i++ {
+		batch = append(batch, events.Event{Type: events.Scored, ReceiptID: fmt.Sprintf("r%d", i)})
+	}
+	appended, err := l.Append(batch...)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	return appended
+}
+
+// TestForwarderDeliversAtLeastOnce tests that a failing sink gets the events once it recovers, and that a restarted
+// forwarder resumes after its saved cursor, reading the events it was never offered from the log.
+func TestForwarderDeliversAtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+	eventLog := events.NewLog(clock.Real{})
+	cursorPath := filepath.Join(t.TempDir(), "flaky.cursor")
+	sink := &flakySink{failures: 1}
+	forwarder, err := NewForwarder(sink, eventLog, cursorPath, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+
+	forwarder.Offer(appendEvents(t, eventLog, 3))
+	if err := forwarder.Forward(ctx); err == nil {
+		t.Fatalf("Forward() to a failing sink succeeded")
+	}
+	if status := forwarder.Status(); status.Failures != 1 || status.Cursor != 0 || status.LastSeq != 3 {
+		t.Errorf("Status() = %+v, expected one failure and no progress", status)
+	}
+	if err := forwarder.Forward(ctx); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if status := forwarder.Status(); status.Failures != 0 || status.Cursor != 3 {
+		t.Errorf("Status() = %+v, expected the cursor at 3", status)
+	}
+
+	// Events recorded while the forwarder was down are only in the log.
+	appendEvents(t, eventLog, 2)
+	restarted, err := NewForwarder(sink, eventLog, cursorPath, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	restarted.Offer(appendEvents(t, eventLog, 1))
+	if err := restarted.Forward(ctx); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if got := fmt.Sprint(sink.published); got != "[1 2 3 4 5 6]" {
+		t.Errorf("published = %s, expected every event once, in order", got)
+	}
+
+	// A log that lost its events, e.g. one kept in memory, leaves the saved cursor ahead of it.
+	restartedLog := events.NewLog(clock.Real{})
+	appendEvents(t, restartedLog, 2)
+	sink.published = nil
+	reset, err := NewForwarder(sink, restartedLog, cursorPath, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	if status := reset.Status(); status.Cursor != 0 {
+		t.Errorf("Status() = %+v, expected the cursor reset to 0", status)
+	}
+	if err := reset.Forward(ctx); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if got := fmt.Sprint(sink.published); got != "[1 2]" {
+		t.Errorf("published = %s, expected the new log's events", got)
+	}
+}
+
+// TestForwarderStartAfter tests that a forwarder without a saved cursor can skip the events already recorded, and
+// that a saved cursor is kept.
+func TestForwarderStartAfter(t *testing.T) {
+	ctx := context.Background()
+	eventLog := events.NewLog(clock.Real{})
+	appendEvents(t, eventLog, 2)
+	cursorPath := filepath.Join(t.TempDir(), "flaky.cursor")
+	sink := &flakySink{}
+	forwarder, err := NewForwarder(sink, eventLog, cursorPath, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	if err := forwarder.StartAfter(eventLog.LastSeq()); err != nil {
+		t.Fatalf("StartAfter() error = %v", err)
+	}
+	appendEvents(t, eventLog, 1)
+
+	restarted, err := NewForwarder(sink, eventLog, cursorPath, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewForwarder() error = %v", err)
+	}
+	if err := restarted.StartAfter(eventLog.LastSeq()); err != nil {
+		t.Fatalf("StartAfter() error = %v", err)
+	}
+	if err := restarted.Forward(ctx); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if got := fmt.Sprint(sink.published); got != "[3]" {
+		t.Errorf("published = %s, expected only the event recorded after the first start", got)
+	}
+}
+
+// TestFileSinkRolls tests that the file sink starts a new file once the current one is full, named after its first
+// event.
+func TestFileSinkRolls(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, 1)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	eventLog := events.NewLog(clock.Real{})
+	for _, n := range []int{2, 1} {
+		if err := sink.Publish(context.Background(), appendEvents(t, eventLog, n)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	sink.Close()
+
+	first, err := os.ReadFile(filepath.Join(dir, "events-00000000000000000001.ndjson"))
+	if err != nil || strings.Count(string(first), "\n") != 2 {
+		t.Errorf("first file = %q, %v; expected events 1 and 2", first, err)
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "events-00000000000000000003.ndjson"))
+	if err != nil || strings.Count(string(second), "\n") != 1 {
+		t.Errorf("second file = %q, %v; expected event 3", second, err)
+	}
+}
+
+// TestChannelSink tests that a channel sink hands over events and fails, rather than blocking, when nobody reads.
+func TestChannelSink(t *testing.T) {
+	sink := NewChannelSink("pipeline", 1)
+	batch := appendEvents(t, events.NewLog(clock.Real{}), 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sink.Publish(ctx, batch); err == nil {
+		t.Fatalf("Publish() to a full channel succeeded")
+	}
+	if event := <-sink.Events(); event.Seq != 1 {
+		t.Errorf("received event %d, expected 1", event.Seq)
+	}
+	if err := sink.Publish(context.Background(), batch[1:]); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if event := <-sink.Events(); event.Seq != 2 {
+		t.Errorf("received event %d, expected 2", event.Seq)
+	}
+}
+
+// standInBroker speaks enough of the NATS protocol to accept publishes: it sends INFO, answers PING and records
+// every PUB.
+type standInBroker struct {
+	listener net.Listener
+	subjects []string
+	payloads [][]byte
+	conns    []net.Conn
+	lock     sync.Mutex
+}
+
+func newStandInBroker(t *testing.T) *standInBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	b := &standInBroker{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.lock.Lock()
+			b.conns = append(b.conns, conn)
+			b.lock.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *standInBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte("INFO {\"server_id\":\"stand-in\",\"max_payload\":1048576}\r\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case fields[0] == "PUB" && len(fields) == 3:
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			b.lock.Lock()
+			b.subjects = append(b.subjects, fields[1])
+			b.payloads = append(b.payloads, payload[:size])
+			b.lock.Unlock()
+		}
+	}
+}
+
+// dropConnections closes every client connection, as a broker restart would.
+func (b *standInBroker) dropConnections() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+// TestNATSSinkPublishesToBroker tests that the NATS sink publishes each event on its type's subject, and reconnects
+// after the broker drops the connection.
+func TestNATSSinkPublishesToBroker(t *testing.T) {
+	broker := newStandInBroker(t)
+	sink, err := NewNATSSink("nats://"+broker.listener.Addr().String(), "receipts")
+	if err != nil {
+		t.Fatalf("NewNATSSink() error = %v", err)
+	}
+	defer sink.Close()
+	eventLog := events.NewLog(clock.Real{})
+	ctx := context.Background()
+
+	if err := sink.Publish(ctx, appendEvents(t, eventLog, 2)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	broker.dropConnections()
+	third := appendEvents(t, eventLog, 1)
+	if err := sink.Publish(ctx, third); err != nil {
+		// The broken connection is only noticed on use; the retry reconnects.
+		if err := sink.Publish(ctx, third); err != nil {
+			t.Fatalf("Publish() after reconnecting error = %v", err)
+		}
+	}
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	if len(broker.subjects) < 3 || broker.subjects[0] != "receipts.scored" {
+		t.Fatalf("broker received subjects %v, expected 3 on receipts.scored", broker.subjects)
+	}
+	var last events.Event
+	if err := json.Unmarshal(broker.payloads[len(broker.payloads)-1], &last); err != nil || last.Seq != 3 {
+		t.Errorf("last payload = %s, expected event 3", broker.payloads[len(broker.payloads)-1])
+	}
+	if _, err := NewNATSSink("http://localhost:4222", "receipts"); err == nil {
+		t.Errorf("NewNATSSink() accepted a non-NATS URL")
+	}
+}
@@ -0,0 +1,375 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+const (
+	// Format names the snapshot format in headers and manifests.
+	Format = "receipt-snapshot"
+	// Version is the snapshot version this package writes and the newest it reads. Version 1 snapshots hold no
+	// transactions, and version 2 snapshots no rewards catalog.
+	Version = 3
+	// ReceiptsEntry and ManifestEntry are the entries of an archive, in that order.
+	ReceiptsEntry = "receipts.ndjson"
+	ManifestEntry = "manifest.json"
+	// MaxEntryBytes bounds the receipts entry of an archive, so a crafted archive cannot exhaust memory.
+	MaxEntryBytes = 1 << 30
+)
+
+var (
+	// ErrChecksumMismatch is returned when a snapshot's contents do not match its recorded checksum or count.
+	ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+	// ErrUnsupported is returned for input that is not a snapshot of a version this package reads.
+	ErrUnsupported = errors.New("unsupported snapshot")
+)
+
+// Snapshot is what a snapshot holds: the stored receipts, the ledger transactions that changed balances outside
+// any receipt, such as manual adjustments, redemptions and expiries, and the rewards catalog as the changes that
+// rebuild it, see rewards.Catalog.Changes.
+type Snapshot struct {
+	Records      []Record
+	Transactions []ledger.Transaction
+	Catalog      []rewards.Change
+}
+
+// Record is one stored receipt and the state that belongs to it. StoredAt is when its points were credited, and
+// RefundedAt and RejectedAt when the last of its items were refunded and when a review rejected it, so an import
+// posts its transactions at their original times. They are nil when unknown, e.g. in older snapshots.
+type Record struct {
+	ID            string         `json:"id"`
+	Receipt       models.Receipt `json:"receipt"`
+	RefundedItems []models.Item  `json:"refundedItems,omitempty"`
+	StoredAt      *time.Time     `json:"storedAt,omitempty"`
+	RefundedAt    *time.Time     `json:"refundedAt,omitempty"`
+	RejectedAt    *time.Time     `json:"rejectedAt,omitempty"`
+}
+
+// line is one line of an NDJSON snapshot: a header first, then one line per record, then a trailer.
+type line struct {
+	Type string `json:"type"`
+	// Header fields.
+	Format    string     `json:"format,omitempty"`
+	Version   int        `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	// Record fields.
+	*Record
+	// Transaction fields.
+	Transaction *ledger.Transaction `json:"transaction,omitempty"`
+	// Catalog fields.
+	Catalog *rewards.Change `json:"catalog,omitempty"`
+	// Trailer fields. Count and SHA256 cover the record, transaction and catalog lines, including their newlines.
+	Count  *int   `json:"count,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Line types.
+const (
+	headerLine      = "header"
+	recordLine      = "receipt"
+	transactionLine = "transaction"
+	catalogLine     = "catalog"
+	trailerLine     = "trailer"
+)
+
+// Writer streams records, transactions and catalog changes as versioned NDJSON.
+type Writer struct {
+	out          io.Writer
+	digest       hash.Hash
+	records      int
+	transactions int
+	catalog      int
+}
+
+// NewWriter writes the snapshot header and returns a writer for the records.
+func NewWriter(out io.Writer, createdAt time.Time) (*Writer, error) {
+	createdAt = createdAt.UTC()
+	writer := &Writer{out: out, digest: sha256.New()}
+	if err := writer.writeLine(line{Type: headerLine, Format: Format, Version: Version, CreatedAt: &createdAt}); err != nil {
+		return nil, errors.Wrap(err, "NewWriter")
+	}
+	return writer, nil
+}
+
+// Write appends a record.
+func (w *Writer) Write(record Record) error {
+	w.records++
+	return errors.Wrap(w.writeBodyLine(line{Type: recordLine, Record: &record}), "Write")
+}
+
+// WriteTransaction appends a ledger transaction.
+func (w *Writer) WriteTransaction(transaction ledger.Transaction) error {
+	w.transactions++
+	return errors.Wrap(w.writeBodyLine(line{Type: transactionLine, Transaction: &transaction}), "WriteTransaction")
+}
+
+// WriteCatalog appends a change to the rewards catalog.
+func (w *Writer) WriteCatalog(change rewards.Change) error {
+	w.catalog++
+	return errors.Wrap(w.writeBodyLine(line{Type: catalogLine, Catalog: &change}), "WriteCatalog")
+}
+
+// Close writes the trailer that lets readers verify the records, transactions and catalog changes.
+func (w *Writer) Close() error {
+	count := w.records + w.transactions + w.catalog
+	return errors.Wrap(w.writeLine(line{Type: trailerLine, Count: &count, SHA256: hex.EncodeToString(w.digest.Sum(nil))}), "Close")
+}
+
+// Count returns how many records have been written.
+func (w *Writer) Count() int {
+	return w.records
+}
+
+// TransactionCount returns how many transactions have been written.
+func (w *Writer) TransactionCount() int {
+	return w.transactions
+}
+
+// CatalogCount returns how many catalog changes have been written.
+func (w *Writer) CatalogCount() int {
+	return w.catalog
+}
+
+// writeBodyLine marshals a record, transaction or catalog line into the checksum.
+func (w *Writer) writeBodyLine(l line) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	w.digest.Write(data)
+	_, err = w.out.Write(data)
+	return err
+}
+
+// writeLine marshals one line.
+func (w *Writer) writeLine(l line) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(data, '\n'))
+	return err
+}
+
+// ReadAll reads an NDJSON snapshot and returns its contents only once the trailer has verified all of them, so a
+// truncated or altered snapshot is never partially applied.
+func ReadAll(in io.Reader) (Snapshot, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return Snapshot{}, errors.Wrap(ErrUnsupported, "ReadAll: empty snapshot")
+	}
+	var header line
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Type != headerLine || header.Format != Format {
+		return Snapshot{}, errors.Wrap(ErrUnsupported, "ReadAll: missing snapshot header")
+	}
+	if header.Version < 1 || header.Version > Version {
+		return Snapshot{}, errors.Wrapf(ErrUnsupported, "ReadAll: version %d", header.Version)
+	}
+
+	digest := sha256.New()
+	var contents Snapshot
+	for lineNumber := 2; scanner.Scan(); lineNumber++ {
+		raw := scanner.Bytes()
+		var l line
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return Snapshot{}, errors.Wrapf(err, "ReadAll: line %d", lineNumber)
+		}
+		switch {
+		case l.Type == recordLine && l.Record != nil:
+			contents.Records = append(contents.Records, *l.Record)
+		case l.Type == transactionLine && l.Transaction != nil && header.Version >= 2:
+			contents.Transactions = append(contents.Transactions, *l.Transaction)
+		case l.Type == catalogLine && l.Catalog != nil && header.Version >= 3:
+			contents.Catalog = append(contents.Catalog, *l.Catalog)
+		case l.Type == trailerLine:
+			if l.Count == nil || *l.Count != len(contents.Records)+len(contents.Transactions)+len(contents.Catalog) || l.SHA256 != hex.EncodeToString(digest.Sum(nil)) {
+				return Snapshot{}, errors.Wrap(ErrChecksumMismatch, "ReadAll")
+			}
+			return contents, nil
+		default:
+			return Snapshot{}, errors.Errorf("ReadAll: line %d is not a %s, %s, %s or %s", lineNumber, recordLine, transactionLine, catalogLine, trailerLine)
+		}
+		digest.Write(raw)
+		digest.Write([]byte{'\n'})
+	}
+	if err := scanner.Err(); err != nil {
+		return Snapshot{}, errors.Wrap(err, "ReadAll")
+	}
+	return Snapshot{}, errors.Wrap(ErrChecksumMismatch, "ReadAll: missing trailer")
+}
+
+// Manifest describes the files of an archive.
+type Manifest struct {
+	Format       string         `json:"format"`
+	Version      int            `json:"version"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	Records      int            `json:"records"`
+	Transactions int            `json:"transactions"`
+	Catalog      int            `json:"catalog"`
+	Files        []ManifestFile `json:"files"`
+}
+
+// ManifestFile is the size and SHA-256 of one archive entry.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// WriteArchive writes a gzip-compressed tar archive holding the NDJSON snapshot and a manifest with its checksum.
+// writeRecords is called once to write the records. The NDJSON is staged in a temporary file, since tar entries
+// need their size up front.
+func WriteArchive(out io.Writer, createdAt time.Time, writeRecords func(*Writer) error) error {
+	staged, err := os.CreateTemp("", "receipt-snapshot-*.ndjson")
+	if err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	digest := sha256.New()
+	writer, err := NewWriter(io.MultiWriter(staged, digest), createdAt)
+	if err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if err := writeRecords(writer); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	size, err := staged.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+
+	manifest, err := json.MarshalIndent(Manifest{
+		Format:       Format,
+		Version:      Version,
+		CreatedAt:    createdAt.UTC(),
+		Records:      writer.Count(),
+		Transactions: writer.TransactionCount(),
+		Catalog:      writer.CatalogCount(),
+		Files:        []ManifestFile{{Name: ReceiptsEntry, Size: size, SHA256: hex.EncodeToString(digest.Sum(nil))}},
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+
+	compressed := gzip.NewWriter(out)
+	archive := tar.NewWriter(compressed)
+	modTime := createdAt.UTC()
+	if err := archive.WriteHeader(&tar.Header{Name: ReceiptsEntry, Mode: 0o644, Size: size, ModTime: modTime}); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if _, err := io.Copy(archive, staged); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: ManifestEntry, Mode: 0o644, Size: int64(len(manifest)), ModTime: modTime}); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if _, err := archive.Write(manifest); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	if err := archive.Close(); err != nil {
+		return errors.Wrap(err, "WriteArchive")
+	}
+	return errors.Wrap(compressed.Close(), "WriteArchive")
+}
+
+// ReadArchive reads an archive written by WriteArchive, checks the NDJSON against the manifest and returns its
+// contents. A receipts entry over MaxEntryBytes is refused.
+func ReadArchive(in io.Reader) (Snapshot, error) {
+	compressed, err := gzip.NewReader(in)
+	if err != nil {
+		return Snapshot{}, errors.Wrap(ErrUnsupported, "ReadArchive: not gzip compressed")
+	}
+	archive := tar.NewReader(compressed)
+
+	var receipts []byte
+	var manifest *Manifest
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Snapshot{}, errors.Wrap(err, "ReadArchive")
+		}
+		switch header.Name {
+		case ReceiptsEntry:
+			if receipts, err = io.ReadAll(io.LimitReader(archive, MaxEntryBytes+1)); err != nil {
+				return Snapshot{}, errors.Wrap(err, "ReadArchive")
+			}
+			if len(receipts) > MaxEntryBytes {
+				return Snapshot{}, errors.Wrapf(ErrUnsupported, "ReadArchive: %s is larger than %d bytes", ReceiptsEntry, MaxEntryBytes)
+			}
+		case ManifestEntry:
+			manifest = &Manifest{}
+			if err := json.NewDecoder(io.LimitReader(archive, MaxEntryBytes)).Decode(manifest); err != nil {
+				return Snapshot{}, errors.Wrap(err, "ReadArchive: reading manifest failed")
+			}
+		}
+	}
+	if manifest == nil || receipts == nil || manifest.Format != Format {
+		return Snapshot{}, errors.Wrap(ErrUnsupported, "ReadArchive: missing manifest or receipts")
+	}
+	if manifest.Version < 1 || manifest.Version > Version {
+		return Snapshot{}, errors.Wrapf(ErrUnsupported, "ReadArchive: version %d", manifest.Version)
+	}
+
+	sum := sha256.Sum256(receipts)
+	verified := false
+	for _, file := range manifest.Files {
+		if file.Name != ReceiptsEntry {
+			continue
+		}
+		if file.Size != int64(len(receipts)) || file.SHA256 != hex.EncodeToString(sum[:]) {
+			return Snapshot{}, errors.Wrapf(ErrChecksumMismatch, "ReadArchive: %s", ReceiptsEntry)
+		}
+		verified = true
+	}
+	if !verified {
+		return Snapshot{}, errors.Wrapf(ErrChecksumMismatch, "ReadArchive: manifest has no checksum for %s", ReceiptsEntry)
+	}
+
+	contents, err := ReadAll(bytes.NewReader(receipts))
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "ReadArchive")
+	}
+	if len(contents.Records) != manifest.Records || len(contents.Transactions) != manifest.Transactions || len(contents.Catalog) != manifest.Catalog {
+		return Snapshot{}, errors.Wrap(ErrChecksumMismatch, "ReadArchive: record count")
+	}
+	return contents, nil
+}
+
+// Read reads either form of snapshot, telling them apart by the gzip magic number.
+func Read(in io.Reader) (Snapshot, error) {
+	buffered := bufio.NewReader(in)
+	magic, _ := buffered.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return ReadArchive(buffered)
+	}
+	return ReadAll(buffered)
+}
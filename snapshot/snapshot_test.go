@@ -0,0 +1,124 @@
+package snapshot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/ledger"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+	"github.com/praveensundaram1/receipt-processor-challenge/rewards"
+)
+
+// sampleRecords returns records to round-trip.
+func sampleRecords() []Record {
+	storedAt := time.Date(2023, 6, 1, 9, 30, 0, 0, time.UTC)
+	return []Record{
+		{ID: "r1_a", Receipt: models.Receipt{Retailer: "Target", PurchaseDate: "2022-01-02", PurchaseTime: "13:13", Total: "1.40", Items: []models.Item{{ShortDescription: "Dasani", Price: "1.40"}}, Points: 31}},
+		{ID: "r1_b", Receipt: models.Receipt{UserID: "alice", Retailer: "Walgreens", PurchaseDate: "2022-01-03", PurchaseTime: "08:13", Total: "2.65", Points: 15}, RefundedItems: []models.Item{{ShortDescription: "Pepsi", Price: "1.25"}}, StoredAt: &storedAt},
+	}
+}
+
+// sampleSnapshot returns the sample records with a manual adjustment and a reward to round-trip.
+func sampleSnapshot() Snapshot {
+	return Snapshot{
+		Records: sampleRecords(),
+		Transactions: []ledger.Transaction{{
+			ID:        7,
+			Kind:      ledger.KindAdjust,
+			Memo:      "Goodwill",
+			Postings:  ledger.Transfer(ledger.IssuedAccount, ledger.UserAccount("alice"), 5),
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		Catalog: []rewards.Change{{Reward: &models.Reward{Id: "mug", Name: "Mug", Cost: 10, Inventory: 5}}},
+	}
+}
+
+// writeContents writes the sample snapshot's records, transactions and catalog.
+func writeContents(writer *Writer) error {
+	contents := sampleSnapshot()
+	for _, record := range contents.Records {
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	for _, transaction := range contents.Transactions {
+		if err := writer.WriteTransaction(transaction); err != nil {
+			return err
+		}
+	}
+	for _, change := range contents.Catalog {
+		if err := writer.WriteCatalog(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNDJSON writes the sample snapshot as NDJSON.
+func writeNDJSON(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := writeContents(writer); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestRoundTrip tests that both forms read back exactly what was written.
+func TestRoundTrip(t *testing.T) {
+	ndjson := writeNDJSON(t)
+	if lines := strings.Count(string(ndjson), "\n"); lines != 6 {
+		t.Errorf("NDJSON has %d lines, expected a header, 2 records, a transaction, a catalog change and a trailer", lines)
+	}
+
+	var archive bytes.Buffer
+	if err := WriteArchive(&archive, time.Now(), writeContents); err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+
+	for name, data := range map[string][]byte{"ndjson": ndjson, "archive": archive.Bytes()} {
+		contents, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Read(%s) error = %v", name, err)
+		}
+		if !reflect.DeepEqual(contents, sampleSnapshot()) {
+			t.Errorf("Read(%s) = %+v, expected %+v", name, contents, sampleSnapshot())
+		}
+	}
+}
+
+// TestTamperedSnapshots tests that altered, truncated and foreign input is refused as a whole.
+func TestTamperedSnapshots(t *testing.T) {
+	ndjson := string(writeNDJSON(t))
+	lines := strings.SplitAfter(ndjson, "\n")
+	testCases := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{"Altered points", strings.Replace(ndjson, `"pointsEarned":31`, `"pointsEarned":3100`, 1), ErrChecksumMismatch},
+		{"Dropped record", lines[0] + lines[2] + lines[3] + lines[4] + lines[5], ErrChecksumMismatch},
+		{"Dropped transaction", lines[0] + lines[1] + lines[2] + lines[4] + lines[5], ErrChecksumMismatch},
+		{"Dropped catalog change", lines[0] + lines[1] + lines[2] + lines[3] + lines[5], ErrChecksumMismatch},
+		{"Missing trailer", lines[0] + lines[1] + lines[2] + lines[3] + lines[4], ErrChecksumMismatch},
+		{"Future version", strings.Replace(ndjson, `"version":3`, `"version":4`, 1), ErrUnsupported},
+		{"Not a snapshot", `{"retailer":"Target"}`, ErrUnsupported},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tc.input)); !errors.Is(err, tc.expected) {
+				t.Errorf("Read() error = %v, expected %v", err, tc.expected)
+			}
+		})
+	}
+}
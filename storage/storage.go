@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// Backend durably stores receipts that no longer fit in memory.
+type Backend interface {
+	// Save stores a receipt, replacing any earlier copy with the same ID.
+	Save(receiptID string, receipt models.Receipt) error
+	// Load returns a stored receipt. found is false when no receipt is stored under the ID.
+	Load(receiptID string) (receipt models.Receipt, found bool, err error)
+	// Delete removes a stored receipt. Deleting a receipt that is not stored is not an error.
+	Delete(receiptID string) error
+	// Each calls fn for every stored receipt, in no particular order, and stops at the first error fn returns.
+	Each(fn func(receiptID string, receipt models.Receipt) error) error
+}
+
+// receiptFileExtension ends the name of every receipt file.
+const receiptFileExtension = ".json"
+
+// FileBackend stores each receipt as a JSON file in a directory. File names are the hex-encoded receipt IDs, so any
+// ID is a safe file name.
+type FileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a backend that stores receipts in dir, creating it if it does not exist.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "NewFileBackend")
+	}
+	return &FileBackend{dir: dir}, nil
+}
+
+// Save writes the receipt to a temporary file and renames it into place, so a crash never leaves a partial receipt.
+func (b *FileBackend) Save(receiptID string, receipt models.Receipt) error {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return errors.Wrap(err, "Save")
+	}
+	temp, err := os.CreateTemp(b.dir, ".receipt-*")
+	if err != nil {
+		return errors.Wrap(err, "Save")
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "Save")
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "Save")
+	}
+	return errors.Wrap(os.Rename(temp.Name(), b.path(receiptID)), "Save")
+}
+
+// Load reads a receipt back.
+func (b *FileBackend) Load(receiptID string) (models.Receipt, bool, error) {
+	data, err := os.ReadFile(b.path(receiptID))
+	if os.IsNotExist(err) {
+		return models.Receipt{}, false, nil
+	}
+	if err != nil {
+		return models.Receipt{}, false, errors.Wrap(err, "Load")
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return models.Receipt{}, false, errors.Wrapf(err, "Load: %q", receiptID)
+	}
+	return receipt, true, nil
+}
+
+// Delete removes the receipt's file.
+func (b *FileBackend) Delete(receiptID string) error {
+	if err := os.Remove(b.path(receiptID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Delete")
+	}
+	return nil
+}
+
+// Each reads every receipt file in the directory.
+func (b *FileBackend) Each(fn func(receiptID string, receipt models.Receipt) error) error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return errors.Wrap(err, "Each")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, receiptFileExtension) {
+			continue
+		}
+		rawID, err := hex.DecodeString(strings.TrimSuffix(name, receiptFileExtension))
+		if err != nil {
+			continue
+		}
+		receipt, found, err := b.Load(string(rawID))
+		if err != nil {
+			return errors.Wrap(err, "Each")
+		}
+		if !found {
+			continue
+		}
+		if err := fn(string(rawID), receipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the file a receipt is stored in.
+func (b *FileBackend) path(receiptID string) string {
+	return filepath.Join(b.dir, hex.EncodeToString([]byte(receiptID))+receiptFileExtension)
+}
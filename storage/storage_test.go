@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/praveensundaram1/receipt-processor-challenge/models"
+)
+
+// TestFileBackendRoundTrip tests that receipts survive being saved, replaced and listed, and are gone once deleted.
+func TestFileBackendRoundTrip(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBackend() error = %v", err)
+	}
+	if _, found, err := backend.Load("missing"); found || err != nil {
+		t.Fatalf("Load() of a missing receipt got found = %v, err = %v", found, err)
+	}
+
+	receipt := models.Receipt{
+		UserID:       "alice",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Items:        []models.Item{{ShortDescription: "Dasani", Price: "1.40"}},
+		Total:        "1.40",
+		Points:       31,
+	}
+	ids := []string{"r1_abc-_DEF", "018f3c2a-7b1e-7c3d-9a4f-5e6d7c8b9a0f", "../escape"}
+	for _, id := range ids {
+		if err := backend.Save(id, receipt); err != nil {
+			t.Fatalf("Save(%q) error = %v", id, err)
+		}
+	}
+	receipt.Points = 40
+	if err := backend.Save(ids[0], receipt); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, found, err := backend.Load(ids[0])
+	if err != nil || !found || !reflect.DeepEqual(loaded, receipt) {
+		t.Errorf("Load() = %+v, %v, %v; expected the replaced receipt", loaded, found, err)
+	}
+
+	seen := make(map[string]int)
+	err = backend.Each(func(receiptID string, stored models.Receipt) error {
+		seen[receiptID] = stored.Points
+		return nil
+	})
+	if err != nil || len(seen) != 3 || seen[ids[0]] != 40 || seen[ids[2]] != 31 {
+		t.Errorf("Each() saw %v, %v", seen, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := backend.Delete(ids[1]); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if _, found, err := backend.Load(ids[1]); found || err != nil {
+		t.Errorf("Load() of a deleted receipt got found = %v, err = %v", found, err)
+	}
+}
@@ -0,0 +1,409 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// Backoff spaces out the attempts of a failing delivery: the n-th retry waits Base * 2^(n-1), capped at Max. After
+// MaxAttempts failed attempts the delivery is dead-lettered.
+type Backoff struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// DefaultBackoff retries after 10s, 20s, 40s, ... up to an hour apart, for eight attempts in all.
+var DefaultBackoff = Backoff{Base: 10 * time.Second, Max: time.Hour, MaxAttempts: 8}
+
+// Delay returns how long to wait after the given number of failed attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+	delay := b.Base
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// deliveryTimeout bounds a single delivery attempt.
+const deliveryTimeout = 10 * time.Second
+
+// DefaultConcurrency is how many subscriptions a dispatcher delivers to at once unless told otherwise.
+const DefaultConcurrency = 8
+
+// Dispatcher fans published events out to the subscriptions that want them. Every delivery goes through the
+// store's outbox first, so deliveries survive restarts, and is retried with backoff until the receiver answers 2xx.
+type Dispatcher struct {
+	store       Store
+	backoff     Backoff
+	clock       clock.Clock
+	client      *http.Client
+	concurrency int
+
+	subscriptions map[string]Subscription
+	outbox        map[string]Delivery
+	// seq orders deliveries that were published at the same time.
+	seq  uint64
+	lock sync.Mutex
+
+	// delivering allows one delivery pass at a time.
+	delivering sync.Mutex
+	// wake nudges Run to deliver as soon as something is published or replayed.
+	wake chan struct{}
+}
+
+// NewDispatcher returns a dispatcher over the store, loading the subscriptions and deliveries it already holds.
+func NewDispatcher(store Store, backoff Backoff, c clock.Clock) (*Dispatcher, error) {
+	if backoff.MaxAttempts < 1 {
+		return nil, errors.New("NewDispatcher: max attempts must be at least 1")
+	}
+	d := &Dispatcher{
+		store:         store,
+		backoff:       backoff,
+		clock:         c,
+		client:        &http.Client{Timeout: deliveryTimeout},
+		concurrency:   DefaultConcurrency,
+		subscriptions: make(map[string]Subscription),
+		outbox:        make(map[string]Delivery),
+		wake:          make(chan struct{}, 1),
+	}
+	subscriptions, err := store.Subscriptions()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewDispatcher")
+	}
+	for _, subscription := range subscriptions {
+		d.subscriptions[subscription.ID] = subscription
+	}
+	deliveries, err := store.Deliveries()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewDispatcher")
+	}
+	for _, delivery := range deliveries {
+		d.outbox[delivery.ID] = delivery
+		if delivery.Seq > d.seq {
+			d.seq = delivery.Seq
+		}
+	}
+	return d, nil
+}
+
+// SetConcurrency sets how many subscriptions DeliverDue delivers to at once. It must be called before Run.
+func (d *Dispatcher) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	d.concurrency = concurrency
+}
+
+// Subscribe adds a subscription. A secret is generated when none is given.
+func (d *Dispatcher) Subscribe(url string, eventTypes []string, secret string) (Subscription, error) {
+	id, err := newID("wh_")
+	if err != nil {
+		return Subscription{}, errors.Wrap(err, "Subscribe")
+	}
+	if secret == "" {
+		if secret, err = NewSecret(); err != nil {
+			return Subscription{}, errors.Wrap(err, "Subscribe")
+		}
+	}
+	subscription := Subscription{
+		ID:         id,
+		URL:        url,
+		EventTypes: append([]string(nil), eventTypes...),
+		Secret:     secret,
+		CreatedAt:  d.clock.Now().UTC(),
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.store.SaveSubscription(subscription); err != nil {
+		return Subscription{}, errors.Wrap(err, "Subscribe")
+	}
+	d.subscriptions[id] = subscription
+	return subscription, nil
+}
+
+// Unsubscribe removes a subscription and drops the deliveries still waiting for it.
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, found := d.subscriptions[id]; !found {
+		return errors.Wrapf(ErrNotFound, "Unsubscribe: subscription %s", id)
+	}
+	if err := d.store.DeleteSubscription(id); err != nil {
+		return errors.Wrap(err, "Unsubscribe")
+	}
+	delete(d.subscriptions, id)
+	for deliveryID, delivery := range d.outbox {
+		if delivery.SubscriptionID != id {
+			continue
+		}
+		if err := d.store.DeleteDelivery(deliveryID); err != nil {
+			return errors.Wrap(err, "Unsubscribe")
+		}
+		delete(d.outbox, deliveryID)
+	}
+	return nil
+}
+
+// Subscriptions returns every subscription, oldest first.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	subscriptions := make([]Subscription, 0, len(d.subscriptions))
+	for _, subscription := range d.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+// Publish queues an event for every subscription that wants its type. The body is sent as is. Every delivery is
+// written to the store before Publish returns, so once it returns the event survives a crash. When a write fails,
+// the deliveries written before it stay queued, and publishing the event again queues them a second time.
+func (d *Dispatcher) Publish(eventType string, body json.RawMessage) error {
+	now := d.clock.Now().UTC()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	queued := false
+	defer func() {
+		if queued {
+			d.nudge()
+		}
+	}()
+	for _, subscription := range d.subscriptions {
+		if !subscription.Wants(eventType) {
+			continue
+		}
+		id, err := newID("dlv_")
+		if err != nil {
+			return errors.Wrap(err, "Publish")
+		}
+		d.seq++
+		delivery := Delivery{
+			ID:             id,
+			Seq:            d.seq,
+			SubscriptionID: subscription.ID,
+			EventType:      eventType,
+			Body:           body,
+			Status:         StatusPending,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		}
+		if err := d.store.SaveDelivery(delivery); err != nil {
+			return errors.Wrap(err, "Publish")
+		}
+		d.outbox[id] = delivery
+		queued = true
+	}
+	return nil
+}
+
+// DeliverDue attempts every pending delivery whose next attempt is
+// due and returns how many were delivered and how many failed. Up to the dispatcher's concurrency subscriptions are
+// delivered to at once, and each subscription's deliveries are attempted one at a time in the order they were
+// published, so a slow receiver holds up only its own deliveries.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, int) {
+	d.delivering.Lock()
+	defer d.delivering.Unlock()
+
+	now := d.clock.Now()
+	d.lock.Lock()
+	bySubscription := make(map[string][]Delivery)
+	for _, delivery := range d.outbox {
+		if delivery.Status == StatusPending && !delivery.NextAttempt.After(now) {
+			bySubscription[delivery.SubscriptionID] = append(bySubscription[delivery.SubscriptionID], delivery)
+		}
+	}
+	d.lock.Unlock()
+
+	queues := make(chan []Delivery, len(bySubscription))
+	for _, due := range bySubscription {
+		sort.Slice(due, func(i, j int) bool { return due[i].Seq < due[j].Seq })
+		queues <- due
+	}
+	close(queues)
+
+	var delivered, failed int
+	var countLock sync.Mutex
+	var workers sync.WaitGroup
+	for i := 0; i < d.concurrency && i < len(bySubscription); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for due := range queues {
+				ok, notOK := d.deliverAll(ctx, due)
+				countLock.Lock()
+				delivered += ok
+				failed += notOK
+				countLock.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+	return delivered, failed
+}
+
+// deliverAll attempts the due deliveries of one subscription in order and returns how many were delivered and how
+// many failed.
+func (d *Dispatcher) deliverAll(ctx context.Context, due []Delivery) (int, int) {
+	delivered, failed := 0, 0
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		d.lock.Lock()
+		subscription, found := d.subscriptions[delivery.SubscriptionID]
+		d.lock.Unlock()
+		if !found {
+			break
+		}
+
+		err := d.send(ctx, subscription, delivery)
+		if err == nil {
+			delivered++
+		} else {
+			failed++
+		}
+		if err := d.settle(delivery, err); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+	}
+	return delivered, failed
+}
+
+// send makes one delivery attempt.
+func (d *Dispatcher) send(ctx context.Context, subscription Subscription, delivery Delivery) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, Sign(subscription.Secret, d.clock.Now(), delivery.Body))
+	request.Header.Set(DeliveryHeader, delivery.ID)
+	request.Header.Set(EventHeader, delivery.EventType)
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("send: receiver answered %s", response.Status)
+	}
+	return nil
+}
+
+// settle removes a delivered delivery from the outbox, or schedules its retry and dead-letters it once it runs out
+// of attempts.
+func (d *Dispatcher) settle(delivery Delivery, sendErr error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, found := d.outbox[delivery.ID]; !found {
+		// The subscription was removed while the attempt was in flight.
+		return nil
+	}
+	if sendErr == nil {
+		delete(d.outbox, delivery.ID)
+		return errors.Wrap(d.store.DeleteDelivery(delivery.ID), "settle")
+	}
+
+	delivery.Attempts++
+	delivery.LastError = sendErr.Error()
+	if delivery.Attempts >= d.backoff.MaxAttempts {
+		delivery.Status = StatusDead
+	} else {
+		delivery.NextAttempt = d.clock.Now().UTC().Add(d.backoff.Delay(delivery.Attempts))
+	}
+	d.outbox[delivery.ID] = delivery
+	return errors.Wrap(d.store.SaveDelivery(delivery), "settle")
+}
+
+// Pending returns the deliveries waiting for an attempt, in the order they were published.
+func (d *Dispatcher) Pending() []Delivery {
+	return d.deliveries(StatusPending)
+}
+
+// DeadLetters returns the deliveries that ran out of attempts, in the order they were published.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	return d.deliveries(StatusDead)
+}
+
+// deliveries returns the outbox deliveries in the given status.
+func (d *Dispatcher) deliveries(status string) []Delivery {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var deliveries []Delivery
+	for _, delivery := range d.outbox {
+		if delivery.Status == status {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Seq < deliveries[j].Seq })
+	return deliveries
+}
+
+// Replay moves a dead letter back to the outbox with a fresh set of attempts, due now.
+func (d *Dispatcher) Replay(id string) (Delivery, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delivery, found := d.outbox[id]
+	if !found || delivery.Status != StatusDead {
+		return Delivery{}, errors.Wrapf(ErrNotFound, "Replay: dead letter %s", id)
+	}
+	delivery.Status = StatusPending
+	delivery.Attempts = 0
+	delivery.NextAttempt = d.clock.Now().UTC()
+	if err := d.store.SaveDelivery(delivery); err != nil {
+		return Delivery{}, errors.Wrap(err, "Replay")
+	}
+	d.outbox[id] = delivery
+	d.nudge()
+	return delivery, nil
+}
+
+// nudge wakes Run without blocking.
+func (d *Dispatcher) nudge() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries every interval, and as soon as something is published or replayed, until the context
+// is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		delivered, failed := d.DeliverDue(ctx)
+		if failed > 0 {
+			log.Printf("webhooks: delivered %d, %d failed", delivered, failed)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Store persists subscriptions and the outbox of deliveries, so neither is lost on restart.
+type Store interface {
+	SaveSubscription(subscription Subscription) error
+	DeleteSubscription(id string) error
+	Subscriptions() ([]Subscription, error)
+	// SaveDelivery adds a delivery to the outbox or replaces it.
+	SaveDelivery(delivery Delivery) error
+	DeleteDelivery(id string) error
+	Deliveries() ([]Delivery, error)
+}
+
+// MemoryStore keeps subscriptions and deliveries in memory. It is lost on restart.
+type MemoryStore struct {
+	subscriptions map[string]Subscription
+	deliveries    map[string]Delivery
+	lock          sync.Mutex
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{subscriptions: make(map[string]Subscription), deliveries: make(map[string]Delivery)}
+}
+
+// SaveSubscription stores a subscription.
+func (s *MemoryStore) SaveSubscription(subscription Subscription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+// DeleteSubscription removes a subscription.
+func (s *MemoryStore) DeleteSubscription(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscriptions, id)
+	return nil
+}
+
+// Subscriptions returns every subscription.
+func (s *MemoryStore) Subscriptions() ([]Subscription, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscriptions := make([]Subscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// SaveDelivery stores a delivery.
+func (s *MemoryStore) SaveDelivery(delivery Delivery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// DeleteDelivery removes a delivery.
+func (s *MemoryStore) DeleteDelivery(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.deliveries, id)
+	return nil
+}
+
+// Deliveries returns every delivery in the outbox.
+func (s *MemoryStore) Deliveries() ([]Delivery, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deliveries := make([]Delivery, 0, len(s.deliveries))
+	for _, delivery := range s.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// FileStore keeps each subscription and delivery as a JSON file under a directory, written atomically.
+type FileStore struct {
+	dir string
+}
+
+// Subdirectories of a FileStore.
+const (
+	subscriptionsDir = "subscriptions"
+	outboxDir        = "outbox"
+)
+
+// NewFileStore returns a store under dir, creating it if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{subscriptionsDir, outboxDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, errors.Wrap(err, "NewFileStore")
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// SaveSubscription writes a subscription file.
+func (s *FileStore) SaveSubscription(subscription Subscription) error {
+	return errors.Wrap(s.write(subscriptionsDir, subscription.ID, subscription), "SaveSubscription")
+}
+
+// DeleteSubscription removes a subscription file.
+func (s *FileStore) DeleteSubscription(id string) error {
+	return errors.Wrap(s.remove(subscriptionsDir, id), "DeleteSubscription")
+}
+
+// Subscriptions reads every subscription file.
+func (s *FileStore) Subscriptions() ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := s.each(subscriptionsDir, func(data []byte) error {
+		var subscription Subscription
+		if err := json.Unmarshal(data, &subscription); err != nil {
+			return err
+		}
+		subscriptions = append(subscriptions, subscription)
+		return nil
+	})
+	return subscriptions, errors.Wrap(err, "Subscriptions")
+}
+
+// SaveDelivery writes a delivery file.
+func (s *FileStore) SaveDelivery(delivery Delivery) error {
+	return errors.Wrap(s.write(outboxDir, delivery.ID, delivery), "SaveDelivery")
+}
+
+// DeleteDelivery removes a delivery file.
+func (s *FileStore) DeleteDelivery(id string) error {
+	return errors.Wrap(s.remove(outboxDir, id), "DeleteDelivery")
+}
+
+// Deliveries reads every delivery file.
+func (s *FileStore) Deliveries() ([]Delivery, error) {
+	var deliveries []Delivery
+	err := s.each(outboxDir, func(data []byte) error {
+		var delivery Delivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+		return nil
+	})
+	return deliveries, errors.Wrap(err, "Deliveries")
+}
+
+// write marshals v to a temporary file and renames it into place, so a crash never leaves a partial file.
+func (s *FileStore) write(sub string, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Join(s.dir, sub), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filepath.Join(s.dir, sub, id+".json"))
+}
+
+// remove deletes a file, ignoring files that are already gone.
+func (s *FileStore) remove(sub string, id string) error {
+	err := os.Remove(filepath.Join(s.dir, sub, id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// each calls fn with the contents of every file in a subdirectory.
+func (s *FileStore) each(sub string, fn func(data []byte) error) error {
+	entries, err := os.ReadDir(filepath.Join(s.dir, sub))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, sub, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return errors.Wrapf(err, "%s", entry.Name())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers set on every delivery.
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+	SignatureHeader = "X-Webhook-Signature"
+	// DeliveryHeader carries the delivery ID, which stays the same across retries so receivers can deduplicate.
+	DeliveryHeader = "X-Webhook-Delivery"
+	// EventHeader carries the event type.
+	EventHeader = "X-Webhook-Event"
+)
+
+// AllEvents subscribes to every event type.
+const AllEvents = "*"
+
+var (
+	// ErrNotFound is returned for unknown subscriptions and dead letters.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidSignature is returned by Verify for a missing, malformed, stale or wrong signature.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Subscription asks for the events of the given types to be delivered to a URL, signed with a secret.
+type Subscription struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`
+	Secret     string    `json:"secret"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Wants reports whether the subscription asked for events of the given type.
+func (s Subscription) Wants(eventType string) bool {
+	for _, wanted := range s.EventTypes {
+		if wanted == AllEvents || wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery states.
+const (
+	// StatusPending deliveries are waiting for their next attempt.
+	StatusPending = "pending"
+	// StatusDead deliveries ran out of attempts and wait in the dead-letter list to be replayed.
+	StatusDead = "dead"
+)
+
+// Delivery is one event on its way to one subscription. Delivered events are removed from the outbox.
+type Delivery struct {
+	ID             string          `json:"id"`
+	Seq            uint64          `json:"seq"`
+	SubscriptionID string          `json:"subscriptionId"`
+	EventType      string          `json:"eventType"`
+	Body           json.RawMessage `json:"body"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"nextAttempt"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// Sign returns the signature header value of a body sent at the given time.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify checks a signature header against the body, rejecting signatures older than tolerance. Receivers use it to
+// authenticate deliveries.
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp, signed string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signed = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signed == "" {
+		return errors.Wrap(ErrInvalidSignature, "Verify: malformed header")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return errors.Wrap(ErrInvalidSignature, "Verify: timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(signed), []byte(signature(secret, timestamp, body))) {
+		return errors.Wrap(ErrInvalidSignature, "Verify: signature mismatch")
+	}
+	return nil
+}
+
+// signature is the hex HMAC-SHA256 of "<timestamp>.<body>".
+func signature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	return randomHex(32)
+}
+
+// newID returns a random identifier with the given prefix.
+func newID(prefix string) (string, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return "", err
+	}
+	return prefix + id, nil
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "randomHex")
+	}
+	return hex.EncodeToString(buf), nil
+}
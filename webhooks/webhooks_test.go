@@ -0,0 +1,279 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/praveensundaram1/receipt-processor-challenge/internal/clock"
+)
+
+// TestSignAndVerify tests that a signature verifies against its body and secret only, and only while it is fresh.
+func TestSignAndVerify(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"receiptId":"r1"}`)
+	header := Sign("secret", at, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		body    []byte
+		header  string
+		now     time.Time
+		wantErr bool
+	}{
+		{"Valid", "secret", body, header, at.Add(time.Minute), false},
+		{"Wrong secret", "other", body, header, at, true},
+		{"Modified body", "secret", []byte(`{"receiptId":"r2"}`), header, at, true},
+		{"Stale", "secret", body, header, at.Add(time.Hour), true},
+		{"Malformed", "secret", body, "v1=abc", at, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, test.body, 5*time.Minute, test.now)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() error = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+// TestBackoffDelay tests that retries back off exponentially up to the cap.
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: 10 * time.Second, MaxAttempts: 10}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, expected := range want {
+		if got := backoff.Delay(i + 1); got != expected {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, expected)
+		}
+	}
+}
+
+// receiver is an httptest webhook receiver that fails until told otherwise and records what it accepted.
+type receiver struct {
+	server   *httptest.Server
+	failing  bool
+	accepted []*http.Request
+	bodies   [][]byte
+	lock     sync.Mutex
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.accepted = append(r.accepted, request)
+		r.bodies = append(r.bodies, body)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) setFailing(failing bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failing = failing
+}
+
+// TestDispatcherRetriesAndDeadLetters tests that a failing delivery is retried with backoff, dead-lettered after its
+// last attempt, kept across a restart, and delivered signed once replayed.
+func TestDispatcherRetriesAndDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	fake := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	backoff := Backoff{Base: time.Minute, Max: time.Hour, MaxAttempts: 3}
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	dispatcher, err := NewDispatcher(store, backoff, fake)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	r := newReceiver(t)
+	r.setFailing(true)
+	subscription, err := dispatcher.Subscribe(r.server.URL, []string{"receipt.scored"}, "")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if subscription.Secret == "" {
+		t.Fatalf("Subscribe() generated no secret")
+	}
+	if err := dispatcher.Publish("receipt.submitted", json.RawMessage(`{"seq":1}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := dispatcher.Publish("receipt.scored", json.RawMessage(`{"seq":2}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if pending := dispatcher.Pending(); len(pending) != 1 {
+		t.Fatalf("Pending() = %d deliveries, want 1 for the subscribed type", len(pending))
+	}
+
+	if delivered, failed := dispatcher.DeliverDue(ctx); delivered != 0 || failed != 1 {
+		t.Fatalf("DeliverDue() = %d, %d, want 0, 1", delivered, failed)
+	}
+	if _, failed := dispatcher.DeliverDue(ctx); failed != 0 {
+		t.Fatalf("DeliverDue() retried before the backoff elapsed")
+	}
+	fake.Advance(time.Minute)
+	if _, failed := dispatcher.DeliverDue(ctx); failed != 1 {
+		t.Fatalf("DeliverDue() did not retry after 1m")
+	}
+	fake.Advance(time.Minute)
+	if _, failed := dispatcher.DeliverDue(ctx); failed != 0 {
+		t.Fatalf("DeliverDue() retried before the doubled backoff elapsed")
+	}
+	fake.Advance(time.Minute)
+	if _, failed := dispatcher.DeliverDue(ctx); failed != 1 {
+		t.Fatalf("DeliverDue() did not retry after 2m")
+	}
+
+	// The outbox is persistent: a restarted dispatcher sees the dead letter.
+	restarted, err := NewDispatcher(store, backoff, fake)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	dead := restarted.DeadLetters()
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError == "" {
+		t.Fatalf("DeadLetters() = %+v, want one delivery after 3 attempts", dead)
+	}
+	if len(restarted.Pending()) != 0 {
+		t.Fatalf("Pending() = %+v, want none", restarted.Pending())
+	}
+
+	r.setFailing(false)
+	if _, err := restarted.Replay(dead[0].ID); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if delivered, _ := restarted.DeliverDue(ctx); delivered != 1 {
+		t.Fatalf("DeliverDue() delivered %d after replay, want 1", delivered)
+	}
+	if len(restarted.DeadLetters()) != 0 || len(restarted.Pending()) != 0 {
+		t.Fatalf("outbox not empty after delivery")
+	}
+	if _, err := restarted.Replay(dead[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Replay() of a delivered letter error = %v, want ErrNotFound", err)
+	}
+
+	if len(r.accepted) != 1 {
+		t.Fatalf("receiver accepted %d deliveries, want 1", len(r.accepted))
+	}
+	request := r.accepted[0]
+	if request.Header.Get(EventHeader) != "receipt.scored" || request.Header.Get(DeliveryHeader) != dead[0].ID {
+		t.Errorf("delivery headers = %v", request.Header)
+	}
+	if err := Verify(subscription.Secret, request.Header.Get(SignatureHeader), r.bodies[0], time.Minute, fake.Now()); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if string(r.bodies[0]) != `{"seq":2}` {
+		t.Errorf("delivered body = %s, want the published body", r.bodies[0])
+	}
+}
+
+// TestUnsubscribeDropsDeliveries tests that removing a subscription removes the deliveries waiting for it.
+func TestUnsubscribeDropsDeliveries(t *testing.T) {
+	dispatcher, err := NewDispatcher(NewMemoryStore(), DefaultBackoff, clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	subscription, err := dispatcher.Subscribe("http://127.0.0.1:1/hook", []string{AllEvents}, "secret")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := dispatcher.Publish("receipt.scored", json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := dispatcher.Unsubscribe(subscription.ID); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if len(dispatcher.Pending()) != 0 || len(dispatcher.Subscriptions()) != 0 {
+		t.Errorf("Unsubscribe() left subscriptions or deliveries behind")
+	}
+	if err := dispatcher.Unsubscribe(subscription.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unsubscribe() twice error = %v, want ErrNotFound", err)
+	}
+}
+
+// countingStore is a MemoryStore that counts the deliveries saved to it.
+type countingStore struct {
+	*MemoryStore
+	saved int
+	lock  sync.Mutex
+}
+
+func (s *countingStore) SaveDelivery(delivery Delivery) error {
+	s.lock.Lock()
+	s.saved++
+	s.lock.Unlock()
+	return s.MemoryStore.SaveDelivery(delivery)
+}
+
+// TestPublishSavesAndDeliverDueDeliversConcurrently tests that Publish writes its deliveries to the store before it
+// returns, and that a slow receiver does not hold up the deliveries to another subscription.
+func TestPublishSavesAndDeliverDueDeliversConcurrently(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	dispatcher, err := NewDispatcher(store, DefaultBackoff, clock.Real{})
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := newReceiver(t)
+	for _, url := range []string{slow.URL, fast.server.URL} {
+		if _, err := dispatcher.Subscribe(url, []string{AllEvents}, "secret"); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+	}
+
+	if err := dispatcher.Publish("receipt.scored", json.RawMessage(`{"seq":1}`)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if saved, err := store.Deliveries(); store.saved != 2 || err != nil || len(saved) != 2 {
+		t.Fatalf("Publish() saved %d deliveries, want both before it returns", store.saved)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if delivered, failed := dispatcher.DeliverDue(context.Background()); delivered != 2 || failed != 0 {
+			t.Errorf("DeliverDue() = %d, %d, want 2, 0", delivered, failed)
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fast.lock.Lock()
+		accepted := len(fast.accepted)
+		fast.lock.Unlock()
+		if accepted == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the fast receiver waited for the slow one")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	<-done
+	if store.saved != 2 || len(dispatcher.Pending()) != 0 {
+		t.Errorf("saved %d deliveries with %d pending, want 2 saved and none pending", store.saved, len(dispatcher.Pending()))
+	}
+}